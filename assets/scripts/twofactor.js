@@ -12,6 +12,7 @@
   const tokenInput = d.getElementById('two-factor-token')
   const trustCheckbox = d.getElementById('two-factor-trust-device')
   const longRunCheckbox = d.getElementById('long-run-session')
+  const webauthnInput = d.getElementById('two-factor-webauthn')
 
   const storage = w.localStorage
 
@@ -33,6 +34,12 @@
     data.append('two-factor-generate-trusted-device-token', trustDevice)
     data.append('redirect', redirect)
 
+    // When a security key is used instead of a passcode
+    if (webauthnInput && webauthnInput.value) {
+      data.append('two-factor-webauthn', webauthnInput.value)
+      webauthnInput.value = ''
+    }
+
     // When 2FA is checked for moving a Cozy to this instance
     if (stateInput) {
       data.append('state', stateInput.value)
@@ -0,0 +1,106 @@
+;(function (w, d) {
+  if (!w.fetch || !w.Headers || !w.PublicKeyCredential) return
+
+  const form = d.getElementById('webauthn-register-form')
+  if (!form) return
+
+  const nameInput = d.getElementById('webauthn-name')
+  const passphraseInput = d.getElementById('password')
+  const submitButton = d.getElementById('webauthn-register-submit')
+  const redirectInput = d.getElementById('redirect')
+  const csrfTokenInput = d.getElementById('csrf_token')
+  const field = d.getElementById('login-field')
+
+  const fromBase64URL = function (str) {
+    const base64 = str.replace(/-/g, '+').replace(/_/g, '/')
+    const padding = '='.repeat((4 - (base64.length % 4)) % 4)
+    const binary = w.atob(base64 + padding)
+    return Uint8Array.from(binary, (c) => c.charCodeAt(0)).buffer
+  }
+
+  const toBase64URL = function (buffer) {
+    const binary = String.fromCharCode.apply(null, new Uint8Array(buffer))
+    return w
+      .btoa(binary)
+      .replace(/\+/g, '-')
+      .replace(/\//g, '_')
+      .replace(/=+$/, '')
+  }
+
+  const post = function (url, data) {
+    data.append('csrf_token', csrfTokenInput.value)
+    const headers = new Headers()
+    headers.append('Content-Type', 'application/x-www-form-urlencoded')
+    headers.append('Accept', 'application/json')
+    return fetch(url, {
+      method: 'POST',
+      headers: headers,
+      body: data,
+      credentials: 'same-origin',
+    }).then((response) =>
+      response.json().then((body) => {
+        if (response.status >= 400) throw body.error
+        return body
+      })
+    )
+  }
+
+  const hashPassphrase = function (passphrase) {
+    const salt = form.dataset.salt
+    const iterations = parseInt(form.dataset.iterations, 10)
+    if (iterations > 0) {
+      return w.password
+        .hash(passphrase, salt, iterations)
+        .then(({ hashed }) => hashed)
+    }
+    return Promise.resolve(passphrase)
+  }
+
+  const createCredential = function (options) {
+    options.challenge = fromBase64URL(options.challenge)
+    options.user.id = fromBase64URL(options.user.id)
+    options.excludeCredentials = (options.excludeCredentials || []).map(
+      (cred) => ({ type: cred.type, id: fromBase64URL(cred.id) })
+    )
+    return navigator.credentials.create({ publicKey: options })
+  }
+
+  const onSubmit = function (event) {
+    event.preventDefault()
+    submitButton.setAttribute('disabled', true)
+
+    hashPassphrase(passphraseInput.value)
+      .then((pass) => {
+        const data = new URLSearchParams()
+        data.append('passphrase', pass)
+        return post('/auth/webauthn/registration', data)
+      })
+      .then(createCredential)
+      .then((cred) => {
+        const data = new URLSearchParams()
+        data.append('name', nameInput.value)
+        data.append('redirect', redirectInput.value)
+        data.append(
+          'credential',
+          JSON.stringify({
+            id: cred.id,
+            type: cred.type,
+            response: {
+              clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+              attestationObject: toBase64URL(cred.response.attestationObject),
+            },
+          })
+        )
+        return post(form.action, data)
+      })
+      .then((body) => {
+        w.location = body.redirect
+      })
+      .catch((err) => {
+        submitButton.removeAttribute('disabled')
+        w.showError(field, err)
+      })
+  }
+
+  form.addEventListener('submit', onSubmit)
+})(window, document)
@@ -0,0 +1,108 @@
+;(function (w, d) {
+  if (!w.fetch || !w.Headers || !w.PublicKeyCredential) return
+
+  const button = d.getElementById('webauthn-button')
+  if (!button) return
+
+  const form = button.form
+  const field = d.getElementById(button.dataset.field)
+  const redirectInput = d.getElementById('redirect')
+  const csrfTokenInput = d.getElementById('csrf_token')
+  const longRunCheckbox = d.getElementById('long-run-session')
+  const webauthnInput = d.getElementById('two-factor-webauthn')
+
+  const fromBase64URL = function (str) {
+    const base64 = str.replace(/-/g, '+').replace(/_/g, '/')
+    const padding = '='.repeat((4 - (base64.length % 4)) % 4)
+    const binary = w.atob(base64 + padding)
+    return Uint8Array.from(binary, (c) => c.charCodeAt(0)).buffer
+  }
+
+  const toBase64URL = function (buffer) {
+    const binary = String.fromCharCode.apply(null, new Uint8Array(buffer))
+    return w
+      .btoa(binary)
+      .replace(/\+/g, '-')
+      .replace(/\//g, '_')
+      .replace(/=+$/, '')
+  }
+
+  const getAssertion = function () {
+    const headers = new Headers()
+    headers.append('Accept', 'application/json')
+    return fetch('/auth/webauthn', {
+      method: 'GET',
+      headers: headers,
+      credentials: 'same-origin',
+    })
+      .then((response) => response.json())
+      .then((options) => {
+        options.challenge = fromBase64URL(options.challenge)
+        options.allowCredentials = options.allowCredentials.map((cred) => ({
+          type: cred.type,
+          id: fromBase64URL(cred.id),
+        }))
+        return navigator.credentials.get({ publicKey: options })
+      })
+      .then((cred) =>
+        JSON.stringify({
+          id: cred.id,
+          type: cred.type,
+          response: {
+            clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+            authenticatorData: toBase64URL(cred.response.authenticatorData),
+            signature: toBase64URL(cred.response.signature),
+          },
+        })
+      )
+  }
+
+  const login = function (assertion) {
+    const longRun = longRunCheckbox && longRunCheckbox.checked ? '1' : '0'
+    const redirect = redirectInput && redirectInput.value + w.location.hash
+
+    const data = new URLSearchParams()
+    data.append('webauthn', assertion)
+    data.append('long-run-session', longRun)
+    data.append('redirect', redirect)
+    data.append('csrf_token', csrfTokenInput.value)
+
+    const headers = new Headers()
+    headers.append('Content-Type', 'application/x-www-form-urlencoded')
+    headers.append('Accept', 'application/json')
+    return fetch(button.dataset.action, {
+      method: 'POST',
+      headers: headers,
+      body: data,
+      credentials: 'same-origin',
+    }).then((response) => {
+      return response.json().then((body) => {
+        if (response.status < 400) {
+          w.location = body.redirect
+        } else {
+          w.showError(field, body.error)
+        }
+      })
+    })
+  }
+
+  const onClick = function () {
+    button.setAttribute('disabled', true)
+    getAssertion()
+      .then((assertion) => {
+        // On the two-factor page, the assertion is sent instead of the
+        // passcode with the form
+        if (webauthnInput) {
+          webauthnInput.value = assertion
+          form.dispatchEvent(new Event('submit', { cancelable: true }))
+          return
+        }
+        return login(assertion)
+      })
+      .catch((err) => w.showError(field, err))
+      .finally(() => button.removeAttribute('disabled'))
+  }
+
+  button.classList.remove('d-none')
+  button.addEventListener('click', onClick)
+})(window, document)
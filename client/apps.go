@@ -58,7 +58,8 @@ type AppManifest struct {
 			Selector    string   `json:"selector,omitempty"`
 			Values      []string `json:"values,omitempty"`
 		} `json:"permissions"`
-		AvailableVersion string `json:"available_version,omitempty"`
+		AvailableVersion string           `json:"available_version,omitempty"`
+		Signature        *json.RawMessage `json:"signature,omitempty"`
 
 		Parameters json.RawMessage `json:"parameters,omitempty"`
 
@@ -190,6 +191,18 @@ func (c *Client) UpdateApp(opts *AppOptions, safe bool) (*AppManifest, error) {
 	return readAppManifestStream(res)
 }
 
+// RollbackApp is used to go back to the previous version of an application.
+func (c *Client) RollbackApp(opts *AppOptions) (*AppManifest, error) {
+	res, err := c.Req(&request.Options{
+		Method: "POST",
+		Path:   makeAppsPath(opts.AppType, url.PathEscape(opts.Slug)+"/rollback"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return readAppManifest(res)
+}
+
 // UninstallApp is used to uninstall an application.
 func (c *Client) UninstallApp(opts *AppOptions) (*AppManifest, error) {
 	res, err := c.Req(&request.Options{
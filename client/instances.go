@@ -85,6 +85,7 @@ type OAuthClientOptions struct {
 	ClientName            string
 	SoftwareID            string
 	AllowLoginScope       bool
+	AllowIntrospection    bool
 	OnboardingSecret      string
 	OnboardingApp         string
 	OnboardingPermissions string
@@ -343,6 +344,7 @@ func (c *Client) RegisterOAuthClient(opts *OAuthClientOptions) (map[string]inter
 		"ClientName":            {opts.ClientName},
 		"SoftwareID":            {opts.SoftwareID},
 		"AllowLoginScope":       {strconv.FormatBool(opts.AllowLoginScope)},
+		"AllowIntrospection":    {strconv.FormatBool(opts.AllowIntrospection)},
 		"OnboardingSecret":      {opts.OnboardingSecret},
 		"OnboardingApp":         {opts.OnboardingApp},
 		"OnboardingPermissions": {opts.OnboardingPermissions},
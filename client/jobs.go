@@ -12,6 +12,7 @@ import (
 type jobOptions struct {
 	MaxExecCount int            `json:"max_exec_count,omitempty"`
 	Timeout      *time.Duration `json:"timeout,omitempty"`
+	Priority     string         `json:"priority,omitempty"`
 }
 
 // JobOptions is the options to run a job.
@@ -20,6 +21,7 @@ type JobOptions struct {
 	Arguments    interface{}
 	MaxExecCount int
 	Timeout      *time.Duration
+	Priority     string
 	Logs         chan *JobLog
 }
 
@@ -40,6 +42,7 @@ type Job struct {
 		TriggerID string          `json:"trigger_id"`
 		Message   json.RawMessage `json:"message"`
 		Debounced bool            `json:"debounced"`
+		Priority  string          `json:"priority"`
 		Event     struct {
 			Domain string          `json:"domain"`
 			Verb   string          `json:"verb"`
@@ -92,6 +95,7 @@ func (c *Client) JobPush(r *JobOptions) (*Job, error) {
 	if r.Timeout != nil {
 		opt.Timeout = r.Timeout
 	}
+	opt.Priority = r.Priority
 
 	withLogs := r.Logs != nil
 	var channel *RealtimeChannel
@@ -236,3 +240,108 @@ func (c *Client) ListTriggers() ([]*Trigger, error) {
 	}
 	return list, nil
 }
+
+// ListDeadLetters returns the jobs in the dead-letter queue of the given
+// worker type.
+func (c *Client) ListDeadLetters(worker string) ([]map[string]interface{}, error) {
+	res, err := c.Req(&request.Options{
+		Method: "GET",
+		Path:   "/instances/jobs/dead-letters/" + url.PathEscape(worker),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetDeadLetter returns a job from the dead-letter queue of the given worker
+// type.
+func (c *Client) GetDeadLetter(worker, jobID string) (map[string]interface{}, error) {
+	res, err := c.Req(&request.Options{
+		Method: "GET",
+		Path:   "/instances/jobs/dead-letters/" + url.PathEscape(worker) + "/" + url.PathEscape(jobID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var job map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ReplayDeadLetter removes a job from the dead-letter queue and pushes a new
+// job with the same parameters. The new job is returned.
+func (c *Client) ReplayDeadLetter(worker, jobID string) (map[string]interface{}, error) {
+	res, err := c.Req(&request.Options{
+		Method: "POST",
+		Path:   "/instances/jobs/dead-letters/" + url.PathEscape(worker) + "/" + url.PathEscape(jobID) + "/replay",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var job map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// DiscardDeadLetter removes a job from the dead-letter queue.
+func (c *Client) DiscardDeadLetter(worker, jobID string) error {
+	_, err := c.Req(&request.Options{
+		Method:     "DELETE",
+		Path:       "/instances/jobs/dead-letters/" + url.PathEscape(worker) + "/" + url.PathEscape(jobID),
+		NoResponse: true,
+	})
+	return err
+}
+
+// JobsStatsOptions is a struct with the options for the statistics of the
+// jobs.
+type JobsStatsOptions struct {
+	Window  string
+	GroupBy string
+	Worker  string
+	Slug    string
+	Domain  string
+}
+
+// JobsStats returns the statistics of the jobs that have finished during a
+// sliding window, aggregated by worker type, slug and/or domain.
+func (c *Client) JobsStats(opts *JobsStatsOptions) ([]map[string]interface{}, error) {
+	q := url.Values{}
+	if opts.Window != "" {
+		q.Add("window", opts.Window)
+	}
+	if opts.GroupBy != "" {
+		q.Add("group_by", opts.GroupBy)
+	}
+	if opts.Worker != "" {
+		q.Add("worker", opts.Worker)
+	}
+	if opts.Slug != "" {
+		q.Add("slug", opts.Slug)
+	}
+	if opts.Domain != "" {
+		q.Add("domain", opts.Domain)
+	}
+	res, err := c.Req(&request.Options{
+		Method:  "GET",
+		Path:    "/instances/jobs/stats",
+		Queries: q,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -24,6 +24,9 @@ var flagKonnectorsParameters string
 var flagKonnectorContext string
 var flagKonnectorsShortMaintenance bool
 var flagKonnectorsDisallowManualExec bool
+var flagKonnectorsMaintenanceStart string
+var flagKonnectorsMaintenanceEnd string
+var flagKonnectorsMaintenanceReason string
 
 var webappsCmdGroup = &cobra.Command{
 	Use:   "apps <command>",
@@ -85,6 +88,21 @@ var uninstallWebappCmd = &cobra.Command{
 	},
 }
 
+var rollbackWebappCmd = &cobra.Command{
+	Use:   "rollback <slug>",
+	Short: "Go back to the previous version of the application with the specified slug name.",
+	Long: `
+When an application is updated, the previous versions are kept (see the
+apps.kept_versions parameter of the configuration file). This command replaces
+the installed version by the most recent of them. The replaced version is then
+marked as available, and will not be installed again by the automatic updates
+until a newer version is published (an explicit update can still install it).
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rollbackApp(cmd, args, consts.Apps)
+	},
+}
+
 var lsWebappsCmd = &cobra.Command{
 	Use:   "ls",
 	Short: "List the installed applications.",
@@ -177,6 +195,14 @@ var uninstallKonnectorCmd = &cobra.Command{
 	},
 }
 
+var rollbackKonnectorCmd = &cobra.Command{
+	Use:   "rollback <slug>",
+	Short: "Go back to the previous version of the konnector with the specified slug name.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rollbackApp(cmd, args, consts.Konnectors)
+	},
+}
+
 var lsKonnectorsCmd = &cobra.Command{
 	Use:   "ls",
 	Short: "List the installed konnectors.",
@@ -292,6 +318,14 @@ var listMaintenancesCmd = &cobra.Command{
 var activateMaintenanceKonnectorsCmd = &cobra.Command{
 	Use:   "maintenance [slug]",
 	Short: `Activate the maintenance for the given konnector`,
+	Long: `
+cozy-stack konnectors maintenance activates the maintenance for the given
+konnector. Without --start and --end, the maintenance starts now and lasts
+until it is deactivated. With them, the runs of the konnector are skipped only
+during the window, and the skipped runs from triggers are rescheduled shortly
+after its end.
+`,
+	Example: "$ cozy-stack konnectors maintenance ameli --start 2021-03-20T22:00:00Z --end 2021-03-21T02:00:00Z --reason 'Website migration'",
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		if len(args) != 1 {
 			return cmd.Help()
@@ -319,6 +353,15 @@ var activateMaintenanceKonnectorsCmd = &cobra.Command{
 			"flag_disallow_manual_exec": flagKonnectorsDisallowManualExec,
 			"messages":                  messages,
 		}
+		if flagKonnectorsMaintenanceStart != "" {
+			opts["start_at"] = flagKonnectorsMaintenanceStart
+		}
+		if flagKonnectorsMaintenanceEnd != "" {
+			opts["end_at"] = flagKonnectorsMaintenanceEnd
+		}
+		if flagKonnectorsMaintenanceReason != "" {
+			opts["reason"] = flagKonnectorsMaintenanceReason
+		}
 		c := newAdminClient()
 		return c.ActivateMaintenance(args[0], opts)
 	},
@@ -456,6 +499,26 @@ func updateApp(cmd *cobra.Command, args []string, appType string) error {
 	return nil
 }
 
+func rollbackApp(cmd *cobra.Command, args []string, appType string) error {
+	if len(args) != 1 {
+		return cmd.Usage()
+	}
+	if flagDomain == "" {
+		errPrintfln("%s", errMissingDomain)
+		return cmd.Usage()
+	}
+	c := newClient(flagDomain, appType)
+	manifest, err := c.RollbackApp(&client.AppOptions{
+		AppType: appType,
+		Slug:    args[0],
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s has been rolled back to %s\n", manifest.Attrs.Slug, manifest.Attrs.Version)
+	return nil
+}
+
 func uninstallApp(cmd *cobra.Command, args []string, appType string) error {
 	if len(args) != 1 {
 		return cmd.Usage()
@@ -662,6 +725,9 @@ func init() {
 	listMaintenancesCmd.PersistentFlags().StringVar(&flagKonnectorContext, "context", "", "include konnectors in maintenance for apps registry of this context")
 	activateMaintenanceKonnectorsCmd.PersistentFlags().BoolVar(&flagKonnectorsShortMaintenance, "short", false, "specify a short maintenance")
 	activateMaintenanceKonnectorsCmd.PersistentFlags().BoolVar(&flagKonnectorsDisallowManualExec, "no-manual-exec", false, "specify a maintenance disallowing manual execution")
+	activateMaintenanceKonnectorsCmd.PersistentFlags().StringVar(&flagKonnectorsMaintenanceStart, "start", "", "start of a scheduled maintenance window (RFC3339 date)")
+	activateMaintenanceKonnectorsCmd.PersistentFlags().StringVar(&flagKonnectorsMaintenanceEnd, "end", "", "end of a scheduled maintenance window (RFC3339 date)")
+	activateMaintenanceKonnectorsCmd.PersistentFlags().StringVar(&flagKonnectorsMaintenanceReason, "reason", "", "reason of the maintenance")
 
 	triggersCmdGroup.PersistentFlags().StringVar(&flagDomain, "domain", cozyDomain(), "specify the domain name of the instance")
 	triggersCmdGroup.AddCommand(launchTriggerCmd)
@@ -673,6 +739,7 @@ func init() {
 	webappsCmdGroup.AddCommand(installWebappCmd)
 	webappsCmdGroup.AddCommand(updateWebappCmd)
 	webappsCmdGroup.AddCommand(uninstallWebappCmd)
+	webappsCmdGroup.AddCommand(rollbackWebappCmd)
 
 	konnectorsCmdGroup.PersistentFlags().StringVar(&flagDomain, "domain", cozyDomain(), "specify the domain name of the instance")
 	konnectorsCmdGroup.PersistentFlags().StringVar(&flagKonnectorsParameters, "parameters", "", "override the parameters of the installed konnector")
@@ -683,6 +750,7 @@ func init() {
 	konnectorsCmdGroup.AddCommand(installKonnectorCmd)
 	konnectorsCmdGroup.AddCommand(updateKonnectorCmd)
 	konnectorsCmdGroup.AddCommand(uninstallKonnectorCmd)
+	konnectorsCmdGroup.AddCommand(rollbackKonnectorCmd)
 	konnectorsCmdGroup.AddCommand(runKonnectorsCmd)
 	konnectorsCmdGroup.AddCommand(listMaintenancesCmd)
 	konnectorsCmdGroup.AddCommand(activateMaintenanceKonnectorsCmd)
@@ -409,6 +409,28 @@ this instance are correctly set.
 	},
 }
 
+var refreshTokensFixer = &cobra.Command{
+	Use:   "refresh-tokens <domain>",
+	Short: "Purge the records of the revoked refresh tokens",
+	Long: `
+This fixer deletes the records of the refresh tokens that have been revoked,
+or that were issued to an OAuth client that has been deleted.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		domain := args[0]
+		c := newAdminClient()
+		path := fmt.Sprintf("/instances/%s/fixers/refresh-tokens", domain)
+		_, err := c.Req(&request.Options{
+			Method: "POST",
+			Path:   path,
+		})
+		return err
+	},
+}
+
 func init() {
 	thumbnailsFixer.Flags().BoolVar(&dryRunFlag, "dry-run", false, "Dry run")
 	thumbnailsFixer.Flags().BoolVar(&withMetadataFlag, "with-metadata", false, "Recalculate images metadata")
@@ -423,6 +445,7 @@ func init() {
 	fixerCmdGroup.AddCommand(contentMismatch64Kfixer)
 	fixerCmdGroup.AddCommand(orphanAccountFixer)
 	fixerCmdGroup.AddCommand(indexesFixer)
+	fixerCmdGroup.AddCommand(refreshTokensFixer)
 
 	RootCmd.AddCommand(fixerCmdGroup)
 }
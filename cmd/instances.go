@@ -54,6 +54,7 @@ var flagOnboardingFinished bool
 var flagTTL time.Duration
 var flagExpire time.Duration
 var flagAllowLoginScope bool
+var flagAllowIntrospection bool
 var flagAvailableFields bool
 var flagOnboardingSecret string
 var flagOnboardingApp string
@@ -797,6 +798,7 @@ var oauthClientInstanceCmd = &cobra.Command{
 			ClientName:            args[2],
 			SoftwareID:            args[3],
 			AllowLoginScope:       flagAllowLoginScope,
+			AllowIntrospection:    flagAllowIntrospection,
 			OnboardingSecret:      flagOnboardingSecret,
 			OnboardingApp:         flagOnboardingApp,
 			OnboardingPermissions: flagOnboardingPermissions,
@@ -1119,6 +1121,7 @@ func init() {
 	fsckInstanceCmd.Flags().BoolVar(&flagJSON, "json", false, "Output more informations in JSON format")
 	oauthClientInstanceCmd.Flags().BoolVar(&flagJSON, "json", false, "Output more informations in JSON format")
 	oauthClientInstanceCmd.Flags().BoolVar(&flagAllowLoginScope, "allow-login-scope", false, "Allow login scope")
+	oauthClientInstanceCmd.Flags().BoolVar(&flagAllowIntrospection, "allow-introspection", false, "Allow to introspect the tokens of the other clients")
 	oauthClientInstanceCmd.Flags().StringVar(&flagOnboardingSecret, "onboarding-secret", "", "Specify an OnboardingSecret")
 	oauthClientInstanceCmd.Flags().StringVar(&flagOnboardingApp, "onboarding-app", "", "Specify an OnboardingApp")
 	oauthClientInstanceCmd.Flags().StringVar(&flagOnboardingPermissions, "onboarding-permissions", "", "Specify an OnboardingPermissions")
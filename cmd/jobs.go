@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"os"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/cozy/cozy-stack/client"
@@ -18,8 +20,14 @@ import (
 var flagJobJSONArg string
 var flagJobPrintLogs bool
 var flagJobPrintLogsVerbose bool
+var flagJobPriority string
 var flagJobWorkers []string
 var flagJobsPurgeDuration string
+var flagJobsStatsWindow string
+var flagJobsStatsGroupBy []string
+var flagJobsStatsWorker string
+var flagJobsStatsSlug string
+var flagJobsStatsDomain string
 
 var jobsCmdGroup = &cobra.Command{
 	Use:   "jobs <command>",
@@ -44,6 +52,7 @@ var jobsRunCmd = &cobra.Command{
 		o := &client.JobOptions{
 			Worker:    args[0],
 			Arguments: json.RawMessage(flagJobJSONArg),
+			Priority:  flagJobPriority,
 		}
 		if flagJobPrintLogs {
 			o.Logs = make(chan *client.JobLog)
@@ -113,17 +122,168 @@ var jobsPurgeCmd = &cobra.Command{
 	},
 }
 
+var jobsStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show statistics on the jobs that have finished recently",
+	Long: `
+Show the number of jobs, the error rate, and the p50/p95 of the durations and
+of the waiting times in the queues (in seconds), for the jobs that have
+finished during a sliding window. The jobs are grouped by worker type, slug of
+the konnector or application, and domain, and the slowest groups come first.
+`,
+	Example: "$ cozy-stack jobs stats --window 30m --group-by worker,slug --worker konnector",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c := newAdminClient()
+		list, err := c.JobsStats(&client.JobsStatsOptions{
+			Window:  flagJobsStatsWindow,
+			GroupBy: strings.Join(flagJobsStatsGroupBy, ","),
+			Worker:  flagJobsStatsWorker,
+			Slug:    flagJobsStatsSlug,
+			Domain:  flagJobsStatsDomain,
+		})
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(list)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "WORKER\tSLUG\tDOMAIN\tCOUNT\tERRORS\tDURATION P50\tDURATION P95\tWAIT P50\tWAIT P95")
+		for _, s := range list {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%.0f%%\t%.1f\t%.1f\t%.1f\t%.1f\n",
+				valueOrDash(s["worker"]), valueOrDash(s["slug"]), valueOrDash(s["domain"]),
+				s["count"], 100*toFloat(s["error_rate"]),
+				toFloat(s["duration_p50"]), toFloat(s["duration_p95"]),
+				toFloat(s["queue_wait_p50"]), toFloat(s["queue_wait_p95"]))
+		}
+		return w.Flush()
+	},
+}
+
+func valueOrDash(v interface{}) interface{} {
+	if v == nil || v == "" {
+		return "-"
+	}
+	return v
+}
+
+func toFloat(v interface{}) float64 {
+	f, _ := v.(float64)
+	return f
+}
+
+var jobsDeadLettersCmdGroup = &cobra.Command{
+	Use:   "dead-letters <command>",
+	Short: "Manage the jobs that have exhausted their retries",
+	Long: `
+The jobs that have failed after all their retries are moved to a dead-letter
+queue, for the workers where it has been enabled in the configuration file.
+These commands can be used to inspect, replay or discard them.
+`,
+}
+
+var jobsDeadLettersLsCmd = &cobra.Command{
+	Use:     "ls <worker>",
+	Short:   "List the jobs in the dead-letter queue of a worker",
+	Example: "$ cozy-stack jobs dead-letters ls konnector",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		c := newAdminClient()
+		list, err := c.ListDeadLetters(args[0])
+		if err != nil {
+			return err
+		}
+		for _, j := range list {
+			fmt.Printf("%s\t%s\t%s\t%s\n", j["_id"], j["domain"], j["finished_at"], j["error"])
+		}
+		return nil
+	},
+}
+
+var jobsDeadLettersShowCmd = &cobra.Command{
+	Use:     "show <worker> <job-id>",
+	Short:   "Show a job from the dead-letter queue of a worker",
+	Example: "$ cozy-stack jobs dead-letters show konnector 4a1d38e0d5b1013989b0543d7eb8149c",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return cmd.Usage()
+		}
+		c := newAdminClient()
+		j, err := c.GetDeadLetter(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(j, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	},
+}
+
+var jobsDeadLettersReplayCmd = &cobra.Command{
+	Use:     "replay <worker> <job-id>",
+	Short:   "Push again a job from the dead-letter queue of a worker",
+	Example: "$ cozy-stack jobs dead-letters replay konnector 4a1d38e0d5b1013989b0543d7eb8149c",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return cmd.Usage()
+		}
+		c := newAdminClient()
+		j, err := c.ReplayDeadLetter(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Job %s has been pushed\n", j["_id"])
+		return nil
+	},
+}
+
+var jobsDeadLettersDiscardCmd = &cobra.Command{
+	Use:     "discard <worker> <job-id>",
+	Aliases: []string{"rm"},
+	Short:   "Remove a job from the dead-letter queue of a worker",
+	Example: "$ cozy-stack jobs dead-letters discard konnector 4a1d38e0d5b1013989b0543d7eb8149c",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return cmd.Usage()
+		}
+		c := newAdminClient()
+		return c.DiscardDeadLetter(args[0], args[1])
+	},
+}
+
 func init() {
 	jobsCmdGroup.PersistentFlags().StringVar(&flagDomain, "domain", cozyDomain(), "specify the domain name of the instance")
 
 	jobsRunCmd.Flags().StringVar(&flagJobJSONArg, "json", "", "specify the job arguments as raw JSON")
 	jobsRunCmd.Flags().BoolVar(&flagJobPrintLogs, "logs", false, "print jobs log in stdout")
 	jobsRunCmd.Flags().BoolVar(&flagJobPrintLogsVerbose, "logs-verbose", false, "verbose logging (with --logs flag)")
+	jobsRunCmd.Flags().StringVar(&flagJobPriority, "priority", "", "specify the priority of the job: high, normal or low")
 
 	jobsPurgeCmd.Flags().StringSliceVar(&flagJobWorkers, "workers", nil, "worker types to iterate over (all workers by default)")
 	jobsPurgeCmd.Flags().StringVar(&flagJobsPurgeDuration, "duration", "", "duration to look for (ie. 3D, 2M)")
 
+	jobsStatsCmd.Flags().StringVar(&flagJobsStatsWindow, "window", "", "duration of the sliding window (1h by default, 24h at most)")
+	jobsStatsCmd.Flags().StringSliceVar(&flagJobsStatsGroupBy, "group-by", nil, "fields used to group the jobs: worker, slug and/or domain (all by default)")
+	jobsStatsCmd.Flags().StringVar(&flagJobsStatsWorker, "worker", "", "keep only the jobs for this worker type")
+	jobsStatsCmd.Flags().StringVar(&flagJobsStatsSlug, "slug", "", "keep only the jobs for this konnector or application")
+	jobsStatsCmd.Flags().StringVar(&flagJobsStatsDomain, "instance", "", "keep only the jobs for this instance (domain)")
+	jobsStatsCmd.Flags().BoolVar(&flagJSON, "json", false, "Output the statistics in JSON format")
+
 	jobsCmdGroup.AddCommand(jobsRunCmd)
 	jobsCmdGroup.AddCommand(jobsPurgeCmd)
+	jobsCmdGroup.AddCommand(jobsStatsCmd)
+
+	jobsDeadLettersCmdGroup.AddCommand(jobsDeadLettersLsCmd)
+	jobsDeadLettersCmdGroup.AddCommand(jobsDeadLettersShowCmd)
+	jobsDeadLettersCmdGroup.AddCommand(jobsDeadLettersReplayCmd)
+	jobsDeadLettersCmdGroup.AddCommand(jobsDeadLettersDiscardCmd)
+	jobsCmdGroup.AddCommand(jobsDeadLettersCmdGroup)
 	RootCmd.AddCommand(jobsCmdGroup)
 }
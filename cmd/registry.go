@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	"github.com/cozy/cozy-stack/client/request"
+	"github.com/spf13/cobra"
+)
+
+var flagRegistrySignature string
+
+var registryCmdGroup = &cobra.Command{
+	Use:   "registry <command>",
+	Short: "Manage the applications on the registry built in the stack",
+	Long: `
+cozy-stack registry allows to publish applications on the registry served by
+the stack itself. This registry can be used by the contexts with a local://
+URL in their registries, for example when the stack cannot reach the internet.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Usage()
+	},
+}
+
+var registryPublishCmd = &cobra.Command{
+	Use:   "publish <tarball>",
+	Short: "Publish a new version of an application",
+	Long: `
+cozy-stack registry publish can be used to publish a new version of an
+application on the registry built in the stack. The slug, version and type of
+the application are read from the manifest inside the tarball. The channel is
+deduced from the version: dev for a -dev suffix, beta for a -beta suffix, and
+stable otherwise.
+
+A minisign signature can be given with the --signature flag. By default, the
+<tarball>.minisig file is used if it exists.
+`,
+	Example: "$ cozy-stack registry publish drive-1.2.3.tar.gz",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		tarball, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer tarball.Close()
+
+		sigFile := flagRegistrySignature
+		if sigFile == "" {
+			if _, err := os.Stat(args[0] + ".minisig"); err == nil {
+				sigFile = args[0] + ".minisig"
+			}
+		}
+		headers := request.Headers{
+			"Content-Type": "application/gzip",
+		}
+		if sigFile != "" {
+			sig, err := ioutil.ReadFile(sigFile)
+			if err != nil {
+				return err
+			}
+			headers["X-Cozy-Minisign-Signature"] = base64.StdEncoding.EncodeToString(sig)
+		}
+
+		c := newAdminClient()
+		res, err := c.Req(&request.Options{
+			Method:  "POST",
+			Path:    "/registry/",
+			Body:    tarball,
+			Headers: headers,
+		})
+		if err != nil {
+			return err
+		}
+		return printRegistryVersion(res.Body)
+	},
+}
+
+var registryDeprecateCmd = &cobra.Command{
+	Use:   "deprecate <slug> <version>",
+	Short: "Deprecate a version of an application",
+	Long: `
+cozy-stack registry deprecate marks a version of an application as deprecated.
+It can still be installed explicitly, but it is no longer given as the latest
+version of its channel.
+`,
+	Example: "$ cozy-stack registry deprecate drive 1.2.3",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return cmd.Usage()
+		}
+		c := newAdminClient()
+		res, err := c.Req(&request.Options{
+			Method: "POST",
+			Path: fmt.Sprintf("/registry/%s/%s/deprecate",
+				url.PathEscape(args[0]), url.PathEscape(args[1])),
+		})
+		if err != nil {
+			return err
+		}
+		return printRegistryVersion(res.Body)
+	},
+}
+
+func printRegistryVersion(body io.ReadCloser) error {
+	defer body.Close()
+	var v struct {
+		Slug       string `json:"slug"`
+		Type       string `json:"type"`
+		Version    string `json:"version"`
+		Channel    string `json:"channel"`
+		Sha256     string `json:"sha256"`
+		Size       int64  `json:"size"`
+		Signature  string `json:"signature,omitempty"`
+		Deprecated bool   `json:"deprecated,omitempty"`
+	}
+	if err := json.NewDecoder(body).Decode(&v); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func init() {
+	registryPublishCmd.Flags().StringVar(&flagRegistrySignature, "signature", "", "Path to the minisign signature of the tarball")
+
+	registryCmdGroup.AddCommand(registryPublishCmd)
+	registryCmdGroup.AddCommand(registryDeprecateCmd)
+
+	RootCmd.AddCommand(registryCmdGroup)
+}
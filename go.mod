@@ -44,6 +44,7 @@ require (
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/viper v1.9.0
 	github.com/stretchr/testify v1.7.0
+	github.com/tetratelabs/wazero v1.0.0
 	github.com/ugorji/go/codec v1.2.6
 	github.com/yuin/goldmark v1.4.4
 	golang.org/x/crypto v0.0.0-20211202192323-5770296d904e
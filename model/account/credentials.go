@@ -32,18 +32,7 @@ func EncryptCredentialsWithKey(encryptorKey *keymgmt.NACLKey, login, password st
 		return "", errCannotEncrypt
 	}
 
-	loginLen := len(login)
-
-	// make a buffer containing the length of the login in bigendian over 4
-	// bytes, followed by the login and password contatenated.
-	creds := make([]byte, plainPrefixLen+loginLen+len(password))
-
-	// put the length of login in the first 4 bytes
-	binary.BigEndian.PutUint32(creds[0:], uint32(loginLen))
-
-	// copy the concatenation of login + password in the end
-	copy(creds[plainPrefixLen:], login)
-	copy(creds[plainPrefixLen+loginLen:], password)
+	creds := packCredentials(login, password)
 
 	var nonce [nonceLen]byte
 	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
@@ -143,6 +132,30 @@ func DecryptCredentialsWithKey(decryptorKey *keymgmt.NACLKey, encryptedCreds []b
 	if !ok {
 		return "", "", ErrBadCredentials
 	}
+	return unpackCredentials(creds)
+}
+
+// packCredentials makes a buffer containing the length of the login in
+// bigendian over 4 bytes, followed by the login and password contatenated.
+func packCredentials(login, password string) []byte {
+	loginLen := len(login)
+	creds := make([]byte, plainPrefixLen+loginLen+len(password))
+
+	// put the length of login in the first 4 bytes
+	binary.BigEndian.PutUint32(creds[0:], uint32(loginLen))
+
+	// copy the concatenation of login + password in the end
+	copy(creds[plainPrefixLen:], login)
+	copy(creds[plainPrefixLen+loginLen:], password)
+	return creds
+}
+
+// unpackCredentials is the reverse operation of packCredentials.
+func unpackCredentials(creds []byte) (login, password string, err error) {
+	// check the plain text is long enough to contain the login length
+	if len(creds) < plainPrefixLen {
+		return "", "", ErrBadCredentials
+	}
 
 	// extract login length from 4 first bytes
 	loginLen := int(binary.BigEndian.Uint32(creds[0:]))
@@ -0,0 +1,200 @@
+package account
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// instanceCipherHeader is the header of the credentials encrypted with the
+// key of an instance, instead of the vault key of the stack.
+const instanceCipherHeader = "inst"
+
+// ErrCredentialsLocked is used when the credentials are encrypted with the
+// key of the instance, but this key has not been unlocked by a login of the
+// user.
+var ErrCredentialsLocked = errors.New("accounts: the credentials vault is locked")
+
+// InstanceKey is the key pair of an instance for its credentials vault. The
+// public key is always available, and is used to encrypt the credentials.
+// The private key is only available when the vault has been unlocked.
+type InstanceKey struct {
+	PublicKey  *[32]byte
+	PrivateKey *[32]byte
+}
+
+// GenerateInstanceKey creates a new key pair for the credentials vault of an
+// instance.
+func GenerateInstanceKey() (*InstanceKey, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &InstanceKey{PublicKey: pub, PrivateKey: priv}, nil
+}
+
+// Locked returns true if the private key is not available.
+func (k *InstanceKey) Locked() bool {
+	return k.PrivateKey == nil
+}
+
+// IsEncryptedForInstance returns true if the given encrypted credentials have
+// been encrypted with the key of an instance.
+func IsEncryptedForInstance(encryptedData string) bool {
+	buf, err := base64.StdEncoding.DecodeString(encryptedData)
+	if err != nil {
+		return false
+	}
+	return bytes.HasPrefix(buf, []byte(instanceCipherHeader))
+}
+
+func (k *InstanceKey) encrypt(buf []byte) (string, error) {
+	out := []byte(instanceCipherHeader)
+	out, err := box.SealAnonymous(out, buf, k.PublicKey, rand.Reader)
+	if err != nil {
+		return "", errCannotEncrypt
+	}
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+func (k *InstanceKey) decrypt(encryptedData string) ([]byte, error) {
+	if k.Locked() {
+		return nil, ErrCredentialsLocked
+	}
+	buf, err := base64.StdEncoding.DecodeString(encryptedData)
+	if err != nil {
+		return nil, errCannotDecrypt
+	}
+	if !bytes.HasPrefix(buf, []byte(instanceCipherHeader)) {
+		return nil, ErrBadCredentials
+	}
+	buf = buf[len(instanceCipherHeader):]
+	plain, ok := box.OpenAnonymous(nil, buf, k.PublicKey, k.PrivateKey)
+	if !ok {
+		return nil, ErrBadCredentials
+	}
+	return plain, nil
+}
+
+// Cipher is used to encrypt and decrypt the credentials of the accounts of
+// an instance. When the instance has a credentials vault, the new credentials
+// are encrypted with its key. Else, the vault key of the stack is used.
+type Cipher struct {
+	key *InstanceKey
+}
+
+// NewCipher returns a cipher for the given instance key, that can be nil for
+// the instances without a credentials vault.
+func NewCipher(key *InstanceKey) *Cipher {
+	return &Cipher{key: key}
+}
+
+// Locked returns true if the instance has a credentials vault that has not
+// been unlocked.
+func (c *Cipher) Locked() bool {
+	return c.key != nil && c.key.Locked()
+}
+
+// CanEncrypt returns true if the credentials can be encrypted.
+func (c *Cipher) CanEncrypt() bool {
+	return c.key != nil || config.GetVault().CredentialsEncryptorKey() != nil
+}
+
+// CanDecrypt returns true if some credentials may be decrypted.
+func (c *Cipher) CanDecrypt() bool {
+	return c.key != nil || config.GetVault().CredentialsDecryptorKey() != nil
+}
+
+// EncryptCredentials encrypts a login / password pair.
+func (c *Cipher) EncryptCredentials(login, password string) (string, error) {
+	if c.key == nil {
+		return EncryptCredentials(login, password)
+	}
+	return c.key.encrypt(packCredentials(login, password))
+}
+
+// EncryptData encodes in JSON and encrypts the given data.
+func (c *Cipher) EncryptData(data interface{}) (string, error) {
+	if c.key == nil {
+		return EncryptCredentialsData(data)
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return c.key.encrypt(buf)
+}
+
+// DecryptCredentials decrypts a login / password pair, with the key of the
+// instance or the vault key of the stack, depending on how they have been
+// encrypted.
+func (c *Cipher) DecryptCredentials(encryptedData string) (login, password string, err error) {
+	if !IsEncryptedForInstance(encryptedData) {
+		return DecryptCredentials(encryptedData)
+	}
+	if c.key == nil {
+		return "", "", ErrCredentialsLocked
+	}
+	creds, err := c.key.decrypt(encryptedData)
+	if err != nil {
+		return "", "", err
+	}
+	return unpackCredentials(creds)
+}
+
+// DecryptData decrypts and decodes some data, with the key of the instance or
+// the vault key of the stack, depending on how they have been encrypted.
+func (c *Cipher) DecryptData(encryptedData string) (interface{}, error) {
+	if !IsEncryptedForInstance(encryptedData) {
+		return DecryptCredentialsData(encryptedData)
+	}
+	if c.key == nil {
+		return nil, ErrCredentialsLocked
+	}
+	buf, err := c.key.decrypt(encryptedData)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err = json.Unmarshal(buf, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Upgrade re-encrypts with the key of the instance a value that was
+// encrypted with the vault key of the stack. The field is the name of the
+// encrypted field in the account, as credentials_encrypted has a special
+// format. It returns false if the value does not need to be upgraded, or
+// cannot be.
+func (c *Cipher) Upgrade(field, encryptedData string) (string, bool) {
+	if c.key == nil || IsEncryptedForInstance(encryptedData) {
+		return "", false
+	}
+	var upgraded string
+	if field == "credentials_encrypted" {
+		login, password, err := DecryptCredentials(encryptedData)
+		if err != nil {
+			return "", false
+		}
+		upgraded, err = c.EncryptCredentials(login, password)
+		if err != nil {
+			return "", false
+		}
+	} else {
+		data, err := DecryptCredentialsData(encryptedData)
+		if err != nil {
+			return "", false
+		}
+		upgraded, err = c.EncryptData(data)
+		if err != nil {
+			return "", false
+		}
+	}
+	return upgraded, true
+}
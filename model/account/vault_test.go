@@ -0,0 +1,66 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceCipher(t *testing.T) {
+	key, err := GenerateInstanceKey()
+	require.NoError(t, err)
+	cipher := NewCipher(key)
+	assert.False(t, cipher.Locked())
+
+	encrypted, err := cipher.EncryptCredentials("me@mycozy.cloud", "fzEE6HFWsSp8jP")
+	require.NoError(t, err)
+	assert.True(t, IsEncryptedForInstance(encrypted))
+	login, password, err := cipher.DecryptCredentials(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "me@mycozy.cloud", login)
+	assert.Equal(t, "fzEE6HFWsSp8jP", password)
+
+	data, err := cipher.EncryptData(map[string]interface{}{"token": "abc"})
+	require.NoError(t, err)
+	decrypted, err := cipher.DecryptData(data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"token": "abc"}, decrypted)
+
+	// The stack cannot decrypt the credentials of the instance
+	_, _, err = DecryptCredentials(encrypted)
+	assert.Equal(t, ErrBadCredentials, err)
+
+	locked := NewCipher(&InstanceKey{PublicKey: key.PublicKey})
+	assert.True(t, locked.Locked())
+	_, _, err = locked.DecryptCredentials(encrypted)
+	assert.Equal(t, ErrCredentialsLocked, err)
+	// but it can still encrypt new credentials
+	encrypted, err = locked.EncryptCredentials("me@mycozy.cloud", "n3wP4ss")
+	require.NoError(t, err)
+	_, password, err = cipher.DecryptCredentials(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "n3wP4ss", password)
+}
+
+func TestCipherUpgrade(t *testing.T) {
+	stackEncrypted, err := EncryptCredentials("me@mycozy.cloud", "fzEE6HFWsSp8jP")
+	require.NoError(t, err)
+
+	_, ok := NewCipher(nil).Upgrade("credentials_encrypted", stackEncrypted)
+	assert.False(t, ok)
+
+	key, err := GenerateInstanceKey()
+	require.NoError(t, err)
+	cipher := NewCipher(&InstanceKey{PublicKey: key.PublicKey})
+	upgraded, ok := cipher.Upgrade("credentials_encrypted", stackEncrypted)
+	require.True(t, ok)
+	assert.True(t, IsEncryptedForInstance(upgraded))
+	_, ok = cipher.Upgrade("credentials_encrypted", upgraded)
+	assert.False(t, ok)
+
+	login, password, err := NewCipher(key).DecryptCredentials(upgraded)
+	require.NoError(t, err)
+	assert.Equal(t, "me@mycozy.cloud", login)
+	assert.Equal(t, "fzEE6HFWsSp8jP", password)
+}
@@ -72,6 +72,8 @@ type Manifest interface {
 	Source() string
 	Version() string
 	AvailableVersion() string
+	PendingUpdate() *PendingUpdate
+	Signature() *SignatureStatus
 	Checksum() string
 	Slug() string
 	State() State
@@ -90,6 +92,8 @@ type Manifest interface {
 	SetState(state State)
 	SetVersion(version string)
 	SetAvailableVersion(version string)
+	SetPendingUpdate(pending *PendingUpdate)
+	SetSignature(status *SignatureStatus)
 	SetChecksum(shasum string)
 }
 
@@ -23,6 +23,9 @@ var (
 	ErrSourceNotReachable = errors.New("Application source is not reachable")
 	// ErrBadManifest when the manifest is not valid or malformed
 	ErrBadManifest = errors.New("Application manifest is invalid or malformed")
+	// ErrInvalidRoute is used when a route of the manifest of a webapp has
+	// headers, a CSP extension or a redirection that are not allowed.
+	ErrInvalidRoute = errors.New("A route of the application manifest is not valid")
 	// ErrBadState is used when trying to use the application while in a
 	// state that is not appropriate for the given operation.
 	ErrBadState = errors.New("Application is not in valid state to perform this operation")
@@ -34,4 +37,25 @@ var (
 	ErrBadChecksum = errors.New("Application checksum does not match")
 	// ErrLinkedAppExists is used when an OAuth client is linked to this app
 	ErrLinkedAppExists = errors.New("A linked OAuth client exists for this app")
+	// ErrHealthCheck is used when the new version of an application has not
+	// passed the health checks, and the update has been cancelled.
+	ErrHealthCheck = errors.New("The new version of the application has not passed the health checks")
+	// ErrNoPreviousVersion is used when there is no previous version to
+	// rollback to.
+	ErrNoPreviousVersion = errors.New("There is no previous version of this application")
+	// ErrNoPendingUpdate is used when trying to approve an update of an
+	// application, but there is no update waiting for an approval.
+	ErrNoPendingUpdate = errors.New("There is no pending update for this application")
+	// ErrMissingSignature is used when the package of an application has no
+	// signature, but the signatures are required.
+	ErrMissingSignature = errors.New("The application package is not signed")
+	// ErrBadSignature is used when the signature of the package of an
+	// application is not valid, or has not been made by a trusted key.
+	ErrBadSignature = errors.New("The application package signature is not valid")
+	// ErrManifestMismatch is used when the manifest in the signed package of
+	// an application is not the manifest used to check its permissions.
+	ErrManifestMismatch = errors.New("The manifest of the application package is not the published one")
+	// ErrInvalidMaintenanceWindow is used when the dates of a maintenance
+	// window cannot be parsed, or the window has already ended.
+	ErrInvalidMaintenanceWindow = errors.New("The maintenance window is not valid")
 )
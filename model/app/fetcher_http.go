@@ -6,6 +6,7 @@ import (
 	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"hash"
 	"io"
 	"io/ioutil"
@@ -13,12 +14,14 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"reflect"
 	"strings"
 	"time"
 
 	"github.com/cozy/cozy-stack/pkg/appfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/logger"
-	"github.com/cozy/cozy-stack/pkg/utils"
 )
 
 var httpClient = http.Client{
@@ -28,12 +31,15 @@ var httpClient = http.Client{
 type httpFetcher struct {
 	manFilename string
 	prefix      string
+	manifest    []byte
+	signatures  *signatureCheck
 	log         *logger.Entry
 }
 
-func newHTTPFetcher(manFilename string, log *logger.Entry) *httpFetcher {
+func newHTTPFetcher(manFilename string, signatures *signatureCheck, log *logger.Entry) *httpFetcher {
 	return &httpFetcher{
 		manFilename: manFilename,
+		signatures:  signatures,
 		log:         log,
 	}
 }
@@ -92,9 +98,14 @@ func (f *httpFetcher) FetchManifest(src *url.URL) (r io.ReadCloser, err error) {
 		if baseName != hdr.Name {
 			f.prefix = path.Dir(hdr.Name) + "/"
 		}
-		return utils.ReadCloser(tarReader, func() error {
-			return resp.Body.Close()
-		}), nil
+		// The manifest is kept to be compared with the one of the tarball
+		// downloaded by Fetch.
+		f.manifest, err = ioutil.ReadAll(io.LimitReader(tarReader, ManifestMaxSize))
+		if err != nil {
+			return nil, ErrManifestNotReachable
+		}
+		resp.Body.Close()
+		return ioutil.NopCloser(bytes.NewReader(f.manifest)), nil
 	}
 }
 
@@ -103,21 +114,40 @@ func (f *httpFetcher) Fetch(src *url.URL, fs appfs.Copier, man Manifest) (err er
 	if frag := src.Fragment; frag != "" {
 		shasum, _ = hex.DecodeString(frag)
 	}
-	return fetchHTTP(src, shasum, fs, man, f.prefix)
+	return fetchHTTP(src, shasum, fs, man, f.manifest, f.prefix, f.signatures, "")
 }
 
-func fetchHTTP(src *url.URL, shasum []byte, fs appfs.Copier, man Manifest, prefix string) (err error) {
+// fetchHTTP downloads the tarball of an application and copies its files. If
+// the signatures are checked, the signature of the tarball is verified, and
+// its status is set on the manifest: in this case, the tarball is also
+// downloaded when its files are already stored, as the signature must be
+// verified for each installation. When the signature is valid, the manifest
+// in the tarball must also be the same as the raw manifest given, which has
+// been used to check the permissions of the application, as it comes from
+// another request.
+func fetchHTTP(src *url.URL, shasum []byte, fs appfs.Copier, man Manifest, manifest []byte, prefix string, signatures *signatureCheck, signature string) (err error) {
 	exists, err := fs.Start(man.Slug(), man.Version(), man.Checksum())
-	if err != nil || exists {
+	if err != nil || (exists && signatures == nil) {
 		return err
 	}
-	defer func() {
-		if err != nil {
-			_ = fs.Abort()
-		} else {
-			err = fs.Commit()
+	if !exists {
+		defer func() {
+			if err != nil {
+				_ = fs.Abort()
+			} else {
+				err = fs.Commit()
+			}
+		}()
+	}
+
+	if signatures != nil && signature == "" {
+		if signature, err = fetchSignature(src); err != nil {
+			if signatures.policy == config.SignaturesRequired {
+				return err
+			}
+			signatures.log.Warnf("Cannot fetch the signature of %s: %s", src.String(), err)
 		}
-	}()
+	}
 
 	req, err := http.NewRequest(http.MethodGet, src.String(), nil)
 	if err != nil {
@@ -141,12 +171,17 @@ func fetchHTTP(src *url.URL, shasum []byte, fs appfs.Copier, man Manifest, prefi
 	}
 
 	var reader io.Reader = resp.Body
-	var h hash.Hash
+	var h, sigHash hash.Hash
 
 	if len(shasum) > 0 {
 		h = sha256.New()
 		reader = io.TeeReader(reader, h)
 	}
+	if signatures != nil {
+		sigHash = signatures.newHash()
+		reader = io.TeeReader(reader, sigHash)
+	}
+	raw := reader
 
 	contentType := resp.Header.Get("Content-Type")
 	switch contentType {
@@ -165,6 +200,8 @@ func fetchHTTP(src *url.URL, shasum []byte, fs appfs.Copier, man Manifest, prefi
 		}
 	}
 
+	var tarManifest []byte
+	manFilename := manifestFilename(man.AppType())
 	tarReader := tar.NewReader(reader)
 	for {
 		hdr, err := tarReader.Next()
@@ -181,14 +218,65 @@ func fetchHTTP(src *url.URL, shasum []byte, fs appfs.Copier, man Manifest, prefi
 		if len(prefix) > 0 && strings.HasPrefix(path.Join("/", name), path.Join("/", prefix)) {
 			name = name[len(prefix):]
 		}
+		var content io.Reader = tarReader
+		if signatures != nil && name == manFilename {
+			if tarManifest, err = ioutil.ReadAll(io.LimitReader(tarReader, ManifestMaxSize)); err != nil {
+				return err
+			}
+			content = bytes.NewReader(tarManifest)
+		}
+		if exists {
+			continue
+		}
 		fileinfo := appfs.NewFileInfo(name, hdr.Size, os.FileMode(hdr.Mode))
-		err = fs.Copy(fileinfo, tarReader)
+		err = fs.Copy(fileinfo, content)
 		if err != nil {
 			return err
 		}
 	}
+	if signatures != nil {
+		// The tar reader can stop before the end of the tarball
+		if _, err = io.Copy(ioutil.Discard, raw); err != nil {
+			return err
+		}
+	}
 	if len(shasum) > 0 && !bytes.Equal(shasum, h.Sum(nil)) {
 		return ErrBadChecksum
 	}
+	if signatures != nil {
+		status, err := signatures.verify(signature, sigHash.Sum(nil))
+		man.SetSignature(status)
+		if err != nil {
+			return err
+		}
+		if status.Status == SignatureValid && !sameManifest(manifest, tarManifest) {
+			return ErrManifestMismatch
+		}
+	}
 	return nil
 }
+
+// manifestFilename returns the name of the manifest file in the tarball of
+// an application of the given type.
+func manifestFilename(appType consts.AppType) string {
+	if appType == consts.KonnectorType {
+		return KonnectorManifestName
+	}
+	return WebappManifestName
+}
+
+// sameManifest returns true if the two raw manifests have the same JSON
+// content, regardless of their formatting.
+func sameManifest(a, b []byte) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	var valA, valB interface{}
+	if err := json.NewDecoder(bytes.NewReader(a)).Decode(&valA); err != nil {
+		return false
+	}
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&valB); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(valA, valB)
+}
@@ -17,11 +17,12 @@ import (
 type registryFetcher struct {
 	log        *logger.Entry
 	registries []*url.URL
+	signatures *signatureCheck
 	version    *registry.Version
 }
 
-func newRegistryFetcher(registries []*url.URL, log *logger.Entry) Fetcher {
-	return &registryFetcher{log: log, registries: registries}
+func newRegistryFetcher(registries []*url.URL, signatures *signatureCheck, log *logger.Entry) Fetcher {
+	return &registryFetcher{log: log, registries: registries, signatures: signatures}
 }
 
 func (f *registryFetcher) FetchManifest(src *url.URL) (io.ReadCloser, error) {
@@ -60,7 +61,8 @@ func (f *registryFetcher) Fetch(src *url.URL, fs appfs.Copier, man Manifest) err
 	}
 	man.SetVersion(v.Version)
 	man.SetChecksum(v.Sha256)
-	return fetchHTTP(u, shasum, fs, man, v.TarPrefix)
+	signatures := f.signatures.forRegistry(v.Registry)
+	return fetchHTTP(u, shasum, fs, man, v.Manifest, v.TarPrefix, signatures, v.Signature)
 }
 
 func getRegistryChannel(src *url.URL) (string, string) {
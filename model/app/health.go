@@ -0,0 +1,164 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/appfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+)
+
+var smokeClient = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+// recordingCopier is a copier that keeps the names of the copied files, to
+// check them before switching to the new version of an application.
+type recordingCopier struct {
+	appfs.Copier
+	files map[string]bool
+}
+
+func newRecordingCopier(fs appfs.Copier) *recordingCopier {
+	return &recordingCopier{Copier: fs, files: make(map[string]bool)}
+}
+
+func (r *recordingCopier) Copy(stat os.FileInfo, src io.Reader) error {
+	r.files[path.Join("/", stat.Name())] = true
+	return r.Copier.Copy(stat, src)
+}
+
+// checkHealth checks that the new version of an application, whose files
+// have been fetched, can replace the installed version: the manifest must be
+// valid, the files of the routes and services must be present, the triggers
+// of the services must be valid, and the optional smoke endpoint must accept
+// the new version.
+//
+// When the files have not been copied, because this version was already
+// stored, the checks on the files are skipped.
+func (i *Installer) checkHealth(man Manifest, files map[string]bool) error {
+	if err := checkManifestHealth(man, files); err != nil {
+		return fmt.Errorf("%w: %s", ErrHealthCheck, err)
+	}
+	if webapp, ok := man.(*WebappManifest); ok {
+		if err := i.checkServicesHealth(webapp); err != nil {
+			return fmt.Errorf("%w: %s", ErrHealthCheck, err)
+		}
+	}
+	if err := i.callSmokeEndpoint(man); err != nil {
+		return fmt.Errorf("%w: %s", ErrHealthCheck, err)
+	}
+	return nil
+}
+
+func checkManifestHealth(man Manifest, files map[string]bool) error {
+	if man.Version() == "" {
+		return errors.New("the manifest has no version")
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	var required []string
+	switch m := man.(type) {
+	case *WebappManifest:
+		required = append(required, "/"+WebappManifestName)
+		// Only the routes declared in the manifest are checked, not the
+		// default one
+		if _, ok := m.doc.M["routes"]; ok {
+			for _, route := range m.val.Routes {
+				if route.Index != "" {
+					required = append(required, path.Join("/", route.Folder, route.Index))
+				}
+			}
+		}
+		for _, service := range m.val.Services {
+			required = append(required, path.Join("/", service.File))
+		}
+	case *KonnManifest:
+		required = append(required, "/"+KonnectorManifestName)
+	}
+	for _, file := range required {
+		if !files[file] {
+			return fmt.Errorf("the file %s is missing", file)
+		}
+	}
+	return nil
+}
+
+// checkServicesHealth does a dry-run of the creation of the triggers for the
+// services.
+func (i *Installer) checkServicesHealth(man *WebappManifest) error {
+	for name, service := range man.val.Services {
+		triggerOpts := strings.SplitN(service.TriggerOptions, " ", 2)
+		triggerType := strings.TrimSpace(triggerOpts[0])
+		// Services called programmatically have no trigger
+		if triggerType == "" || service.TriggerOptions == "@at 2000-01-01T00:00:00.000Z" {
+			continue
+		}
+		var triggerArgs string
+		if len(triggerOpts) > 1 {
+			triggerArgs = strings.TrimSpace(triggerOpts[1])
+		}
+		msg := map[string]string{
+			"slug": man.Slug(),
+			"name": name,
+		}
+		_, err := job.NewTrigger(i.db, job.TriggerInfos{
+			Type:       triggerType,
+			WorkerType: "service",
+			Debounce:   service.Debounce,
+			Arguments:  triggerArgs,
+		}, msg)
+		if err != nil {
+			return fmt.Errorf("the trigger of the service %s is invalid: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// callSmokeEndpoint sends the new version of the application to the smoke
+// endpoint of the configuration, if any. The update is cancelled if the
+// endpoint does not respond with a 2xx status code.
+func (i *Installer) callSmokeEndpoint(man Manifest) error {
+	smokeURL := config.GetConfig().Apps.SmokeURL
+	if smokeURL == "" {
+		return nil
+	}
+	appType := "webapp"
+	if man.AppType() == consts.KonnectorType {
+		appType = "konnector"
+	}
+	body, err := json.Marshal(map[string]string{
+		"domain":   i.Domain(),
+		"slug":     man.Slug(),
+		"type":     appType,
+		"version":  man.Version(),
+		"checksum": man.Checksum(),
+		"source":   man.Source(),
+	})
+	if err != nil {
+		return err
+	}
+	res, err := smokeClient.Post(smokeURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("the smoke endpoint is not reachable: %s", err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("the smoke endpoint has responded with status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -49,6 +49,8 @@ type Installer struct {
 
 	overridenParameters map[string]interface{}
 	permissionsAcked    bool
+	approve             bool
+	automatic           bool
 
 	man     Manifest
 	src     *url.URL
@@ -71,6 +73,14 @@ type InstallerOptions struct {
 	PermissionsAcked bool
 	Registries       []*url.URL
 
+	// Approve is used to install the pending update of an application, after
+	// the user has approved its permissions.
+	Approve bool
+
+	// Automatic is used for the updates that have not been asked by the user:
+	// they do not install again a version replaced by a rollback.
+	Automatic bool
+
 	// Used to override the "Parameters" field of konnectors during installation.
 	// This modification is useful to allow the parameterization of a konnector
 	// at its installation as we do not have yet a registry up and running.
@@ -104,7 +114,13 @@ func NewInstaller(in *instance.Instance, fs appfs.Copier, opts *InstallerOptions
 		src, err = url.Parse(opts.SourceURL)
 	case Update, Delete:
 		var srcString string
-		if opts.SourceURL == "" {
+		if opts.Approve {
+			pending := man.PendingUpdate()
+			if pending == nil {
+				return nil, ErrNoPendingUpdate
+			}
+			srcString = pending.Source
+		} else if opts.SourceURL == "" {
 			srcString = man.Source()
 		} else {
 			srcString = opts.SourceURL
@@ -149,16 +165,30 @@ func NewInstaller(in *instance.Instance, fs appfs.Copier, opts *InstallerOptions
 		manFilename = KonnectorManifestName
 	}
 
+	var signatures *signatureCheck
+	if opts.Operation != Delete {
+		signatures = newSignatureCheck(in.ContextName, log)
+	}
+
 	var fetcher Fetcher
 	switch src.Scheme {
-	case "git", "git+ssh", "ssh+git", "git+https":
-		fetcher = newGitFetcher(manFilename, log)
+	case "git", "git+ssh", "ssh+git", "git+https", "file":
+		// There is no package to sign for these sources
+		if signatures != nil {
+			if signatures.policy == config.SignaturesRequired {
+				return nil, ErrMissingSignature
+			}
+			log.Warnf("The signature cannot be checked for the source %s", src.String())
+		}
+		if src.Scheme == "file" {
+			fetcher = newFileFetcher(manFilename, log)
+		} else {
+			fetcher = newGitFetcher(manFilename, log)
+		}
 	case "http", "https":
-		fetcher = newHTTPFetcher(manFilename, log)
+		fetcher = newHTTPFetcher(manFilename, signatures, log)
 	case "registry":
-		fetcher = newRegistryFetcher(opts.Registries, log)
-	case "file":
-		fetcher = newFileFetcher(manFilename, log)
+		fetcher = newRegistryFetcher(opts.Registries, signatures, log)
 	default:
 		return nil, ErrNotSupportedSource
 	}
@@ -172,6 +202,8 @@ func NewInstaller(in *instance.Instance, fs appfs.Copier, opts *InstallerOptions
 
 		overridenParameters: opts.OverridenParameters,
 		permissionsAcked:    opts.PermissionsAcked,
+		approve:             opts.Approve,
+		automatic:           opts.Automatic,
 
 		man:     man,
 		src:     src,
@@ -359,6 +391,16 @@ func (i *Installer) update() error {
 		return err
 	}
 
+	// A rollback marks the replaced version as available, without a pending
+	// update: it is pinned until a new version is published or the user asks
+	// for the update.
+	if i.automatic && oldManifest.PendingUpdate() == nil &&
+		oldManifest.AvailableVersion() != "" &&
+		oldManifest.AvailableVersion() == newManifest.Version() {
+		i.log.Infof("Version %s is skipped after a rollback", newManifest.Version())
+		return nil
+	}
+
 	// Fast path for registry:// and http:// sources: we do not need to go
 	// further in the case where the fetched manifest has the same version has
 	// the one in database.
@@ -372,6 +414,14 @@ func (i *Installer) update() error {
 		makeUpdate = (newManifest.Version() != oldManifest.Version())
 	}
 
+	// The approval of the user is only valid for the permissions and terms
+	// that were shown to them. If the source now gives a version that asks
+	// for something else, a new approval is needed.
+	if i.approve {
+		pending := oldManifest.PendingUpdate()
+		i.permissionsAcked = pending != nil && pending.approves(oldManifest, newManifest)
+	}
+
 	// Check the possible permissions changes before updating. If the
 	// verifyPermissions flag is activated (for non manual updates for example),
 	// we cancel out the update and mark the UpdateAvailable field of the
@@ -406,8 +456,11 @@ func (i *Installer) update() error {
 		availableVersion = newManifest.Version()
 	}
 
-	extraPerms := permission.Set{}
-	var alteredPerms *permission.Permission
+	var pending *PendingUpdate
+	if availableVersion != "" {
+		pending = newPendingUpdate(oldManifest, newManifest)
+	}
+
 	// The "extraPerms" set represents the post-install alterations of the
 	// permissions between the oldManifest and the current permissions.
 	//
@@ -415,48 +468,88 @@ func (i *Installer) update() error {
 	// to set an AvailableVersion. In this case, the current webapp/konnector
 	// perms will be reapplied and custom ones will be lost if we don't rewrite
 	// them.
-	inst, err := instance.Get(i.Domain())
-	if err == nil {
-		// Check if perms were added on the old manifest
-		if i.man.AppType() == consts.WebappType {
-			alteredPerms, err = permission.GetForWebapp(inst, i.man.Slug())
-		} else if i.man.AppType() == consts.KonnectorType {
-			alteredPerms, err = permission.GetForKonnector(inst, i.man.Slug())
-		}
-		if err != nil {
-			return err
-		}
+	extraPerms, err := extraPermissions(i.Domain(), oldManifest)
+	if err != nil {
+		return err
 	}
 
-	if alteredPerms != nil {
-		extraPerms, err = permission.Diff(oldManifest.Permissions(), alteredPerms.Permissions)
-		if err != nil {
-			return err
-		}
+	if makeUpdate {
+		return i.stagedUpdate(oldManifest, newManifest, extraPerms)
 	}
 
-	if makeUpdate {
-		i.man = newManifest
-		i.sendRealtimeEvent()
-		i.notifyChannel()
-		if err := i.fetcher.Fetch(i.src, i.fs, i.man); err != nil {
+	if i.man.AppType() == consts.WebappType {
+		i.man.(*WebappManifest).oldServices = i.man.(*WebappManifest).val.Services
+	}
+	i.man.SetSource(i.src)
+	if availableVersion != "" {
+		i.man.SetAvailableVersion(availableVersion)
+		i.man.SetPendingUpdate(pending)
+	}
+	i.sendRealtimeEvent()
+	i.notifyChannel()
+	return i.man.Update(i.db, extraPerms)
+}
+
+// stagedUpdate installs the new version of an application alongside the old
+// one, checks its health, and then switches to it. The old version is kept
+// for a rollback. If the new version fails its health checks, the old
+// version stays installed.
+func (i *Installer) stagedUpdate(oldManifest, newManifest Manifest, extraPerms permission.Set) error {
+	fs := newRecordingCopier(i.fs)
+	if err := i.fetcher.Fetch(i.src, fs, newManifest); err != nil {
+		i.removeFailedVersion(oldManifest, fs)
+		return err
+	}
+	if err := i.checkHealth(newManifest, fs.files); err != nil {
+		i.log.Warnf("Update to %s cancelled: %s", newManifest.Version(), err)
+		i.removeFailedVersion(oldManifest, fs)
+		return err
+	}
+
+	// The clients are notified of the new version only when it has passed the
+	// health checks.
+	i.man = newManifest
+	i.sendRealtimeEvent()
+	i.notifyChannel()
+	i.man.SetAvailableVersion("")
+	i.man.SetPendingUpdate(nil)
+	i.man.SetState(i.endState)
+
+	sameVersion := oldManifest.Version() == i.man.Version() &&
+		oldManifest.Checksum() == i.man.Checksum()
+	var archived *Version
+	if !sameVersion {
+		var err error
+		if archived, err = archiveVersion(i.db, oldManifest); err != nil {
 			return err
 		}
-		i.man.SetAvailableVersion("")
-		i.man.SetState(i.endState)
-	} else {
-		if i.man.AppType() == consts.WebappType {
-			i.man.(*WebappManifest).oldServices = i.man.(*WebappManifest).val.Services
+	}
+	if err := i.man.Update(i.db, extraPerms); err != nil {
+		if archived != nil {
+			_ = couchdb.DeleteDoc(i.db, archived)
 		}
-		i.man.SetSource(i.src)
-		if availableVersion != "" {
-			i.man.SetAvailableVersion(availableVersion)
+		return err
+	}
+	if err := pruneVersions(i.db, i.fs, i.man); err != nil {
+		i.log.Warnf("Cannot prune the old versions: %s", err)
+	}
+	if !sameVersion && i.man.AppType() == consts.WebappType {
+		if err := clearServiceViolations(i.db, i.slug); err != nil {
+			i.log.Warnf("Cannot clear the violations of the services: %s", err)
 		}
-		i.sendRealtimeEvent()
-		i.notifyChannel()
 	}
+	return nil
+}
 
-	return i.man.Update(i.db, extraPerms)
+// removeFailedVersion removes the files of a new version of an application
+// that has not been installed, if they have been copied for this update.
+func (i *Installer) removeFailedVersion(oldManifest Manifest, fs *recordingCopier) {
+	if len(fs.files) == 0 {
+		return
+	}
+	if err := pruneVersions(i.db, i.fs, oldManifest); err != nil {
+		i.log.Warnf("Cannot remove the files of the failed version: %s", err)
+	}
 }
 
 func (i *Installer) notifyChannel() {
@@ -515,6 +608,12 @@ func (i *Installer) ReadManifest(state State) (Manifest, error) {
 		}
 	}
 
+	if m, ok := newManifest.(*WebappManifest); ok {
+		if err := m.checkRoutes(); err != nil {
+			return nil, err
+		}
+	}
+
 	shouldOverrideParameters := (i.overridenParameters != nil &&
 		i.man.AppType() == consts.KonnectorType &&
 		i.src.Scheme != "registry")
@@ -617,6 +716,7 @@ func DoLazyUpdate(in *instance.Instance, man Manifest, copier appfs.Copier, regi
 		Manifest:   man,
 		Registries: registries,
 		SourceURL:  src.String(),
+		Automatic:  true,
 	})
 	if err != nil {
 		return man
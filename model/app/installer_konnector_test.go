@@ -315,6 +315,83 @@ func TestKonnectorUpdateSkipPerms(t *testing.T) {
 	assert.Contains(t, konnManifest.Version(), "2.0.0")
 }
 
+func TestKonnectorUpdateApprove(t *testing.T) {
+	finished := true
+	instance, err := lifecycle.Create(&lifecycle.Options{
+		Domain:             "test-approve-perms",
+		OnboardingFinished: &finished,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = lifecycle.Destroy("test-approve-perms") }()
+
+	manGen = manifestKonnector1
+	manName = app.KonnectorManifestName
+
+	inst, err := app.NewInstaller(instance, fs, &app.InstallerOptions{
+		Operation: app.Install,
+		Type:      consts.KonnectorType,
+		Slug:      "cozy-konnector-test-approve",
+		SourceURL: "git://localhost/",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = inst.RunSync()
+	assert.NoError(t, err)
+
+	// Nothing to approve
+	_, err = app.NewInstaller(instance, fs, &app.InstallerOptions{
+		Operation: app.Update,
+		Type:      consts.KonnectorType,
+		Slug:      "cozy-konnector-test-approve",
+		Approve:   true,
+	})
+	assert.Equal(t, app.ErrNoPendingUpdate, err)
+
+	// The new permissions are kept with the pending update
+	manGen = manifestKonnector2
+	inst, err = app.NewInstaller(instance, fs, &app.InstallerOptions{
+		Operation: app.Update,
+		Type:      consts.KonnectorType,
+		Slug:      "cozy-konnector-test-approve",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	man, err := inst.RunSync()
+	assert.NoError(t, err)
+	assert.Contains(t, man.Version(), "1.0.0")
+	pending := man.PendingUpdate()
+	if assert.NotNil(t, pending) {
+		assert.Contains(t, pending.Version, "2.0.0")
+		assert.Equal(t, []string{"io.cozy.files"}, pending.Diff.AddedDoctypes)
+		perms := man.Permissions()
+		assert.True(t, perms.IsSubSetOf(pending.Permissions))
+	}
+
+	// The approval installs the pending update
+	inst, err = app.NewInstaller(instance, fs, &app.InstallerOptions{
+		Operation: app.Update,
+		Type:      consts.KonnectorType,
+		Slug:      "cozy-konnector-test-approve",
+		Approve:   true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	man, err = inst.RunSync()
+	assert.NoError(t, err)
+	assert.Contains(t, man.Version(), "2.0.0")
+	assert.Empty(t, man.AvailableVersion())
+	assert.Nil(t, man.PendingUpdate())
+
+	man, err = app.GetKonnectorBySlug(instance, "cozy-konnector-test-approve")
+	assert.NoError(t, err)
+	assert.Nil(t, man.PendingUpdate())
+}
+
 func TestKonnectorInstallAndUpgradeWithBranch(t *testing.T) {
 	manGen = manifestKonnector
 	manName = app.KonnectorManifestName
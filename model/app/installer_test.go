@@ -205,9 +205,16 @@ func TestMain(m *testing.M) {
 		os.Exit(1)
 	}
 
+	err = couchdb.ResetDB(db, consts.AppsVersions)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	g, _ := errgroup.WithContext(context.Background())
 	couchdb.DefineIndexes(g, db, couchdb.IndexesByDoctype(consts.Files))
 	couchdb.DefineIndexes(g, db, couchdb.IndexesByDoctype(consts.Permissions))
+	couchdb.DefineIndexes(g, db, couchdb.IndexesByDoctype(consts.AppsVersions))
 	if err = g.Wait(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -219,6 +226,7 @@ func TestMain(m *testing.M) {
 	_ = couchdb.DeleteDB(db, consts.Konnectors)
 	_ = couchdb.DeleteDB(db, consts.Files)
 	_ = couchdb.DeleteDB(db, consts.Permissions)
+	_ = couchdb.DeleteDB(db, consts.AppsVersions)
 	ts.Close()
 
 	_ = localGitCmd.Process.Signal(os.Interrupt)
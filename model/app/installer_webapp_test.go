@@ -367,6 +367,105 @@ func TestWebappInstallWithUpgrade(t *testing.T) {
 	assert.Nil(t, manWebapp.Services()["service1"])
 }
 
+func TestWebappUpdateAndRollback(t *testing.T) {
+	manGen = manifestWebapp
+	manName = app.WebappManifestName
+
+	doUpgrade(3)
+
+	inst, err := app.NewInstaller(db, fs, &app.InstallerOptions{
+		Operation: app.Install,
+		Type:      consts.WebappType,
+		Slug:      "cozy-app-rollback",
+		SourceURL: "git://localhost/",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	man, err := inst.RunSync()
+	if !assert.NoError(t, err) {
+		return
+	}
+	version1 := man.Version()
+
+	_, err = app.Rollback(db, fs, consts.WebappType, "cozy-app-rollback")
+	assert.Equal(t, app.ErrNoPreviousVersion, err)
+
+	doUpgrade(4)
+
+	inst, err = app.NewInstaller(db, fs, &app.InstallerOptions{
+		Operation: app.Update,
+		Type:      consts.WebappType,
+		Slug:      "cozy-app-rollback",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	man, err = inst.RunSync()
+	if !assert.NoError(t, err) {
+		return
+	}
+	version2 := man.Version()
+	assert.NotEqual(t, version1, version2)
+
+	versions, err := app.ListVersions(db, consts.WebappType, "cozy-app-rollback")
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 1) {
+		assert.Equal(t, version1, versions[0].Version)
+	}
+
+	man, err = app.Rollback(db, fs, consts.WebappType, "cozy-app-rollback")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, version1, man.Version())
+	assert.Equal(t, version2, man.AvailableVersion())
+
+	man, err = app.GetWebappBySlug(db, "cozy-app-rollback")
+	assert.NoError(t, err)
+	assert.Equal(t, version1, man.Version())
+	ok, err := afero.Exists(baseFS, path.Join("/", man.Slug(), version1, app.WebappManifestName+".br"))
+	assert.NoError(t, err)
+	assert.True(t, ok, "The files of the previous version are kept")
+
+	versions, err = app.ListVersions(db, consts.WebappType, "cozy-app-rollback")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 0)
+
+	_, err = app.Rollback(db, fs, consts.WebappType, "cozy-app-rollback")
+	assert.Equal(t, app.ErrNoPreviousVersion, err)
+
+	inst, err = app.NewInstaller(db, fs, &app.InstallerOptions{
+		Operation: app.Update,
+		Type:      consts.WebappType,
+		Slug:      "cozy-app-rollback",
+		Automatic: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	man, err = inst.RunSync()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, version1, man.Version(), "The rolled back version is pinned")
+
+	inst, err = app.NewInstaller(db, fs, &app.InstallerOptions{
+		Operation: app.Update,
+		Type:      consts.WebappType,
+		Slug:      "cozy-app-rollback",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	man, err = inst.RunSync()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, version2, man.Version())
+	assert.Equal(t, "", man.AvailableVersion())
+}
+
 func TestWebappInstallAndUpgradeWithBranch(t *testing.T) {
 	manGen = manifestWebapp
 	manName = app.WebappManifestName
@@ -47,6 +47,10 @@ type KonnManifest struct {
 		Permissions   permission.Set `json:"permissions"`
 		Terms         Terms          `json:"terms"`
 		Notifications Notifications  `json:"notifications"`
+		PendingUpdate *PendingUpdate `json:"pending_update,omitempty"`
+
+		// Set by the installer
+		Signature *SignatureStatus `json:"signature,omitempty"`
 	}
 }
 
@@ -110,6 +114,18 @@ func (m *KonnManifest) SetVersion(version string) { m.val.Version = version }
 // SetAvailableVersion is part of the Manifest interface
 func (m *KonnManifest) SetAvailableVersion(version string) { m.val.AvailableVersion = version }
 
+// PendingUpdate is part of the Manifest interface
+func (m *KonnManifest) PendingUpdate() *PendingUpdate { return m.val.PendingUpdate }
+
+// SetPendingUpdate is part of the Manifest interface
+func (m *KonnManifest) SetPendingUpdate(pending *PendingUpdate) { m.val.PendingUpdate = pending }
+
+// Signature is part of the Manifest interface
+func (m *KonnManifest) Signature() *SignatureStatus { return m.val.Signature }
+
+// SetSignature is part of the Manifest interface
+func (m *KonnManifest) SetSignature(status *SignatureStatus) { m.val.Signature = status }
+
 // SetChecksum is part of the Manifest interface
 func (m *KonnManifest) SetChecksum(shasum string) { m.val.Checksum = shasum }
 
@@ -183,6 +199,16 @@ func (m *KonnManifest) MarshalJSON() ([]byte, error) {
 	} else {
 		m.doc.M["available_version"] = m.val.AvailableVersion
 	}
+	if m.val.PendingUpdate == nil {
+		delete(m.doc.M, "pending_update")
+	} else {
+		m.doc.M["pending_update"] = m.val.PendingUpdate
+	}
+	if m.val.Signature == nil {
+		delete(m.doc.M, "signature")
+	} else {
+		m.doc.M["signature"] = m.val.Signature
+	}
 	m.doc.M["checksum"] = m.val.Checksum
 	if m.val.Parameters == nil {
 		delete(m.doc.M, "parameters")
@@ -228,6 +254,8 @@ func (m *KonnManifest) ReadManifest(r io.Reader, slug, sourceURL string) (Manife
 	newManifest.val.CreatedAt = m.val.CreatedAt
 	newManifest.val.Slug = slug
 	newManifest.val.Source = sourceURL
+	newManifest.val.PendingUpdate = nil
+	newManifest.val.Signature = nil
 	if newManifest.val.Parameters == nil {
 		newManifest.val.Parameters = m.val.Parameters
 	}
@@ -1,13 +1,26 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
+	"net/url"
+	"time"
 
+	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/registry"
+	"github.com/cozy/cozy-stack/pkg/utils"
 )
 
-// ActivateMaintenance activates maintenance for the given konnector.
+// maintenanceCacheKeyPrefix is the prefix of the cache keys where the lists
+// of konnectors in maintenance on the registries are kept.
+const maintenanceCacheKeyPrefix = "konnectors-maintenance:"
+
+// ActivateMaintenance activates maintenance for the given konnector. The
+// options can have a start_at and an end_at dates (RFC3339) for a scheduled
+// maintenance window, a reason, and translated messages.
 func ActivateMaintenance(slug string, opts map[string]interface{}) error {
 	doc, err := loadMaintenance(slug)
 	if err != nil {
@@ -17,6 +30,13 @@ func ActivateMaintenance(slug string, opts map[string]interface{}) error {
 	if doc.M == nil {
 		doc.M = map[string]interface{}{}
 	}
+	start, end, err := parseMaintenanceWindow(doc.M)
+	if err != nil {
+		return err
+	}
+	if !end.IsZero() && (end.Before(time.Now()) || (!start.IsZero() && !start.Before(end))) {
+		return ErrInvalidMaintenanceWindow
+	}
 	doc.M["flag_infra_maintenance"] = true
 	doc.SetID(slug)
 	return couchdb.Upsert(couchdb.GlobalDB, &doc)
@@ -45,6 +65,45 @@ func loadMaintenance(slug string) (couchdb.JSONDoc, error) {
 	return doc, nil
 }
 
+// parseMaintenanceWindow returns the start and end dates of a maintenance
+// window. A zero time is returned when there is no bound.
+func parseMaintenanceWindow(opts map[string]interface{}) (start, end time.Time, err error) {
+	if s, ok := opts["start_at"].(string); ok && s != "" {
+		if start, err = time.Parse(time.RFC3339, s); err != nil {
+			return start, end, ErrInvalidMaintenanceWindow
+		}
+	}
+	if e, ok := opts["end_at"].(string); ok && e != "" {
+		if end, err = time.Parse(time.RFC3339, e); err != nil {
+			return start, end, ErrInvalidMaintenanceWindow
+		}
+	}
+	return start, end, nil
+}
+
+// IsMaintenanceActive returns true if the maintenance with the given options
+// is active at the given time, ie the time is inside its window.
+func IsMaintenanceActive(opts map[string]interface{}, now time.Time) bool {
+	start, end, err := parseMaintenanceWindow(opts)
+	if err != nil {
+		return true
+	}
+	if !start.IsZero() && now.Before(start) {
+		return false
+	}
+	if !end.IsZero() && !now.Before(end) {
+		return false
+	}
+	return true
+}
+
+// MaintenanceEnd returns the end of the maintenance window, or a zero time if
+// the maintenance has no scheduled end.
+func MaintenanceEnd(opts map[string]interface{}) time.Time {
+	_, end, _ := parseMaintenanceWindow(opts)
+	return end
+}
+
 // GetMaintenanceOptions will return the maintenance options for the given
 // konnector if it is in maintenance on this stack.
 func GetMaintenanceOptions(slug string) (map[string]interface{}, error) {
@@ -56,15 +115,20 @@ func GetMaintenanceOptions(slug string) (map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	if !IsMaintenanceActive(doc.M, time.Now()) {
+		return nil, nil
+	}
 	delete(doc.M, "_id")
 	delete(doc.M, "_rev")
 	return doc.M, nil
 }
 
 // ListMaintenance returns the list of konnectors in maintenance for the stack
-// (not from apps registry).
+// (not from apps registry). The scheduled maintenances that have not started
+// yet are included, with maintenance_activated set to false.
 func ListMaintenance() ([]map[string]interface{}, error) {
 	list := []map[string]interface{}{}
+	now := time.Now()
 	err := couchdb.ForeachDocs(couchdb.GlobalDB, consts.KonnectorsMaintenance, func(id string, raw json.RawMessage) error {
 		var opts map[string]interface{}
 		if err := json.Unmarshal(raw, &opts); err != nil {
@@ -75,7 +139,7 @@ func ListMaintenance() ([]map[string]interface{}, error) {
 		doc := map[string]interface{}{
 			"slug":                  id,
 			"type":                  "konnector",
-			"maintenance_activated": true,
+			"maintenance_activated": IsMaintenanceActive(opts, now),
 			"maintenance_options":   opts,
 		}
 		list = append(list, doc)
@@ -86,3 +150,146 @@ func ListMaintenance() ([]map[string]interface{}, error) {
 	}
 	return list, nil
 }
+
+// ListRegistryMaintenance returns the list of konnectors in maintenance on
+// the given registries. The lists are kept in cache, and refreshed by
+// SyncMaintenances.
+func ListRegistryMaintenance(registries []*url.URL) ([]json.RawMessage, error) {
+	apps := make([]json.RawMessage, 0)
+	for _, r := range registries {
+		list, err := registryMaintenance(r)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, list...)
+	}
+	return apps, nil
+}
+
+// GetRegistryMaintenanceOptions returns the maintenance options for the given
+// konnector if it is in maintenance on one of the registries.
+func GetRegistryMaintenanceOptions(slug string, registries []*url.URL) (map[string]interface{}, error) {
+	list, err := ListRegistryMaintenance(registries)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, raw := range list {
+		var item struct {
+			Slug      string                 `json:"slug"`
+			Activated bool                   `json:"maintenance_activated"`
+			Options   map[string]interface{} `json:"maintenance_options"`
+		}
+		if err := json.Unmarshal(raw, &item); err != nil || item.Slug != slug {
+			continue
+		}
+		if item.Options == nil {
+			item.Options = map[string]interface{}{}
+		}
+		if item.Activated && IsMaintenanceActive(item.Options, now) {
+			return item.Options, nil
+		}
+	}
+	return nil, nil
+}
+
+func registryMaintenance(r *url.URL) ([]json.RawMessage, error) {
+	cache := config.GetConfig().CacheStorage
+	key := maintenanceCacheKeyPrefix + r.String()
+	if data, ok := cache.Get(key); ok {
+		var list []json.RawMessage
+		if err := json.Unmarshal(data, &list); err == nil {
+			return list, nil
+		}
+	}
+	return fetchRegistryMaintenance(r)
+}
+
+func fetchRegistryMaintenance(r *url.URL) ([]json.RawMessage, error) {
+	list, err := registry.ProxyMaintenance([]*url.URL{r})
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	cache := config.GetConfig().CacheStorage
+	cache.Set(maintenanceCacheKeyPrefix+r.String(), data, 2*maintenanceSyncInterval())
+	return list, nil
+}
+
+func maintenanceSyncInterval() time.Duration {
+	if d := config.GetConfig().Konnectors.MaintenanceSyncInterval; d > 0 {
+		return d
+	}
+	return 10 * time.Minute
+}
+
+// SyncMaintenances starts a loop that periodically refreshes the lists of
+// konnectors in maintenance on the registries, and removes the maintenances
+// of the stack whose window has ended.
+func SyncMaintenances() utils.Shutdowner {
+	closed := make(chan struct{})
+	go func() {
+		log := logger.WithNamespace("maintenance")
+		for {
+			select {
+			case <-time.After(maintenanceSyncInterval()):
+				syncMaintenances(log)
+			case <-closed:
+				return
+			}
+		}
+	}()
+	return &maintenanceSyncer{closed}
+}
+
+type maintenanceSyncer struct {
+	closed chan struct{}
+}
+
+func (s *maintenanceSyncer) Shutdown(ctx context.Context) error {
+	select {
+	case s.closed <- struct{}{}:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func syncMaintenances(log *logger.Entry) {
+	seen := make(map[string]bool)
+	for _, registries := range config.GetConfig().Registries {
+		for _, r := range registries {
+			if seen[r.String()] {
+				continue
+			}
+			seen[r.String()] = true
+			if _, err := fetchRegistryMaintenance(r); err != nil {
+				log.Warnf("Could not sync the maintenance list of %s: %s", r, err)
+			}
+		}
+	}
+
+	now := time.Now()
+	var expired []string
+	err := couchdb.ForeachDocs(couchdb.GlobalDB, consts.KonnectorsMaintenance, func(id string, raw json.RawMessage) error {
+		var opts map[string]interface{}
+		if err := json.Unmarshal(raw, &opts); err != nil {
+			return err
+		}
+		if end := MaintenanceEnd(opts); !end.IsZero() && !now.Before(end) {
+			expired = append(expired, id)
+		}
+		return nil
+	})
+	if err != nil && !couchdb.IsNotFoundError(err) {
+		log.Warnf("Could not list the maintenances: %s", err)
+		return
+	}
+	for _, slug := range expired {
+		if err := DeactivateMaintenance(slug); err != nil {
+			log.Warnf("Could not remove the ended maintenance of %s: %s", slug, err)
+		}
+	}
+}
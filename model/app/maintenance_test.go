@@ -0,0 +1,32 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	now := time.Now()
+	assert.True(t, IsMaintenanceActive(map[string]interface{}{}, now))
+
+	opts := map[string]interface{}{
+		"start_at": now.Add(1 * time.Hour).Format(time.RFC3339),
+		"end_at":   now.Add(3 * time.Hour).Format(time.RFC3339),
+	}
+	assert.False(t, IsMaintenanceActive(opts, now))
+	assert.True(t, IsMaintenanceActive(opts, now.Add(2*time.Hour)))
+	assert.False(t, IsMaintenanceActive(opts, now.Add(3*time.Hour)))
+	assert.Equal(t, now.Add(3*time.Hour).Unix(), MaintenanceEnd(opts).Unix())
+
+	opts = map[string]interface{}{
+		"end_at": now.Add(1 * time.Hour).Format(time.RFC3339),
+	}
+	assert.True(t, IsMaintenanceActive(opts, now))
+	assert.False(t, IsMaintenanceActive(opts, now.Add(2*time.Hour)))
+
+	_, _, err := parseMaintenanceWindow(map[string]interface{}{"end_at": "tomorrow"})
+	assert.Equal(t, ErrInvalidMaintenanceWindow, err)
+	assert.True(t, MaintenanceEnd(map[string]interface{}{}).IsZero())
+}
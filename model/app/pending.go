@@ -0,0 +1,44 @@
+package app
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/model/permission"
+)
+
+// PendingUpdate is an update of an application that has not been installed
+// because it needs the approval of the user: the new version asks for more
+// permissions, or has new terms of use.
+type PendingUpdate struct {
+	Version      string              `json:"version"`
+	Source       string              `json:"source"`
+	Permissions  permission.Set      `json:"permissions"`
+	Diff         *permission.SetDiff `json:"permissions_diff"`
+	TermsVersion string              `json:"terms_version,omitempty"`
+	CreatedAt    time.Time           `json:"created_at"`
+}
+
+func newPendingUpdate(oldManifest, newManifest Manifest) *PendingUpdate {
+	pending := &PendingUpdate{
+		Version:     newManifest.Version(),
+		Source:      newManifest.Source(),
+		Permissions: newManifest.Permissions(),
+		Diff:        permission.Compare(oldManifest.Permissions(), newManifest.Permissions()),
+		CreatedAt:   time.Now().UTC(),
+	}
+	if terms := newManifest.Terms().Version; terms != oldManifest.Terms().Version {
+		pending.TermsVersion = terms
+	}
+	return pending
+}
+
+// approves returns true if the approval of the pending update by the user
+// covers the given manifest: it must not ask for other permissions or terms
+// than the ones that have been shown to the user.
+func (p *PendingUpdate) approves(oldManifest, newManifest Manifest) bool {
+	if !newManifest.Permissions().HasSameRules(p.Permissions) {
+		return false
+	}
+	terms := newManifest.Terms().Version
+	return terms == oldManifest.Terms().Version || terms == p.TermsVersion
+}
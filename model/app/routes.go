@@ -0,0 +1,186 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/utils"
+)
+
+// allowedRouteHeaders is the list of the response headers that a webapp can
+// declare for its routes. The headers used for the security, like
+// X-Frame-Options, are left to the stack.
+var allowedRouteHeaders = []string{
+	"Cache-Control",
+	"Content-Language",
+	"Expires",
+	"Link",
+	"Referrer-Policy",
+	"Vary",
+	"X-Robots-Tag",
+}
+
+// allowedCSPDirectives is the list of the CSP directives that a webapp can
+// extend for its routes. The script-src directive is not in this list, as
+// the index of the apps has a token that must not leak.
+var allowedCSPDirectives = []string{
+	"connect-src",
+	"font-src",
+	"frame-src",
+	"img-src",
+	"manifest-src",
+	"media-src",
+	"style-src",
+	"worker-src",
+}
+
+// restrictedCSPDirectives are the CSP directives where the origins declared
+// by a webapp are kept only if they are also in the apps_csp_allowlist of the
+// context of the instance, as they allow the app to send data to other sites
+// or to embed them.
+var restrictedCSPDirectives = []string{
+	"connect-src",
+	"frame-src",
+}
+
+// checkRoutes checks that the headers, CSP extensions and redirections of the
+// routes are allowed. The headers names are normalized.
+func (m *WebappManifest) checkRoutes() error {
+	for key, route := range m.val.Routes {
+		if err := route.check(); err != nil {
+			return err
+		}
+		m.val.Routes[key] = route
+	}
+	return nil
+}
+
+func (c *Route) check() error {
+	if len(c.Headers) > 0 {
+		headers := make(map[string]string, len(c.Headers))
+		for name, value := range c.Headers {
+			name = http.CanonicalHeaderKey(name)
+			if !utils.IsInArray(name, allowedRouteHeaders) {
+				return ErrInvalidRoute
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				return ErrInvalidRoute
+			}
+			headers[name] = value
+		}
+		c.Headers = headers
+	}
+
+	for directive, sources := range c.CSP {
+		if !utils.IsInArray(directive, allowedCSPDirectives) {
+			return ErrInvalidRoute
+		}
+		for _, source := range sources {
+			if !isAllowedCSPSource(source) {
+				return ErrInvalidRoute
+			}
+		}
+	}
+
+	switch c.RedirectStatus {
+	case 0:
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		if c.Redirect == "" {
+			return ErrInvalidRoute
+		}
+	default:
+		return ErrInvalidRoute
+	}
+	if c.Redirect != "" && !isAllowedRedirect(c.Redirect) {
+		return ErrInvalidRoute
+	}
+	return nil
+}
+
+// RedirectCode returns the HTTP status code to use for the redirection of
+// the route (302 Found by default).
+func (c *Route) RedirectCode() int {
+	if c.RedirectStatus == 0 {
+		return http.StatusFound
+	}
+	return c.RedirectStatus
+}
+
+// AllowedCSP returns the CSP extensions of the route that can be applied for
+// the instance: the origins of connect-src and frame-src that are not in the
+// apps_csp_allowlist of the context of the instance are removed.
+func (c *Route) AllowedCSP(inst *instance.Instance) map[string][]string {
+	if len(c.CSP) == 0 {
+		return nil
+	}
+	var allowlist []string
+	if ctxSettings, ok := inst.SettingsContext(); ok {
+		if list, ok := ctxSettings["apps_csp_allowlist"].([]interface{}); ok {
+			for _, origin := range list {
+				if origin, ok := origin.(string); ok {
+					allowlist = append(allowlist, strings.TrimSuffix(origin, "/"))
+				}
+			}
+		}
+	}
+	csp := make(map[string][]string, len(c.CSP))
+	for directive, sources := range c.CSP {
+		if !utils.IsInArray(directive, restrictedCSPDirectives) {
+			csp[directive] = sources
+			continue
+		}
+		var allowed []string
+		for _, source := range sources {
+			switch source {
+			case "'self'", "data:", "blob:":
+				allowed = append(allowed, source)
+			default:
+				if utils.IsInArray(strings.TrimSuffix(source, "/"), allowlist) {
+					allowed = append(allowed, source)
+				}
+			}
+		}
+		if len(allowed) > 0 {
+			csp[directive] = allowed
+		}
+	}
+	return csp
+}
+
+// isAllowedCSPSource returns true for the sources that can be added by a
+// webapp: 'self', data:, blob:, and the https/wss origins (scheme://host[:port]
+// without a path). The wildcard, the unsafe keywords, and the sources with
+// characters that could inject other sources or directives are rejected.
+func isAllowedCSPSource(source string) bool {
+	switch source {
+	case "'self'", "data:", "blob:":
+		return true
+	}
+	if strings.ContainsAny(source, " \t\r\n\f\v;,'\"") {
+		return false
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" || u.Host == "*" {
+		return false
+	}
+	if source != u.Scheme+"://"+u.Host {
+		return false
+	}
+	return u.Scheme == "https" || u.Scheme == "wss"
+}
+
+// isAllowedRedirect returns true for a path on the same app, or for an https
+// URL.
+func isAllowedRedirect(target string) bool {
+	if strings.HasPrefix(target, "/") {
+		return !strings.HasPrefix(target, "//") && !strings.Contains(target, "\\")
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "https" && u.Host != ""
+}
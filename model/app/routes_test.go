@@ -0,0 +1,92 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRoute(t *testing.T) {
+	route := Route{
+		Folder:  "/",
+		Index:   "index.html",
+		Headers: map[string]string{"cache-control": "max-age=3600"},
+		CSP: map[string][]string{
+			"img-src": {"'self'", "data:", "https://tiles.example.com"},
+		},
+	}
+	assert.NoError(t, route.check())
+	assert.Equal(t, "max-age=3600", route.Headers["Cache-Control"])
+
+	route = Route{Headers: map[string]string{"X-Frame-Options": "ALLOW"}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{Headers: map[string]string{"Link": "</a>\r\nSet-Cookie: foo"}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+
+	route = Route{CSP: map[string][]string{"script-src": {"https://cdn.example.com"}}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{CSP: map[string][]string{"img-src": {"*"}}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{CSP: map[string][]string{"style-src": {"'unsafe-inline'"}}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{CSP: map[string][]string{"img-src": {"http://tiles.example.com"}}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{CSP: map[string][]string{"img-src": {"https://x.com/ 'unsafe-inline'"}}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{CSP: map[string][]string{"img-src": {"https://x.com/ *"}}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{CSP: map[string][]string{"img-src": {"https://x.com/;script-src *"}}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{CSP: map[string][]string{"img-src": {"https://x.com,https://y.com"}}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{CSP: map[string][]string{"img-src": {"https://x.com/path"}}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{CSP: map[string][]string{"img-src": {"https://user@x.com"}}}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{CSP: map[string][]string{"connect-src": {"wss://x.com:8443"}}}
+	assert.NoError(t, route.check())
+
+	route = Route{Redirect: "/new-path", RedirectStatus: http.StatusMovedPermanently}
+	assert.NoError(t, route.check())
+	assert.False(t, route.NotFound())
+	assert.Equal(t, http.StatusMovedPermanently, route.RedirectCode())
+	route = Route{Redirect: "https://docs.example.com/"}
+	assert.NoError(t, route.check())
+	assert.Equal(t, http.StatusFound, route.RedirectCode())
+	route = Route{Redirect: "//evil.example.com/"}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{Redirect: "javascript:alert(1)"}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+	route = Route{Redirect: "/new-path", RedirectStatus: http.StatusOK}
+	assert.Equal(t, ErrInvalidRoute, route.check())
+}
+
+func TestAllowedCSP(t *testing.T) {
+	config.UseTestFile()
+	cfg := config.GetConfig()
+	previous := cfg.Contexts
+	defer func() { cfg.Contexts = previous }()
+	cfg.Contexts = map[string]interface{}{
+		"csp": map[string]interface{}{
+			"apps_csp_allowlist": []interface{}{"https://api.example.com/"},
+		},
+	}
+
+	route := Route{CSP: map[string][]string{
+		"img-src":     {"https://tiles.example.com"},
+		"connect-src": {"'self'", "https://api.example.com", "https://evil.example.com"},
+		"frame-src":   {"https://evil.example.com"},
+	}}
+	inst := &instance.Instance{ContextName: "csp"}
+	csp := route.AllowedCSP(inst)
+	assert.Equal(t, []string{"https://tiles.example.com"}, csp["img-src"])
+	assert.Equal(t, []string{"'self'", "https://api.example.com"}, csp["connect-src"])
+	assert.NotContains(t, csp, "frame-src")
+
+	inst = &instance.Instance{ContextName: "other"}
+	csp = route.AllowedCSP(inst)
+	assert.Equal(t, []string{"'self'"}, csp["connect-src"])
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	humanize "github.com/dustin/go-humanize"
+)
+
+const (
+	// ServiceLimitTimeout is the limit exceeded when a service has been
+	// killed because it has run for too long.
+	ServiceLimitTimeout = "timeout"
+	// ServiceLimitMemory is the limit exceeded when a service has been killed
+	// because it has used too much memory.
+	ServiceLimitMemory = "memory"
+)
+
+// ServiceLimits are the limits for the executions of a service. They can be
+// declared in the manifest of the webapp, and overridden by the operators in
+// the service_limits of the contexts.
+type ServiceLimits struct {
+	Concurrency int    `json:"concurrency,omitempty"` // the maximal number of executions in parallel for an instance
+	Timeout     string `json:"timeout,omitempty"`     // the maximal duration of an execution (e.g. "2m")
+	Memory      string `json:"memory,omitempty"`      // the maximal memory of an execution (e.g. "512MB")
+}
+
+// TimeoutDuration returns the timeout of the limits, or 0 if there is none.
+func (l ServiceLimits) TimeoutDuration() time.Duration {
+	if l.Timeout == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(l.Timeout)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// MemoryBytes returns the memory limit in bytes, or 0 if there is none.
+func (l ServiceLimits) MemoryBytes() int64 {
+	if l.Memory == "" {
+		return 0
+	}
+	bytes, err := humanize.ParseBytes(l.Memory)
+	if err != nil {
+		return 0
+	}
+	return int64(bytes)
+}
+
+// ServiceViolation is kept when a service has been stopped because it has
+// exceeded one of its limits.
+type ServiceViolation struct {
+	Limit string    `json:"limit"`
+	JobID string    `json:"job_id"`
+	At    time.Time `json:"at"`
+	Count int       `json:"count"`
+}
+
+// FindService returns the service of the webapp with the given name, or, if
+// the name is empty, the service executed from the given file.
+func (m *WebappManifest) FindService(name, file string) (string, *Service, bool) {
+	if name != "" {
+		service, ok := m.val.Services[name]
+		return name, service, ok
+	}
+	for n, s := range m.val.Services {
+		if s.File == file {
+			return n, s, true
+		}
+	}
+	return "", nil, false
+}
+
+// ServiceViolations is the document with the last violations of the limits
+// of the services of a webapp, by service name. It is kept outside of the
+// manifest, as it is written by the workers while the installer can update
+// the manifest. Its identifier is the slug of the webapp.
+type ServiceViolations struct {
+	DocID    string                       `json:"_id,omitempty"`
+	DocRev   string                       `json:"_rev,omitempty"`
+	Services map[string]*ServiceViolation `json:"services"`
+}
+
+// ID implements the couchdb.Doc interface
+func (v *ServiceViolations) ID() string { return v.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (v *ServiceViolations) Rev() string { return v.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (v *ServiceViolations) DocType() string { return consts.AppsServiceViolations }
+
+// Clone implements the couchdb.Doc interface
+func (v *ServiceViolations) Clone() couchdb.Doc {
+	cloned := *v
+	cloned.Services = make(map[string]*ServiceViolation, len(v.Services))
+	for name, violation := range v.Services {
+		copied := *violation
+		cloned.Services[name] = &copied
+	}
+	return &cloned
+}
+
+// SetID implements the couchdb.Doc interface
+func (v *ServiceViolations) SetID(id string) { v.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (v *ServiceViolations) SetRev(rev string) { v.DocRev = rev }
+
+// ServiceLimitsFor returns the limits of a service for the given instance:
+// the limits from the manifest, overridden by the limits for the app
+// (service_limits.<slug>) and for the service (service_limits.<slug>/<name>)
+// in the context of the instance.
+func ServiceLimitsFor(inst *instance.Instance, slug, name string, service *Service) ServiceLimits {
+	var limits ServiceLimits
+	if service != nil && service.Limits != nil {
+		limits = *service.Limits
+	}
+	ctxSettings, ok := inst.SettingsContext()
+	if !ok {
+		return limits
+	}
+	overrides, ok := ctxSettings["service_limits"].(map[string]interface{})
+	if !ok {
+		return limits
+	}
+	for _, key := range []string{slug, slug + "/" + name} {
+		if override, ok := overrides[key].(map[string]interface{}); ok {
+			limits.override(override)
+		}
+	}
+	return limits
+}
+
+func (l *ServiceLimits) override(values map[string]interface{}) {
+	switch v := values["concurrency"].(type) {
+	case int:
+		l.Concurrency = v
+	case float64:
+		l.Concurrency = int(v)
+	}
+	if timeout, ok := values["timeout"].(string); ok {
+		l.Timeout = timeout
+	}
+	switch v := values["memory"].(type) {
+	case string:
+		l.Memory = v
+	case int:
+		l.Memory = humanize.Bytes(uint64(v))
+	case float64:
+		l.Memory = humanize.Bytes(uint64(v))
+	}
+}
+
+// maxViolationAttempts is the number of attempts to record a violation when
+// the document is updated concurrently by another service.
+const maxViolationAttempts = 3
+
+// RecordServiceViolation keeps that a service of the webapp has exceeded a
+// limit, to let the user and the developers know why the service has been
+// stopped.
+func RecordServiceViolation(db prefixer.Prefixer, slug, name, limit, jobID string) error {
+	var err error
+	for attempt := 0; attempt < maxViolationAttempts; attempt++ {
+		if err = recordServiceViolation(db, slug, name, limit, jobID); !couchdb.IsConflictError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func recordServiceViolation(db prefixer.Prefixer, slug, name, limit, jobID string) error {
+	doc := &ServiceViolations{}
+	err := couchdb.GetDoc(db, consts.AppsServiceViolations, slug, doc)
+	if err != nil && !couchdb.IsNotFoundError(err) {
+		return err
+	}
+	if doc.Services == nil {
+		doc.Services = make(map[string]*ServiceViolation)
+	}
+	violation, ok := doc.Services[name]
+	if !ok {
+		violation = &ServiceViolation{}
+		doc.Services[name] = violation
+	}
+	violation.Limit = limit
+	violation.JobID = jobID
+	violation.At = time.Now()
+	violation.Count++
+	if doc.DocRev == "" {
+		doc.DocID = slug
+		return couchdb.CreateNamedDocWithDB(db, doc)
+	}
+	return couchdb.UpdateDoc(db, doc)
+}
+
+// clearServiceViolations removes the violations of the services of a webapp,
+// when it is updated or uninstalled.
+func clearServiceViolations(db prefixer.Prefixer, slug string) error {
+	doc := &ServiceViolations{}
+	err := couchdb.GetDoc(db, consts.AppsServiceViolations, slug, doc)
+	if couchdb.IsNotFoundError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return couchdb.DeleteDoc(db, doc)
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceLimits(t *testing.T) {
+	limits := ServiceLimits{Concurrency: 2, Timeout: "2m", Memory: "512MB"}
+	assert.Equal(t, 2*time.Minute, limits.TimeoutDuration())
+	assert.EqualValues(t, 512*1000*1000, limits.MemoryBytes())
+
+	limits.override(map[string]interface{}{
+		"concurrency": 1,
+		"memory":      1000000000,
+	})
+	assert.Equal(t, 1, limits.Concurrency)
+	assert.Equal(t, 2*time.Minute, limits.TimeoutDuration())
+	assert.EqualValues(t, 1000*1000*1000, limits.MemoryBytes())
+
+	limits.override(map[string]interface{}{"timeout": "30s", "memory": "1GiB"})
+	assert.Equal(t, 30*time.Second, limits.TimeoutDuration())
+	assert.EqualValues(t, 1<<30, limits.MemoryBytes())
+
+	limits = ServiceLimits{Timeout: "forever", Memory: "a lot"}
+	assert.EqualValues(t, 0, limits.TimeoutDuration())
+	assert.EqualValues(t, 0, limits.MemoryBytes())
+}
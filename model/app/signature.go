@@ -0,0 +1,153 @@
+package app
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/logger"
+)
+
+// maxSignatureSize is the maximal size of a .minisig file
+const maxSignatureSize = 4096
+
+// The possible values for the status of the signature of an application
+const (
+	// SignatureValid is used when the package has been signed by a trusted key
+	SignatureValid = "valid"
+	// SignatureMissing is used when the package has no signature
+	SignatureMissing = "missing"
+	// SignatureInvalid is used when the signature of the package has not been
+	// made by a trusted key, or does not match the package
+	SignatureInvalid = "invalid"
+)
+
+// SignatureStatus is the result of the verification of the signature of the
+// package of an application. It is kept in the manifest of the application.
+type SignatureStatus struct {
+	Status    string    `json:"status"`
+	KeyID     string    `json:"key_id,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// signatureCheck is used to verify the minisign signature of a package while
+// it is downloaded.
+type signatureCheck struct {
+	policy string
+	keys   []*crypto.MinisignKey
+	log    *logger.Entry
+}
+
+// newSignatureCheck returns the signature check for an instance in the given
+// context, or nil if the signatures are not checked.
+func newSignatureCheck(contextName string, log *logger.Entry) *signatureCheck {
+	conf := config.GetConfig().Apps.Signatures
+	if conf.Policy == "" || conf.Policy == config.SignaturesOff {
+		return nil
+	}
+	keys, ok := conf.Keys[contextName]
+	if !ok {
+		keys = conf.Keys[config.DefaultInstanceContext]
+	}
+	check := &signatureCheck{policy: conf.Policy, log: log}
+	check.addKeys(keys)
+	return check
+}
+
+// forRegistry returns a signature check that also trusts the keys pinned for
+// the given registry.
+func (c *signatureCheck) forRegistry(registry *url.URL) *signatureCheck {
+	if c == nil || registry == nil {
+		return c
+	}
+	keys := config.GetConfig().Apps.Signatures.Registries[strings.TrimSuffix(registry.String(), "/")]
+	if len(keys) == 0 {
+		return c
+	}
+	check := &signatureCheck{policy: c.policy, log: c.log}
+	check.keys = append(check.keys, c.keys...)
+	check.addKeys(keys)
+	return check
+}
+
+func (c *signatureCheck) addKeys(keys []string) {
+	for _, k := range keys {
+		key, err := crypto.ParseMinisignKey(k)
+		if err != nil {
+			c.log.Errorf("Invalid key for the signatures: %s", err)
+			continue
+		}
+		c.keys = append(c.keys, key)
+	}
+}
+
+// newHash returns the hash that must be computed on the package.
+func (c *signatureCheck) newHash() hash.Hash {
+	return crypto.NewMinisignHash()
+}
+
+// verify checks the signature of a package, from its digest. The signature
+// can be empty if the package has no signature. An error is returned only if
+// the policy requires a valid signature.
+func (c *signatureCheck) verify(signature string, digest []byte) (*SignatureStatus, error) {
+	status := &SignatureStatus{CheckedAt: time.Now().UTC()}
+	if signature == "" {
+		status.Status = SignatureMissing
+		if c.policy == config.SignaturesRequired {
+			return status, ErrMissingSignature
+		}
+		c.log.Warnf("The package has no signature")
+		return status, nil
+	}
+
+	sig, err := crypto.ParseMinisignSignature(signature)
+	if err == nil {
+		var key *crypto.MinisignKey
+		if key, err = sig.Verify(c.keys, digest); err == nil {
+			status.Status = SignatureValid
+			status.KeyID = key.KeyID()
+			return status, nil
+		}
+	}
+	status.Status = SignatureInvalid
+	status.Error = err.Error()
+	if c.policy == config.SignaturesRequired {
+		return status, fmt.Errorf("%w: %s", ErrBadSignature, err)
+	}
+	c.log.Warnf("The signature of the package is invalid: %s", err)
+	return status, nil
+}
+
+// fetchSignature fetches the detached signature published next to a
+// package, with the .minisig extension. It returns an empty string if there
+// is no signature.
+func fetchSignature(src *url.URL) (string, error) {
+	u := *src
+	u.Fragment = ""
+	u.Path += ".minisig"
+	u.RawPath = ""
+	res, err := httpClient.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", ErrSourceNotReachable
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxSignatureSize))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
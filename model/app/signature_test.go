@@ -0,0 +1,141 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cozy/cozy-stack/pkg/appfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestFetchSignedPackage(t *testing.T) {
+	conf := config.GetConfig()
+	oldSignatures := conf.Apps.Signatures
+	defer func() { conf.Apps.Signatures = oldSignatures }()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	keyID := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	key := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID[:]...), pub...))
+
+	tarball := makeTarball(t, map[string]string{
+		"manifest.konnector": `{"slug": "signed"}`,
+		"index.js":           "console.log('hello')",
+	})
+	h := crypto.NewMinisignHash()
+	_, _ = h.Write(tarball)
+	signature := crypto.SignMinisign(priv, keyID, h.Sum(nil), "signed")
+
+	signed := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/signed.tar.gz":
+			w.Header().Set("Content-Type", "application/gzip")
+			_, _ = w.Write(tarball)
+		case "/signed.tar.gz.minisig":
+			if !signed {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(signature))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	src, err := url.Parse(ts.URL + "/signed.tar.gz")
+	require.NoError(t, err)
+
+	log := logger.WithNamespace("test")
+	manifest := []byte(`{ "slug": "signed" }`)
+	fetch := func(version string) (Manifest, error) {
+		man := &KonnManifest{doc: &couchdb.JSONDoc{M: map[string]interface{}{}}}
+		man.SetSlug("signed")
+		man.SetVersion(version)
+		fs := appfs.NewAferoCopier(afero.NewMemMapFs())
+		err := fetchHTTP(src, nil, fs, man, manifest, "", newSignatureCheck("foo", log), "")
+		return man, err
+	}
+
+	// Off
+	conf.Apps.Signatures = config.AppsSignatures{Policy: config.SignaturesOff}
+	man, err := fetch("1.0.0")
+	assert.NoError(t, err)
+	assert.Nil(t, man.Signature())
+
+	// Required with a trusted key
+	conf.Apps.Signatures = config.AppsSignatures{
+		Policy: config.SignaturesRequired,
+		Keys:   map[string][]string{config.DefaultInstanceContext: {key}},
+	}
+	man, err = fetch("1.0.1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, man.Signature()) {
+		assert.Equal(t, SignatureValid, man.Signature().Status)
+		assert.Equal(t, "0102030405060708", man.Signature().KeyID)
+	}
+
+	// Required with a trusted key, but the manifest used for the permissions
+	// is not the one in the package
+	manifest = []byte(`{"slug": "signed", "permissions": {"files": {"type": "io.cozy.files"}}}`)
+	_, err = fetch("1.0.1")
+	assert.Equal(t, ErrManifestMismatch, err)
+	manifest = []byte(`{"slug": "signed"}`)
+
+	// Required with an unknown key
+	conf.Apps.Signatures.Keys = map[string][]string{"foo": {}}
+	_, err = fetch("1.0.2")
+	assert.True(t, errors.Is(err, ErrBadSignature))
+
+	// Warn with an unknown key
+	conf.Apps.Signatures.Policy = config.SignaturesWarn
+	man, err = fetch("1.0.3")
+	assert.NoError(t, err)
+	if assert.NotNil(t, man.Signature()) {
+		assert.Equal(t, SignatureInvalid, man.Signature().Status)
+	}
+
+	// Missing signature
+	signed = false
+	man, err = fetch("1.0.4")
+	assert.NoError(t, err)
+	if assert.NotNil(t, man.Signature()) {
+		assert.Equal(t, SignatureMissing, man.Signature().Status)
+	}
+	conf.Apps.Signatures.Policy = config.SignaturesRequired
+	_, err = fetch("1.0.5")
+	assert.Equal(t, ErrMissingSignature, err)
+}
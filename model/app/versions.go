@@ -0,0 +1,231 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/appfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// defaultKeptVersions is the number of previous versions kept for an
+// application when it is not configured.
+const defaultKeptVersions = 3
+
+// Version is a previous version of an application, kept to be able to
+// rollback to it. It contains the manifest of the application as it was
+// installed.
+type Version struct {
+	DocID      string          `json:"_id,omitempty"`
+	DocRev     string          `json:"_rev,omitempty"`
+	AppType    string          `json:"app_type"` // io.cozy.apps or io.cozy.konnectors
+	Slug       string          `json:"slug"`
+	Version    string          `json:"version"`
+	Checksum   string          `json:"checksum,omitempty"`
+	Source     string          `json:"source"`
+	Manifest   json.RawMessage `json:"manifest"`
+	ArchivedAt time.Time       `json:"archived_at"`
+}
+
+// ID implements the couchdb.Doc interface
+func (v *Version) ID() string { return v.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (v *Version) Rev() string { return v.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (v *Version) DocType() string { return consts.AppsVersions }
+
+// Clone implements the couchdb.Doc interface
+func (v *Version) Clone() couchdb.Doc {
+	cloned := *v
+	cloned.Manifest = make(json.RawMessage, len(v.Manifest))
+	copy(cloned.Manifest, v.Manifest)
+	return &cloned
+}
+
+// SetID implements the couchdb.Doc interface
+func (v *Version) SetID(id string) { v.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (v *Version) SetRev(rev string) { v.DocRev = rev }
+
+func keptVersions() int {
+	if n := config.GetConfig().Apps.KeptVersions; n > 0 {
+		return n
+	}
+	return defaultKeptVersions
+}
+
+// archiveVersion saves the manifest of the installed version of an
+// application, before it is replaced by a new version.
+func archiveVersion(db prefixer.Prefixer, man Manifest) (*Version, error) {
+	raw, err := json.Marshal(man)
+	if err != nil {
+		return nil, err
+	}
+	v := &Version{
+		AppType:    man.DocType(),
+		Slug:       man.Slug(),
+		Version:    man.Version(),
+		Checksum:   man.Checksum(),
+		Source:     man.Source(),
+		Manifest:   raw,
+		ArchivedAt: time.Now().UTC(),
+	}
+	if err := couchdb.CreateDoc(db, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// ListVersions returns the previous versions kept for an application, the
+// most recent first.
+func ListVersions(db prefixer.Prefixer, appType consts.AppType, slug string) ([]*Version, error) {
+	doctype := consts.Apps
+	if appType == consts.KonnectorType {
+		doctype = consts.Konnectors
+	}
+	return listVersions(db, doctype, slug)
+}
+
+func listVersions(db prefixer.Prefixer, doctype, slug string) ([]*Version, error) {
+	var versions []*Version
+	req := &couchdb.FindRequest{
+		UseIndex: "by-slug",
+		Selector: mango.And(
+			mango.Equal("app_type", doctype),
+			mango.Equal("slug", slug),
+		),
+		Sort: mango.SortBy{
+			{Field: "app_type", Direction: mango.Desc},
+			{Field: "slug", Direction: mango.Desc},
+			{Field: "archived_at", Direction: mango.Desc},
+		},
+		Limit: 100,
+	}
+	err := couchdb.FindDocs(db, consts.AppsVersions, req, &versions)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return []*Version{}, nil
+		}
+		return nil, err
+	}
+	return versions, nil
+}
+
+// pruneVersions removes the oldest versions of an application, and their
+// files, to keep only the configured number of previous versions.
+func pruneVersions(db prefixer.Prefixer, fs appfs.Copier, man Manifest) error {
+	versions, err := listVersions(db, man.DocType(), man.Slug())
+	if err != nil {
+		return err
+	}
+	keep := []string{appfs.VersionName(man.Version(), man.Checksum())}
+	n := keptVersions()
+	if len(versions) > n {
+		old := make([]couchdb.Doc, 0, len(versions)-n)
+		for _, v := range versions[n:] {
+			old = append(old, v)
+		}
+		if err := couchdb.BulkDeleteDocs(db, consts.AppsVersions, old); err != nil {
+			return err
+		}
+		versions = versions[:n]
+	}
+	for _, v := range versions {
+		keep = append(keep, appfs.VersionName(v.Version, v.Checksum))
+	}
+	return fs.Prune(man.Slug(), keep)
+}
+
+// extraPermissions returns the permissions that have been added to the
+// application after its installation, as they must be kept when the
+// application is updated.
+func extraPermissions(domain string, man Manifest) (permission.Set, error) {
+	extraPerms := permission.Set{}
+	inst, err := instance.Get(domain)
+	if err != nil {
+		return extraPerms, nil
+	}
+	var alteredPerms *permission.Permission
+	// Check if perms were added on the old manifest
+	if man.AppType() == consts.WebappType {
+		alteredPerms, err = permission.GetForWebapp(inst, man.Slug())
+	} else if man.AppType() == consts.KonnectorType {
+		alteredPerms, err = permission.GetForKonnector(inst, man.Slug())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if alteredPerms == nil {
+		return extraPerms, nil
+	}
+	return permission.Diff(man.Permissions(), alteredPerms.Permissions)
+}
+
+// Rollback replaces the installed version of an application by the most
+// recent previous version whose files are still available. The replaced
+// version is marked as the available version, so that the automatic updates
+// do not install it again.
+func Rollback(inst *instance.Instance, fs appfs.Copier, appType consts.AppType, slug string) (Manifest, error) {
+	man, err := GetBySlug(inst, slug, appType)
+	if err != nil {
+		return nil, err
+	}
+	if state := man.State(); state != Ready && state != Installed {
+		return nil, ErrBadState
+	}
+
+	versions, err := listVersions(inst, man.DocType(), slug)
+	if err != nil {
+		return nil, err
+	}
+	var previous *Version
+	for _, v := range versions {
+		exists, err := fs.Exists(slug, v.Version, v.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			previous = v
+			break
+		}
+		_ = couchdb.DeleteDoc(inst, v)
+	}
+	if previous == nil {
+		return nil, ErrNoPreviousVersion
+	}
+
+	newManifest, err := man.ReadManifest(bytes.NewReader(previous.Manifest), slug, previous.Source)
+	if err != nil {
+		return nil, err
+	}
+	newManifest.SetAvailableVersion(man.Version())
+	newManifest.SetPendingUpdate(nil)
+	var archived struct {
+		Signature *SignatureStatus `json:"signature"`
+	}
+	if err := json.Unmarshal(previous.Manifest, &archived); err == nil {
+		newManifest.SetSignature(archived.Signature)
+	}
+
+	extraPerms, err := extraPermissions(inst.Domain, man)
+	if err != nil {
+		return nil, err
+	}
+	if err := newManifest.Update(inst, extraPerms); err != nil {
+		return nil, err
+	}
+	if err := couchdb.DeleteDoc(inst, previous); err != nil {
+		return nil, err
+	}
+	return newManifest, nil
+}
@@ -30,10 +30,16 @@ type Route struct {
 	Folder string `json:"folder"`
 	Index  string `json:"index"`
 	Public bool   `json:"public"`
+
+	// Optional fields to customize the responses (see routes.go)
+	Headers        map[string]string   `json:"headers,omitempty"`
+	CSP            map[string][]string `json:"csp,omitempty"`
+	Redirect       string              `json:"redirect,omitempty"`
+	RedirectStatus int                 `json:"redirect_status,omitempty"`
 }
 
 // NotFound returns true for a blank route (ie not found by FindRoute)
-func (c *Route) NotFound() bool { return c.Folder == "" }
+func (c *Route) NotFound() bool { return c.Folder == "" && c.Redirect == "" }
 
 // Routes is a map for routing inside an application.
 type Routes map[string]Route
@@ -47,6 +53,8 @@ type Service struct {
 	Debounce       string `json:"debounce"`
 	TriggerOptions string `json:"trigger"`
 	TriggerID      string `json:"trigger_id"`
+
+	Limits *ServiceLimits `json:"limits,omitempty"`
 }
 
 // Services is a map to define services assciated with an application.
@@ -106,6 +114,10 @@ type WebappManifest struct {
 		Services      Services       `json:"services"`
 		Locales       Locales        `json:"locales"`
 		Notifications Notifications  `json:"notifications"`
+		PendingUpdate *PendingUpdate `json:"pending_update,omitempty"`
+
+		// Set by the installer
+		Signature *SignatureStatus `json:"signature,omitempty"`
 	}
 
 	FromAppsDir bool        `json:"-"` // Used in development
@@ -174,6 +186,18 @@ func (m *WebappManifest) SetVersion(version string) { m.val.Version = version }
 // SetAvailableVersion is part of the Manifest interface
 func (m *WebappManifest) SetAvailableVersion(version string) { m.val.AvailableVersion = version }
 
+// PendingUpdate is part of the Manifest interface
+func (m *WebappManifest) PendingUpdate() *PendingUpdate { return m.val.PendingUpdate }
+
+// SetPendingUpdate is part of the Manifest interface
+func (m *WebappManifest) SetPendingUpdate(pending *PendingUpdate) { m.val.PendingUpdate = pending }
+
+// Signature is part of the Manifest interface
+func (m *WebappManifest) Signature() *SignatureStatus { return m.val.Signature }
+
+// SetSignature is part of the Manifest interface
+func (m *WebappManifest) SetSignature(status *SignatureStatus) { m.val.Signature = status }
+
 // SetChecksum is part of the Manifest interface
 func (m *WebappManifest) SetChecksum(shasum string) { m.val.Checksum = shasum }
 
@@ -249,6 +273,16 @@ func (m *WebappManifest) MarshalJSON() ([]byte, error) {
 	} else {
 		m.doc.M["available_version"] = m.val.AvailableVersion
 	}
+	if m.val.PendingUpdate == nil {
+		delete(m.doc.M, "pending_update")
+	} else {
+		m.doc.M["pending_update"] = m.val.PendingUpdate
+	}
+	if m.val.Signature == nil {
+		delete(m.doc.M, "signature")
+	} else {
+		m.doc.M["signature"] = m.val.Signature
+	}
 	m.doc.M["checksum"] = m.val.Checksum
 	m.doc.M["created_at"] = m.val.CreatedAt
 	m.doc.M["updated_at"] = m.val.UpdatedAt
@@ -289,6 +323,8 @@ func (m *WebappManifest) ReadManifest(r io.Reader, slug, sourceURL string) (Mani
 	newManifest.val.CreatedAt = m.val.CreatedAt
 	newManifest.val.Slug = slug
 	newManifest.val.Source = sourceURL
+	newManifest.val.PendingUpdate = nil
+	newManifest.val.Signature = nil
 	newManifest.Instance = m.Instance
 	newManifest.oldServices = m.val.Services
 	if newManifest.val.Routes == nil {
@@ -358,6 +394,9 @@ func (m *WebappManifest) Delete(db prefixer.Prefixer) error {
 	if err != nil && !couchdb.IsNotFoundError(err) {
 		return err
 	}
+	if err = clearServiceViolations(db, m.Slug()); err != nil {
+		return err
+	}
 	return couchdb.DeleteDoc(db, m)
 }
 
@@ -134,12 +134,19 @@ func CreateRefreshJWT(i *instance.Instance, c *oauth.Client) (string, error) {
 	if settings, err := settings.Get(i); err == nil {
 		stamp = settings.SecurityStamp
 	}
+	jti, err := oauth.RecordRefreshToken(i, c.CouchID, BitwardenScope)
+	if err != nil {
+		i.Logger().WithNamespace("oauth").
+			Errorf("Failed to record the bitwarden refresh token: %s", err)
+		return "", err
+	}
 	token, err := crypto.NewJWT(i.OAuthSecret, permission.Claims{
 		StandardClaims: crypto.StandardClaims{
 			Audience: consts.RefreshTokenAudience,
 			Issuer:   i.Domain,
 			IssuedAt: crypto.Timestamp(),
 			Subject:  c.CouchID,
+			ID:       jti,
 		},
 		SStamp: stamp,
 		Scope:  BitwardenScope,
@@ -0,0 +1,411 @@
+package settings
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/account"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// credentialsVaultCacheKeyPrefix is the prefix of the cache keys where the
+// unlocked private keys of the credentials vaults are kept.
+const credentialsVaultCacheKeyPrefix = "credentials-vault:"
+
+// credentialsVaultPendingKeyPrefix is the prefix of the cache keys where the
+// private keys are kept between the check of the passphrase and the check of
+// the second factor.
+const credentialsVaultPendingKeyPrefix = "credentials-vault-pending:"
+
+// credentialsVaultPendingTTL is how long the private key is kept while
+// waiting for the second factor.
+const credentialsVaultPendingTTL = 15 * time.Minute
+
+const (
+	wrapSaltLen  = 16
+	wrapNonceLen = 24
+)
+
+var (
+	// ErrCredentialsVaultEnabled is used when trying to enable the credentials
+	// vault of an instance that already has one.
+	ErrCredentialsVaultEnabled = errors.New("The credentials vault is already enabled")
+	// ErrInvalidCredentialsKey is used when the private key of the credentials
+	// vault cannot be unwrapped.
+	ErrInvalidCredentialsKey = errors.New("Invalid credentials key")
+	// ErrNoCredentialsReentry is used when the passphrase of the credentials
+	// vault is given again, but it was not needed.
+	ErrNoCredentialsReentry = errors.New("The credentials vault does not need the previous passphrase")
+)
+
+var cbCredentialsReentry func(inst *instance.Instance)
+
+// RegisterCredentialsReentryCallback registers a function that is called when
+// the credentials vault of an instance needs the previous passphrase of the
+// user, after a reset of the passphrase.
+func RegisterCredentialsReentryCallback(cb func(inst *instance.Instance)) {
+	cbCredentialsReentry = cb
+}
+
+// NotifyCredentialsReentry tells the user that the previous passphrase is
+// needed to unlock the credentials vault again.
+func (s *Settings) NotifyCredentialsReentry(inst *instance.Instance) {
+	if s.CredentialsReentry && cbCredentialsReentry != nil {
+		cbCredentialsReentry(inst)
+	}
+}
+
+// HasCredentialsVault returns true if the credentials of the accounts are
+// encrypted with a key specific to this instance.
+func (s *Settings) HasCredentialsVault() bool {
+	return s.CredentialsPublicKey != ""
+}
+
+// EnableCredentialsVault generates a key pair for the credentials vault of
+// the instance. The private key is wrapped with a key derived from the
+// passphrase of the user, as hashed by the client (it is the master password
+// hash of bitwarden). The settings are not saved.
+func (s *Settings) EnableCredentialsVault(passphrase []byte) (*account.InstanceKey, error) {
+	if s.HasCredentialsVault() {
+		return nil, ErrCredentialsVaultEnabled
+	}
+	key, err := account.GenerateInstanceKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.WrapCredentialsKey(key, passphrase); err != nil {
+		return nil, err
+	}
+	s.CredentialsPublicKey = base64.StdEncoding.EncodeToString(key.PublicKey[:])
+	return key, nil
+}
+
+// WrapCredentialsKey encrypts the private key of the credentials vault with
+// a key derived from the given passphrase.
+func (s *Settings) WrapCredentialsKey(key *account.InstanceKey, passphrase []byte) error {
+	if key.Locked() {
+		return account.ErrCredentialsLocked
+	}
+	salt := crypto.GenerateRandomBytes(wrapSaltLen)
+	kek, err := deriveWrappingKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	var nonce [wrapNonceLen]byte
+	copy(nonce[:], crypto.GenerateRandomBytes(wrapNonceLen))
+	out := make([]byte, 0, wrapSaltLen+wrapNonceLen+secretbox.Overhead+len(key.PrivateKey))
+	out = append(out, salt...)
+	out = append(out, nonce[:]...)
+	out = secretbox.Seal(out, key.PrivateKey[:], &nonce, kek)
+	s.CredentialsPrivateKey = base64.StdEncoding.EncodeToString(out)
+	return nil
+}
+
+// UnwrapCredentialsKey returns the key pair of the credentials vault, by
+// decrypting the private key with the given passphrase.
+func (s *Settings) UnwrapCredentialsKey(passphrase []byte) (*account.InstanceKey, error) {
+	key, err := s.credentialsPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(s.CredentialsPrivateKey)
+	if err != nil || len(wrapped) < wrapSaltLen+wrapNonceLen {
+		return nil, ErrInvalidCredentialsKey
+	}
+	kek, err := deriveWrappingKey(passphrase, wrapped[:wrapSaltLen])
+	if err != nil {
+		return nil, err
+	}
+	var nonce [wrapNonceLen]byte
+	copy(nonce[:], wrapped[wrapSaltLen:])
+	priv, ok := secretbox.Open(nil, wrapped[wrapSaltLen+wrapNonceLen:], &nonce, kek)
+	if !ok || len(priv) != 32 {
+		return nil, ErrInvalidCredentialsKey
+	}
+	key.PrivateKey = new([32]byte)
+	copy(key.PrivateKey[:], priv)
+	return key, nil
+}
+
+// UpdateCredentialsPassphrase wraps again the private key of the credentials
+// vault when the passphrase is changed. The current passphrase is optional,
+// as the key can also be taken from the cache if the vault is unlocked. If
+// the key cannot be unwrapped, the key pair is kept as is, wrapped with the
+// previous passphrase, and the vault is marked as needing this previous
+// passphrase (see ReenterCredentialsPassphrase). It returns false in that
+// case, and the caller should notify the user.
+func (s *Settings) UpdateCredentialsPassphrase(inst *instance.Instance, current, passphrase []byte) bool {
+	if !s.HasCredentialsVault() {
+		return true
+	}
+	key, err := CredentialsKey(inst, s)
+	if (err != nil || key.Locked()) && len(current) > 0 {
+		key, err = s.UnwrapCredentialsKey(current)
+	}
+	if err == nil && !key.Locked() {
+		if err = s.WrapCredentialsKey(key, passphrase); err == nil {
+			s.CredentialsReentry = false
+			return true
+		}
+	}
+	inst.Logger().WithNamespace("credentials-vault").
+		Warnf("Cannot wrap the credentials key with the new passphrase: %v", err)
+	s.CredentialsReentry = true
+	LockCredentialsVault(inst)
+	return false
+}
+
+// ReenterCredentialsPassphrase unwraps the private key of the credentials
+// vault with the previous passphrase of the user, after a reset of the
+// passphrase, and wraps it again with the current passphrase. The settings
+// are saved.
+func (s *Settings) ReenterCredentialsPassphrase(inst *instance.Instance, previous, passphrase []byte) error {
+	if !s.HasCredentialsVault() || !s.CredentialsReentry {
+		return ErrNoCredentialsReentry
+	}
+	key, err := s.UnwrapCredentialsKey(previous)
+	if err != nil {
+		return err
+	}
+	if err := s.WrapCredentialsKey(key, passphrase); err != nil {
+		return err
+	}
+	s.CredentialsReentry = false
+	if err := s.Save(inst); err != nil {
+		return err
+	}
+	UnlockCredentialsVault(inst, key)
+	return nil
+}
+
+func (s *Settings) credentialsPublicKey() (*account.InstanceKey, error) {
+	pub, err := base64.StdEncoding.DecodeString(s.CredentialsPublicKey)
+	if err != nil || len(pub) != 32 {
+		return nil, ErrInvalidCredentialsKey
+	}
+	key := &account.InstanceKey{PublicKey: new([32]byte)}
+	copy(key.PublicKey[:], pub)
+	return key, nil
+}
+
+func deriveWrappingKey(passphrase, salt []byte) (*[32]byte, error) {
+	dk, err := scrypt.Key(passphrase, salt, 32768, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	var kek [32]byte
+	copy(kek[:], dk)
+	return &kek, nil
+}
+
+// UnlockCredentialsVault keeps the private key of the credentials vault in
+// the cache, for the konnectors that are started in the next minutes (a
+// konnector reads it once, at the beginning of its run). It acts as a
+// short-lived token issued at login, and the key is encrypted with the vault
+// key of the stack when there is one.
+func UnlockCredentialsVault(inst *instance.Instance, key *account.InstanceKey) {
+	if key.Locked() {
+		return
+	}
+	value := key.PrivateKey[:]
+	if encryptorKey := config.GetVault().CredentialsEncryptorKey(); encryptorKey != nil {
+		encrypted, err := account.EncryptBufferWithKey(encryptorKey, value)
+		if err != nil {
+			return
+		}
+		value = encrypted
+	}
+	cache := config.GetConfig().CacheStorage
+	cache.Set(credentialsVaultCacheKeyPrefix+inst.Domain, value, config.GetConfig().CredentialsUnlockTTL)
+}
+
+// LockCredentialsVault removes the private key of the credentials vault from
+// the cache.
+func LockCredentialsVault(inst *instance.Instance) {
+	cache := config.GetConfig().CacheStorage
+	cache.Clear(credentialsVaultCacheKeyPrefix + inst.Domain)
+}
+
+// CredentialsKey returns the key pair of the credentials vault of the
+// instance, or nil if the instance has no credentials vault. The private key
+// is only present if the vault has been unlocked.
+func CredentialsKey(inst *instance.Instance, s *Settings) (*account.InstanceKey, error) {
+	if !s.HasCredentialsVault() {
+		return nil, nil
+	}
+	key, err := s.credentialsPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	cache := config.GetConfig().CacheStorage
+	value, ok := cache.Get(credentialsVaultCacheKeyPrefix + inst.Domain)
+	if !ok {
+		return key, nil
+	}
+	if decryptorKey := config.GetVault().CredentialsDecryptorKey(); decryptorKey != nil {
+		value, err = account.DecryptBufferWithKey(decryptorKey, value)
+		if err != nil {
+			return key, nil
+		}
+	}
+	if len(value) == 32 {
+		key.PrivateKey = new([32]byte)
+		copy(key.PrivateKey[:], value)
+	}
+	return key, nil
+}
+
+// CredentialsCipher returns the cipher to use for the accounts of the
+// instance.
+func CredentialsCipher(inst *instance.Instance) (*account.Cipher, error) {
+	s, err := Get(inst)
+	if err != nil {
+		return nil, err
+	}
+	key, err := CredentialsKey(inst, s)
+	if err != nil {
+		return nil, err
+	}
+	return account.NewCipher(key), nil
+}
+
+// UnlockCredentialsVaultAtLogin is called when the user has logged in with
+// their passphrase, and the second factor if any. It unlocks the credentials
+// vault, and creates it if the context of the instance asks for it.
+func UnlockCredentialsVaultAtLogin(inst *instance.Instance, passphrase []byte) error {
+	key, err := credentialsKeyAtLogin(inst, passphrase)
+	if err != nil || key == nil {
+		return err
+	}
+	UnlockCredentialsVault(inst, key)
+	return nil
+}
+
+// PrepareCredentialsVaultUnlock is called when the passphrase of the user has
+// been checked, but the second factor has not. The private key is kept in the
+// cache, encrypted with a key derived from the two-factor token, and the
+// vault is unlocked only by CompleteCredentialsVaultUnlock.
+func PrepareCredentialsVaultUnlock(inst *instance.Instance, passphrase, twoFactorToken []byte) error {
+	key, err := credentialsKeyAtLogin(inst, passphrase)
+	if err != nil || key == nil {
+		return err
+	}
+	cacheKey, kek := pendingCredentialsKeys(inst, twoFactorToken)
+	var nonce [wrapNonceLen]byte
+	copy(nonce[:], crypto.GenerateRandomBytes(wrapNonceLen))
+	value := secretbox.Seal(nonce[:], key.PrivateKey[:], &nonce, kek)
+	cache := config.GetConfig().CacheStorage
+	cache.Set(cacheKey, value, credentialsVaultPendingTTL)
+	return nil
+}
+
+// CompleteCredentialsVaultUnlock is called when the second factor has been
+// checked, to unlock the credentials vault prepared with the same token.
+func CompleteCredentialsVaultUnlock(inst *instance.Instance, twoFactorToken []byte) {
+	cacheKey, kek := pendingCredentialsKeys(inst, twoFactorToken)
+	cache := config.GetConfig().CacheStorage
+	value, ok := cache.Get(cacheKey)
+	if !ok {
+		return
+	}
+	cache.Clear(cacheKey)
+	if len(value) < wrapNonceLen {
+		return
+	}
+	var nonce [wrapNonceLen]byte
+	copy(nonce[:], value)
+	priv, ok := secretbox.Open(nil, value[wrapNonceLen:], &nonce, kek)
+	if !ok || len(priv) != 32 {
+		return
+	}
+	s, err := Get(inst)
+	if err != nil {
+		return
+	}
+	key, err := s.credentialsPublicKey()
+	if err != nil {
+		return
+	}
+	key.PrivateKey = new([32]byte)
+	copy(key.PrivateKey[:], priv)
+	UnlockCredentialsVault(inst, key)
+}
+
+// pendingCredentialsKeys returns the cache key and the encryption key used
+// for keeping a private key until the second factor has been checked.
+func pendingCredentialsKeys(inst *instance.Instance, twoFactorToken []byte) (string, *[32]byte) {
+	id := sha256.Sum256(append([]byte("id:"), twoFactorToken...))
+	kek := sha256.Sum256(append([]byte("key:"), twoFactorToken...))
+	return credentialsVaultPendingKeyPrefix + inst.Domain + ":" + hex.EncodeToString(id[:]), &kek
+}
+
+// credentialsKeyAtLogin returns the unlocked key of the credentials vault for
+// the given passphrase, or nil if the instance has no credentials vault. The
+// vault is created if the context of the instance asks for it.
+func credentialsKeyAtLogin(inst *instance.Instance, passphrase []byte) (*account.InstanceKey, error) {
+	s, err := Get(inst)
+	if err != nil {
+		return nil, err
+	}
+	if !s.HasCredentialsVault() {
+		if !credentialsVaultForced(inst) {
+			return nil, nil
+		}
+		return enableCredentialsVaultAndMigrate(inst, s, passphrase)
+	}
+	if s.CredentialsReentry {
+		// The key is still wrapped with the previous passphrase
+		return nil, nil
+	}
+	return s.UnwrapCredentialsKey(passphrase)
+}
+
+// EnableCredentialsVaultAndMigrate creates the credentials vault of the
+// instance, unlocks it, and pushes a job to encrypt the existing accounts
+// with its key.
+func EnableCredentialsVaultAndMigrate(inst *instance.Instance, s *Settings, passphrase []byte) error {
+	key, err := enableCredentialsVaultAndMigrate(inst, s, passphrase)
+	if err != nil {
+		return err
+	}
+	UnlockCredentialsVault(inst, key)
+	return nil
+}
+
+func enableCredentialsVaultAndMigrate(inst *instance.Instance, s *Settings, passphrase []byte) (*account.InstanceKey, error) {
+	key, err := s.EnableCredentialsVault(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Save(inst); err != nil {
+		return nil, err
+	}
+	msg, err := job.NewMessage(map[string]interface{}{
+		"type": "accounts-to-credentials-vault",
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: "migrations",
+		Message:    msg,
+	})
+	return key, err
+}
+
+func credentialsVaultForced(inst *instance.Instance) bool {
+	ctxSettings, ok := inst.SettingsContext()
+	if !ok {
+		return false
+	}
+	forced, _ := ctxSettings["credentials_vault"].(bool)
+	return forced
+}
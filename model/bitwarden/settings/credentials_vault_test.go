@@ -0,0 +1,65 @@
+package settings
+
+import (
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapCredentialsKey(t *testing.T) {
+	s := &Settings{}
+	assert.False(t, s.HasCredentialsVault())
+
+	key, err := s.EnableCredentialsVault([]byte("passphrase-hash"))
+	require.NoError(t, err)
+	assert.True(t, s.HasCredentialsVault())
+	_, err = s.EnableCredentialsVault([]byte("passphrase-hash"))
+	assert.Equal(t, ErrCredentialsVaultEnabled, err)
+
+	unwrapped, err := s.UnwrapCredentialsKey([]byte("passphrase-hash"))
+	require.NoError(t, err)
+	assert.Equal(t, key.PublicKey, unwrapped.PublicKey)
+	assert.Equal(t, key.PrivateKey, unwrapped.PrivateKey)
+
+	_, err = s.UnwrapCredentialsKey([]byte("wrong"))
+	assert.Equal(t, ErrInvalidCredentialsKey, err)
+
+	require.NoError(t, s.WrapCredentialsKey(key, []byte("new-passphrase-hash")))
+	_, err = s.UnwrapCredentialsKey([]byte("passphrase-hash"))
+	assert.Equal(t, ErrInvalidCredentialsKey, err)
+	unwrapped, err = s.UnwrapCredentialsKey([]byte("new-passphrase-hash"))
+	require.NoError(t, err)
+	assert.Equal(t, key.PrivateKey, unwrapped.PrivateKey)
+}
+
+func TestCredentialsReentry(t *testing.T) {
+	config.UseTestFile()
+	inst := &instance.Instance{Domain: "reentry.example.net"}
+	s := &Settings{}
+	key, err := s.EnableCredentialsVault([]byte("old-passphrase-hash"))
+	require.NoError(t, err)
+	wrapped := s.CredentialsPrivateKey
+	LockCredentialsVault(inst)
+
+	// The passphrase is reset while the vault is locked: the key is kept
+	assert.False(t, s.UpdateCredentialsPassphrase(inst, nil, []byte("new-passphrase-hash")))
+	assert.True(t, s.CredentialsReentry)
+	assert.Equal(t, wrapped, s.CredentialsPrivateKey)
+
+	err = s.ReenterCredentialsPassphrase(inst, []byte("wrong"), []byte("new-passphrase-hash"))
+	assert.Equal(t, ErrInvalidCredentialsKey, err)
+	assert.True(t, s.CredentialsReentry)
+
+	unwrapped, err := s.UnwrapCredentialsKey([]byte("old-passphrase-hash"))
+	require.NoError(t, err)
+	require.NoError(t, s.WrapCredentialsKey(unwrapped, []byte("new-passphrase-hash")))
+	s.CredentialsReentry = false
+	unwrapped, err = s.UnwrapCredentialsKey([]byte("new-passphrase-hash"))
+	require.NoError(t, err)
+	assert.Equal(t, key.PrivateKey, unwrapped.PrivateKey)
+	assert.Equal(t, ErrNoCredentialsReentry,
+		s.ReenterCredentialsPassphrase(inst, []byte("old-passphrase-hash"), []byte("new-passphrase-hash")))
+}
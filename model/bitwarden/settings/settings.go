@@ -37,6 +37,9 @@ type Settings struct {
 	GlobalEquivalentDomains []int                  `json:"global_equivalent_domains,omitempty"`
 	Metadata                *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
 	ExtensionInstalled      bool                   `json:"extension_installed,omitempty"`
+	CredentialsPublicKey    string                 `json:"credentials_public_key,omitempty"`
+	CredentialsPrivateKey   string                 `json:"credentials_private_key,omitempty"`
+	CredentialsReentry      bool                   `json:"credentials_reentry,omitempty"`
 }
 
 // ID returns the settings qualified identifier
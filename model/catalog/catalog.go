@@ -0,0 +1,589 @@
+// Package catalog is the registry of applications built in the stack. The
+// versions are persisted in a global CouchDB database, and their tarballs and
+// icons are kept on the local file system or in Swift. It can be used by the
+// contexts that cannot reach an external registry, via local:// URLs.
+package catalog
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	semver "github.com/Masterminds/semver/v3"
+	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+)
+
+const (
+	// Stable is the channel of the versions without a pre-release suffix.
+	Stable = "stable"
+	// Beta is the channel of the versions with a -beta suffix.
+	Beta = "beta"
+	// Dev is the channel of the versions with a -dev suffix.
+	Dev = "dev"
+)
+
+// MaxTarballSize is the maximal size of a tarball that can be published.
+const MaxTarballSize = 100 << 20
+
+// maxIconSize is the maximal size of the icon of a version.
+const maxIconSize = 1 << 20
+
+// pendingTimeout is the delay after which a version that is still pending,
+// because its publication has been interrupted, can be published again.
+const pendingTimeout = 1 * time.Hour
+
+var (
+	// ErrNoStorage is used when the storage of the registry has not been
+	// initialized
+	ErrNoStorage = errors.New("catalog: the storage is not initialized")
+	// ErrTarballTooLarge is used when the tarball exceeds MaxTarballSize
+	ErrTarballTooLarge = errors.New("catalog: the tarball is too large")
+	// ErrInvalidTarball is used when the tarball cannot be read
+	ErrInvalidTarball = errors.New("catalog: the tarball is invalid")
+	// ErrNoManifest is used when the tarball has no manifest
+	ErrNoManifest = errors.New("catalog: no manifest found in the tarball")
+	// ErrInvalidManifest is used when the manifest has no valid slug or
+	// version
+	ErrInvalidManifest = errors.New("catalog: the manifest has an invalid slug or version")
+	// ErrInvalidSignature is used when the signature cannot be parsed
+	ErrInvalidSignature = errors.New("catalog: the signature is invalid")
+	// ErrVersionExists is used when publishing a version that is already on
+	// the registry
+	ErrVersionExists = errors.New("catalog: this version has already been published")
+	// ErrVersionNotFound is used when the version does not exist
+	ErrVersionNotFound = errors.New("catalog: version not found")
+	// ErrAppNotFound is used when no version has been published for a slug
+	ErrAppNotFound = errors.New("catalog: application not found")
+)
+
+var slugReg = regexp.MustCompile(`^[a-z0-9\-]+$`)
+var versionReg = regexp.MustCompile(`^\d`)
+
+// Version is a version of an application published on the registry.
+type Version struct {
+	DocID        string          `json:"_id,omitempty"`
+	DocRev       string          `json:"_rev,omitempty"`
+	Slug         string          `json:"slug"`
+	Type         string          `json:"type"`
+	Version      string          `json:"version"`
+	Channel      string          `json:"channel"`
+	Sha256       string          `json:"sha256"`
+	Size         int64           `json:"size"`
+	Manifest     json.RawMessage `json:"manifest"`
+	TarPrefix    string          `json:"tar_prefix,omitempty"`
+	Signature    string          `json:"signature,omitempty"`
+	IconMIME     string          `json:"icon_mime,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Deprecated   bool            `json:"deprecated,omitempty"`
+	DeprecatedAt *time.Time      `json:"deprecated_at,omitempty"`
+	// Pending is true while the tarball of the version is being stored: the
+	// version is not visible before the end of its publication.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (v *Version) ID() string { return v.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (v *Version) Rev() string { return v.DocRev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (v *Version) DocType() string { return consts.RegistryVersions }
+
+// SetID is used to implement the couchdb.Doc interface
+func (v *Version) SetID(id string) { v.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (v *Version) SetRev(rev string) { v.DocRev = rev }
+
+// Clone implements couchdb.Doc
+func (v *Version) Clone() couchdb.Doc {
+	cloned := *v
+	cloned.Manifest = make(json.RawMessage, len(v.Manifest))
+	copy(cloned.Manifest, v.Manifest)
+	if v.DeprecatedAt != nil {
+		at := *v.DeprecatedAt
+		cloned.DeprecatedAt = &at
+	}
+	return &cloned
+}
+
+// TarballName returns the name of the tarball in the storage.
+func (v *Version) TarballName() string {
+	return path.Join(v.Slug, v.Version, "tarball")
+}
+
+// IconName returns the name of the icon in the storage.
+func (v *Version) IconName() string {
+	return path.Join(v.Slug, v.Version, "icon")
+}
+
+func versionID(slug, version string) string {
+	return slug + "@" + version
+}
+
+// ChannelOf returns the channel of a version number: dev for the versions
+// with a -dev suffix, beta for -beta, and stable for the others.
+func ChannelOf(version string) string {
+	switch {
+	case strings.Contains(version, "-dev"):
+		return Dev
+	case strings.Contains(version, "-beta"):
+		return Beta
+	default:
+		return Stable
+	}
+}
+
+// IsValidChannel returns true if the given string is a known channel.
+func IsValidChannel(channel string) bool {
+	return channel == Stable || channel == Beta || channel == Dev
+}
+
+// channelRank is used to compare the channels: the latest version of a
+// channel can be taken from the channels with a lower or equal rank.
+func channelRank(channel string) int {
+	switch channel {
+	case Stable:
+		return 0
+	case Beta:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Publish adds a new version on the registry from its tarball, and an
+// optional minisign signature. The slug, version and type of the application
+// are read from the manifest inside the tarball.
+//
+// The tarball is written in a temporary file to be read, and the document of
+// the version is created before storing the tarball: it prevents the
+// concurrent publications of the same version, and it is removed if the
+// files cannot be stored.
+func Publish(tarball io.Reader, signature string) (*Version, error) {
+	s, err := getStorage()
+	if err != nil {
+		return nil, err
+	}
+	if signature != "" {
+		if _, err := crypto.ParseMinisignSignature(signature); err != nil {
+			return nil, ErrInvalidSignature
+		}
+	}
+
+	tmp, err := ioutil.TempFile("", "cozy-registry-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(tarball, MaxTarballSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > MaxTarballSize {
+		return nil, ErrTarballTooLarge
+	}
+
+	v, err := readPackage(tmp)
+	if err != nil {
+		return nil, err
+	}
+	icon, mimetype := readIcon(tmp, v)
+	v.DocID = versionID(v.Slug, v.Version)
+	v.Channel = ChannelOf(v.Version)
+	v.Sha256 = hex.EncodeToString(h.Sum(nil))
+	v.Size = size
+	v.Signature = signature
+	v.CreatedAt = time.Now().UTC()
+	v.Pending = true
+	if icon != nil {
+		v.IconMIME = mimetype
+	}
+	if err := createVersion(s, v); err != nil {
+		return nil, err
+	}
+
+	err = storeFiles(s, v, tmp, icon)
+	if err == nil {
+		v.Pending = false
+		err = couchdb.UpdateDoc(couchdb.GlobalDB, v)
+	}
+	if err != nil {
+		deleteFiles(s, v)
+		_ = couchdb.DeleteDoc(couchdb.GlobalDB, v)
+		return nil, err
+	}
+	return v, nil
+}
+
+// createVersion creates the document of a pending version. A version whose
+// publication has been interrupted for a long time is replaced.
+func createVersion(s storage, v *Version) error {
+	err := couchdb.CreateNamedDocWithDB(couchdb.GlobalDB, v)
+	if !couchdb.IsConflictError(err) {
+		return err
+	}
+	old, err := getVersion(v.Slug, v.Version)
+	if err != nil {
+		return err
+	}
+	if !old.Pending || time.Since(old.CreatedAt) < pendingTimeout {
+		return ErrVersionExists
+	}
+	deleteFiles(s, old)
+	if err := couchdb.DeleteDoc(couchdb.GlobalDB, old); err != nil {
+		return err
+	}
+	if err := couchdb.CreateNamedDoc(couchdb.GlobalDB, v); err != nil {
+		if couchdb.IsConflictError(err) {
+			return ErrVersionExists
+		}
+		return err
+	}
+	return nil
+}
+
+func storeFiles(s storage, v *Version, tarball io.ReadSeeker, icon []byte) error {
+	if _, err := tarball.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.Put(v.TarballName(), tarball); err != nil {
+		return err
+	}
+	if icon != nil {
+		return s.Put(v.IconName(), bytes.NewReader(icon))
+	}
+	return nil
+}
+
+func deleteFiles(s storage, v *Version) {
+	_ = s.Delete(v.TarballName())
+	if v.IconMIME != "" {
+		_ = s.Delete(v.IconName())
+	}
+}
+
+// Deprecate marks a version as deprecated: it is still available, but it is
+// no longer given as the latest version of a channel.
+func Deprecate(slug, version string) (*Version, error) {
+	v, err := GetVersion(slug, version)
+	if err != nil {
+		return nil, err
+	}
+	if v.Deprecated {
+		return v, nil
+	}
+	now := time.Now().UTC()
+	v.Deprecated = true
+	v.DeprecatedAt = &now
+	if err := couchdb.UpdateDoc(couchdb.GlobalDB, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// GetVersion returns the version of an application with the given number.
+func GetVersion(slug, version string) (*Version, error) {
+	v, err := getVersion(slug, version)
+	if err != nil {
+		return nil, err
+	}
+	if v.Pending {
+		return nil, ErrVersionNotFound
+	}
+	return v, nil
+}
+
+func getVersion(slug, version string) (*Version, error) {
+	var v Version
+	err := couchdb.GetDoc(couchdb.GlobalDB, consts.RegistryVersions, versionID(slug, version), &v)
+	if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ListVersions returns the versions of an application, sorted from the lowest
+// to the highest version number.
+func ListVersions(slug string) ([]*Version, error) {
+	var docs []*Version
+	req := &couchdb.FindRequest{
+		UseIndex: "by-slug",
+		Selector: mango.Equal("slug", slug),
+		Sort: mango.SortBy{
+			{Field: "slug", Direction: mango.Asc},
+			{Field: "created_at", Direction: mango.Asc},
+		},
+		Limit: 1000,
+	}
+	err := couchdb.FindDocs(couchdb.GlobalDB, consts.RegistryVersions, req, &docs)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	versions := make([]*Version, 0, len(docs))
+	for _, v := range docs {
+		if !v.Pending {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, ErrAppNotFound
+	}
+	sortVersions(versions)
+	return versions, nil
+}
+
+// LatestVersion returns the most recent version of an application that has
+// not been deprecated, for the given channel. The beta channel includes the
+// stable versions, and the dev channel includes the beta and stable versions.
+func LatestVersion(slug, channel string) (*Version, error) {
+	versions, err := ListVersions(slug)
+	if err != nil {
+		return nil, err
+	}
+	if v := latestOf(versions, channel); v != nil {
+		return v, nil
+	}
+	return nil, ErrVersionNotFound
+}
+
+func latestOf(versions []*Version, channel string) *Version {
+	rank := channelRank(channel)
+	var latest *Version
+	for _, v := range versions {
+		if v.Deprecated || channelRank(v.Channel) > rank {
+			continue
+		}
+		if latest == nil || lessVersion(latest, v) {
+			latest = v
+		}
+	}
+	return latest
+}
+
+// lessVersion returns true if the version number of a is lower than the one
+// of b. The creation dates are compared for the numbers that are not valid
+// semver.
+func lessVersion(a, b *Version) bool {
+	va, erra := semver.NewVersion(a.Version)
+	vb, errb := semver.NewVersion(b.Version)
+	if erra != nil || errb != nil || va.Equal(vb) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return va.LessThan(vb)
+}
+
+func sortVersions(versions []*Version) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return lessVersion(versions[i], versions[j])
+	})
+}
+
+// App is an application on the registry, with all its versions.
+type App struct {
+	Slug     string
+	Type     string
+	Versions []*Version
+}
+
+// Latest returns the most recent version of the application for the given
+// channel, or nil if there is none.
+func (a *App) Latest(channel string) *Version {
+	return latestOf(a.Versions, channel)
+}
+
+// ListApps returns all the applications on the registry, sorted by slug.
+func ListApps() ([]*App, error) {
+	bySlug := make(map[string]*App)
+	err := couchdb.ForeachDocs(couchdb.GlobalDB, consts.RegistryVersions, func(_ string, doc json.RawMessage) error {
+		var v Version
+		if err := json.Unmarshal(doc, &v); err != nil {
+			return err
+		}
+		if v.Slug == "" || v.Pending {
+			return nil
+		}
+		a, ok := bySlug[v.Slug]
+		if !ok {
+			a = &App{Slug: v.Slug, Type: v.Type}
+			bySlug[v.Slug] = a
+		}
+		a.Versions = append(a.Versions, &v)
+		return nil
+	})
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	apps := make([]*App, 0, len(bySlug))
+	for _, a := range bySlug {
+		sortVersions(a.Versions)
+		apps = append(apps, a)
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Slug < apps[j].Slug })
+	return apps, nil
+}
+
+// OpenTarball returns a reader for the tarball of the given version.
+func OpenTarball(v *Version) (io.ReadCloser, error) {
+	return openFile(v.TarballName())
+}
+
+// OpenIcon returns a reader for the icon of the given version.
+func OpenIcon(v *Version) (io.ReadCloser, error) {
+	if v.IconMIME == "" {
+		return nil, os.ErrNotExist
+	}
+	return openFile(v.IconName())
+}
+
+func openFile(name string) (io.ReadCloser, error) {
+	s, err := getStorage()
+	if err != nil {
+		return nil, err
+	}
+	return s.Open(name)
+}
+
+// readPackage looks for the manifest in the tarball, and returns a version
+// filled with the information from it.
+func readPackage(tarball io.ReadSeeker) (*Version, error) {
+	var v *Version
+	err := walkTarball(tarball, func(name string, r io.Reader) (bool, error) {
+		prefix, base := path.Split(name)
+		prefix = strings.TrimSuffix(prefix, "/")
+		if strings.Contains(prefix, "/") {
+			return false, nil
+		}
+		var appType string
+		switch base {
+		case app.WebappManifestName:
+			appType = "webapp"
+		case app.KonnectorManifestName:
+			appType = "konnector"
+		default:
+			return false, nil
+		}
+		raw, err := ioutil.ReadAll(r)
+		if err != nil {
+			return false, ErrInvalidTarball
+		}
+		var man struct {
+			Slug    string `json:"slug"`
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal(raw, &man); err != nil {
+			return false, ErrInvalidManifest
+		}
+		if !slugReg.MatchString(man.Slug) || !versionReg.MatchString(man.Version) {
+			return false, ErrInvalidManifest
+		}
+		v = &Version{
+			Slug:      man.Slug,
+			Type:      appType,
+			Version:   man.Version,
+			Manifest:  raw,
+			TarPrefix: prefix,
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrNoManifest
+	}
+	return v, nil
+}
+
+// readIcon returns the icon declared in the manifest of the version, with its
+// mime-type, or nil if there is none.
+func readIcon(tarball io.ReadSeeker, v *Version) ([]byte, string) {
+	var man struct {
+		Icon string `json:"icon"`
+	}
+	if err := json.Unmarshal(v.Manifest, &man); err != nil || man.Icon == "" {
+		return nil, ""
+	}
+	iconPath := path.Join(v.TarPrefix, path.Clean("/" + man.Icon)[1:])
+	var icon []byte
+	_ = walkTarball(tarball, func(name string, r io.Reader) (bool, error) {
+		if name != iconPath {
+			return false, nil
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(r, maxIconSize+1))
+		if err == nil && len(data) <= maxIconSize {
+			icon = data
+		}
+		return true, err
+	})
+	if icon == nil {
+		return nil, ""
+	}
+	mimetype := mime.TypeByExtension(path.Ext(iconPath))
+	if mimetype == "" {
+		mimetype = "application/octet-stream"
+	}
+	return icon, mimetype
+}
+
+// walkTarball calls fn for each regular file of the (optionally gzipped)
+// tarball, from its beginning, until it returns true or an error.
+func walkTarball(tarball io.ReadSeeker, fn func(name string, r io.Reader) (bool, error)) error {
+	if _, err := tarball.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	br := bufio.NewReader(tarball)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return ErrInvalidTarball
+		}
+		defer gr.Close()
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return ErrInvalidTarball
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		done, err := fn(name, tr)
+		if done || err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package catalog
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestChannelOf(t *testing.T) {
+	assert.Equal(t, Stable, ChannelOf("1.2.3"))
+	assert.Equal(t, Beta, ChannelOf("1.2.3-beta.2"))
+	assert.Equal(t, Dev, ChannelOf("1.2.3-dev.7f3e2a1"))
+}
+
+func TestLatestOf(t *testing.T) {
+	now := time.Now()
+	versions := []*Version{
+		{Version: "1.0.0", Channel: Stable, CreatedAt: now.Add(-5 * time.Hour)},
+		{Version: "1.1.0-beta.1", Channel: Beta, CreatedAt: now.Add(-4 * time.Hour)},
+		{Version: "1.1.0", Channel: Stable, CreatedAt: now.Add(-3 * time.Hour), Deprecated: true},
+		{Version: "1.2.0-dev.abc", Channel: Dev, CreatedAt: now.Add(-2 * time.Hour)},
+	}
+	assert.Equal(t, "1.0.0", latestOf(versions, Stable).Version)
+	assert.Equal(t, "1.1.0-beta.1", latestOf(versions, Beta).Version)
+	assert.Equal(t, "1.2.0-dev.abc", latestOf(versions, Dev).Version)
+
+	versions[0].Deprecated = true
+	assert.Nil(t, latestOf(versions, Stable))
+
+	// The version numbers are compared, not the publication dates
+	versions = []*Version{
+		{Version: "1.10.0", Channel: Stable, CreatedAt: now.Add(-5 * time.Hour)},
+		{Version: "1.9.3", Channel: Stable, CreatedAt: now.Add(-1 * time.Hour)},
+		{Version: "1.11.0-beta.2", Channel: Beta, CreatedAt: now.Add(-4 * time.Hour)},
+		{Version: "1.11.0-beta.10", Channel: Beta, CreatedAt: now.Add(-3 * time.Hour)},
+	}
+	assert.Equal(t, "1.10.0", latestOf(versions, Stable).Version)
+	assert.Equal(t, "1.11.0-beta.10", latestOf(versions, Beta).Version)
+	sortVersions(versions)
+	assert.Equal(t, "1.9.3", versions[0].Version)
+	assert.Equal(t, "1.11.0-beta.10", versions[3].Version)
+}
+
+func TestReadPackage(t *testing.T) {
+	data := makeTarball(t, map[string]string{
+		"build/manifest.webapp": `{"slug": "drive", "version": "1.2.3", "icon": "./img/icon.svg"}`,
+		"build/img/icon.svg":    "<svg></svg>",
+		"build/index.html":      "<html></html>",
+	})
+	v, err := readPackage(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "drive", v.Slug)
+	assert.Equal(t, "1.2.3", v.Version)
+	assert.Equal(t, "webapp", v.Type)
+	assert.Equal(t, "build", v.TarPrefix)
+
+	icon, mimetype := readIcon(bytes.NewReader(data), v)
+	assert.Equal(t, "<svg></svg>", string(icon))
+	assert.Equal(t, "image/svg+xml", mimetype)
+
+	data = makeTarball(t, map[string]string{
+		"manifest.konnector": `{"slug": "bank", "version": "2.0.0-beta.1"}`,
+	})
+	v, err = readPackage(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "konnector", v.Type)
+	assert.Equal(t, "", v.TarPrefix)
+	icon, _ = readIcon(bytes.NewReader(data), v)
+	assert.Nil(t, icon)
+
+	data = makeTarball(t, map[string]string{
+		"manifest.konnector": `{"slug": "Bank", "version": "latest"}`,
+	})
+	_, err = readPackage(bytes.NewReader(data))
+	assert.Equal(t, ErrInvalidManifest, err)
+
+	data = makeTarball(t, map[string]string{
+		"a/b/manifest.webapp": `{"slug": "drive", "version": "1.2.3"}`,
+	})
+	_, err = readPackage(bytes.NewReader(data))
+	assert.Equal(t, ErrNoManifest, err)
+
+	_, err = readPackage(strings.NewReader("not a tarball"))
+	assert.Equal(t, ErrInvalidTarball, err)
+}
@@ -0,0 +1,133 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/ncw/swift/v2"
+	"github.com/spf13/afero"
+)
+
+// ContainerName is the Swift container name for the files of the registry
+const ContainerName = "__registry__"
+
+// FolderName is the folder name for the files of the registry
+const FolderName = "registry"
+
+// storage is used to persist the tarballs and the icons of the versions
+// published on the registry.
+type storage interface {
+	Put(name string, r io.Reader) error
+	Open(name string) (io.ReadCloser, error)
+	Delete(name string) error
+}
+
+var store storage
+
+// InitStorage initializes the storage for the tarballs and icons of the
+// registry. It uses the same backend as the VFS.
+func InitStorage() error {
+	fsURL := config.FsURL()
+	switch fsURL.Scheme {
+	case config.SchemeFile:
+		folder := filepath.Join(fsURL.Path, FolderName)
+		fs := afero.NewOsFs()
+		if err := fs.MkdirAll(folder, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+		store = &aferoStorage{fs: fs, folder: folder}
+	case config.SchemeMem:
+		store = &aferoStorage{fs: afero.NewMemMapFs(), folder: "/"}
+	case config.SchemeSwift, config.SchemeSwiftSecure:
+		ctx := context.Background()
+		conn := config.GetSwiftConnection()
+		if err := conn.ContainerCreate(ctx, ContainerName, nil); err != nil {
+			return err
+		}
+		store = &swiftStorage{conn: conn, ctx: ctx}
+	default:
+		return fmt.Errorf("Invalid scheme %s for the registry storage", fsURL.Scheme)
+	}
+	return nil
+}
+
+func getStorage() (storage, error) {
+	if store == nil {
+		return nil, ErrNoStorage
+	}
+	return store, nil
+}
+
+type aferoStorage struct {
+	fs     afero.Fs
+	folder string
+}
+
+func (a *aferoStorage) Put(name string, r io.Reader) error {
+	filePath := filepath.Join(a.folder, name)
+	if err := a.fs.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	f, err := a.fs.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (a *aferoStorage) Open(name string) (io.ReadCloser, error) {
+	return a.fs.Open(filepath.Join(a.folder, name))
+}
+
+func (a *aferoStorage) Delete(name string) error {
+	err := a.fs.Remove(filepath.Join(a.folder, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+type swiftStorage struct {
+	conn *swift.Connection
+	ctx  context.Context
+}
+
+func (s *swiftStorage) Put(name string, r io.Reader) error {
+	f, err := s.conn.ObjectCreate(s.ctx, ContainerName, path.Clean(name), true, "", "", nil)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *swiftStorage) Open(name string) (io.ReadCloser, error) {
+	f, _, err := s.conn.ObjectOpen(s.ctx, ContainerName, path.Clean(name), false, nil)
+	if err == swift.ObjectNotFound {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *swiftStorage) Delete(name string) error {
+	err := s.conn.ObjectDelete(s.ctx, ContainerName, path.Clean(name))
+	if err == swift.ObjectNotFound {
+		return nil
+	}
+	return err
+}
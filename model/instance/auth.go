@@ -50,6 +50,9 @@ const (
 	Basic AuthMode = iota
 	// TwoFactorMail authentication mode, with passcode sent via email
 	TwoFactorMail
+	// TwoFactorApp authentication mode, with passcode generated by an
+	// authenticator app (TOTP)
+	TwoFactorApp
 )
 
 // AuthModeToString encode authentication mode in a string
@@ -57,6 +60,8 @@ func AuthModeToString(authMode AuthMode) string {
 	switch authMode {
 	case TwoFactorMail:
 		return "two_factor_mail"
+	case TwoFactorApp:
+		return "two_factor_app"
 	default:
 		return "basic"
 	}
@@ -68,6 +73,8 @@ func StringToAuthMode(authMode string) (AuthMode, error) {
 	switch authMode {
 	case "two_factor_mail":
 		return TwoFactorMail, nil
+	case "two_factor_app":
+		return TwoFactorApp, nil
 	case "basic":
 		return Basic, nil
 	default:
@@ -81,12 +88,20 @@ func (i *Instance) HasAuthMode(authMode AuthMode) bool {
 	return i.AuthMode == authMode
 }
 
+// HasTwoFactor returns whether or not the instance has a two-factor
+// authentication mode activated (by mail or with an authenticator app).
+func (i *Instance) HasTwoFactor() bool {
+	return i.AuthMode == TwoFactorMail || i.AuthMode == TwoFactorApp
+}
+
 // GenerateTwoFactorSecrets generates a (token, passcode) pair that can be
 // used as a two factor authentication secret value. The token is used to allow
 // the two-factor form — meaning the user has correctly entered its passphrase
 // and successfully done the first part of the two factor authentication.
 //
-// The passcode should be send to the user by another mean (mail, SMS, ...)
+// The passcode should be send to the user by another mean (mail, SMS, ...).
+// With the two_factor_app mode, the passcode is not used, as it is the
+// authenticator app of the user that generates it.
 func (i *Instance) GenerateTwoFactorSecrets() (token []byte, passcode string, err error) {
 	// A salt is used when we generate a new 2FA secret to derive a new TOTP
 	// function from. This allow us to have TOTP derived from a new key each time
@@ -116,6 +131,9 @@ func (i *Instance) ValidateTwoFactorPasscode(token []byte, passcode string) bool
 	if err != nil {
 		return false
 	}
+	if i.HasAuthMode(TwoFactorApp) {
+		return i.validateTwoFactorAppPasscode(passcode)
+	}
 
 	h := hkdf.New(sha256.New, i.SessionSecret(), salt, nil)
 	key := make([]byte, 32)
@@ -128,6 +146,14 @@ func (i *Instance) ValidateTwoFactorPasscode(token []byte, passcode string) bool
 	return ok && err == nil
 }
 
+// ValidateTwoFactorToken returns true if the token has been generated for the
+// first step of the two factor authentication. It is used when the second
+// step is done with a security key instead of a passcode.
+func (i *Instance) ValidateTwoFactorToken(token []byte) bool {
+	_, err := crypto.DecodeAuthMessage(totpMACConfig, i.SessionSecret(), token, nil)
+	return err == nil
+}
+
 // GenerateTwoFactorTrustedDeviceSecret generates a token that can be kept by the
 // user on-demand to avoid having two-factor authentication on a specific
 // machine.
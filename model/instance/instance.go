@@ -88,6 +88,15 @@ type Instance struct {
 	OAuthSecret []byte `json:"oauth_secret,omitempty"`
 	// CLISecret is used to authenticate request from the CLI
 	CLISecret []byte `json:"cli_secret,omitempty"`
+	// TOTPSecret is the secret shared with the authenticator app of the user,
+	// for the two_factor_app authentication mode
+	TOTPSecret []byte `json:"totp_secret,omitempty"`
+	// TOTPLastCounter is the time step of the last passcode accepted from the
+	// authenticator app, to reject a passcode that has already been used
+	TOTPLastCounter int64 `json:"totp_last_counter,omitempty"`
+	// TwoFactorRecoveryCodes are the hashes of the one-time codes that can be
+	// used instead of a passcode from the authenticator app
+	TwoFactorRecoveryCodes []string `json:"two_factor_recovery_codes,omitempty"`
 
 	// FeatureFlags is the feature flags that are specific to this instance
 	FeatureFlags map[string]interface{} `json:"feature_flags,omitempty"`
@@ -142,6 +151,12 @@ func (i *Instance) Clone() couchdb.Doc {
 
 	cloned.CLISecret = make([]byte, len(i.CLISecret))
 	copy(cloned.CLISecret, i.CLISecret)
+
+	cloned.TOTPSecret = make([]byte, len(i.TOTPSecret))
+	copy(cloned.TOTPSecret, i.TOTPSecret)
+
+	cloned.TwoFactorRecoveryCodes = make([]string, len(i.TwoFactorRecoveryCodes))
+	copy(cloned.TwoFactorRecoveryCodes, i.TwoFactorRecoveryCodes)
 	return &cloned
 }
 
@@ -268,6 +283,17 @@ func (i *Instance) SettingsDocument() (*couchdb.JSONDoc, error) {
 	return doc, nil
 }
 
+// SettingsTimezone returns the timezone (an IANA name like Europe/Paris)
+// defined in the settings of this instance.
+func (i *Instance) SettingsTimezone() (string, error) {
+	settings, err := i.SettingsDocument()
+	if err != nil {
+		return "", err
+	}
+	tz, _ := settings.M["tz"].(string)
+	return tz, nil
+}
+
 // SettingsEMail returns the email address defined in the settings of this
 // instance.
 func (i *Instance) SettingsEMail() (string, error) {
@@ -3,12 +3,15 @@ package instance_test
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/crypto"
 	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSubdomain(t *testing.T) {
@@ -50,6 +53,46 @@ func TestBuildAppToken(t *testing.T) {
 	assert.Equal(t, "my-app", claims["sub"])
 }
 
+func TestTwoFactorApp(t *testing.T) {
+	inst := &instance.Instance{
+		Domain:     "test-2fa-app.example.com",
+		SessSecret: crypto.GenerateRandomBytes(64),
+		AuthMode:   instance.TwoFactorApp,
+	}
+
+	key, err := inst.NewTwoFactorAppKey()
+	require.NoError(t, err)
+	assert.Equal(t, "test-2fa-app.example.com", key.AccountName())
+	assert.Contains(t, key.URL(), "otpauth://totp/")
+
+	passcode, err := totp.GenerateCode(key.Secret(), time.Now())
+	require.NoError(t, err)
+	counter, ok := instance.ValidateTwoFactorAppKey(key.Secret(), passcode)
+	assert.True(t, ok)
+	assert.InDelta(t, time.Now().Unix()/30, counter, 1)
+	_, ok = instance.ValidateTwoFactorAppKey(key.Secret(), "abcdef")
+	assert.False(t, ok)
+
+	token, _, err := inst.GenerateTwoFactorSecrets()
+	require.NoError(t, err)
+	assert.False(t, inst.ValidateTwoFactorPasscode(token, passcode))
+
+	// The passcode used for the enrollment can't be used to log in
+	require.NoError(t, inst.SetTwoFactorAppSecret(key.Secret(), counter))
+	assert.False(t, inst.ValidateTwoFactorPasscode(token, passcode))
+	assert.False(t, inst.ValidateTwoFactorPasscode([]byte("invalid"), passcode))
+	assert.False(t, inst.ValidateTwoFactorPasscode(token, "abcdef"))
+
+	codes := inst.GenerateTwoFactorRecoveryCodes()
+	assert.Len(t, codes, instance.TwoFactorRecoveryCodesCount)
+	assert.Len(t, inst.TwoFactorRecoveryCodes, instance.TwoFactorRecoveryCodesCount)
+	for k, code := range codes {
+		assert.Len(t, code, 19)
+		assert.NotContains(t, inst.TwoFactorRecoveryCodes, code)
+		assert.NotEqual(t, codes[(k+1)%len(codes)], code)
+	}
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	res := m.Run()
@@ -154,7 +154,10 @@ func CreateWithoutHooks(opts *Options) (*instance.Instance, error) {
 
 	if opts.AuthMode != "" {
 		var authMode instance.AuthMode
-		if authMode, err = instance.StringToAuthMode(opts.AuthMode); err == nil {
+		// The two_factor_app mode needs an enrolled authenticator app, and so
+		// it can't be set on a new instance.
+		authMode, err = instance.StringToAuthMode(opts.AuthMode)
+		if err == nil && authMode != instance.TwoFactorApp {
 			i.AuthMode = authMode
 		}
 	}
@@ -196,9 +196,13 @@ func PassphraseRenew(inst *instance.Instance, tok []byte, params PassParameters)
 	inst.PassphraseResetTime = nil
 	settings.PassphraseHint = params.Hint
 	setPassphraseKdfAndSecret(inst, settings, hash, params)
+	updated := settings.UpdateCredentialsPassphrase(inst, nil, params.Pass)
 	if err := settings.Save(inst); err != nil {
 		return err
 	}
+	if !updated {
+		settings.NotifyCredentialsReentry(inst)
+	}
 	return update(inst)
 }
 
@@ -216,7 +220,7 @@ func UpdatePassphrase(
 	// With two factor authentication, we do not check the validity of the
 	// current passphrase, but the validity of the pair passcode/token which has
 	// been exchanged against the current passphrase.
-	if inst.HasAuthMode(instance.TwoFactorMail) {
+	if inst.HasTwoFactor() {
 		if !inst.ValidateTwoFactorPasscode(twoFactorToken, twoFactorPasscode) {
 			return instance.ErrInvalidTwoFactor
 		}
@@ -237,9 +241,13 @@ func UpdatePassphrase(
 		return nil
 	}
 	setPassphraseKdfAndSecret(inst, settings, hash, params)
+	updated := settings.UpdateCredentialsPassphrase(inst, current, params.Pass)
 	if err := settings.Save(inst); err != nil {
 		return err
 	}
+	if !updated {
+		settings.NotifyCredentialsReentry(inst)
+	}
 	return update(inst)
 }
 
@@ -262,9 +270,13 @@ func ForceUpdatePassphrase(inst *instance.Instance, newPassword []byte, params P
 		return err
 	}
 	setPassphraseKdfAndSecret(inst, settings, hash, params)
+	updated := settings.UpdateCredentialsPassphrase(inst, nil, params.Pass)
 	if err := settings.Save(inst); err != nil {
 		return err
 	}
+	if !updated {
+		settings.NotifyCredentialsReentry(inst)
+	}
 	return update(inst)
 }
 
@@ -91,6 +91,14 @@ func Patch(i *instance.Instance, opts *Options) error {
 				return err
 			}
 			if i.AuthMode != authMode {
+				if authMode == instance.TwoFactorApp && len(i.TOTPSecret) == 0 {
+					return instance.ErrInvalidTwoFactor
+				}
+				if authMode != instance.TwoFactorApp {
+					i.TOTPSecret = nil
+					i.TOTPLastCounter = 0
+					i.TwoFactorRecoveryCodes = nil
+				}
 				i.AuthMode = authMode
 				needUpdate = true
 			}
@@ -3,12 +3,16 @@ package lifecycle
 import "github.com/cozy/cozy-stack/model/instance"
 
 // SendTwoFactorPasscode sends by mail the two factor secret to the owner of
-// the instance. It returns the generated token.
+// the instance. It returns the generated token. For the two_factor_app mode,
+// no mail is sent as the passcode is generated by the authenticator app.
 func SendTwoFactorPasscode(inst *instance.Instance) ([]byte, error) {
 	token, passcode, err := inst.GenerateTwoFactorSecrets()
 	if err != nil {
 		return nil, err
 	}
+	if inst.HasAuthMode(instance.TwoFactorApp) {
+		return token, nil
+	}
 	err = SendMail(inst, &Mail{
 		TemplateName:   "two_factor",
 		TemplateValues: map[string]interface{}{"TwoFactorPasscode": passcode},
@@ -31,3 +35,32 @@ func SendMailConfirmationCode(inst *instance.Instance) error {
 		TemplateValues: map[string]interface{}{"TwoFactorActivationPasscode": passcode},
 	})
 }
+
+// EnableTwoFactorApp activates the two_factor_app authentication mode with
+// the secret of the enrolled authenticator app, and the time step of the
+// passcode used for the enrollment. It returns the recovery codes that the
+// user can use if the app is lost. It can also be used to enroll a new app.
+func EnableTwoFactorApp(inst *instance.Instance, secret string, counter int64) ([]string, error) {
+	if err := inst.SetTwoFactorAppSecret(secret, counter); err != nil {
+		return nil, err
+	}
+	codes := inst.GenerateTwoFactorRecoveryCodes()
+	inst.AuthMode = instance.TwoFactorApp
+	if err := update(inst); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateTwoFactorRecoveryCodes replaces the recovery codes of the
+// two_factor_app authentication mode by new ones, that are returned.
+func RegenerateTwoFactorRecoveryCodes(inst *instance.Instance) ([]string, error) {
+	if !inst.HasAuthMode(instance.TwoFactorApp) {
+		return nil, instance.ErrInvalidTwoFactor
+	}
+	codes := inst.GenerateTwoFactorRecoveryCodes()
+	if err := update(inst); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
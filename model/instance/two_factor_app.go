@@ -0,0 +1,149 @@
+package instance
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+)
+
+// TwoFactorRecoveryCodesCount is the number of recovery codes generated when
+// the user enrolls an authenticator app.
+const TwoFactorRecoveryCodesCount = 10
+
+// The options used by the authenticator apps: most of them support only the
+// default options of the otpauth URI (SHA1, 6 digits, 30 seconds).
+var twoFactorAppTOTPOptions = totp.ValidateOpts{
+	Period:    30, // 30s
+	Skew:      1,  // 30s +- 1*30s, to allow a small clock drift
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+var totpSecretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTwoFactorAppKey generates a new TOTP key for enrolling an authenticator
+// app. The key is not kept on the instance: it is only saved when the user has
+// confirmed that the app can generate valid passcodes for it.
+func (i *Instance) NewTwoFactorAppKey() (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      i.TemplateTitle(),
+		AccountName: i.Domain,
+		Period:      uint(twoFactorAppTOTPOptions.Period),
+		Digits:      twoFactorAppTOTPOptions.Digits,
+		Algorithm:   twoFactorAppTOTPOptions.Algorithm,
+	})
+}
+
+// ValidateTwoFactorAppKey checks that the given passcode has been generated by
+// an authenticator app for the secret (base32 encoded). It returns the time
+// step of the passcode, to be kept for rejecting a replay of this passcode.
+func ValidateTwoFactorAppKey(secret, passcode string) (int64, bool) {
+	return validateTwoFactorAppCounter(secret, passcode, 0)
+}
+
+// validateTwoFactorAppCounter looks for the time step of the passcode in the
+// window allowed by the skew, only after the last accepted time step.
+func validateTwoFactorAppCounter(secret, passcode string, last int64) (int64, bool) {
+	opts := hotp.ValidateOpts{
+		Digits:    twoFactorAppTOTPOptions.Digits,
+		Algorithm: twoFactorAppTOTPOptions.Algorithm,
+	}
+	period := int64(twoFactorAppTOTPOptions.Period)
+	skew := int64(twoFactorAppTOTPOptions.Skew)
+	current := time.Now().UTC().Unix() / period
+	for counter := current - skew; counter <= current+skew; counter++ {
+		if counter <= last || counter < 0 {
+			continue
+		}
+		if ok, err := hotp.ValidateCustom(passcode, uint64(counter), secret, opts); ok && err == nil {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// SetTwoFactorAppSecret decodes the base32 encoded secret of an enrolled
+// authenticator app and keeps it on the instance, with the time step of the
+// passcode used for the enrollment. The caller must save the instance.
+func (i *Instance) SetTwoFactorAppSecret(secret string, counter int64) error {
+	key, err := totpSecretEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return ErrInvalidTwoFactor
+	}
+	i.TOTPSecret = key
+	i.TOTPLastCounter = counter
+	return nil
+}
+
+// GenerateTwoFactorRecoveryCodes generates new one-time recovery codes that
+// can be used instead of a passcode from the authenticator app. Only their
+// hashes are kept on the instance, and the codes are returned to be shown
+// once to the user. The caller must save the instance.
+func (i *Instance) GenerateTwoFactorRecoveryCodes() []string {
+	codes := make([]string, TwoFactorRecoveryCodesCount)
+	hashes := make([]string, TwoFactorRecoveryCodesCount)
+	for k := range codes {
+		raw := strings.ToLower(totpSecretEncoding.EncodeToString(crypto.GenerateRandomBytes(10)))
+		codes[k] = raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+		hashes[k] = hashRecoveryCode(codes[k])
+	}
+	i.TwoFactorRecoveryCodes = hashes
+	return codes
+}
+
+// validateTwoFactorAppPasscode returns true if the passcode has been
+// generated by the enrolled authenticator app, or if it is one of the
+// recovery codes. In both cases, the passcode can't be used again.
+func (i *Instance) validateTwoFactorAppPasscode(passcode string) bool {
+	if len(i.TOTPSecret) == 0 {
+		return false
+	}
+	secret := totpSecretEncoding.EncodeToString(i.TOTPSecret)
+	if counter, ok := validateTwoFactorAppCounter(secret, passcode, i.TOTPLastCounter); ok {
+		i.TOTPLastCounter = counter
+		if err := i.Update(); err != nil {
+			i.Logger().WithNamespace("auth").
+				Errorf("Cannot save the last accepted passcode: %s", err)
+			return false
+		}
+		return true
+	}
+	return i.useTwoFactorRecoveryCode(passcode)
+}
+
+func (i *Instance) useTwoFactorRecoveryCode(code string) bool {
+	hashed := hashRecoveryCode(code)
+	for k, h := range i.TwoFactorRecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hashed)) != 1 {
+			continue
+		}
+		codes := make([]string, 0, len(i.TwoFactorRecoveryCodes)-1)
+		codes = append(codes, i.TwoFactorRecoveryCodes[:k]...)
+		codes = append(codes, i.TwoFactorRecoveryCodes[k+1:]...)
+		i.TwoFactorRecoveryCodes = codes
+		if err := i.Update(); err != nil {
+			i.Logger().WithNamespace("auth").
+				Errorf("Cannot remove the used recovery code: %s", err)
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code. The recovery codes
+// are random with enough entropy to make a simple SHA-256 hash safe.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -52,11 +52,30 @@ type (
 		// WorkerQueueLen returns the total element in the queue of the specified
 		// worker type.
 		WorkerQueueLen(workerType string) (int, error)
+		// WorkerQueueLenByPriority returns the number of elements in the queue
+		// of the specified worker type, for each priority.
+		WorkerQueueLenByPriority(workerType string) (map[Priority]int, error)
 		// WorkerIsReserved returns true if the given worker type is reserved
 		// (ie clients should not push jobs to it, only the stack).
 		WorkerIsReserved(workerType string) (bool, error)
 		// WorkersTypes returns the list of registered workers types.
 		WorkersTypes() []string
+
+		// DeadLetters returns the jobs of the specified worker type that have
+		// exhausted their retries and have been moved to the dead-letter queue.
+		DeadLetters(workerType string) ([]*Job, error)
+		// DeadLetter returns a job from the dead-letter queue of the specified
+		// worker type.
+		DeadLetter(workerType, jobID string) (*Job, error)
+		// ReplayDeadLetter removes a job from the dead-letter queue and pushes
+		// a new job with the same parameters.
+		ReplayDeadLetter(workerType, jobID string) (*Job, error)
+		// DiscardDeadLetter removes a job from the dead-letter queue.
+		DiscardDeadLetter(workerType, jobID string) error
+
+		// Stats returns the statistics of the jobs that have finished during
+		// the sliding window.
+		Stats(opts StatsOptions) ([]*Stats, error)
 	}
 
 	// State represent the state of a job.
@@ -85,6 +104,7 @@ type (
 		Payload     Payload     `json:"payload,omitempty"`
 		Manual      bool        `json:"manual_execution,omitempty"`
 		Debounced   bool        `json:"debounced,omitempty"`
+		Priority    Priority    `json:"priority,omitempty"`
 		Options     *JobOptions `json:"options,omitempty"`
 		State       State       `json:"state"`
 		QueuedAt    time.Time   `json:"queued_at"`
@@ -92,6 +112,11 @@ type (
 		FinishedAt  time.Time   `json:"finished_at"`
 		Error       string      `json:"error,omitempty"`
 		ForwardLogs bool        `json:"forward_logs,omitempty"`
+		DeadLetter  bool        `json:"dead_letter,omitempty"`
+		Progress    *Progress   `json:"progress,omitempty"`
+		// ExecCount is the number of executions of a job that has been put
+		// back in the queue to be retried later.
+		ExecCount int `json:"exec_count,omitempty"`
 	}
 
 	// JobRequest struct is used to represent a new job request.
@@ -105,6 +130,7 @@ type (
 		Manual      bool
 		Debounced   bool
 		ForwardLogs bool
+		Priority    Priority
 		Options     *JobOptions
 	}
 
@@ -112,6 +138,7 @@ type (
 	JobOptions struct {
 		MaxExecCount int           `json:"max_exec_count"`
 		Timeout      time.Duration `json:"timeout"`
+		Priority     Priority      `json:"priority,omitempty"`
 	}
 )
 
@@ -143,6 +170,10 @@ func (j *Job) Clone() couchdb.Doc {
 		tmp := *j.Options
 		cloned.Options = &tmp
 	}
+	if j.Progress != nil {
+		tmp := *j.Progress
+		cloned.Progress = &tmp
+	}
 	if j.Message != nil {
 		tmp := j.Message
 		j.Message = make([]byte, len(tmp))
@@ -228,8 +259,12 @@ func (j *Job) Nack(errorMessage string) error {
 	j.FinishedAt = time.Now()
 	j.State = Errored
 	j.Error = errorMessage
-	j.Event = nil
-	j.Payload = nil
+	// The event and payload are kept for the jobs in the dead-letter queue,
+	// as they are needed to replay them.
+	if !j.DeadLetter {
+		j.Event = nil
+		j.Payload = nil
+	}
 	return j.Update()
 }
 
@@ -325,6 +360,7 @@ func NewJob(db prefixer.Prefixer, req *JobRequest) *Job {
 		Manual:      req.Manual,
 		Message:     req.Message,
 		Debounced:   req.Debounced,
+		Priority:    req.priority(),
 		Event:       req.Event,
 		Payload:     req.Payload,
 		Options:     req.Options,
@@ -0,0 +1,83 @@
+package job
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// deadLetterMaxLen is the maximal number of jobs kept in the dead-letter
+// queue of a worker type. The oldest jobs are dropped when the queue is full.
+const deadLetterMaxLen = 1000
+
+// brokerHooks is implemented by the brokers to be notified by their workers
+// of the end of the jobs.
+type brokerHooks interface {
+	// pushDeadLetter keeps a job that has exhausted its retries.
+	pushDeadLetter(job *Job) error
+	// jobFinished is called when the execution of a job has ended.
+	jobFinished(job *Job)
+	// acquireSlot reserves a slot for the instance of the job in a
+	// concurrency group. It returns false if the group is full.
+	acquireSlot(job *Job, group string, max int) (bool, error)
+	// releaseSlot frees a slot reserved by acquireSlot.
+	releaseSlot(job *Job, group string)
+	// requeue puts back in the queue, after the given delay, a job that has
+	// been taken by a worker but not executed.
+	requeue(job *Job, delay time.Duration)
+}
+
+// deadLetterEntry returns the value used to reference a job in a dead-letter
+// queue.
+func deadLetterEntry(job *Job) string {
+	return job.DBPrefix() + "/" + job.ID()
+}
+
+// isDeadLetterEntry returns true if the dead-letter queue entry references
+// the job with the given identifier.
+func isDeadLetterEntry(entry, jobID string) bool {
+	return strings.HasSuffix(entry, "/"+jobID)
+}
+
+// getDeadLetter loads the job referenced by a dead-letter queue entry.
+func getDeadLetter(entry string) (*Job, error) {
+	parts := strings.SplitN(entry, "/", 2)
+	if len(parts) != 2 {
+		return nil, ErrNotFoundJob
+	}
+	return Get(prefixer.NewPrefixer("", parts[0]), parts[1])
+}
+
+// getDeadLetters loads the jobs referenced by the dead-letter queue entries.
+// The entries for jobs that have been deleted since are ignored.
+func getDeadLetters(entries []string) ([]*Job, error) {
+	jobs := make([]*Job, 0, len(entries))
+	for _, entry := range entries {
+		job, err := getDeadLetter(entry)
+		if err == ErrNotFoundJob {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// replayRequest returns a job request with the same parameters as the given
+// job, to execute it again.
+func (j *Job) replayRequest() *JobRequest {
+	return &JobRequest{
+		WorkerType:  j.WorkerType,
+		TriggerID:   j.TriggerID,
+		Message:     j.Message,
+		Event:       j.Event,
+		Payload:     j.Payload,
+		Manual:      j.Manual,
+		ForwardLogs: j.ForwardLogs,
+		Priority:    j.Priority,
+		Options:     j.Options,
+	}
+}
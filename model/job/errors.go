@@ -3,6 +3,7 @@ package job
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -23,6 +24,8 @@ var (
 	// ErrAbort can be used to abort the execution of the job without causing
 	// errors.
 	ErrAbort = errors.New("jobs: abort")
+	// ErrUnknownPriority is used when the priority of a job is not recognized
+	ErrUnknownPriority = errors.New("jobs: unknown priority")
 
 	// ErrUnknownTrigger is used when the trigger type is not recognized
 	ErrUnknownTrigger = errors.New("Unknown trigger type")
@@ -33,6 +36,15 @@ var (
 	// ErrNotCronTrigger is used when a @cron trigger is expected, but it is
 	// not the case
 	ErrNotCronTrigger = errors.New("Invalid type for trigger (@cron expected)")
+	// ErrUnknownTimezone is used when the timezone of a trigger is not a
+	// valid IANA timezone
+	ErrUnknownTimezone = errors.New("Unknown timezone")
+	// ErrInvalidTimeWindow is used when an exclusion window of a trigger
+	// cannot be parsed
+	ErrInvalidTimeWindow = errors.New("Invalid exclusion window")
+	// ErrExcludedExecution is used when all the executions of a trigger are
+	// inside its exclusion windows
+	ErrExcludedExecution = errors.New("No execution outside of the exclusion windows")
 )
 
 // ErrBadTrigger is an error conveying the information of a trigger that is not
@@ -44,3 +56,13 @@ type ErrBadTrigger struct {
 func (e ErrBadTrigger) Error() string {
 	return e.Err.Error()
 }
+
+// ErrPostponed can be returned by the BeforeHook of a worker to push the job
+// in the queue only at the given time, instead of now.
+type ErrPostponed struct {
+	Until time.Time
+}
+
+func (e ErrPostponed) Error() string {
+	return "jobs: postponed until " + e.Until.Format(time.RFC3339)
+}
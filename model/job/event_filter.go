@@ -0,0 +1,188 @@
+package job
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+)
+
+// conditionSep is the separator for the conditions added to a rule in the
+// arguments of an @event trigger, like in
+// io.cozy.bank.operations:CREATED,UPDATED:!=:category&<0:amount
+const conditionSep = "&"
+
+// eventRule is a rule of an @event trigger, with the conditions that the
+// document must also match.
+type eventRule struct {
+	permission.Rule
+	conditions []permission.Rule
+}
+
+// parseEventArguments parses the arguments of an @event trigger: a list of
+// rules separated by spaces. Each rule can be followed by conditions on the
+// same doctype and verbs, in the values:selector form, separated by &.
+func parseEventArguments(arguments string) ([]eventRule, error) {
+	args := strings.Split(arguments, " ")
+	rules := make([]eventRule, len(args))
+	for i, arg := range args {
+		parts := strings.Split(arg, conditionSep)
+		rule, err := permission.UnmarshalRuleString(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		rules[i].Rule = rule
+		for _, part := range parts[1:] {
+			cond := strings.Split(part, ":")
+			if len(cond) != 2 || cond[0] == "" || cond[1] == "" {
+				return nil, permission.ErrBadScope
+			}
+			rules[i].conditions = append(rules[i].conditions, permission.Rule{
+				Type:     rule.Type,
+				Verbs:    rule.Verbs,
+				Values:   strings.Split(cond[0], ","),
+				Selector: cond[1],
+			})
+		}
+	}
+	return rules, nil
+}
+
+// match returns true if the event matches the rule and all its conditions.
+func (r *eventRule) match(e *realtime.Event) bool {
+	if !eventMatchRule(e, &r.Rule) {
+		return false
+	}
+	for i := range r.conditions {
+		if !eventMatchRule(e, &r.conditions[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldsChanged returns true if at least one of the fields, separated by
+// commas, has a different value in the old and new documents. The dot
+// notation can be used for the nested fields.
+func fieldsChanged(old, doc realtime.Doc, fields string) bool {
+	before := docToMap(old)
+	after := docToMap(doc)
+	for _, field := range strings.Split(fields, ",") {
+		if !reflect.DeepEqual(lookupField(before, field), lookupField(after, field)) {
+			return true
+		}
+	}
+	return false
+}
+
+// valuesMatch returns true if the field of the document given by the selector
+// of the rule is equal to one of the values. A value can also be a comparison
+// with a number, like <0 or >=100.
+func valuesMatch(rule *permission.Rule, doc realtime.Doc) bool {
+	var plain []string
+	for _, v := range rule.Values {
+		op, n, ok := parseComparison(v)
+		if !ok {
+			plain = append(plain, v)
+			continue
+		}
+		value, ok := lookupField(docToMap(doc), rule.Selector).(float64)
+		if ok && compare(op, value, n) {
+			return true
+		}
+	}
+	if len(plain) == 0 {
+		return false
+	}
+	fetcher, ok := doc.(permission.Fetcher)
+	if !ok {
+		return false
+	}
+	r := *rule
+	r.Values = plain
+	return r.ValuesMatch(fetcher)
+}
+
+// parseComparison parses a value like <0 or >=100.
+func parseComparison(v string) (string, float64, bool) {
+	for _, op := range []string{"<=", ">=", "<", ">"} {
+		if !strings.HasPrefix(v, op) {
+			continue
+		}
+		n, err := strconv.ParseFloat(v[len(op):], 64)
+		if err != nil {
+			return "", 0, false
+		}
+		return op, n, true
+	}
+	return "", 0, false
+}
+
+func compare(op string, value, n float64) bool {
+	switch op {
+	case "<":
+		return value < n
+	case "<=":
+		return value <= n
+	case ">":
+		return value > n
+	case ">=":
+		return value >= n
+	}
+	return false
+}
+
+// lookupField returns the value of a field in a document, with the dot
+// notation for the nested fields.
+func lookupField(doc map[string]interface{}, field string) interface{} {
+	var value interface{} = doc
+	for _, part := range strings.Split(field, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[part]
+	}
+	return value
+}
+
+// docToMap returns the fields of a document as a map, like they would be
+// serialized in JSON.
+func docToMap(doc realtime.Doc) map[string]interface{} {
+	var m map[string]interface{}
+	switch d := doc.(type) {
+	case *couchdb.JSONDoc:
+		m = d.M
+	case *realtime.JSONDoc:
+		m = d.M
+	}
+	if m != nil {
+		return m
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	if err = json.Unmarshal(b, &m); err != nil || m == nil {
+		return map[string]interface{}{}
+	}
+	return m
+}
+
+// eventDocID returns the identifier of the document of an encoded realtime
+// event.
+func eventDocID(e Event) string {
+	var evt struct {
+		Doc struct {
+			ID string `json:"_id"`
+		} `json:"doc"`
+	}
+	if err := json.Unmarshal(e, &evt); err != nil {
+		return ""
+	}
+	return evt.Doc.ID
+}
@@ -4,8 +4,11 @@ import (
 	"container/list"
 	"context"
 	"fmt"
+	"math/rand"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/limits"
@@ -21,9 +24,23 @@ type (
 		Jobs        chan *Job
 		closed      chan struct{}
 
-		list *list.List
-		run  bool
-		jmu  sync.RWMutex
+		lists map[Priority]*list.List
+		rng   *rand.Rand
+		run   bool
+		jmu   sync.RWMutex
+
+		// delayed are the jobs put back in the queue after a delay, sorted
+		// by date, and timer is used to promote them when the first one is
+		// due.
+		delayed []memDelayedJob
+		timer   *time.Timer
+	}
+
+	// memDelayedJob is a job that will be added in the queue at the given
+	// date.
+	memDelayedJob struct {
+		at  time.Time
+		job *Job
 	}
 
 	// memBroker is an in-memory broker implementation of the Broker interface.
@@ -32,13 +49,27 @@ type (
 		workers      []*Worker
 		workersTypes []string
 		running      uint32
+
+		deadLetters map[string][]string
+		dlmu        sync.Mutex
+
+		stats   map[string]map[int64]statsCounters
+		statsmu sync.Mutex
+
+		groups  map[string]int
+		groupmu sync.Mutex
 	}
 )
 
 // newMemQueue creates and a new in-memory queue.
 func newMemQueue(workerType string) *memQueue {
+	lists := make(map[Priority]*list.List, len(priorities))
+	for _, p := range priorities {
+		lists[p] = list.New()
+	}
 	return &memQueue{
-		list:   list.New(),
+		lists:  lists,
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
 		Jobs:   make(chan *Job),
 		closed: make(chan struct{}),
 	}
@@ -48,7 +79,11 @@ func newMemQueue(workerType string) *memQueue {
 func (q *memQueue) Enqueue(job *Job) error {
 	q.jmu.Lock()
 	defer q.jmu.Unlock()
-	q.list.PushBack(job.Clone())
+	l, ok := q.lists[job.Priority]
+	if !ok {
+		l = q.lists[PriorityNormal]
+	}
+	l.PushBack(job.Clone())
 	if !q.run {
 		q.run = true
 		go q.send()
@@ -56,16 +91,70 @@ func (q *memQueue) Enqueue(job *Job) error {
 	return nil
 }
 
+// enqueueDelayed adds the job to the delayed jobs, that are pushed in the
+// queue after the delay.
+func (q *memQueue) enqueueDelayed(job *Job, delay time.Duration) {
+	q.jmu.Lock()
+	defer q.jmu.Unlock()
+	at := time.Now().Add(delay)
+	i := sort.Search(len(q.delayed), func(i int) bool {
+		return q.delayed[i].at.After(at)
+	})
+	q.delayed = append(q.delayed, memDelayedJob{})
+	copy(q.delayed[i+1:], q.delayed[i:])
+	q.delayed[i] = memDelayedJob{at: at, job: job}
+	if i == 0 {
+		q.armTimer()
+	}
+}
+
+// armTimer starts the timer for the first delayed job. It must be called
+// with the lock.
+func (q *memQueue) armTimer() {
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	if len(q.delayed) == 0 {
+		return
+	}
+	q.timer = time.AfterFunc(time.Until(q.delayed[0].at), q.promoteDelayed)
+}
+
+// promoteDelayed pushes in the queue the delayed jobs that are due.
+func (q *memQueue) promoteDelayed() {
+	q.jmu.Lock()
+	now := time.Now()
+	n := 0
+	for n < len(q.delayed) && !q.delayed[n].at.After(now) {
+		n++
+	}
+	due := q.delayed[:n]
+	q.delayed = q.delayed[n:]
+	q.armTimer()
+	q.jmu.Unlock()
+	for _, d := range due {
+		_ = q.Enqueue(d.job)
+	}
+}
+
 func (q *memQueue) send() {
 	for {
 		q.jmu.Lock()
-		e := q.list.Front()
+		var l *list.List
+		var e *list.Element
+		for _, p := range pollOrder(q.rng) {
+			l = q.lists[p]
+			if e = l.Front(); e != nil {
+				break
+			}
+		}
 		if e == nil || !q.run {
 			q.run = false
 			q.jmu.Unlock()
 			return
 		}
-		q.list.Remove(e)
+		l.Remove(e)
 		q.jmu.Unlock()
 		select {
 		case <-q.closed:
@@ -78,6 +167,10 @@ func (q *memQueue) send() {
 func (q *memQueue) close() {
 	q.jmu.Lock()
 	defer q.jmu.Unlock()
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
 	if !q.run {
 		return
 	}
@@ -89,7 +182,22 @@ func (q *memQueue) close() {
 func (q *memQueue) Len() int {
 	q.jmu.RLock()
 	defer q.jmu.RUnlock()
-	return q.list.Len()
+	total := 0
+	for _, l := range q.lists {
+		total += l.Len()
+	}
+	return total
+}
+
+// LenByPriority returns the length of the queue for each priority
+func (q *memQueue) LenByPriority() map[Priority]int {
+	q.jmu.RLock()
+	defer q.jmu.RUnlock()
+	lens := make(map[Priority]int, len(q.lists))
+	for p, l := range q.lists {
+		lens[p] = l.Len()
+	}
+	return lens
 }
 
 // NewMemBroker creates a new in-memory broker system.
@@ -98,7 +206,9 @@ func (q *memQueue) Len() int {
 // workers are actually launched by the broker at its creation.
 func NewMemBroker() Broker {
 	return &memBroker{
-		queues: make(map[string]*memQueue),
+		queues:      make(map[string]*memQueue),
+		deadLetters: make(map[string][]string),
+		groups:      make(map[string]int),
 	}
 }
 
@@ -114,6 +224,7 @@ func (b *memBroker) StartWorkers(ws WorkersList) error {
 		}
 		q := newMemQueue(conf.WorkerType)
 		w := NewWorker(conf)
+		w.hooks = b
 		b.queues[conf.WorkerType] = q
 		b.workers = append(b.workers, w)
 		if err := w.Start(q.Jobs); err != nil {
@@ -187,6 +298,11 @@ func (b *memBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, error)
 		return nil, ErrUnknownWorker
 	}
 
+	req = req.withDefaultPriority(db, worker)
+	if !req.priority().IsValid() {
+		return nil, ErrUnknownPriority
+	}
+
 	// Check for limits
 	ct, err := GetCounterTypeFromWorkerType(req.WorkerType)
 	if err == nil {
@@ -206,6 +322,13 @@ func (b *memBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, error)
 	job := NewJob(db, req)
 	if worker != nil && worker.Conf.BeforeHook != nil {
 		ok, err := worker.Conf.BeforeHook(job)
+		if postponed, is := err.(ErrPostponed); is {
+			if err := job.Create(); err != nil {
+				return nil, err
+			}
+			b.queues[workerType].enqueueDelayed(job, time.Until(postponed.Until))
+			return job, nil
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -240,6 +363,16 @@ func (b *memBroker) WorkerQueueLen(workerType string) (int, error) {
 	return q.Len(), nil
 }
 
+// WorkerQueueLenByPriority returns the number of elements in queue of the
+// specified worker type, for each priority.
+func (b *memBroker) WorkerQueueLenByPriority(workerType string) (map[Priority]int, error) {
+	q, ok := b.queues[workerType]
+	if !ok {
+		return nil, ErrUnknownWorker
+	}
+	return q.LenByPriority(), nil
+}
+
 func (b *memBroker) WorkerIsReserved(workerType string) (bool, error) {
 	for _, w := range b.workers {
 		if w.Type == workerType {
@@ -253,4 +386,167 @@ func (b *memBroker) WorkersTypes() []string {
 	return b.workersTypes
 }
 
+// jobFinished is called by the workers when the execution of a job has ended.
+func (b *memBroker) jobFinished(job *Job) {
+	fields := statsFields(job)
+	if fields == nil {
+		return
+	}
+	b.statsmu.Lock()
+	defer b.statsmu.Unlock()
+	if b.stats == nil {
+		b.stats = make(map[string]map[int64]statsCounters)
+	}
+	buckets, ok := b.stats[job.WorkerType]
+	if !ok {
+		buckets = make(map[int64]statsCounters)
+		b.stats[job.WorkerType] = buckets
+	}
+	now := statsBucketOf(time.Now())
+	counters, ok := buckets[now]
+	if !ok {
+		counters = make(statsCounters)
+		buckets[now] = counters
+		oldest := statsBucketOf(time.Now().Add(-MaxStatsWindow))
+		for bucket := range buckets {
+			if bucket < oldest {
+				delete(buckets, bucket)
+			}
+		}
+	}
+	for _, field := range fields {
+		counters[field]++
+	}
+}
+
+// memGroupKey returns the key used to count the running jobs of an instance
+// in a concurrency group.
+func memGroupKey(job *Job, group string) string {
+	return job.WorkerType + "/" + job.DBPrefix() + "/" + group
+}
+
+func (b *memBroker) acquireSlot(job *Job, group string, max int) (bool, error) {
+	b.groupmu.Lock()
+	defer b.groupmu.Unlock()
+	key := memGroupKey(job, group)
+	if b.groups[key] >= max {
+		return false, nil
+	}
+	b.groups[key]++
+	return true, nil
+}
+
+func (b *memBroker) releaseSlot(job *Job, group string) {
+	b.groupmu.Lock()
+	defer b.groupmu.Unlock()
+	key := memGroupKey(job, group)
+	if b.groups[key] <= 1 {
+		delete(b.groups, key)
+	} else {
+		b.groups[key]--
+	}
+}
+
+func (b *memBroker) requeue(job *Job, delay time.Duration) {
+	q, ok := b.queues[job.WorkerType]
+	if !ok {
+		return
+	}
+	q.enqueueDelayed(job, delay)
+}
+
+func (b *memBroker) Stats(opts StatsOptions) ([]*Stats, error) {
+	if err := checkStatsOptions(&opts); err != nil {
+		return nil, err
+	}
+	counters := make(map[string][]statsCounters)
+	b.statsmu.Lock()
+	defer b.statsmu.Unlock()
+	for _, workerType := range statsWorkersTypes(opts, b.workersTypes) {
+		for _, bucket := range statsBuckets(opts, time.Now()) {
+			if c, ok := b.stats[workerType][bucket]; ok {
+				counters[workerType] = append(counters[workerType], c)
+			}
+		}
+	}
+	return aggregateStats(counters, opts), nil
+}
+
+func (b *memBroker) pushDeadLetter(job *Job) error {
+	b.dlmu.Lock()
+	defer b.dlmu.Unlock()
+	entries := append([]string{deadLetterEntry(job)}, b.deadLetters[job.WorkerType]...)
+	if len(entries) > deadLetterMaxLen {
+		entries = entries[:deadLetterMaxLen]
+	}
+	b.deadLetters[job.WorkerType] = entries
+	return nil
+}
+
+// findDeadLetter returns the entry of the given job in the dead-letter queue.
+func (b *memBroker) findDeadLetter(workerType, jobID string) (string, error) {
+	b.dlmu.Lock()
+	defer b.dlmu.Unlock()
+	for _, entry := range b.deadLetters[workerType] {
+		if isDeadLetterEntry(entry, jobID) {
+			return entry, nil
+		}
+	}
+	return "", ErrNotFoundJob
+}
+
+// removeDeadLetter removes the entry of the given job from the dead-letter
+// queue, and returns it.
+func (b *memBroker) removeDeadLetter(workerType, jobID string) (string, error) {
+	b.dlmu.Lock()
+	defer b.dlmu.Unlock()
+	entries := b.deadLetters[workerType]
+	for i, entry := range entries {
+		if isDeadLetterEntry(entry, jobID) {
+			b.deadLetters[workerType] = append(entries[:i:i], entries[i+1:]...)
+			return entry, nil
+		}
+	}
+	return "", ErrNotFoundJob
+}
+
+func (b *memBroker) DeadLetters(workerType string) ([]*Job, error) {
+	if _, ok := b.queues[workerType]; !ok {
+		return nil, ErrUnknownWorker
+	}
+	b.dlmu.Lock()
+	entries := make([]string, len(b.deadLetters[workerType]))
+	copy(entries, b.deadLetters[workerType])
+	b.dlmu.Unlock()
+	return getDeadLetters(entries)
+}
+
+func (b *memBroker) DeadLetter(workerType, jobID string) (*Job, error) {
+	if _, ok := b.queues[workerType]; !ok {
+		return nil, ErrUnknownWorker
+	}
+	entry, err := b.findDeadLetter(workerType, jobID)
+	if err != nil {
+		return nil, err
+	}
+	return getDeadLetter(entry)
+}
+
+func (b *memBroker) ReplayDeadLetter(workerType, jobID string) (*Job, error) {
+	entry, err := b.removeDeadLetter(workerType, jobID)
+	if err != nil {
+		return nil, err
+	}
+	job, err := getDeadLetter(entry)
+	if err != nil {
+		return nil, err
+	}
+	return b.PushJob(job, job.replayRequest())
+}
+
+func (b *memBroker) DiscardDeadLetter(workerType, jobID string) error {
+	_, err := b.removeDeadLetter(workerType, jobID)
+	return err
+}
+
 var _ Broker = &memBroker{}
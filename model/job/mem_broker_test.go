@@ -2,9 +2,11 @@ package job_test
 
 import (
 	"encoding/json"
+	"errors"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -235,6 +237,90 @@ func TestRetry(t *testing.T) {
 	w.Wait()
 }
 
+func TestRetryRescheduled(t *testing.T) {
+	var w sync.WaitGroup
+	var count, commits int32
+
+	jitter := 0.0
+	broker := jobs.NewMemBroker()
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:   "rescheduled",
+			Concurrency:  1,
+			MaxExecCount: 2,
+			Timeout:      1 * time.Second,
+			RetryDelay:   2 * time.Second,
+			RetryJitter:  &jitter,
+			Backoff:      jobs.BackoffConstant,
+			DeadLetter:   true,
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				atomic.AddInt32(&count, 1)
+				return errors.New("failure")
+			},
+			WorkerCommit: func(ctx *jobs.WorkerContext, errjob error) error {
+				atomic.AddInt32(&commits, 1)
+				assert.Error(t, errjob)
+				w.Done()
+				return nil
+			},
+		},
+	}))
+
+	w.Add(1)
+	j, err := broker.PushJob(testInstance, &jobs.JobRequest{
+		WorkerType: "rescheduled",
+		Message:    nil,
+	})
+	assert.NoError(t, err)
+	w.Wait()
+	assert.EqualValues(t, 2, atomic.LoadInt32(&count))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&commits))
+
+	var list []*jobs.Job
+	for i := 0; i < 100 && len(list) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		list, err = broker.DeadLetters("rescheduled")
+		assert.NoError(t, err)
+	}
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, j.ID(), list[0].ID())
+		assert.Equal(t, 2, list[0].ExecCount)
+	}
+}
+
+func TestBeforeHookPostponed(t *testing.T) {
+	var w sync.WaitGroup
+	var executedAt time.Time
+
+	until := time.Now().Add(200 * time.Millisecond)
+	broker := jobs.NewMemBroker()
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:  "postponed",
+			Concurrency: 1,
+			Timeout:     1 * time.Second,
+			BeforeHook: func(j *jobs.Job) (bool, error) {
+				return false, jobs.ErrPostponed{Until: until}
+			},
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				executedAt = time.Now()
+				w.Done()
+				return nil
+			},
+		},
+	}))
+
+	w.Add(1)
+	j, err := broker.PushJob(testInstance, &jobs.JobRequest{
+		WorkerType: "postponed",
+		Message:    nil,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, jobs.Queued, j.State)
+	w.Wait()
+	assert.False(t, executedAt.Before(until))
+}
+
 func TestPanicRetried(t *testing.T) {
 	var w sync.WaitGroup
 
@@ -303,6 +389,307 @@ func TestPanic(t *testing.T) {
 	w.Wait()
 }
 
+func TestUnknownPriority(t *testing.T) {
+	broker := jobs.NewMemBroker()
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:  "prio",
+			Concurrency: 1,
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				return nil
+			},
+		},
+	}))
+	_, err := broker.PushJob(testInstance, &jobs.JobRequest{
+		WorkerType: "prio",
+		Options:    &jobs.JobOptions{Priority: "urgent"},
+	})
+	assert.Equal(t, jobs.ErrUnknownPriority, err)
+
+	j, err := broker.PushJob(testInstance, &jobs.JobRequest{
+		WorkerType: "prio",
+		Manual:     true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, jobs.PriorityHigh, j.Priority)
+
+	j, err = broker.PushJob(testInstance, &jobs.JobRequest{
+		WorkerType: "prio",
+		Options:    &jobs.JobOptions{Priority: jobs.PriorityLow},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, jobs.PriorityLow, j.Priority)
+}
+
+func TestPriorityDequeueOrder(t *testing.T) {
+	n := 100
+	block := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	var w sync.WaitGroup
+
+	broker := jobs.NewMemBroker()
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:  "ordered",
+			Concurrency: 1,
+			Priority:    jobs.PriorityLow,
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				var msg string
+				if err := ctx.UnmarshalMessage(&msg); err != nil {
+					return err
+				}
+				if msg == "blocker" {
+					<-block
+				} else {
+					mu.Lock()
+					order = append(order, msg)
+					mu.Unlock()
+				}
+				w.Done()
+				return nil
+			},
+		},
+	}))
+
+	w.Add(2*n + 1)
+	msg, _ := jobs.NewMessage("blocker")
+	_, err := broker.PushJob(testInstance, &jobs.JobRequest{
+		WorkerType: "ordered",
+		Message:    msg,
+	})
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	// The low priority is the default priority of the worker
+	msg, _ = jobs.NewMessage("low")
+	for i := 0; i < n; i++ {
+		j, err := broker.PushJob(testInstance, &jobs.JobRequest{
+			WorkerType: "ordered",
+			Message:    msg,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, jobs.PriorityLow, j.Priority)
+	}
+	msg, _ = jobs.NewMessage("high")
+	for i := 0; i < n; i++ {
+		_, err := broker.PushJob(testInstance, &jobs.JobRequest{
+			WorkerType: "ordered",
+			Message:    msg,
+			Priority:   jobs.PriorityHigh,
+		})
+		assert.NoError(t, err)
+	}
+	close(block)
+	w.Wait()
+
+	// The high priority jobs are dequeued first, but the low priority jobs
+	// are not starved.
+	var high, low int
+	for _, m := range order[:n] {
+		if m == "high" {
+			high++
+		} else {
+			low++
+		}
+	}
+	assert.Greater(t, high, low)
+	assert.Greater(t, low, 0)
+}
+
+func TestDeadLetter(t *testing.T) {
+	var w sync.WaitGroup
+
+	maxExecCount := 2
+
+	broker := jobs.NewMemBroker()
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:   "dead",
+			Concurrency:  1,
+			MaxExecCount: maxExecCount,
+			RetryDelay:   1 * time.Millisecond,
+			Backoff:      jobs.BackoffConstant,
+			DeadLetter:   true,
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				w.Done()
+				return errors.New("failure")
+			},
+		},
+	}))
+
+	w.Add(maxExecCount)
+	msg, _ := jobs.NewMessage("dead-0")
+	j, err := broker.PushJob(testInstance, &jobs.JobRequest{
+		WorkerType: "dead",
+		Message:    msg,
+	})
+	assert.NoError(t, err)
+	w.Wait()
+
+	var list []*jobs.Job
+	for i := 0; i < 100 && len(list) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		list, err = broker.DeadLetters("dead")
+		assert.NoError(t, err)
+	}
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, j.ID(), list[0].ID())
+		assert.Equal(t, jobs.Errored, list[0].State)
+		assert.True(t, list[0].DeadLetter)
+	}
+
+	assert.Equal(t, jobs.ErrNotFoundJob, broker.DiscardDeadLetter("dead", "not-a-job"))
+	assert.NoError(t, broker.DiscardDeadLetter("dead", j.ID()))
+	list, err = broker.DeadLetters("dead")
+	assert.NoError(t, err)
+	assert.Len(t, list, 0)
+
+	_, err = broker.DeadLetters("nope")
+	assert.Equal(t, jobs.ErrUnknownWorker, err)
+}
+
+func TestConcurrencyGroup(t *testing.T) {
+	var w sync.WaitGroup
+	var running, maxRunning int32
+
+	broker := jobs.NewMemBroker()
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:  "grouped",
+			Concurrency: 4,
+			ConcurrencyGroup: func(job *jobs.Job) (string, int) {
+				return "group", 1
+			},
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				defer w.Done()
+				n := atomic.AddInt32(&running, 1)
+				if n > atomic.LoadInt32(&maxRunning) {
+					atomic.StoreInt32(&maxRunning, n)
+				}
+				time.Sleep(50 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			},
+		},
+	}))
+
+	for i := 0; i < 3; i++ {
+		w.Add(1)
+		msg, _ := jobs.NewMessage("grouped-" + strconv.Itoa(i))
+		_, err := broker.PushJob(testInstance, &jobs.JobRequest{
+			WorkerType: "grouped",
+			Message:    msg,
+		})
+		assert.NoError(t, err)
+	}
+	w.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&maxRunning))
+}
+
+func TestProgress(t *testing.T) {
+	var w sync.WaitGroup
+
+	broker := jobs.NewMemBroker()
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:  "progress",
+			Concurrency: 1,
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				defer w.Done()
+				ctx.SetProgress(1, 4, "first")
+				ctx.SetProgress(2, 4, "second")
+				return nil
+			},
+		},
+	}))
+
+	w.Add(1)
+	msg, _ := jobs.NewMessage("progress-0")
+	j, err := broker.PushJob(testInstance, &jobs.JobRequest{
+		WorkerType: "progress",
+		Message:    msg,
+	})
+	assert.NoError(t, err)
+	w.Wait()
+
+	var doc *jobs.Job
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		doc, err = jobs.Get(testInstance, j.ID())
+		assert.NoError(t, err)
+		if doc.State == jobs.Done {
+			break
+		}
+	}
+	assert.Equal(t, jobs.Done, doc.State)
+	if assert.NotNil(t, doc.Progress) {
+		assert.EqualValues(t, 4, doc.Progress.Done)
+		assert.EqualValues(t, 4, doc.Progress.Total)
+		assert.Equal(t, "second", doc.Progress.Message)
+	}
+}
+
+func TestJobsStats(t *testing.T) {
+	var w sync.WaitGroup
+
+	broker := jobs.NewMemBroker()
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:   "stats",
+			Concurrency:  1,
+			MaxExecCount: 1,
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				defer w.Done()
+				var msg map[string]string
+				_ = ctx.UnmarshalMessage(&msg)
+				if msg["konnector"] == "failing" {
+					return errors.New("failed")
+				}
+				return nil
+			},
+		},
+	}))
+
+	for _, slug := range []string{"ok", "ok", "failing"} {
+		w.Add(1)
+		msg, _ := jobs.NewMessage(map[string]string{"konnector": slug})
+		_, err := broker.PushJob(testInstance, &jobs.JobRequest{
+			WorkerType: "stats",
+			Message:    msg,
+		})
+		assert.NoError(t, err)
+	}
+	w.Wait()
+
+	var stats []*jobs.Stats
+	var err error
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		stats, err = broker.Stats(jobs.StatsOptions{GroupBy: []string{"worker"}})
+		assert.NoError(t, err)
+		if len(stats) == 1 && stats[0].Count == 3 {
+			break
+		}
+	}
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, "stats", stats[0].WorkerType)
+		assert.Equal(t, "", stats[0].Domain)
+		assert.Equal(t, 3, stats[0].Count)
+		assert.Equal(t, 1, stats[0].Errors)
+	}
+
+	stats, err = broker.Stats(jobs.StatsOptions{Slug: "failing"})
+	assert.NoError(t, err)
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, testInstance.Domain, stats[0].Domain)
+		assert.Equal(t, 1.0, stats[0].ErrorRate)
+	}
+
+	_, err = broker.Stats(jobs.StatsOptions{GroupBy: []string{"foo"}})
+	assert.Equal(t, jobs.ErrInvalidStatsGroup, err)
+}
+
 func TestMemAddJobRateLimitExceeded(t *testing.T) {
 	workersTestList := jobs.WorkersList{
 		{
@@ -148,6 +148,27 @@ func (s *memScheduler) GetTrigger(db prefixer.Prefixer, id string) (Trigger, err
 	return t, nil
 }
 
+// updateTrigger calls fn with the infos of the trigger under the lock, and
+// saves them if fn returns true.
+func (s *memScheduler) updateTrigger(db prefixer.Prefixer, id string, fn func(infos *TriggerInfos) bool) (*TriggerInfos, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.ts[db.DBPrefix()+"/"+id]
+	if !ok {
+		return nil, false, ErrNotFoundTrigger
+	}
+	infos := t.Infos()
+	updated := infos.Clone().(*TriggerInfos)
+	if !fn(updated) {
+		return infos, false, nil
+	}
+	if err := couchdb.UpdateDoc(db, updated); err != nil {
+		return nil, false, err
+	}
+	*infos = *updated
+	return infos, true, nil
+}
+
 // UpdateCron will change the frequency of execution for the given trigger.
 func (s *memScheduler) UpdateCron(db prefixer.Prefixer, trigger Trigger, arguments string) error {
 	s.mu.RLock()
@@ -161,6 +182,10 @@ func (s *memScheduler) UpdateCron(db prefixer.Prefixer, trigger Trigger, argumen
 	if err != nil {
 		return err
 	}
+	updated.useInstanceTimezone(db)
+	if _, err := updated.NextExecution(time.Now()); err != nil {
+		return err
+	}
 	trigger.Unschedule()
 	s.ts[updated.DBPrefix()+"/"+infos.TID] = updated
 	go s.schedule(updated)
@@ -216,12 +241,13 @@ func (s *memScheduler) HasTrigger(db prefixer.Prefixer, infos TriggerInfos) bool
 func (s *memScheduler) schedule(t Trigger) {
 	s.log.Debugf("trigger %s(%s): Starting trigger",
 		t.Type(), t.Infos().TID)
+	if c, ok := t.(*CronTrigger); ok {
+		c.useInstanceTimezone(c.TriggerInfos)
+	}
 	ch := t.Schedule()
 	if ch == nil {
 		return
 	}
-	var debounced <-chan time.Time
-	var combinedReq *JobRequest
 	var d time.Duration
 	infos := t.Infos()
 	if infos.Debounce != "" {
@@ -231,6 +257,13 @@ func (s *memScheduler) schedule(t Trigger) {
 				infos.TID, infos.Debounce)
 		}
 	}
+	perDoc := infos.DebouncePerDocument
+	// The debounced requests are indexed by document ID when the debounce is
+	// per document, and by the empty string else.
+	combinedReqs := make(map[string]*JobRequest)
+	debounced := make(chan string)
+	stopped := make(chan struct{})
+	defer close(stopped)
 	for {
 		select {
 		case req, ok := <-ch:
@@ -239,16 +272,33 @@ func (s *memScheduler) schedule(t Trigger) {
 			}
 			if d == 0 {
 				s.pushJob(t, req)
-			} else if debounced == nil {
-				debounced = time.After(d)
-				combinedReq = combineRequests(t, req, nil)
-			} else {
-				combinedReq = combineRequests(t, combinedReq, req)
+				continue
+			}
+			key := ""
+			if perDoc {
+				key = eventDocID(req.Event)
+			}
+			combined, pending := combinedReqs[key]
+			switch {
+			case perDoc:
+				req.Debounced = true
+				combinedReqs[key] = req
+			case pending:
+				combinedReqs[key] = combineRequests(t, combined, req)
+			default:
+				combinedReqs[key] = combineRequests(t, req, nil)
 			}
-		case <-debounced:
-			s.pushJob(t, combinedReq)
-			debounced = nil
-			combinedReq = nil
+			if !pending {
+				time.AfterFunc(d, func() {
+					select {
+					case debounced <- key:
+					case <-stopped:
+					}
+				})
+			}
+		case key := <-debounced:
+			s.pushJob(t, combinedReqs[key])
+			delete(combinedReqs, key)
 		}
 	}
 }
@@ -273,6 +323,14 @@ func combineRequests(t Trigger, req1, req2 *JobRequest) *JobRequest {
 
 func (s *memScheduler) pushJob(t Trigger, req *JobRequest) {
 	log := s.log.WithField("domain", t.DomainName())
+	s.mu.RLock()
+	paused := t.Infos().Paused
+	s.mu.RUnlock()
+	if paused {
+		log.Debugf("trigger %s(%s): paused, no job pushed",
+			t.Type(), t.Infos().TID)
+		return
+	}
 	log.Infof("trigger %s(%s): Pushing new job %s",
 		t.Type(), t.Infos().TID, req.WorkerType)
 	if _, err := s.broker.PushJob(t, req); err != nil {
@@ -9,8 +9,8 @@ type workersQueuesCollector struct {
 func newWorkersQueuesCollector() prometheus.Collector {
 	desc := prometheus.NewDesc(
 		prometheus.BuildFQName("workers", "queues", "len"),
-		`Len of the workers queues by worker type`,
-		[]string{"worker_type"},
+		`Len of the workers queues by worker type and priority`,
+		[]string{"worker_type", "priority"},
 		prometheus.Labels{},
 	)
 	return &workersQueuesCollector{*desc}
@@ -23,14 +23,16 @@ func (i *workersQueuesCollector) Describe(ch chan<- *prometheus.Desc) {
 func (i *workersQueuesCollector) Collect(ch chan<- prometheus.Metric) {
 	broker := globalJobSystem
 	for _, workerType := range broker.WorkersTypes() {
-		count, err := broker.WorkerQueueLen(workerType)
+		counts, err := broker.WorkerQueueLenByPriority(workerType)
 		if err != nil {
 			continue
 		}
-		ch <- prometheus.MustNewConstMetric(
-			&i.Desc, prometheus.GaugeValue, float64(count),
-			workerType,
-		)
+		for priority, count := range counts {
+			ch <- prometheus.MustNewConstMetric(
+				&i.Desc, prometheus.GaugeValue, float64(count),
+				workerType, string(priority),
+			)
+		}
 	}
 }
 
@@ -0,0 +1,111 @@
+package job
+
+import (
+	"math/rand"
+
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// Priority is the priority of a job in the queue of its worker type. The jobs
+// with a higher priority are dequeued first, but the queues with a lower
+// priority are regularly polled first too, to avoid their starvation.
+type Priority string
+
+const (
+	// PriorityHigh is the priority for the interactive jobs, like the jobs
+	// launched manually by the user.
+	PriorityHigh Priority = "high"
+	// PriorityNormal is the default priority.
+	PriorityNormal Priority = "normal"
+	// PriorityLow is the priority for the bulk jobs, that can wait.
+	PriorityLow Priority = "low"
+)
+
+// priorities is the list of the priorities, from the highest to the lowest.
+var priorities = []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// priorityWeights is used to choose which queue is polled first: the high
+// priority queue is polled first 4 times out of 7, the normal priority queue 2
+// times out of 7, and the low priority queue 1 time out of 7.
+var priorityWeights = map[Priority]int{
+	PriorityHigh:   4,
+	PriorityNormal: 2,
+	PriorityLow:    1,
+}
+
+// IsValid returns true if the priority is known. An empty priority is valid,
+// and means that the default priority will be used.
+func (p Priority) IsValid() bool {
+	if p == "" {
+		return true
+	}
+	_, ok := priorityWeights[p]
+	return ok
+}
+
+// pollOrder returns the order in which the queues of a worker type should be
+// polled: a priority is picked randomly according to its weight, followed by
+// the other priorities from the highest to the lowest.
+func pollOrder(rng *rand.Rand) []Priority {
+	total := 0
+	for _, p := range priorities {
+		total += priorityWeights[p]
+	}
+	n := rng.Intn(total)
+	first := PriorityHigh
+	for _, p := range priorities {
+		if n < priorityWeights[p] {
+			first = p
+			break
+		}
+		n -= priorityWeights[p]
+	}
+	order := make([]Priority, 0, len(priorities))
+	order = append(order, first)
+	for _, p := range priorities {
+		if p != first {
+			order = append(order, p)
+		}
+	}
+	return order
+}
+
+// priority returns the priority of the job created from the request: the
+// priority of the request, or else the priority of its options. Jobs launched
+// manually have a high priority by default.
+func (jr *JobRequest) priority() Priority {
+	if jr.Priority != "" {
+		return jr.Priority
+	}
+	if jr.Options != nil && jr.Options.Priority != "" {
+		return jr.Options.Priority
+	}
+	if jr.Manual {
+		return PriorityHigh
+	}
+	return PriorityNormal
+}
+
+// withDefaultPriority returns the request with the priority computed by the
+// worker type for this job, or its default priority, when no priority has
+// been given for the job.
+func (jr *JobRequest) withDefaultPriority(db prefixer.Prefixer, w *Worker) *JobRequest {
+	if w == nil || jr.Priority != "" || jr.Manual {
+		return jr
+	}
+	if jr.Options != nil && jr.Options.Priority != "" {
+		return jr
+	}
+	priority := w.Conf.Priority
+	if w.Conf.JobPriority != nil {
+		if p := w.Conf.JobPriority(db, jr); p != "" {
+			priority = p
+		}
+	}
+	if priority == "" {
+		return jr
+	}
+	req := *jr
+	req.Priority = priority
+	return &req
+}
@@ -0,0 +1,87 @@
+package job
+
+import (
+	"sync"
+	"time"
+)
+
+// progressThrottle is the minimal delay between two updates of the job
+// document for reporting its progress.
+var progressThrottle = 2 * time.Second
+
+// Progress describes the advancement of a long running job. It is persisted
+// on the job document, so that the clients can follow it with the realtime
+// or by polling the job.
+type Progress struct {
+	Done      int64     `json:"done"`
+	Total     int64     `json:"total,omitempty"` // 0 when the total is unknown
+	Message   string    `json:"message,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProgressFunc is a function used to report the progress of a long running
+// task. The SetProgress method of the WorkerContext can be used as a
+// ProgressFunc.
+type ProgressFunc func(done, total int64, message string)
+
+// Report calls the function to report the progress, if it is not nil.
+func (f ProgressFunc) Report(done, total int64, message string) {
+	if f != nil {
+		f(done, total, message)
+	}
+}
+
+// progressReporter is shared by a worker context and its clones to throttle
+// the updates of the job document.
+type progressReporter struct {
+	mu       sync.Mutex
+	savedAt  time.Time
+	finished bool
+}
+
+// SetProgress reports the progress of the job: done is the number of items
+// already processed, and total the number of items to process (or 0 if it is
+// not known). The job document is updated at most once every 2 seconds, except
+// for the last item.
+func (c *WorkerContext) SetProgress(done, total int64, message string) {
+	if c.job == nil || c.progress == nil {
+		return
+	}
+	p := c.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.finished {
+		return
+	}
+
+	now := time.Now()
+	c.job.Progress = &Progress{
+		Done:      done,
+		Total:     total,
+		Message:   message,
+		UpdatedAt: now,
+	}
+	last := total > 0 && done >= total
+	if !last && now.Sub(p.savedAt) < progressThrottle {
+		return
+	}
+	p.savedAt = now
+	if err := c.job.Update(); err != nil {
+		c.Logger().Warnf("Cannot save the progress of the job: %s", err)
+	}
+}
+
+// finishProgress is called when the execution of the job has ended, to
+// ignore the progress reported after that, and to complete the progress if
+// the job has succeeded.
+func (c *WorkerContext) finishProgress(succeeded bool) {
+	if c.progress == nil {
+		return
+	}
+	c.progress.mu.Lock()
+	defer c.progress.mu.Unlock()
+	c.progress.finished = true
+	if p := c.job.Progress; succeeded && p != nil && p.Total > 0 {
+		p.Done = p.Total
+	}
+}
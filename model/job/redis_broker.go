@@ -2,8 +2,10 @@ package job
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -17,12 +19,156 @@ import (
 )
 
 const (
-	// redisPrefix is the prefix for jobs queues in redis.
+	// redisPrefix is the prefix for jobs queues in redis. These lists are no
+	// longer filled, but they are still polled for the jobs pushed by a
+	// previous version of the stack.
 	redisPrefix = "j/"
+	// redisFairPrefix is the prefix for the keys used by the fair scheduling
+	// of the jobs across the instances.
+	redisFairPrefix = "jf/"
 	// redisHighPrioritySuffix suffix is the suffix used for prioritized queue.
 	redisHighPrioritySuffix = "/p0"
+	// redisLowPrioritySuffix suffix is the suffix used for the low priority
+	// queue.
+	redisLowPrioritySuffix = "/p2"
+	// redisDeadLetterPrefix is the prefix for the dead-letter queues in redis.
+	redisDeadLetterPrefix = "dlq/"
+	// redisStatsPrefix is the prefix of the hashes with the counters used
+	// for the statistics of the jobs, per worker type and time bucket.
+	redisStatsPrefix = "jobs-stats/"
 )
 
+// The jobs are not pushed in a single list per worker type and priority, as
+// an instance with many jobs could starve the other instances. Instead, each
+// instance has its own sub-queue, and a ring (a list used in a round-robin
+// fashion) contains the prefixes of the instances that have pending jobs:
+//
+//   - jf/{<worker>}[/p0|/p2] is the ring of the instances prefixes
+//   - jf/{<worker>}[/p0|/p2]/q/<prefix> is the sub-queue of the jobs
+//     identifiers
+//   - jf/{<worker>}/w is a hash with the weight of the instances (default to 1)
+//   - jf/{<worker>}/r/<prefix> counts the running jobs of an instance, when the
+//     concurrency per instance is capped
+//   - jf/{<worker>}/g/<prefix>/<group> counts the running jobs of an instance
+//     in a concurrency group (see WorkerConfig.ConcurrencyGroup)
+//   - jf/{<worker>}/d is a sorted set of the jobs put back in the queue with a
+//     delay, scored by the time (in milliseconds) when they can be executed
+//   - jf/{<worker>}/n is used to wake up the stacks waiting for jobs (it is
+//     trimmed to 100 notifications)
+//
+// The weight of an instance is the number of jobs that can be taken from its
+// sub-queue when it is its turn in the ring.
+//
+// The worker type is used as a hash tag, so that all the keys of a worker
+// type are in the same slot with Redis Cluster, and the lua scripts only use
+// the keys given in their KEYS arguments.
+
+// luaFairPush is the lua script used to push a job in the sub-queue of its
+// instance, and to add the instance to the ring if needed.
+//
+// KEYS[1]: ring, KEYS[2]: sub-queue, KEYS[3]: weights, KEYS[4]: wake-up
+// ARGV[1]: prefix, ARGV[2]: job ID, ARGV[3]: weight
+const luaFairPush = `
+redis.call("LPUSH", KEYS[2], ARGV[2])
+if redis.call("LLEN", KEYS[2]) == 1 then
+  redis.call("LPUSH", KEYS[1], ARGV[1])
+end
+if ARGV[3] == "1" then
+  redis.call("HDEL", KEYS[3], ARGV[1])
+else
+  redis.call("HSET", KEYS[3], ARGV[1], ARGV[3])
+end
+redis.call("LPUSH", KEYS[4], 1)
+redis.call("LTRIM", KEYS[4], 0, 99)
+return 1`
+
+// luaPromoteDelayed is the lua script used to move a delayed job to the
+// sub-queue of its instance, when its delay has expired. The job is pushed
+// only if the stack has been able to remove it from the delayed jobs, to
+// avoid pushing it twice.
+//
+// KEYS[1]: delayed jobs, KEYS[2]: ring, KEYS[3]: sub-queue, KEYS[4]: weights,
+// KEYS[5]: wake-up
+// ARGV[1]: delayed job, ARGV[2]: prefix, ARGV[3]: job ID, ARGV[4]: weight
+const luaPromoteDelayed = `
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+  return 0
+end
+redis.call("LPUSH", KEYS[3], ARGV[3])
+if redis.call("LLEN", KEYS[3]) == 1 then
+  redis.call("LPUSH", KEYS[2], ARGV[2])
+end
+if ARGV[4] == "1" then
+  redis.call("HDEL", KEYS[4], ARGV[2])
+else
+  redis.call("HSET", KEYS[4], ARGV[2], ARGV[4])
+end
+redis.call("LPUSH", KEYS[5], 1)
+redis.call("LTRIM", KEYS[5], 0, 99)
+return 1`
+
+// luaFairTake is the lua script used to take the next jobs to execute from
+// the sub-queue of an instance, when it is its turn in the ring: it takes up
+// to its weight jobs, without going over its concurrency cap. The instance is
+// removed from the ring when its sub-queue is empty.
+//
+// KEYS[1]: ring, KEYS[2]: weights, KEYS[3]: sub-queue, KEYS[4]: running
+// ARGV[1]: prefix, ARGV[2]: concurrency cap per instance (0 for no cap),
+// ARGV[3]: TTL of the running key in seconds
+const luaFairTake = `
+local cap = tonumber(ARGV[2])
+local limit = tonumber(redis.call("HGET", KEYS[2], ARGV[1]) or "1")
+if cap > 0 then
+  local available = cap - tonumber(redis.call("GET", KEYS[4]) or "0")
+  if available < limit then
+    limit = available
+  end
+end
+local jobs = {}
+for j = 1, limit do
+  local id = redis.call("RPOP", KEYS[3])
+  if not id then
+    break
+  end
+  jobs[#jobs + 1] = id
+end
+if redis.call("LLEN", KEYS[3]) == 0 then
+  redis.call("LREM", KEYS[1], 0, ARGV[1])
+  redis.call("HDEL", KEYS[2], ARGV[1])
+end
+if #jobs > 0 and cap > 0 then
+  redis.call("INCRBY", KEYS[4], #jobs)
+  redis.call("EXPIRE", KEYS[4], ARGV[3])
+end
+return jobs`
+
+// luaFairDone is the lua script used to decrement the number of running jobs
+// of an instance, and to wake up a stack that may wait for a free slot.
+//
+// KEYS[1]: running, KEYS[2]: wake-up
+const luaFairDone = `
+if redis.call("DECR", KEYS[1]) <= 0 then
+  redis.call("DEL", KEYS[1])
+end
+redis.call("LPUSH", KEYS[2], 1)
+redis.call("LTRIM", KEYS[2], 0, 99)
+return 1`
+
+// luaAcquireSlot is the lua script used to reserve a slot in a concurrency
+// group for an instance.
+//
+// KEYS[1]: group counter
+// ARGV[1]: maximal number of running jobs, ARGV[2]: TTL of the counter in
+// seconds
+const luaAcquireSlot = `
+local n = redis.call("INCR", KEYS[1])
+if n > tonumber(ARGV[1]) then
+  redis.call("DECR", KEYS[1])
+  return 0
+end
+redis.call("EXPIRE", KEYS[1], ARGV[2])
+return 1`
+
 type redisBroker struct {
 	client         redis.UniversalClient
 	ctx            context.Context
@@ -52,6 +198,7 @@ func (b *redisBroker) StartWorkers(ws WorkersList) error {
 	for _, conf := range ws {
 		b.workersTypes = append(b.workersTypes, conf.WorkerType)
 		w := NewWorker(conf)
+		w.hooks = b
 		b.workers = append(b.workers, w)
 		if conf.Concurrency <= 0 {
 			continue
@@ -61,7 +208,7 @@ func (b *redisBroker) StartWorkers(ws WorkersList) error {
 		if err := w.Start(ch); err != nil {
 			return err
 		}
-		go b.pollLoop(redisPrefix+conf.WorkerType, ch)
+		go b.pollLoop(w, ch)
 	}
 
 	if len(b.workersRunning) > 0 {
@@ -130,7 +277,181 @@ func SetRedisTimeoutForTest() {
 	redisBRPopTimeout = 1 * time.Second
 }
 
-func (b *redisBroker) pollLoop(key string, ch chan<- *Job) {
+// prioritySuffix returns the suffix of the redis keys for the given priority.
+func prioritySuffix(priority Priority) string {
+	switch priority {
+	case PriorityHigh:
+		return redisHighPrioritySuffix
+	case PriorityLow:
+		return redisLowPrioritySuffix
+	}
+	return ""
+}
+
+// redisLegacyQueueKey returns the key of the redis list that was used as the
+// queue for the given worker type and priority.
+func redisLegacyQueueKey(workerType string, priority Priority) string {
+	return redisPrefix + workerType + prioritySuffix(priority)
+}
+
+// redisFairKey returns the start of the keys used for the fair scheduling of
+// the given worker type, with the worker type as the hash tag.
+func redisFairKey(workerType string) string {
+	return redisFairPrefix + "{" + workerType + "}"
+}
+
+// redisRingKey returns the key of the ring of the instances with pending jobs
+// for the given worker type and priority.
+func redisRingKey(workerType string, priority Priority) string {
+	return redisFairKey(workerType) + prioritySuffix(priority)
+}
+
+// redisSubQueueKey returns the key of the sub-queue of an instance for the
+// given worker type and priority.
+func redisSubQueueKey(workerType string, priority Priority, prefix string) string {
+	return redisRingKey(workerType, priority) + "/q/" + prefix
+}
+
+func redisWeightsKey(workerType string) string {
+	return redisFairKey(workerType) + "/w"
+}
+
+func redisRunningKey(workerType, prefix string) string {
+	return redisFairKey(workerType) + "/r/" + prefix
+}
+
+func redisGroupKey(workerType, prefix, group string) string {
+	return redisFairKey(workerType) + "/g/" + prefix + "/" + group
+}
+
+func redisWakeUpKey(workerType string) string {
+	return redisFairKey(workerType) + "/n"
+}
+
+func redisDelayedKey(workerType string) string {
+	return redisFairKey(workerType) + "/d"
+}
+
+// delayedMember returns the member of the sorted set of the delayed jobs for
+// the given job.
+func delayedMember(job *Job) string {
+	return string(job.Priority) + "/" + job.DBPrefix() + "/" + job.ID()
+}
+
+// promoteDelayed pushes in the queues the delayed jobs that can be executed,
+// and returns the duration until the next delayed job can be executed (or
+// the default timeout if there are no delayed jobs).
+func (b *redisBroker) promoteDelayed(w *Worker) time.Duration {
+	key := redisDelayedKey(w.Type)
+	now := time.Now()
+	members, err := b.client.ZRangeByScore(b.ctx, key, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10),
+		Count: 100,
+	}).Result()
+	if err != nil {
+		joblog.Warnf("Cannot get the delayed jobs for %s: %s", w.Type, err)
+		return redisBRPopTimeout
+	}
+	for _, member := range members {
+		parts := strings.SplitN(member, "/", 3)
+		if len(parts) != 3 {
+			b.client.ZRem(b.ctx, key, member)
+			continue
+		}
+		priority, prefix, jobID := Priority(parts[0]), parts[1], parts[2]
+		keys := []string{
+			key,
+			redisRingKey(w.Type, priority),
+			redisSubQueueKey(w.Type, priority, prefix),
+			redisWeightsKey(w.Type),
+			redisWakeUpKey(w.Type),
+		}
+		weight := contextWeight(prefixer.NewPrefixer("", prefix))
+		if err := b.client.Eval(b.ctx, luaPromoteDelayed, keys, member, prefix, jobID, weight).Err(); err != nil {
+			joblog.Warnf("Cannot push the delayed job %s for %s: %s", jobID, prefix, err)
+		}
+	}
+
+	next, err := b.client.ZRangeWithScores(b.ctx, key, 0, 0).Result()
+	if err != nil || len(next) == 0 {
+		return redisBRPopTimeout
+	}
+	at := time.Unix(0, int64(next[0].Score)*int64(time.Millisecond))
+	wait := at.Sub(now)
+	if wait < 100*time.Millisecond {
+		wait = 100 * time.Millisecond
+	}
+	if wait > redisBRPopTimeout {
+		wait = redisBRPopTimeout
+	}
+	return wait
+}
+
+// popJobs takes the next jobs to execute for the given worker type and
+// priority. It returns a list of prefix/jobID values. The legacy list is
+// polled first, and then the instances of the ring are looked at, until one
+// of them has not reached its concurrency cap.
+func (b *redisBroker) popJobs(w *Worker, priority Priority) ([]string, error) {
+	legacy, err := b.client.RPop(b.ctx, redisLegacyQueueKey(w.Type, priority)).Result()
+	if err == nil {
+		return []string{legacy}, nil
+	}
+	if err != redis.Nil {
+		return nil, err
+	}
+
+	conf := w.defaultedConf(nil)
+	// The counter of running jobs expires if the stack crashes before
+	// decrementing it.
+	ttl := conf.Timeout*time.Duration(conf.MaxExecCount) + time.Minute
+	ring := redisRingKey(w.Type, priority)
+	n, err := b.client.LLen(b.ctx, ring).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i := int64(0); i < n; i++ {
+		// Rotate the ring to find the next instance
+		prefix, err := b.client.RPopLPush(b.ctx, ring, ring).Result()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys := []string{
+			ring,
+			redisWeightsKey(w.Type),
+			redisSubQueueKey(w.Type, priority, prefix),
+			redisRunningKey(w.Type, prefix),
+		}
+		res, err := b.client.Eval(b.ctx, luaFairTake, keys,
+			prefix,
+			conf.MaxConcurrencyPerInstance,
+			int(ttl.Seconds()),
+		).Result()
+		if err != nil {
+			return nil, err
+		}
+		results, ok := res.([]interface{})
+		if !ok {
+			return nil, errors.New("Unexpected response from redis")
+		}
+		if len(results) == 0 {
+			continue
+		}
+		vals := make([]string, 0, len(results))
+		for _, r := range results {
+			if id, ok := r.(string); ok {
+				vals = append(vals, prefix+"/"+id)
+			}
+		}
+		return vals, nil
+	}
+	return nil, nil
+}
+
+func (b *redisBroker) pollLoop(w *Worker, ch chan<- *Job) {
 	defer func() {
 		b.closed <- struct{}{}
 	}()
@@ -140,48 +461,198 @@ func (b *redisBroker) pollLoop(key string, ch chan<- *Job) {
 		if atomic.LoadUint32(&b.running) == 0 {
 			return
 		}
-
-		// The brpop redis command will always take elements in priority from the
-		// first key containing elements at the call. By always priorizing the
-		// manual queue, this would cause a starvation for our main queue if too
-		// many "manual" jobs are pushed. By randomizing the order we make sure we
-		// avoid such starvation. For one in three call, the main queue is
-		// selected.
-		keyP0 := key + redisHighPrioritySuffix
-		keyP1 := key
-		if rng.Intn(3) == 0 {
-			keyP1, keyP0 = keyP0, keyP1
+		timeout := b.promoteDelayed(w)
+
+		// By always priorizing the high priority queue, this would cause a
+		// starvation for the other queues if too many high priority jobs are
+		// pushed. By randomizing the order (with weights) we make sure we avoid
+		// such starvation.
+		var vals []string
+		var err error
+		for _, p := range pollOrder(rng) {
+			vals, err = b.popJobs(w, p)
+			if err != nil || len(vals) > 0 {
+				break
+			}
 		}
-		results, err := b.client.BRPop(b.ctx, redisBRPopTimeout, keyP0, keyP1).Result()
-		if err != nil || len(results) < 2 {
+		if err != nil {
+			joblog.Warnf("Cannot poll jobs for %s: %s", w.Type, err)
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 
-		key := results[0]
-		val := results[1]
-		if len(key) < len(redisPrefix) {
-			joblog.Warnf("Invalid key %s", key)
+		// Wait until a job is pushed or a running job has finished. The
+		// timeout is still needed for the jobs pushed in the legacy lists, and
+		// for the delayed jobs.
+		if len(vals) == 0 {
+			_ = b.client.BRPop(b.ctx, timeout, redisWakeUpKey(w.Type)).Err()
 			continue
 		}
 
-		parts := strings.SplitN(val, "/", 2)
-		if len(parts) != 2 {
-			joblog.Warnf("Invalid val %s", val)
-			continue
+		for _, val := range vals {
+			parts := strings.SplitN(val, "/", 2)
+			if len(parts) != 2 {
+				joblog.Warnf("Invalid val %s", val)
+				continue
+			}
+
+			prefix, jobID := parts[0], parts[1]
+			job, err := Get(prefixer.NewPrefixer("", prefix), jobID)
+			if err != nil {
+				joblog.Warnf("Cannot find job %s on domain %s: %s", parts[1], parts[0], err)
+				b.jobFinished(&Job{WorkerType: w.Type, Prefix: prefix})
+				continue
+			}
+
+			ch <- job
 		}
+	}
+}
 
-		prefix, jobID := parts[0], parts[1]
-		job, err := Get(prefixer.NewPrefixer("", prefix), jobID)
-		if err != nil {
-			joblog.Warnf("Cannot find job %s on domain %s: %s", parts[1], parts[0], err)
-			continue
+// contextWeight returns the weight of the instance for the fair scheduling
+// of the jobs, from the context of the instance.
+func contextWeight(db prefixer.Prefixer) int {
+	weights := config.GetConfig().Jobs.ContextWeights
+	if len(weights) == 0 {
+		return 1
+	}
+	inst, err := getInstance(db)
+	if err != nil {
+		return 1
+	}
+	contextName := inst.ContextName
+	if contextName == "" {
+		contextName = config.DefaultInstanceContext
+	}
+	if weight, ok := weights[contextName]; ok && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// jobFinished is called by the workers when the execution of a job has ended.
+func (b *redisBroker) jobFinished(job *Job) {
+	if fields := statsFields(job); fields != nil {
+		b.incrStats(job.WorkerType, fields)
+	}
+	b.releaseRunning(job)
+}
+
+// releaseRunning decrements the number of running jobs of the instance, when
+// the concurrency per instance is capped.
+func (b *redisBroker) releaseRunning(job *Job) {
+	for _, w := range b.workers {
+		if w.Type == job.WorkerType && w.Conf.MaxConcurrencyPerInstance > 0 {
+			keys := []string{
+				redisRunningKey(job.WorkerType, job.DBPrefix()),
+				redisWakeUpKey(job.WorkerType),
+			}
+			if err := b.client.Eval(b.ctx, luaFairDone, keys).Err(); err != nil {
+				joblog.Warnf("Cannot decrement the running jobs for %s: %s", job.DBPrefix(), err)
+			}
+			return
+		}
+	}
+}
+
+func (b *redisBroker) acquireSlot(job *Job, group string, max int) (bool, error) {
+	keys := []string{redisGroupKey(job.WorkerType, job.DBPrefix(), group)}
+	// The counter expires if the stack crashes before decrementing it.
+	ttl := 10 * time.Minute
+	for _, w := range b.workers {
+		if w.Type == job.WorkerType {
+			conf := w.defaultedConf(job.Options)
+			ttl = conf.Timeout*time.Duration(conf.MaxExecCount) + time.Minute
 		}
+	}
+	res, err := b.client.Eval(b.ctx, luaAcquireSlot, keys, max, int(ttl.Seconds())).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+func (b *redisBroker) releaseSlot(job *Job, group string) {
+	keys := []string{
+		redisGroupKey(job.WorkerType, job.DBPrefix(), group),
+		redisWakeUpKey(job.WorkerType),
+	}
+	if err := b.client.Eval(b.ctx, luaFairDone, keys).Err(); err != nil {
+		joblog.Warnf("Cannot release the slot of %s for %s: %s", group, job.DBPrefix(), err)
+	}
+}
+
+// requeue adds the job to the delayed jobs, that are kept in redis to not
+// lose them if the stack is stopped during the delay.
+func (b *redisBroker) requeue(job *Job, delay time.Duration) {
+	b.releaseRunning(job)
+	if err := b.pushDelayed(job, time.Now().Add(delay)); err != nil {
+		joblog.Warnf("Cannot requeue the job %s for %s: %s", job.JobID, job.DBPrefix(), err)
+	}
+}
+
+// pushDelayed adds the job to the delayed jobs, that are pushed in the queue
+// at the given time.
+func (b *redisBroker) pushDelayed(job *Job, at time.Time) error {
+	ms := at.UnixNano() / int64(time.Millisecond)
+	member := &redis.Z{Score: float64(ms), Member: delayedMember(job)}
+	return b.client.ZAdd(b.ctx, redisDelayedKey(job.WorkerType), member).Err()
+}
 
-		ch <- job
+// redisStatsKey returns the key of the hash with the counters of the jobs
+// of a worker type for a time bucket.
+func redisStatsKey(workerType string, bucket int64) string {
+	return redisStatsPrefix + "{" + workerType + "}/" + strconv.FormatInt(bucket, 10)
+}
+
+func (b *redisBroker) incrStats(workerType string, fields []string) {
+	key := redisStatsKey(workerType, statsBucketOf(time.Now()))
+	pipe := b.client.Pipeline()
+	for _, field := range fields {
+		pipe.HIncrBy(b.ctx, key, field, 1)
+	}
+	pipe.Expire(b.ctx, key, MaxStatsWindow+statsBucket)
+	if _, err := pipe.Exec(b.ctx); err != nil {
+		joblog.Warnf("Cannot save the statistics of a job: %s", err)
 	}
 }
 
+func (b *redisBroker) Stats(opts StatsOptions) ([]*Stats, error) {
+	if err := checkStatsOptions(&opts); err != nil {
+		return nil, err
+	}
+	workersTypes := statsWorkersTypes(opts, b.workersTypes)
+	buckets := statsBuckets(opts, time.Now())
+	pipe := b.client.Pipeline()
+	cmds := make(map[string][]*redis.StringStringMapCmd, len(workersTypes))
+	for _, workerType := range workersTypes {
+		for _, bucket := range buckets {
+			cmd := pipe.HGetAll(b.ctx, redisStatsKey(workerType, bucket))
+			cmds[workerType] = append(cmds[workerType], cmd)
+		}
+	}
+	if _, err := pipe.Exec(b.ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	counters := make(map[string][]statsCounters, len(cmds))
+	for workerType, list := range cmds {
+		for _, cmd := range list {
+			vals := cmd.Val()
+			if len(vals) == 0 {
+				continue
+			}
+			c := make(statsCounters, len(vals))
+			for field, val := range vals {
+				if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+					c[field] = n
+				}
+			}
+			counters[workerType] = append(counters[workerType], c)
+		}
+	}
+	return aggregateStats(counters, opts), nil
+}
+
 // PushJob will produce a new Job with the given options and enqueue the job in
 // the proper queue.
 func (b *redisBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, error) {
@@ -200,6 +671,11 @@ func (b *redisBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, erro
 		return nil, ErrUnknownWorker
 	}
 
+	req = req.withDefaultPriority(db, worker)
+	if !req.priority().IsValid() {
+		return nil, ErrUnknownPriority
+	}
+
 	// Check for limits
 	ct, err := GetCounterTypeFromWorkerType(req.WorkerType)
 	if err == nil {
@@ -219,6 +695,15 @@ func (b *redisBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, erro
 	job := NewJob(db, req)
 	if worker != nil && worker.Conf.BeforeHook != nil {
 		ok, err := worker.Conf.BeforeHook(job)
+		if postponed, is := err.(ErrPostponed); is {
+			if err := job.Create(); err != nil {
+				return nil, err
+			}
+			if err := b.pushDelayed(job, postponed.Until); err != nil {
+				return nil, err
+			}
+			return job, nil
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -236,16 +721,14 @@ func (b *redisBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, erro
 		return job, nil
 	}
 
-	key := redisPrefix + job.WorkerType
-	val := job.DBPrefix() + "/" + job.JobID
-
-	// When the job is manual, it is being pushed in a specific prioritized
-	// queue.
-	if job.Manual {
-		key += redisHighPrioritySuffix
+	keys := []string{
+		redisRingKey(job.WorkerType, job.Priority),
+		redisSubQueueKey(job.WorkerType, job.Priority, job.DBPrefix()),
+		redisWeightsKey(job.WorkerType),
+		redisWakeUpKey(job.WorkerType),
 	}
-
-	if err := b.client.LPush(b.ctx, key, val).Err(); err != nil {
+	weight := contextWeight(db)
+	if err := b.client.Eval(b.ctx, luaFairPush, keys, job.DBPrefix(), job.JobID, weight).Err(); err != nil {
 		return nil, err
 	}
 
@@ -255,16 +738,40 @@ func (b *redisBroker) PushJob(db prefixer.Prefixer, req *JobRequest) (*Job, erro
 // QueueLen returns the size of the number of elements in queue of the
 // specified worker type.
 func (b *redisBroker) WorkerQueueLen(workerType string) (int, error) {
-	key := redisPrefix + workerType
-	l1, err := b.client.LLen(b.ctx, key).Result()
+	lens, err := b.WorkerQueueLenByPriority(workerType)
 	if err != nil {
 		return 0, err
 	}
-	l2, err := b.client.LLen(b.ctx, key+redisHighPrioritySuffix).Result()
-	if err != nil {
-		return 0, err
+	total := 0
+	for _, l := range lens {
+		total += l
 	}
-	return int(l1 + l2), nil
+	return total, nil
+}
+
+// WorkerQueueLenByPriority returns the number of elements in queue of the
+// specified worker type, for each priority.
+func (b *redisBroker) WorkerQueueLenByPriority(workerType string) (map[Priority]int, error) {
+	lens := make(map[Priority]int, len(priorities))
+	for _, p := range priorities {
+		prefixes, err := b.client.LRange(b.ctx, redisRingKey(workerType, p), 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		pipe := b.client.Pipeline()
+		cmds := make([]*redis.IntCmd, 0, len(prefixes)+1)
+		cmds = append(cmds, pipe.LLen(b.ctx, redisLegacyQueueKey(workerType, p)))
+		for _, prefix := range prefixes {
+			cmds = append(cmds, pipe.LLen(b.ctx, redisSubQueueKey(workerType, p, prefix)))
+		}
+		if _, err := pipe.Exec(b.ctx); err != nil {
+			return nil, err
+		}
+		for _, cmd := range cmds {
+			lens[p] += int(cmd.Val())
+		}
+	}
+	return lens, nil
 }
 
 func (b *redisBroker) WorkerIsReserved(workerType string) (bool, error) {
@@ -275,3 +782,122 @@ func (b *redisBroker) WorkerIsReserved(workerType string) (bool, error) {
 	}
 	return false, ErrUnknownWorker
 }
+
+// The dead-letter queue of a worker type is made of a list of jobs
+// identifiers, from the most recent to the oldest, and a hash with the prefix
+// of the instance for each job.
+
+// luaPushDeadLetter is the lua script used to push a job in a dead-letter
+// queue, and to drop the oldest jobs when the queue is full.
+//
+// KEYS[1]: list, KEYS[2]: hash
+// ARGV[1]: job ID, ARGV[2]: prefix, ARGV[3]: maximal length
+const luaPushDeadLetter = `
+redis.call("LPUSH", KEYS[1], ARGV[1])
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[2])
+local max = tonumber(ARGV[3])
+while redis.call("LLEN", KEYS[1]) > max do
+  local id = redis.call("RPOP", KEYS[1])
+  redis.call("HDEL", KEYS[2], id)
+end
+return 1`
+
+// luaRemoveDeadLetter is the lua script used to remove a job from a
+// dead-letter queue. It returns the prefix of the instance of the job, or
+// false if the job was not in the queue.
+//
+// KEYS[1]: list, KEYS[2]: hash
+// ARGV[1]: job ID
+const luaRemoveDeadLetter = `
+local prefix = redis.call("HGET", KEYS[2], ARGV[1])
+if not prefix then
+  return false
+end
+redis.call("HDEL", KEYS[2], ARGV[1])
+redis.call("LREM", KEYS[1], 1, ARGV[1])
+return prefix`
+
+// redisDeadLetterKeys returns the keys of the list and of the hash of the
+// dead-letter queue for the given worker type.
+func redisDeadLetterKeys(workerType string) []string {
+	return []string{
+		redisDeadLetterPrefix + "{" + workerType + "}",
+		redisDeadLetterPrefix + "{" + workerType + "}/p",
+	}
+}
+
+func (b *redisBroker) pushDeadLetter(job *Job) error {
+	keys := redisDeadLetterKeys(job.WorkerType)
+	return b.client.Eval(b.ctx, luaPushDeadLetter, keys, job.ID(), job.DBPrefix(), deadLetterMaxLen).Err()
+}
+
+// removeDeadLetter removes the entry of the given job from the dead-letter
+// queue, and returns it.
+func (b *redisBroker) removeDeadLetter(workerType, jobID string) (string, error) {
+	if _, err := b.WorkerIsReserved(workerType); err != nil {
+		return "", err
+	}
+	keys := redisDeadLetterKeys(workerType)
+	prefix, err := b.client.Eval(b.ctx, luaRemoveDeadLetter, keys, jobID).Text()
+	// Another stack may have replayed or discarded the job concurrently
+	if err == redis.Nil {
+		return "", ErrNotFoundJob
+	}
+	if err != nil {
+		return "", err
+	}
+	return prefix + "/" + jobID, nil
+}
+
+func (b *redisBroker) DeadLetters(workerType string) ([]*Job, error) {
+	if _, err := b.WorkerIsReserved(workerType); err != nil {
+		return nil, err
+	}
+	keys := redisDeadLetterKeys(workerType)
+	ids, err := b.client.LRange(b.ctx, keys[0], 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return []*Job{}, err
+	}
+	prefixes, err := b.client.HMGet(b.ctx, keys[1], ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]string, 0, len(ids))
+	for i, id := range ids {
+		if prefix, ok := prefixes[i].(string); ok {
+			entries = append(entries, prefix+"/"+id)
+		}
+	}
+	return getDeadLetters(entries)
+}
+
+func (b *redisBroker) DeadLetter(workerType, jobID string) (*Job, error) {
+	if _, err := b.WorkerIsReserved(workerType); err != nil {
+		return nil, err
+	}
+	prefix, err := b.client.HGet(b.ctx, redisDeadLetterKeys(workerType)[1], jobID).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFoundJob
+	}
+	if err != nil {
+		return nil, err
+	}
+	return getDeadLetter(prefix + "/" + jobID)
+}
+
+func (b *redisBroker) ReplayDeadLetter(workerType, jobID string) (*Job, error) {
+	entry, err := b.removeDeadLetter(workerType, jobID)
+	if err != nil {
+		return nil, err
+	}
+	job, err := getDeadLetter(entry)
+	if err != nil {
+		return nil, err
+	}
+	return b.PushJob(job, job.replayRequest())
+}
+
+func (b *redisBroker) DiscardDeadLetter(workerType, jobID string) error {
+	_, err := b.removeDeadLetter(workerType, jobID)
+	return err
+}
@@ -6,9 +6,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/job"
 	jobs "github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/pkg/limits"
@@ -169,3 +171,181 @@ func TestRedisAddJobRateLimitExceeded(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, j)
 }
+
+func TestRedisFairScheduling(t *testing.T) {
+	job.SetRedisTimeoutForTest()
+	opts, _ := redis.ParseURL(redisURL1)
+	client := redis.NewClient(opts)
+
+	other, err := lifecycle.Create(&lifecycle.Options{
+		Domain: "fair-" + strconv.Itoa(rand.Int()) + ".cozy.tools:8080",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = lifecycle.Destroy(other.Domain) }()
+
+	n := 10
+	workerType := "fair-" + strconv.Itoa(rand.Int())
+	block := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	var w sync.WaitGroup
+	w.Add(n + 2)
+
+	broker := jobs.NewRedisBroker(client)
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:  workerType,
+			Concurrency: 1,
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				var msg string
+				if err := ctx.UnmarshalMessage(&msg); err != nil {
+					return err
+				}
+				if msg == "blocker" {
+					<-block
+				} else {
+					mu.Lock()
+					order = append(order, ctx.Instance.Domain)
+					mu.Unlock()
+				}
+				w.Done()
+				return nil
+			},
+		},
+	}))
+
+	msg, _ := jobs.NewMessage("blocker")
+	_, err = broker.PushJob(testInstance, &jobs.JobRequest{
+		WorkerType: workerType,
+		Message:    msg,
+	})
+	assert.NoError(t, err)
+	time.Sleep(200 * time.Millisecond)
+
+	// The jobs of the other instance are not executed after all the jobs of
+	// the first instance, even if they have been pushed later.
+	msg, _ = jobs.NewMessage("job")
+	for i := 0; i < n; i++ {
+		_, err = broker.PushJob(testInstance, &jobs.JobRequest{
+			WorkerType: workerType,
+			Message:    msg,
+		})
+		assert.NoError(t, err)
+	}
+	_, err = broker.PushJob(other, &jobs.JobRequest{
+		WorkerType: workerType,
+		Message:    msg,
+	})
+	assert.NoError(t, err)
+	close(block)
+	w.Wait()
+
+	if assert.Len(t, order, n+1) {
+		assert.Contains(t, order[:2], other.Domain)
+	}
+	assert.NoError(t, broker.ShutdownWorkers(context.Background()))
+}
+
+func TestRedisConcurrencyPerInstance(t *testing.T) {
+	job.SetRedisTimeoutForTest()
+	opts, _ := redis.ParseURL(redisURL1)
+	client := redis.NewClient(opts)
+
+	n := 6
+	workerType := "capped-" + strconv.Itoa(rand.Int())
+	var running, maxRunning int32
+	var w sync.WaitGroup
+	w.Add(n)
+
+	broker := jobs.NewRedisBroker(client)
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:                workerType,
+			Concurrency:               4,
+			MaxConcurrencyPerInstance: 2,
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				r := atomic.AddInt32(&running, 1)
+				for {
+					max := atomic.LoadInt32(&maxRunning)
+					if r <= max || atomic.CompareAndSwapInt32(&maxRunning, max, r) {
+						break
+					}
+				}
+				time.Sleep(50 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				w.Done()
+				return nil
+			},
+		},
+	}))
+
+	for i := 0; i < n; i++ {
+		_, err := broker.PushJob(testInstance, &jobs.JobRequest{
+			WorkerType: workerType,
+			Message:    nil,
+		})
+		assert.NoError(t, err)
+	}
+	w.Wait()
+	assert.EqualValues(t, 2, atomic.LoadInt32(&maxRunning))
+
+	// The counter of the running jobs is back to zero
+	time.Sleep(100 * time.Millisecond)
+	key := "jf/{" + workerType + "}/r/" + testInstance.DBPrefix()
+	assert.EqualValues(t, 0, client.Exists(context.Background(), key).Val())
+	assert.NoError(t, broker.ShutdownWorkers(context.Background()))
+}
+
+func TestRedisConcurrencyGroup(t *testing.T) {
+	job.SetRedisTimeoutForTest()
+	opts, _ := redis.ParseURL(redisURL1)
+	client := redis.NewClient(opts)
+
+	n := 3
+	workerType := "grouped-" + strconv.Itoa(rand.Int())
+	var running, maxRunning int32
+	var w sync.WaitGroup
+	w.Add(n)
+
+	broker := jobs.NewRedisBroker(client)
+	assert.NoError(t, broker.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:  workerType,
+			Concurrency: 4,
+			ConcurrencyGroup: func(job *jobs.Job) (string, int) {
+				return "group", 1
+			},
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				r := atomic.AddInt32(&running, 1)
+				for {
+					max := atomic.LoadInt32(&maxRunning)
+					if r <= max || atomic.CompareAndSwapInt32(&maxRunning, max, r) {
+						break
+					}
+				}
+				time.Sleep(50 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				w.Done()
+				return nil
+			},
+		},
+	}))
+
+	for i := 0; i < n; i++ {
+		_, err := broker.PushJob(testInstance, &jobs.JobRequest{
+			WorkerType: workerType,
+			Message:    nil,
+		})
+		assert.NoError(t, err)
+	}
+	w.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&maxRunning))
+
+	// The counter of the group is back to zero
+	time.Sleep(100 * time.Millisecond)
+	key := "jf/{" + workerType + "}/g/" + testInstance.DBPrefix() + "/group"
+	assert.EqualValues(t, 0, client.Exists(context.Background(), key).Val())
+	assert.NoError(t, broker.ShutdownWorkers(context.Background()))
+}
@@ -9,11 +9,11 @@ import (
 	"strings"
 	"time"
 
-	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
 	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/cozy/cozy-stack/pkg/lock"
 	"github.com/cozy/cozy-stack/pkg/logger"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
 	"github.com/cozy/cozy-stack/pkg/realtime"
@@ -145,15 +145,15 @@ func (s *redisScheduler) eventLoop(eventsCh <-chan *realtime.Event) {
 			continue
 		}
 		for triggerID, arguments := range m {
+			rules, err := parseEventArguments(arguments)
+			if err != nil {
+				s.log.Warnf("Coud not unmarshal rule %s: %s",
+					key, err.Error())
+				continue
+			}
 			found := false
-			for _, args := range strings.Split(arguments, " ") {
-				rule, err := permission.UnmarshalRuleString(args)
-				if err != nil {
-					s.log.Warnf("Coud not unmarshal rule %s: %s",
-						key, err.Error())
-					continue
-				}
-				if eventMatchRule(event, &rule) {
+			for i := range rules {
+				if rules[i].match(event) {
 					found = true
 					break
 				}
@@ -171,11 +171,7 @@ func (s *redisScheduler) eventLoop(eventsCh <-chan *realtime.Event) {
 			if et.Infos().Debounce != "" {
 				var d time.Duration
 				if d, err = time.ParseDuration(et.Infos().Debounce); err == nil {
-					timestamp := time.Now().Add(d)
-					s.client.ZAddNX(s.ctx, TriggersKey, &redis.Z{
-						Score:  float64(timestamp.UTC().Unix()),
-						Member: redisKey(t),
-					})
+					s.debounceEvent(et, event, time.Now().Add(d))
 					continue
 				} else {
 					s.log.Warnf("Trigger %s %s has an invalid debounce: %s",
@@ -189,7 +185,7 @@ func (s *redisScheduler) eventLoop(eventsCh <-chan *realtime.Event) {
 					event.Domain, triggerID, err.Error())
 				continue
 			}
-			_, err = s.broker.PushJob(t, jobRequest)
+			err = s.pushJob(t, jobRequest)
 			if err != nil {
 				s.log.Warnf("Could not push job trigger by event %s %s: %s",
 					event.Domain, triggerID, err.Error())
@@ -199,11 +195,37 @@ func (s *redisScheduler) eventLoop(eventsCh <-chan *realtime.Event) {
 	}
 }
 
+// debounceEvent adds the trigger to the set of the triggers waiting to be
+// activated. If the debounce is per document, the member of the sorted set is
+// suffixed by the document ID, and the last event for this document is kept
+// in a payload key.
+func (s *redisScheduler) debounceEvent(t *EventTrigger, event *realtime.Event, timestamp time.Time) {
+	member := redisKey(t)
+	pipe := s.client.Pipeline()
+	if t.DebouncePerDocument {
+		member += "/" + event.Doc.ID()
+		evt, err := NewEvent(event)
+		if err != nil {
+			s.log.Warnf("Could not encode realtime event %s %s: %s",
+				event.Domain, t.TID, err.Error())
+			return
+		}
+		pipe.Set(s.ctx, payloadKey(t)+"/"+event.Doc.ID(), string(evt), 30*24*time.Hour)
+	}
+	pipe.ZAddNX(s.ctx, TriggersKey, &redis.Z{
+		Score:  float64(timestamp.UTC().Unix()),
+		Member: member,
+	})
+	if _, err := pipe.Exec(s.ctx); err != nil {
+		s.log.Warnf("Cannot debounce trigger because of redis error: %s", err)
+	}
+}
+
 // fire is called when a webhook is fired.
 func (s *redisScheduler) fire(trigger Trigger, request *JobRequest) {
 	infos := trigger.Infos()
 	if infos.Debounce == "" {
-		if _, err := s.broker.PushJob(trigger, request); err != nil {
+		if err := s.pushJob(trigger, request); err != nil {
 			s.log.Warnf("Could not push job trigger by webhook %s %s: %s",
 				infos.Domain, infos.TID, err.Error())
 		}
@@ -232,6 +254,18 @@ func (s *redisScheduler) fire(trigger Trigger, request *JobRequest) {
 	}
 }
 
+// pushJob pushes a job for the trigger, except if the trigger has been
+// paused.
+func (s *redisScheduler) pushJob(t Trigger, req *JobRequest) error {
+	if t.Infos().Paused {
+		s.log.Debugf("trigger %s(%s): paused, no job pushed",
+			t.Type(), t.Infos().TID)
+		return nil
+	}
+	_, err := s.broker.PushJob(t, req)
+	return err
+}
+
 // ShutdownScheduler shuts down the the scheduling of triggers
 func (s *redisScheduler) ShutdownScheduler(ctx context.Context) error {
 	if s.closed == nil {
@@ -264,8 +298,10 @@ func (s *redisScheduler) PollScheduler(now int64) error {
 		if len(results) < 2 {
 			return nil
 		}
-		parts := strings.SplitN(results[0].(string), "/", 2)
-		if len(parts) != 2 {
+		// The key is prefix/triggerID, or prefix/triggerID/docID for the
+		// triggers debounced per document.
+		parts := strings.SplitN(results[0].(string), "/", 3)
+		if len(parts) < 2 {
 			s.client.ZRem(s.ctx, SchedKey, results[0])
 			return fmt.Errorf("Invalid key %s", res)
 		}
@@ -285,6 +321,15 @@ func (s *redisScheduler) PollScheduler(now int64) error {
 			if err = s.client.ZRem(s.ctx, SchedKey, results[0]).Err(); err != nil {
 				return err
 			}
+			if len(parts) == 3 {
+				key := payloadKey(t) + "/" + parts[2]
+				pipe := s.client.Pipeline()
+				get := pipe.Get(s.ctx, key)
+				pipe.Del(s.ctx, key)
+				if _, err := pipe.Exec(s.ctx); err == nil {
+					job.Event = Event(get.Val())
+				}
+			}
 			switch t.CombineRequest() {
 			case appendPayload:
 				pipe := s.client.Pipeline()
@@ -302,12 +347,12 @@ func (s *redisScheduler) PollScheduler(now int64) error {
 					job.Payload = Payload(get.Val())
 				}
 			}
-			if _, err = s.broker.PushJob(t, job); err != nil {
+			if err = s.pushJob(t, job); err != nil {
 				return err
 			}
 		case *AtTrigger:
 			job := t.Infos().JobRequest()
-			if _, err = s.broker.PushJob(t, job); err != nil {
+			if err = s.pushJob(t, job); err != nil {
 				if limits.IsLimitReachedOrExceeded(err) {
 					s.client.ZRem(s.ctx, SchedKey, results[0])
 				}
@@ -318,7 +363,7 @@ func (s *redisScheduler) PollScheduler(now int64) error {
 			}
 		case *CronTrigger:
 			job := t.Infos().JobRequest()
-			if _, err = s.broker.PushJob(t, job); err != nil {
+			if err = s.pushJob(t, job); err != nil {
 				// Remove the cron trigger from redis if it is invalid, as it
 				// may block other cron triggers
 				if err == ErrUnknownWorker || limits.IsLimitReachedOrExceeded(err) {
@@ -335,6 +380,11 @@ func (s *redisScheduler) PollScheduler(now int64) error {
 				prev = time.Unix(score, 0)
 			}
 			if err := s.addToRedis(t, prev); err != nil {
+				// A trigger without executions outside of its exclusion
+				// windows can't be scheduled anymore
+				if err == ErrExcludedExecution {
+					s.client.ZRem(s.ctx, SchedKey, results[0])
+				}
 				return err
 			}
 		default:
@@ -361,10 +411,17 @@ func (s *redisScheduler) addToRedis(t Trigger, prev time.Time) error {
 	case *AtTrigger:
 		timestamp = t.at
 	case *CronTrigger:
-		timestamp = t.NextExecution(prev)
+		t.useInstanceTimezone(t.TriggerInfos)
+		var err error
+		timestamp, err = t.NextExecution(prev)
+		if err != nil {
+			return err
+		}
 		now := time.Now()
 		if timestamp.Before(now) {
-			timestamp = t.NextExecution(now)
+			if timestamp, err = t.NextExecution(now); err != nil {
+				return err
+			}
 		}
 	case *WebhookTrigger, *ClientTrigger:
 		return nil
@@ -406,6 +463,37 @@ func (s *redisScheduler) GetTrigger(db prefixer.Prefixer, id string) (Trigger, e
 	return t, nil
 }
 
+// updateTrigger calls fn with the infos of the trigger fetched from CouchDB,
+// and saves them if fn returns true. It is done under a lock shared by the
+// stacks, to avoid pausing and resuming a trigger at the same time, and it
+// retries once on a conflict with another update.
+func (s *redisScheduler) updateTrigger(db prefixer.Prefixer, id string, fn func(infos *TriggerInfos) bool) (*TriggerInfos, bool, error) {
+	mu := lock.ReadWrite(db, "triggers/"+id)
+	if err := mu.Lock(); err != nil {
+		return nil, false, err
+	}
+	defer mu.Unlock()
+	for i := 0; ; i++ {
+		var infos TriggerInfos
+		if err := couchdb.GetDoc(db, consts.Triggers, id, &infos); err != nil {
+			if couchdb.IsNotFoundError(err) {
+				return nil, false, ErrNotFoundTrigger
+			}
+			return nil, false, err
+		}
+		if !fn(&infos) {
+			return &infos, false, nil
+		}
+		err := couchdb.UpdateDoc(db, &infos)
+		if err == nil {
+			return &infos, true, nil
+		}
+		if !couchdb.IsConflictError(err) || i > 0 {
+			return nil, false, err
+		}
+	}
+}
+
 // UpdateCron will change the frequency of execution for the given trigger.
 func (s *redisScheduler) UpdateCron(db prefixer.Prefixer, trigger Trigger, arguments string) error {
 	if trigger.Type() != "@cron" {
@@ -417,10 +505,14 @@ func (s *redisScheduler) UpdateCron(db prefixer.Prefixer, trigger Trigger, argum
 	if err != nil {
 		return err
 	}
+	updated.useInstanceTimezone(db)
+	timestamp, err := updated.NextExecution(time.Now())
+	if err != nil {
+		return err
+	}
 	if err := couchdb.UpdateDoc(db, infos); err != nil {
 		return err
 	}
-	timestamp := updated.NextExecution(time.Now())
 	pipe := s.client.Pipeline()
 	pipe.ZRem(s.ctx, TriggersKey, redisKey(updated))
 	pipe.ZRem(s.ctx, SchedKey, redisKey(updated))
@@ -534,7 +626,10 @@ func (s *redisScheduler) RebuildRedis(db prefixer.Prefixer) error {
 		return err
 	}
 	for _, t := range triggers {
-		if err = s.addToRedis(t, time.Now()); err != nil {
+		if err = s.addToRedis(t, time.Now()); err == ErrExcludedExecution {
+			joblog.Warnf("Trigger %s of domain %q is never executed: %s",
+				t.ID(), db.DomainName(), err)
+		} else if err != nil {
 			joblog.Errorf("Error when rebuilding redis for domain %q: %s (%v)",
 				db.DomainName(), err, t)
 			return err
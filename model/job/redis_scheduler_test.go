@@ -62,6 +62,14 @@ func (b *mockBroker) WorkerQueueLen(workerType string) (int, error) {
 	return count, nil
 }
 
+func (b *mockBroker) WorkerQueueLenByPriority(workerType string) (map[jobs.Priority]int, error) {
+	count, err := b.WorkerQueueLen(workerType)
+	if err != nil {
+		return nil, err
+	}
+	return map[jobs.Priority]int{jobs.PriorityNormal: count}, nil
+}
+
 func (b *mockBroker) WorkerIsReserved(workerType string) (bool, error) {
 	return false, nil
 }
@@ -70,6 +78,26 @@ func (b *mockBroker) WorkersTypes() []string {
 	return []string{}
 }
 
+func (b *mockBroker) DeadLetters(workerType string) ([]*jobs.Job, error) {
+	return nil, nil
+}
+
+func (b *mockBroker) DeadLetter(workerType, jobID string) (*jobs.Job, error) {
+	return nil, jobs.ErrNotFoundJob
+}
+
+func (b *mockBroker) ReplayDeadLetter(workerType, jobID string) (*jobs.Job, error) {
+	return nil, jobs.ErrNotFoundJob
+}
+
+func (b *mockBroker) DiscardDeadLetter(workerType, jobID string) error {
+	return jobs.ErrNotFoundJob
+}
+
+func (b *mockBroker) Stats(opts jobs.StatsOptions) ([]*jobs.Stats, error) {
+	return nil, nil
+}
+
 func TestRedisSchedulerWithTimeTriggers(t *testing.T) {
 	var wAt sync.WaitGroup
 	var wIn sync.WaitGroup
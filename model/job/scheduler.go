@@ -54,37 +54,49 @@ type (
 		HasTrigger(db prefixer.Prefixer, infos TriggerInfos) bool
 		CleanRedis() error
 		RebuildRedis(db prefixer.Prefixer) error
+
+		// updateTrigger calls fn with the infos of the trigger, and saves them
+		// if fn returns true. It returns the infos and if they were updated.
+		updateTrigger(db prefixer.Prefixer, id string, fn func(infos *TriggerInfos) bool) (*TriggerInfos, bool, error)
 	}
 
 	// TriggerInfos is a struct containing all the options of a trigger.
 	TriggerInfos struct {
-		TID          string                 `json:"_id,omitempty"`
-		TRev         string                 `json:"_rev,omitempty"`
-		Domain       string                 `json:"domain"`
-		Prefix       string                 `json:"prefix,omitempty"`
-		Type         string                 `json:"type"`
-		WorkerType   string                 `json:"worker"`
-		Arguments    string                 `json:"arguments"`
-		Debounce     string                 `json:"debounce"`
-		Options      *JobOptions            `json:"options"`
-		Message      Message                `json:"message"`
-		CurrentState *TriggerState          `json:"current_state,omitempty"`
-		Metadata     *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
+		TID                 string                 `json:"_id,omitempty"`
+		TRev                string                 `json:"_rev,omitempty"`
+		Domain              string                 `json:"domain"`
+		Prefix              string                 `json:"prefix,omitempty"`
+		Type                string                 `json:"type"`
+		WorkerType          string                 `json:"worker"`
+		Arguments           string                 `json:"arguments"`
+		Debounce            string                 `json:"debounce"`
+		DebouncePerDocument bool                   `json:"debounce_per_document,omitempty"`
+		Timezone            string                 `json:"timezone,omitempty"`
+		Exclusions          []TimeWindow           `json:"exclusion_windows,omitempty"`
+		Paused              bool                   `json:"paused,omitempty"`
+		ResumedAt           *time.Time             `json:"resumed_at,omitempty"`
+		Options             *JobOptions            `json:"options"`
+		Message             Message                `json:"message"`
+		CurrentState        *TriggerState          `json:"current_state,omitempty"`
+		Metadata            *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
 	}
 
 	// TriggerState represent the current state of the trigger
 	TriggerState struct {
-		TID                 string     `json:"trigger_id"`
-		Status              State      `json:"status"`
-		LastSuccess         *time.Time `json:"last_success,omitempty"`
-		LastSuccessfulJobID string     `json:"last_successful_job_id,omitempty"`
-		LastExecution       *time.Time `json:"last_execution,omitempty"`
-		LastExecutedJobID   string     `json:"last_executed_job_id,omitempty"`
-		LastFailure         *time.Time `json:"last_failure,omitempty"`
-		LastFailedJobID     string     `json:"last_failed_job_id,omitempty"`
-		LastError           string     `json:"last_error,omitempty"`
-		LastManualExecution *time.Time `json:"last_manual_execution,omitempty"`
-		LastManualJobID     string     `json:"last_manual_job_id,omitempty"`
+		TID                 string             `json:"trigger_id"`
+		Status              State              `json:"status"`
+		LastSuccess         *time.Time         `json:"last_success,omitempty"`
+		LastSuccessfulJobID string             `json:"last_successful_job_id,omitempty"`
+		LastExecution       *time.Time         `json:"last_execution,omitempty"`
+		LastExecutedJobID   string             `json:"last_executed_job_id,omitempty"`
+		LastFailure         *time.Time         `json:"last_failure,omitempty"`
+		LastFailedJobID     string             `json:"last_failed_job_id,omitempty"`
+		LastError           string             `json:"last_error,omitempty"`
+		LastManualExecution *time.Time         `json:"last_manual_execution,omitempty"`
+		LastManualJobID     string             `json:"last_manual_job_id,omitempty"`
+		Paused              bool               `json:"paused,omitempty"`
+		ConsecutiveFailures int                `json:"consecutive_failures,omitempty"`
+		History             []TriggerExecution `json:"history,omitempty"`
 	}
 )
 
@@ -170,6 +182,14 @@ func (t *TriggerInfos) Clone() couchdb.Doc {
 		t.Message = make([]byte, len(tmp))
 		copy(t.Message[:], tmp)
 	}
+	if t.ResumedAt != nil {
+		tmp := *t.ResumedAt
+		cloned.ResumedAt = &tmp
+	}
+	if t.Exclusions != nil {
+		cloned.Exclusions = make([]TimeWindow, len(t.Exclusions))
+		copy(cloned.Exclusions, t.Exclusions)
+	}
 	if t.CurrentState != nil {
 		tmp := *t.CurrentState
 		cloned.CurrentState = &tmp
@@ -220,6 +240,12 @@ func (t *TriggerInfos) Fetch(field string) []string {
 }
 
 func createTrigger(t Trigger) error {
+	if c, ok := t.(*CronTrigger); ok {
+		c.useInstanceTimezone(c.TriggerInfos)
+		if _, err := c.NextExecution(time.Now()); err != nil {
+			return err
+		}
+	}
 	infos := t.Infos()
 	if infos.TID != "" {
 		return couchdb.CreateNamedDoc(t, infos)
@@ -290,6 +316,16 @@ func GetTriggerState(db prefixer.Prefixer, triggerID string) (*TriggerState, err
 		}
 	}
 
+	state.ConsecutiveFailures = consecutiveFailures(js, nil)
+	for _, j := range js {
+		if len(state.History) >= maxTriggerHistory {
+			break
+		}
+		if j.State == Done || j.State == Errored {
+			state.History = append(state.History, newTriggerExecution(j))
+		}
+	}
+
 	return &state, nil
 }
 
@@ -0,0 +1,284 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsBucket is the duration of the time buckets used to count the finished
+// jobs. The sliding window of the statistics is rounded to these buckets.
+const statsBucket = 5 * time.Minute
+
+// MaxStatsWindow is the maximal duration of the sliding window used for the
+// statistics: the older buckets are dropped.
+const MaxStatsWindow = 24 * time.Hour
+
+// DefaultStatsWindow is the duration of the sliding window used for the
+// statistics when no window is given.
+const DefaultStatsWindow = 1 * time.Hour
+
+// statsBounds are the upper bounds (in seconds) of the histograms of the
+// durations and waiting times. The last bucket of the histograms is for the
+// values above the last bound.
+var statsBounds = []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// ErrInvalidStatsGroup is used when the statistics are grouped on an unknown
+// field.
+var ErrInvalidStatsGroup = errors.New("jobs: invalid group for statistics")
+
+// StatsOptions are the options for computing the statistics of the jobs.
+type StatsOptions struct {
+	// Window is the duration of the sliding window (DefaultStatsWindow if 0,
+	// and at most MaxStatsWindow)
+	Window time.Duration
+	// GroupBy is the list of fields used to aggregate the jobs: "worker",
+	// "slug" and/or "domain" (all of them if empty)
+	GroupBy []string
+	// WorkerType, Slug and Domain can be used to keep only the matching jobs
+	WorkerType string
+	Slug       string
+	Domain     string
+}
+
+// Stats are the statistics for a group of jobs, on a sliding window. The
+// durations are in seconds.
+type Stats struct {
+	WorkerType  string  `json:"worker,omitempty"`
+	Slug        string  `json:"slug,omitempty"`
+	Domain      string  `json:"domain,omitempty"`
+	Count       int     `json:"count"`
+	Errors      int     `json:"errors"`
+	ErrorRate   float64 `json:"error_rate"`
+	DurationP50 float64 `json:"duration_p50"`
+	DurationP95 float64 `json:"duration_p95"`
+	WaitP50     float64 `json:"queue_wait_p50"`
+	WaitP95     float64 `json:"queue_wait_p95"`
+}
+
+// statsCounters are the counters of the jobs of a worker type finished during
+// a time bucket. The fields are <slug>/<domain>/<counter>, where the counter
+// is c for the number of jobs, e for the errors, d<i> and q<i> for the i-th
+// bucket of the histograms of the durations and waiting times.
+type statsCounters map[string]int64
+
+// statsBucketOf returns the number of the time bucket for the given time.
+func statsBucketOf(t time.Time) int64 {
+	return t.Unix() / int64(statsBucket/time.Second)
+}
+
+// statsFields returns the fields of the counters to increment for a finished
+// job, or nil if the job has not been executed.
+func statsFields(job *Job) []string {
+	if job.StartedAt.IsZero() || job.FinishedAt.IsZero() {
+		return nil
+	}
+	prefix := jobSlug(job) + "/" + job.Domain + "/"
+	duration := job.FinishedAt.Sub(job.StartedAt).Seconds()
+	var wait float64
+	if !job.QueuedAt.IsZero() && job.StartedAt.After(job.QueuedAt) {
+		wait = job.StartedAt.Sub(job.QueuedAt).Seconds()
+	}
+	fields := []string{
+		prefix + "c",
+		prefix + "d" + strconv.Itoa(statsBoundIndex(duration)),
+		prefix + "q" + strconv.Itoa(statsBoundIndex(wait)),
+	}
+	if job.State == Errored {
+		fields = append(fields, prefix+"e")
+	}
+	return fields
+}
+
+func statsBoundIndex(value float64) int {
+	for i, bound := range statsBounds {
+		if value <= bound {
+			return i
+		}
+	}
+	return len(statsBounds)
+}
+
+// jobSlug returns the slug of the konnector or application executed by the
+// job, if any.
+func jobSlug(job *Job) string {
+	if len(job.Message) == 0 {
+		return ""
+	}
+	var msg struct {
+		Konnector string `json:"konnector"`
+		Slug      string `json:"slug"`
+	}
+	if err := json.Unmarshal(job.Message, &msg); err != nil {
+		return ""
+	}
+	if msg.Konnector != "" {
+		return msg.Konnector
+	}
+	return msg.Slug
+}
+
+// checkStatsOptions checks the options and fills the default values.
+func checkStatsOptions(opts *StatsOptions) error {
+	if opts.Window <= 0 {
+		opts.Window = DefaultStatsWindow
+	}
+	if opts.Window > MaxStatsWindow {
+		opts.Window = MaxStatsWindow
+	}
+	if len(opts.GroupBy) == 0 {
+		opts.GroupBy = []string{"worker", "slug", "domain"}
+	}
+	for _, field := range opts.GroupBy {
+		switch field {
+		case "worker", "slug", "domain":
+		default:
+			return ErrInvalidStatsGroup
+		}
+	}
+	return nil
+}
+
+// statsBuckets returns the numbers of the time buckets in the sliding window.
+func statsBuckets(opts StatsOptions, now time.Time) []int64 {
+	last := statsBucketOf(now)
+	n := int64(math.Ceil(float64(opts.Window) / float64(statsBucket)))
+	buckets := make([]int64, 0, n)
+	for i := last - n + 1; i <= last; i++ {
+		buckets = append(buckets, i)
+	}
+	return buckets
+}
+
+// statsWorkersTypes returns the worker types for which the counters must be
+// read.
+func statsWorkersTypes(opts StatsOptions, workersTypes []string) []string {
+	if opts.WorkerType != "" {
+		return []string{opts.WorkerType}
+	}
+	return workersTypes
+}
+
+type statsGroup struct {
+	stats     *Stats
+	durations []int64
+	waits     []int64
+}
+
+// aggregateStats computes the statistics from the counters of the time
+// buckets, indexed by worker type, sorted by decreasing p95 duration.
+func aggregateStats(counters map[string][]statsCounters, opts StatsOptions) []*Stats {
+	groups := make(map[string]*statsGroup)
+	for workerType, buckets := range counters {
+		for _, bucket := range buckets {
+			for field, value := range bucket {
+				parts := strings.SplitN(field, "/", 3)
+				if len(parts) != 3 || parts[2] == "" {
+					continue
+				}
+				slug, domain, counter := parts[0], parts[1], parts[2]
+				if (opts.Slug != "" && slug != opts.Slug) ||
+					(opts.Domain != "" && domain != opts.Domain) {
+					continue
+				}
+				stats := &Stats{}
+				keys := make([]string, len(opts.GroupBy))
+				for i, by := range opts.GroupBy {
+					switch by {
+					case "worker":
+						stats.WorkerType = workerType
+						keys[i] = workerType
+					case "slug":
+						stats.Slug = slug
+						keys[i] = slug
+					case "domain":
+						stats.Domain = domain
+						keys[i] = domain
+					}
+				}
+				key := strings.Join(keys, "/")
+				g, ok := groups[key]
+				if !ok {
+					g = &statsGroup{
+						stats:     stats,
+						durations: make([]int64, len(statsBounds)+1),
+						waits:     make([]int64, len(statsBounds)+1),
+					}
+					groups[key] = g
+				}
+				g.add(counter, value)
+			}
+		}
+	}
+
+	list := make([]*Stats, 0, len(groups))
+	for _, g := range groups {
+		stats := g.stats
+		if stats.Count == 0 {
+			continue
+		}
+		stats.ErrorRate = float64(stats.Errors) / float64(stats.Count)
+		stats.DurationP50 = histogramPercentile(g.durations, 50)
+		stats.DurationP95 = histogramPercentile(g.durations, 95)
+		stats.WaitP50 = histogramPercentile(g.waits, 50)
+		stats.WaitP95 = histogramPercentile(g.waits, 95)
+		list = append(list, stats)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].DurationP95 != list[j].DurationP95 {
+			return list[i].DurationP95 > list[j].DurationP95
+		}
+		return list[i].Count > list[j].Count
+	})
+	return list
+}
+
+func (g *statsGroup) add(counter string, value int64) {
+	switch {
+	case counter == "c":
+		g.stats.Count += int(value)
+	case counter == "e":
+		g.stats.Errors += int(value)
+	case counter[0] == 'd' || counter[0] == 'q':
+		i, err := strconv.Atoi(counter[1:])
+		if err != nil || i < 0 || i > len(statsBounds) {
+			return
+		}
+		if counter[0] == 'd' {
+			g.durations[i] += value
+		} else {
+			g.waits[i] += value
+		}
+	}
+}
+
+// histogramPercentile returns the upper bound of the bucket of the histogram
+// where the p-th percentile is, or the last bound for the last bucket.
+func histogramPercentile(histogram []int64, p float64) float64 {
+	var total int64
+	for _, n := range histogram {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var cumulative int64
+	for i, n := range histogram {
+		cumulative += n
+		if cumulative >= rank {
+			if i < len(statsBounds) {
+				return statsBounds[i]
+			}
+			break
+		}
+	}
+	return statsBounds[len(statsBounds)-1]
+}
@@ -3,44 +3,180 @@ package job
 import (
 	"time"
 
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
 	"github.com/robfig/cron/v3"
 )
 
+// maxExcludedSkips is the maximal number of times the next execution of a
+// trigger is postponed because of its exclusion windows.
+const maxExcludedSkips = 100
+
 // CronTrigger implements the @cron trigger type. It schedules recurring jobs with
 // the weird but very used Cron syntax.
 type CronTrigger struct {
 	*TriggerInfos
-	sched cron.Schedule
-	done  chan struct{}
+	sched   cron.Schedule
+	loc     *time.Location
+	windows []dailyWindow
+	done    chan struct{}
+}
+
+// TimeWindow is a range of the day, in the local time of the trigger, during
+// which the jobs must not be executed. From and To use the 15:04 format, and
+// the window can go over midnight (from 22:00 to 06:00 for example).
+type TimeWindow struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// dailyWindow is a parsed TimeWindow, with the number of minutes since
+// midnight.
+type dailyWindow struct {
+	from, to int
 }
 
 var parser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
 
 // NewCronTrigger returns a new instance of CronTrigger given the specified options.
 func NewCronTrigger(infos *TriggerInfos) (*CronTrigger, error) {
-	schedule, err := parser.Parse(infos.Arguments)
-	if err != nil {
-		return nil, ErrMalformedTrigger
-	}
-	return &CronTrigger{
-		TriggerInfos: infos,
-		sched:        schedule,
-		done:         make(chan struct{}),
-	}, nil
+	return newCronTrigger(infos, infos.Arguments)
 }
 
 // NewEveryTrigger returns an new instance of CronTrigger given the specified
 // options as @every.
 func NewEveryTrigger(infos *TriggerInfos) (*CronTrigger, error) {
-	schedule, err := parser.Parse("@every " + infos.Arguments)
+	return newCronTrigger(infos, "@every "+infos.Arguments)
+}
+
+func newCronTrigger(infos *TriggerInfos, spec string) (*CronTrigger, error) {
+	schedule, err := parser.Parse(spec)
 	if err != nil {
 		return nil, ErrMalformedTrigger
 	}
-	return &CronTrigger{
+	c := &CronTrigger{
 		TriggerInfos: infos,
 		sched:        schedule,
 		done:         make(chan struct{}),
-	}, nil
+	}
+	// Without an explicit timezone, the trigger uses UTC until the timezone
+	// of the instance is resolved by the scheduler.
+	c.setLocation(time.UTC)
+	if infos.Timezone != "" {
+		loc, err := time.LoadLocation(infos.Timezone)
+		if err != nil {
+			return nil, ErrUnknownTimezone
+		}
+		c.setLocation(loc)
+	}
+	for _, w := range infos.Exclusions {
+		window, err := parseTimeWindow(w)
+		if err != nil {
+			return nil, err
+		}
+		c.windows = append(c.windows, window)
+	}
+	return c, nil
+}
+
+func (c *CronTrigger) setLocation(loc *time.Location) {
+	c.loc = loc
+	// The cron expression is evaluated in the timezone of the trigger, and
+	// the DST changes are handled by the cron library.
+	if spec, ok := c.sched.(*cron.SpecSchedule); ok {
+		spec.Location = loc
+	}
+}
+
+// useInstanceTimezone evaluates the trigger in the timezone from the settings
+// of the instance, if the trigger has no explicit timezone. It is called each
+// time the trigger is scheduled, so that a change of the timezone in the
+// settings is taken into account for the next executions.
+func (c *CronTrigger) useInstanceTimezone(db prefixer.Prefixer) {
+	if c.TriggerInfos.Timezone != "" {
+		return
+	}
+	inst, err := getInstance(db)
+	if err != nil {
+		return
+	}
+	if tz, err := inst.SettingsTimezone(); err == nil && tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			c.setLocation(loc)
+		}
+	}
+}
+
+func parseTimeWindow(w TimeWindow) (dailyWindow, error) {
+	from, err := time.Parse("15:04", w.From)
+	if err != nil {
+		return dailyWindow{}, ErrInvalidTimeWindow
+	}
+	to, err := time.Parse("15:04", w.To)
+	if err != nil {
+		return dailyWindow{}, ErrInvalidTimeWindow
+	}
+	window := dailyWindow{
+		from: from.Hour()*60 + from.Minute(),
+		to:   to.Hour()*60 + to.Minute(),
+	}
+	if window.from == window.to {
+		return dailyWindow{}, ErrInvalidTimeWindow
+	}
+	return window, nil
+}
+
+// end returns the end of the window that contains t, or the zero time if t
+// is not in the window.
+func (w dailyWindow) end(t time.Time) time.Time {
+	minutes := t.Hour()*60 + t.Minute()
+	year, month, day := t.Date()
+	switch {
+	case w.from < w.to && w.from <= minutes && minutes < w.to:
+	case w.from > w.to && minutes >= w.from:
+		day++
+	case w.from > w.to && minutes < w.to:
+	default:
+		return time.Time{}
+	}
+	return time.Date(year, month, day, w.to/60, w.to%60, 0, 0, t.Location())
+}
+
+// NextExecution returns the next time when a job should be fired for this
+// trigger. The executions that would happen during an exclusion window are
+// postponed to the next execution after the end of the window. An error is
+// returned if no execution outside of the windows can be found.
+func (c *CronTrigger) NextExecution(last time.Time) (time.Time, error) {
+	next := c.sched.Next(last)
+	if next.IsZero() {
+		return next, ErrExcludedExecution
+	}
+	for i := 0; i < maxExcludedSkips; i++ {
+		var end time.Time
+		local := next.In(c.loc)
+		for _, w := range c.windows {
+			if e := w.end(local); !e.IsZero() && e.After(end) {
+				end = e
+			}
+		}
+		if end.IsZero() {
+			return next, nil
+		}
+		if _, ok := c.sched.(cron.ConstantDelaySchedule); ok {
+			next = end
+		} else {
+			next = c.sched.Next(end.Add(-time.Second))
+		}
+	}
+	return time.Time{}, ErrExcludedExecution
+}
+
+// getInstance returns the instance for the given prefixer.
+func getInstance(db prefixer.Prefixer) (*instance.Instance, error) {
+	if inst, ok := db.(*instance.Instance); ok {
+		return inst, nil
+	}
+	return instance.Get(db.DomainName())
 }
 
 // Type implements the Type method of the Trigger interface.
@@ -48,18 +184,18 @@ func (c *CronTrigger) Type() string {
 	return c.TriggerInfos.Type
 }
 
-// NextExecution returns the next time when a job should be fired for this trigger
-func (c *CronTrigger) NextExecution(last time.Time) time.Time {
-	return c.sched.Next(last)
-}
-
 // Schedule implements the Schedule method of the Trigger interface.
 func (c *CronTrigger) Schedule() <-chan *JobRequest {
 	ch := make(chan *JobRequest)
 	go func() {
 		next := time.Now()
 		for {
-			next = c.NextExecution(next)
+			var err error
+			next, err = c.NextExecution(next)
+			if err != nil {
+				close(ch)
+				return
+			}
 			select {
 			case <-time.After(-time.Since(next)):
 				ch <- c.TriggerInfos.JobRequest()
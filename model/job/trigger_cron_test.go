@@ -0,0 +1,103 @@
+package job_test
+
+import (
+	"testing"
+	"time"
+
+	jobs "github.com/cozy/cozy-stack/model/job"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronTriggerTimezone(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	trigger, err := jobs.NewCronTrigger(&jobs.TriggerInfos{
+		Type:      "@cron",
+		Arguments: "0 0 3 * * *",
+		Timezone:  "Europe/Paris",
+	})
+	require.NoError(t, err)
+
+	// The DST starts on 2021-03-28 in France: the job must still run at 3am
+	// local time, ie 2am UTC before and 1am UTC after the change.
+	last := time.Date(2021, time.March, 27, 12, 0, 0, 0, time.UTC)
+	next, err := trigger.NextExecution(last)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, time.March, 28, 1, 0, 0, 0, time.UTC), next.UTC())
+	next, err = trigger.NextExecution(next)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, time.March, 29, 3, 0, 0, 0, paris), next.In(paris))
+	assert.Equal(t, time.Date(2021, time.March, 29, 1, 0, 0, 0, time.UTC), next.UTC())
+
+	_, err = jobs.NewCronTrigger(&jobs.TriggerInfos{
+		Type:      "@cron",
+		Arguments: "0 0 3 * * *",
+		Timezone:  "Mars/Olympus_Mons",
+	})
+	assert.Equal(t, jobs.ErrUnknownTimezone, err)
+
+	// Without a timezone, the trigger is evaluated in UTC, not in the local
+	// time of the server
+	utc, err := jobs.NewCronTrigger(&jobs.TriggerInfos{
+		Type:      "@cron",
+		Arguments: "0 0 3 * * *",
+	})
+	require.NoError(t, err)
+	next, err = utc.NextExecution(last)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, time.March, 28, 3, 0, 0, 0, time.UTC), next.UTC())
+	assert.Empty(t, utc.Infos().Timezone)
+}
+
+func TestCronTriggerExclusionWindows(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	trigger, err := jobs.NewCronTrigger(&jobs.TriggerInfos{
+		Type:       "@cron",
+		Arguments:  "0 0 * * * *",
+		Timezone:   "Europe/Paris",
+		Exclusions: []jobs.TimeWindow{{From: "22:00", To: "06:00"}},
+	})
+	require.NoError(t, err)
+
+	last := time.Date(2021, time.June, 1, 20, 30, 0, 0, paris)
+	next, err := trigger.NextExecution(last)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, time.June, 1, 21, 0, 0, 0, paris), next.In(paris))
+	next, err = trigger.NextExecution(next)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, time.June, 2, 6, 0, 0, 0, paris), next.In(paris))
+
+	every, err := jobs.NewEveryTrigger(&jobs.TriggerInfos{
+		Type:       "@every",
+		Arguments:  "2h",
+		Timezone:   "Europe/Paris",
+		Exclusions: []jobs.TimeWindow{{From: "12:00", To: "14:00"}},
+	})
+	require.NoError(t, err)
+	last = time.Date(2021, time.June, 1, 11, 0, 0, 0, paris)
+	next, err = every.NextExecution(last)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, time.June, 1, 14, 0, 0, 0, paris), next.In(paris))
+
+	_, err = jobs.NewCronTrigger(&jobs.TriggerInfos{
+		Type:       "@cron",
+		Arguments:  "0 0 * * * *",
+		Exclusions: []jobs.TimeWindow{{From: "25:00", To: "06:00"}},
+	})
+	assert.Equal(t, jobs.ErrInvalidTimeWindow, err)
+
+	// The trigger is never scheduled inside an exclusion window
+	never, err := jobs.NewCronTrigger(&jobs.TriggerInfos{
+		Type:       "@cron",
+		Arguments:  "0 0 3 * * *",
+		Timezone:   "Europe/Paris",
+		Exclusions: []jobs.TimeWindow{{From: "22:00", To: "06:00"}},
+	})
+	require.NoError(t, err)
+	_, err = never.NextExecution(last)
+	assert.Equal(t, jobs.ErrExcludedExecution, err)
+}
@@ -16,20 +16,15 @@ import (
 type EventTrigger struct {
 	*TriggerInfos
 	unscheduled chan struct{}
-	mask        []permission.Rule
+	mask        []eventRule
 }
 
 // NewEventTrigger returns a new instance of EventTrigger given the specified
 // options.
 func NewEventTrigger(infos *TriggerInfos) (*EventTrigger, error) {
-	args := strings.Split(infos.Arguments, " ")
-	rules := make([]permission.Rule, len(args))
-	for i, arg := range args {
-		rule, err := permission.UnmarshalRuleString(arg)
-		if err != nil {
-			return nil, err
-		}
-		rules[i] = rule
+	rules, err := parseEventArguments(infos.Arguments)
+	if err != nil {
+		return nil, err
 	}
 	return &EventTrigger{
 		TriggerInfos: infos,
@@ -58,14 +53,7 @@ func (t *EventTrigger) Schedule() <-chan *JobRequest {
 		for {
 			select {
 			case e := <-sub.Channel:
-				found := false
-				for _, m := range t.mask {
-					if eventMatchRule(e, &m) {
-						found = true
-						break
-					}
-				}
-				if found {
+				if t.match(e) {
 					if evt, err := t.Infos().JobRequestWithEvent(e); err == nil {
 						ch <- evt
 					}
@@ -83,6 +71,26 @@ func (t *EventTrigger) Unschedule() {
 	close(t.unscheduled)
 }
 
+// Rules returns the permission rules used to match the realtime events.
+func (t *EventTrigger) Rules() []permission.Rule {
+	rules := make([]permission.Rule, len(t.mask))
+	for i, m := range t.mask {
+		rules[i] = m.Rule
+	}
+	return rules
+}
+
+// match returns true if the realtime event matches one of the rules of the
+// trigger.
+func (t *EventTrigger) match(e *realtime.Event) bool {
+	for i := range t.mask {
+		if t.mask[i].match(e) {
+			return true
+		}
+	}
+	return false
+}
+
 // Infos implements the Infos method of the Trigger interface.
 func (t *EventTrigger) Infos() *TriggerInfos {
 	return t.TriggerInfos
@@ -149,24 +157,16 @@ func eventMatchRule(e *realtime.Event, rule *permission.Rule) bool {
 		if e.OldDoc == nil {
 			return false
 		}
-		if doc, ok := e.Doc.(permission.Fetcher); ok {
-			if old, ok := e.OldDoc.(permission.Fetcher); ok {
-				return rule.ValuesChanged(old, doc)
-			}
-		}
-	} else {
-		// Selector with normal values
-		if v, ok := e.Doc.(permission.Fetcher); ok {
-			if rule.ValuesMatch(v) {
-				return true
-			}
-			// Particular case where the new doc is not valid but the old one was.
-			if e.OldDoc != nil {
-				if vOld, okOld := e.OldDoc.(permission.Fetcher); okOld {
-					return rule.ValuesMatch(vOld)
-				}
-			}
-		}
+		return fieldsChanged(e.OldDoc, e.Doc, rule.Selector)
+	}
+
+	// Selector with normal values
+	if valuesMatch(rule, e.Doc) {
+		return true
+	}
+	// Particular case where the new doc is not valid but the old one was.
+	if e.OldDoc != nil {
+		return valuesMatch(rule, e.OldDoc)
 	}
 
 	return false
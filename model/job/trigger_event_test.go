@@ -210,3 +210,109 @@ func TestTriggerEvent(t *testing.T) {
 	err := sch.ShutdownScheduler(context.Background())
 	assert.NoError(t, err)
 }
+
+func TestTriggerEventConditions(t *testing.T) {
+	called := make(chan string, 10)
+	bro := jobs.NewMemBroker()
+	assert.NoError(t, bro.StartWorkers(jobs.WorkersList{
+		{
+			WorkerType:   "worker_event_cond",
+			Concurrency:  1,
+			MaxExecCount: 1,
+			Timeout:      1 * time.Second,
+			WorkerFunc: func(ctx *jobs.WorkerContext) error {
+				var msg string
+				if err := ctx.UnmarshalMessage(&msg); err != nil {
+					return err
+				}
+				called <- msg
+				return nil
+			},
+		},
+	}))
+	sch := jobs.NewMemScheduler()
+	assert.NoError(t, sch.StartScheduler(bro))
+
+	triggersInfos := []jobs.TriggerInfos{
+		{
+			Type:       "@event",
+			Arguments:  "io.cozy.testeventcond:UPDATED:!=:category,metadata.label",
+			WorkerType: "worker_event_cond",
+			Message:    makeMessage(t, "changed"),
+		},
+		{
+			Type:       "@event",
+			Arguments:  "io.cozy.testeventcond:CREATED,UPDATED:<0:amount",
+			WorkerType: "worker_event_cond",
+			Message:    makeMessage(t, "debit"),
+		},
+		{
+			Type:       "@event",
+			Arguments:  "io.cozy.testeventcond:UPDATED:!=:category&<0:amount",
+			WorkerType: "worker_event_cond",
+			Message:    makeMessage(t, "changed-debit"),
+		},
+	}
+	var triggers []jobs.Trigger
+	for _, infos := range triggersInfos {
+		trigger, err := jobs.NewTrigger(testInstance, infos, infos.Message)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, sch.AddTrigger(trigger))
+		triggers = append(triggers, trigger)
+	}
+
+	doc := func(rev, category, label string, amount float64) *couchdb.JSONDoc {
+		return &couchdb.JSONDoc{
+			Type: "io.cozy.testeventcond",
+			M: map[string]interface{}{
+				"_id":      "op-1",
+				"_rev":     rev,
+				"category": category,
+				"amount":   amount,
+				"metadata": map[string]interface{}{"label": label},
+			},
+		}
+	}
+	collect := func(n int) map[string]bool {
+		msgs := make(map[string]bool)
+		for i := 0; i < n; i++ {
+			select {
+			case msg := <-called:
+				msgs[msg] = true
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout")
+			}
+		}
+		select {
+		case msg := <-called:
+			t.Fatalf("unexpected job %s", msg)
+		case <-time.After(100 * time.Millisecond):
+		}
+		return msgs
+	}
+
+	realtime.GetHub().Publish(testInstance, realtime.EventUpdate,
+		doc("2-b", "food", "bar", 1000), doc("1-a", "food", "foo", 1000))
+	assert.Equal(t, map[string]bool{"changed": true}, collect(1))
+
+	realtime.GetHub().Publish(testInstance, realtime.EventUpdate,
+		doc("3-c", "food", "bar", -12), doc("2-b", "food", "bar", -10))
+	assert.Equal(t, map[string]bool{"debit": true}, collect(1))
+
+	realtime.GetHub().Publish(testInstance, realtime.EventUpdate,
+		doc("4-d", "salary", "bar", -12), doc("3-c", "food", "bar", -12))
+	assert.Equal(t, map[string]bool{"changed": true, "debit": true, "changed-debit": true}, collect(3))
+
+	_, err := jobs.NewEventTrigger(&jobs.TriggerInfos{
+		Type:      "@event",
+		Arguments: "io.cozy.testeventcond:UPDATED:!=:category&amount",
+	})
+	assert.Error(t, err)
+
+	for _, trigger := range triggers {
+		assert.NoError(t, sch.DeleteTrigger(testInstance, trigger.ID()))
+	}
+	assert.NoError(t, sch.ShutdownScheduler(context.Background()))
+}
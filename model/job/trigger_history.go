@@ -0,0 +1,124 @@
+package job
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// maxTriggerHistory is the number of executions kept in the history of a
+// trigger.
+const maxTriggerHistory = 20
+
+// TriggerExecution is an entry in the execution history of a trigger.
+type TriggerExecution struct {
+	JobID      string    `json:"job_id"`
+	State      State     `json:"state"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Duration   float64   `json:"duration"` // in seconds
+	Error      string    `json:"error,omitempty"`
+	Manual     bool      `json:"manual,omitempty"`
+}
+
+var cbTriggerPaused func(infos *TriggerInfos, failures int, paused bool)
+
+// RegisterTriggerPausedCallback allows to register a callback function called
+// when a trigger is paused after too many consecutive failures, or resumed.
+func RegisterTriggerPausedCallback(cb func(infos *TriggerInfos, failures int, paused bool)) {
+	cbTriggerPaused = cb
+}
+
+// newTriggerExecution returns the entry of the history for the given job.
+func newTriggerExecution(j *Job) TriggerExecution {
+	return TriggerExecution{
+		JobID:      j.ID(),
+		State:      j.State,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+		Duration:   j.FinishedAt.Sub(j.StartedAt).Seconds(),
+		Error:      j.Error,
+		Manual:     j.Manual,
+	}
+}
+
+// consecutiveFailures returns the number of errored jobs at the start of the
+// list (ordered from the most recent to the oldest job). The jobs queued
+// before since are not counted, as the trigger was resumed after them.
+func consecutiveFailures(js []*Job, since *time.Time) int {
+	failures := 0
+	for _, j := range js {
+		if since != nil && j.QueuedAt.Before(*since) {
+			break
+		}
+		switch j.State {
+		case Errored:
+			failures++
+		case Done:
+			return failures
+		}
+	}
+	return failures
+}
+
+// recordTriggerExecution pauses the trigger of the job if it has failed too
+// many times in a row. The history itself is not written in the trigger
+// document: it is computed from the jobs of the trigger, and so it doesn't
+// include the jobs removed by a purge.
+func recordTriggerExecution(j *Job) {
+	threshold := config.GetConfig().Jobs.MaxTriggerFailures
+	if threshold <= 0 || j.State != Errored {
+		return
+	}
+	js, err := GetJobs(j, j.TriggerID, threshold)
+	if err != nil {
+		j.Logger().Warnf("Cannot get the jobs of the trigger %s: %s",
+			j.TriggerID, err)
+		return
+	}
+	var failures int
+	infos, updated, err := globalJobSystem.updateTrigger(j, j.TriggerID, func(infos *TriggerInfos) bool {
+		if infos.Paused {
+			return false
+		}
+		failures = consecutiveFailures(js, infos.ResumedAt)
+		if failures < threshold {
+			return false
+		}
+		infos.Paused = true
+		return true
+	})
+	if err != nil {
+		j.Logger().Warnf("Cannot pause the trigger %s: %s", j.TriggerID, err)
+		return
+	}
+	if !updated {
+		return
+	}
+	j.Logger().Infof("Trigger %s paused after %d failures", infos.TID, failures)
+	if cbTriggerPaused != nil {
+		cbTriggerPaused(infos, failures, true)
+	}
+}
+
+// ResumeTrigger unpauses a trigger that has been paused after too many
+// consecutive failures. It returns the updated trigger infos.
+func ResumeTrigger(db prefixer.Prefixer, t Trigger) (*TriggerInfos, error) {
+	infos, updated, err := globalJobSystem.updateTrigger(db, t.ID(), func(infos *TriggerInfos) bool {
+		if !infos.Paused {
+			return false
+		}
+		now := time.Now()
+		infos.Paused = false
+		infos.ResumedAt = &now
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if updated && cbTriggerPaused != nil {
+		cbTriggerPaused(infos, 0, false)
+	}
+	return infos, nil
+}
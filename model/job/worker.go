@@ -24,10 +24,36 @@ var (
 	defaultConcurrency  = runtime.NumCPU()
 	defaultMaxExecCount = 1
 	defaultRetryDelay   = 60 * time.Millisecond
+	defaultRetryJitter  = 0.1
 	defaultTimeout      = 10 * time.Second
+
+	// requeueDelay is the delay before a job is put back in the queue when
+	// its concurrency group is full.
+	requeueDelay = 1 * time.Second
+
+	// maxInlineRetryDelay is the maximal delay before a retry that is waited
+	// by the worker. For longer delays, the job is put back in the queue, to
+	// not hold the worker during the wait.
+	maxInlineRetryDelay = 1 * time.Second
+)
+
+const (
+	// BackoffConstant is the strategy where all the retries are delayed by
+	// the same amount of time.
+	BackoffConstant BackoffStrategy = "constant"
+	// BackoffLinear is the strategy where the delay grows linearly with the
+	// number of retries.
+	BackoffLinear BackoffStrategy = "linear"
+	// BackoffExponential is the strategy where the delay is doubled after
+	// each retry. It is the default strategy.
+	BackoffExponential BackoffStrategy = "exponential"
 )
 
 type (
+	// BackoffStrategy is the strategy used to compute the delay between two
+	// executions of a job that has failed.
+	BackoffStrategy string
+
 	// WorkerInitFunc is called at the start of the worker system, only once. It
 	// is not called before every job process. It can be useful to initialize a
 	// global variable used by the worker.
@@ -46,7 +72,7 @@ type (
 
 	// WorkerBeforeHook is an optional method that is always called before the
 	// job is being pushed into the queue. It can be useful to skip the job
-	// beforehand.
+	// beforehand, or to postpone it by returning an ErrPostponed error.
 	WorkerBeforeHook func(job *Job) (bool, error)
 
 	// JobErrorCheckerHook is an optional method called at the beginning of the
@@ -58,18 +84,42 @@ type (
 	// system. It contains parameters of the worker along with the worker main
 	// function that perform the work against a job's message.
 	WorkerConfig struct {
-		WorkerInit   WorkerInitFunc
-		WorkerStart  WorkerStartFunc
-		WorkerFunc   WorkerFunc
-		WorkerCommit WorkerCommit
-		WorkerType   string
-		BeforeHook   WorkerBeforeHook
-		ErrorHook    JobErrorCheckerHook
-		Concurrency  int
-		MaxExecCount int
-		Reserved     bool // true when the clients must not push jobs for this worker
-		Timeout      time.Duration
-		RetryDelay   time.Duration
+		WorkerInit    WorkerInitFunc
+		WorkerStart   WorkerStartFunc
+		WorkerFunc    WorkerFunc
+		WorkerCommit  WorkerCommit
+		WorkerType    string
+		BeforeHook    WorkerBeforeHook
+		ErrorHook     JobErrorCheckerHook
+		Concurrency   int
+		MaxExecCount  int
+		Reserved      bool // true when the clients must not push jobs for this worker
+		Timeout       time.Duration
+		RetryDelay    time.Duration
+		MaxRetryDelay time.Duration
+		RetryJitter   *float64 // nil for the default jitter, 0 to disable it
+		Backoff       BackoffStrategy
+		DeadLetter    bool // true when the jobs that have exhausted their retries are kept in a dead-letter queue
+
+		// Priority is the default priority of the jobs of this worker type,
+		// when the job request has none (PriorityNormal if empty).
+		Priority Priority
+
+		// JobPriority is an optional function that returns the priority of a
+		// job from its request, when the request has none. It can return an
+		// empty priority to use the default priority of the worker type.
+		JobPriority func(db prefixer.Prefixer, req *JobRequest) Priority
+
+		// MaxConcurrencyPerInstance is the maximal number of jobs executed in
+		// parallel for a single instance (0 for no limit). It is only enforced
+		// by the redis broker.
+		MaxConcurrencyPerInstance int
+
+		// ConcurrencyGroup is an optional function that returns a group for a
+		// job, and the maximal number of jobs of this group executed in
+		// parallel for an instance. The jobs over this limit are put back in
+		// the queue.
+		ConcurrencyGroup func(job *Job) (group string, max int)
 	}
 
 	// Worker is a unit of work that will consume from a queue and execute the do
@@ -80,6 +130,7 @@ type (
 		jobs    chan *Job
 		running uint32
 		closed  chan struct{}
+		hooks   brokerHooks
 	}
 
 	// WorkerContext is a context.Context passed to the worker for each job
@@ -92,6 +143,7 @@ type (
 		id       string
 		cookie   interface{}
 		noRetry  bool
+		progress *progressReporter
 	}
 )
 
@@ -130,6 +182,7 @@ func NewWorkerContext(workerID string, job *Job, inst *instance.Instance) *Worke
 		job:      job,
 		log:      log,
 		id:       id,
+		progress: &progressReporter{},
 	}
 }
 
@@ -166,6 +219,7 @@ func (c *WorkerContext) clone() *WorkerContext {
 		log:      c.log,
 		id:       c.id,
 		cookie:   c.cookie,
+		progress: c.progress,
 	}
 }
 
@@ -174,6 +228,11 @@ func (c *WorkerContext) ID() string {
 	return c.id
 }
 
+// JobID returns the identifier of the job executed by the worker.
+func (c *WorkerContext) JobID() string {
+	return c.job.ID()
+}
+
 // Logger return the logger associated with the worker context.
 func (c *WorkerContext) Logger() *logger.Entry {
 	return c.log
@@ -267,6 +326,7 @@ func (w *Worker) work(workerID string, closed chan<- struct{}) {
 		domain := job.Domain
 		if domain == "" {
 			joblog.Errorf("%s: missing domain from job request", workerID)
+			w.skipJob(job)
 			continue
 		}
 		var inst *instance.Instance
@@ -275,6 +335,7 @@ func (w *Worker) work(workerID string, closed chan<- struct{}) {
 			inst, err = instance.Get(job.Domain)
 			if err != nil {
 				joblog.Errorf("Instance not found for %s: %s", job.Domain, err)
+				w.skipJob(job)
 				continue
 			}
 			// Do not execute jobs for instances with blocking not signed TOS,
@@ -286,14 +347,21 @@ func (w *Worker) work(workerID string, closed chan<- struct{}) {
 			if w.Type != "sendmail" && w.Type != "migrations" {
 				notSigned, deadline := inst.CheckTOSNotSignedAndDeadline()
 				if notSigned && deadline == instance.TOSBlocked {
+					w.skipJob(job)
 					continue
 				}
 			}
 		}
+		group, ok := w.acquireSlot(job)
+		if !ok {
+			continue
+		}
 		parentCtx := NewWorkerContext(workerID, job, inst)
 		if err := job.AckConsumed(); err != nil {
 			parentCtx.Logger().Errorf("error acking consume job: %s",
 				err.Error())
+			w.releaseSlot(job, group)
+			w.skipJob(job)
 			continue
 		}
 		t := &task{
@@ -308,11 +376,25 @@ func (w *Worker) work(workerID string, closed chan<- struct{}) {
 		if errRun == ErrAbort {
 			errRun = nil
 		}
+		if t.retryIn > 0 {
+			w.reschedule(t, errRun)
+			w.releaseSlot(job, group)
+			continue
+		}
+		job.ExecCount = t.execCount
+		parentCtx.finishProgress(errRun == nil)
 		if errRun != nil {
 			parentCtx.Logger().Errorf("error while performing job: %s",
 				errRun.Error())
 			runResultLabel = metrics.WorkerExecResultErrored
+			job.DeadLetter = t.conf.DeadLetter && w.hooks != nil && t.exhausted()
 			errAck = job.Nack(errRun.Error())
+			if job.DeadLetter {
+				if err := w.hooks.pushDeadLetter(job); err != nil {
+					parentCtx.Logger().Errorf("error while moving job to the dead-letter queue: %s",
+						err.Error())
+				}
+			}
 		} else {
 			runResultLabel = metrics.WorkerExecResultSuccess
 			errAck = job.Ack()
@@ -337,12 +419,18 @@ func (w *Worker) work(workerID string, closed chan<- struct{}) {
 			parentCtx.Logger().Errorf("error while acking job done: %s",
 				errAck.Error())
 		}
+		if w.hooks != nil {
+			w.hooks.jobFinished(job)
+		}
+		w.releaseSlot(job, group)
 
 		// Delete the trigger associated with the job (if any) when we receive a
-		// ErrBadTrigger.
+		// ErrBadTrigger, or record the execution in the trigger history.
 		if job.TriggerID != "" && globalJobSystem != nil {
 			if _, ok := errRun.(ErrBadTrigger); ok {
 				_ = globalJobSystem.DeleteTrigger(job, job.TriggerID)
+			} else {
+				recordTriggerExecution(job)
 			}
 		}
 	}
@@ -350,6 +438,62 @@ func (w *Worker) work(workerID string, closed chan<- struct{}) {
 	closed <- struct{}{}
 }
 
+// skipJob is called for a job taken from the queue that will not be
+// executed, to free its place in the running jobs of its instance.
+func (w *Worker) skipJob(job *Job) {
+	if w.hooks != nil {
+		w.hooks.jobFinished(job)
+	}
+}
+
+// acquireSlot reserves a slot for the job in its concurrency group, if it
+// has one. When the group is full, the job is put back in the queue, and
+// false is returned.
+func (w *Worker) acquireSlot(job *Job) (string, bool) {
+	if w.Conf.ConcurrencyGroup == nil || w.hooks == nil {
+		return "", true
+	}
+	group, max := w.Conf.ConcurrencyGroup(job)
+	if group == "" || max <= 0 {
+		return "", true
+	}
+	ok, err := w.hooks.acquireSlot(job, group, max)
+	if err != nil {
+		joblog.Warnf("Cannot acquire a slot for %s on %s: %s", group, job.Domain, err)
+		return "", true
+	}
+	if !ok {
+		joblog.Debugf("Concurrency limit reached for %s on %s: job %s requeued",
+			group, job.Domain, job.ID())
+		w.hooks.requeue(job, requeueDelay)
+		return "", false
+	}
+	return group, true
+}
+
+// reschedule puts back in the queue a job that has failed and must be retried
+// after a long delay. The number of executions is kept in the job, to know
+// when its retries are exhausted.
+func (w *Worker) reschedule(t *task, errRun error) {
+	job := t.job
+	job.State = Queued
+	job.ExecCount = t.execCount
+	if errRun != nil {
+		job.Error = errRun.Error()
+	}
+	if err := job.Update(); err != nil {
+		t.ctx.Logger().Errorf("error while rescheduling job: %s", err)
+	}
+	w.hooks.requeue(job, t.retryIn)
+}
+
+// releaseSlot frees the slot reserved by acquireSlot.
+func (w *Worker) releaseSlot(job *Job, group string) {
+	if group != "" && w.hooks != nil {
+		w.hooks.releaseSlot(job, group)
+	}
+}
+
 func (w *Worker) defaultedConf(opts *JobOptions) *WorkerConfig {
 	c := w.Conf.Clone()
 	if c.Concurrency == 0 {
@@ -361,6 +505,13 @@ func (w *Worker) defaultedConf(opts *JobOptions) *WorkerConfig {
 	if c.RetryDelay == 0 {
 		c.RetryDelay = defaultRetryDelay
 	}
+	if c.RetryJitter == nil {
+		jitter := defaultRetryJitter
+		c.RetryJitter = &jitter
+	}
+	if c.Backoff == "" {
+		c.Backoff = BackoffExponential
+	}
 	if c.Timeout == 0 {
 		c.Timeout = defaultTimeout
 	}
@@ -376,6 +527,36 @@ func (w *Worker) defaultedConf(opts *JobOptions) *WorkerConfig {
 	return c
 }
 
+// retryDelay returns the delay to wait before the given retry of a job. It
+// follows the backoff strategy, is capped by the maximal retry delay (if any),
+// and is randomized by the jitter ratio.
+func (c *WorkerConfig) retryDelay(retry int) time.Duration {
+	var delay time.Duration
+	switch c.Backoff {
+	case BackoffConstant:
+		delay = c.RetryDelay
+	case BackoffLinear:
+		delay = c.RetryDelay * time.Duration(retry)
+	default:
+		delay = c.RetryDelay << uint(retry-1)
+	}
+
+	// delay <= 0 means that the exponential backoff has overflowed
+	if c.MaxRetryDelay > 0 && (delay > c.MaxRetryDelay || delay <= 0) {
+		delay = c.MaxRetryDelay
+	}
+
+	// fuzzDelay number between delay * (1 +/- jitter)
+	var jitter float64
+	if c.RetryJitter != nil {
+		jitter = *c.RetryJitter
+	}
+	if fuzzDelay := int64(jitter * float64(delay)); fuzzDelay > 0 {
+		delay += time.Duration(rand.Int63n(2*fuzzDelay) - fuzzDelay)
+	}
+	return delay
+}
+
 type task struct {
 	w    *Worker
 	ctx  *WorkerContext
@@ -385,11 +566,12 @@ type task struct {
 	startTime time.Time
 	endTime   time.Time
 	execCount int
+	retryIn   time.Duration // delay before the next try, when rescheduled
 }
 
 func (t *task) run() (err error) {
 	t.startTime = time.Now()
-	t.execCount = 0
+	t.execCount = t.job.ExecCount
 
 	if t.conf.WorkerStart != nil {
 		t.ctx, err = t.conf.WorkerStart(t.ctx)
@@ -398,7 +580,8 @@ func (t *task) run() (err error) {
 		}
 	}
 	defer func() {
-		if t.conf.WorkerCommit != nil {
+		// The commit is done only once, after the last execution
+		if t.conf.WorkerCommit != nil && t.retryIn == 0 {
 			t.ctx.log = t.ctx.Logger().WithField("exec_time", t.endTime.Sub(t.startTime))
 			if errc := t.conf.WorkerCommit(t.ctx, err); errc != nil {
 				t.ctx.Logger().Warnf("Error while committing job: %s",
@@ -406,8 +589,15 @@ func (t *task) run() (err error) {
 			}
 		}
 	}()
+	// A job that has been rescheduled comes back from the delayed queue after
+	// its retry delay: it is executed right away.
+	served := t.execCount > 0
 	for {
 		retry, delay, timeout := t.nextDelay(err)
+		if served {
+			delay = 0
+			served = false
+		}
 
 		// The optional ErrorHook function allows to prevent retries depending
 		// on the previous error
@@ -422,6 +612,10 @@ func (t *task) run() (err error) {
 				err.Error(), delay)
 		}
 
+		if delay > maxInlineRetryDelay && t.w.hooks != nil {
+			t.retryIn = delay
+			break
+		}
 		if delay > 0 {
 			time.Sleep(delay)
 		}
@@ -484,7 +678,9 @@ func (t *task) run() (err error) {
 		}
 	}
 
-	metrics.WorkerExecRetries.WithLabelValues(t.w.Type).Observe(float64(t.execCount))
+	if t.retryIn == 0 {
+		metrics.WorkerExecRetries.WithLabelValues(t.w.Type).Observe(float64(t.execCount))
+	}
 	return
 }
 
@@ -537,12 +733,14 @@ func (t *task) nextDelay(prevError error) (bool, time.Duration, time.Duration) {
 		// on first execution, execute immediately
 		nextDelay = 0
 	} else {
-		nextDelay = c.RetryDelay << uint(t.execCount-1)
-
-		// fuzzDelay number between delay * (1 +/- 0.1)
-		fuzzDelay := int(0.1 * float64(nextDelay))
-		nextDelay = nextDelay + time.Duration((rand.Intn(2*fuzzDelay) - fuzzDelay))
+		nextDelay = c.retryDelay(t.execCount)
 	}
 
 	return true, nextDelay, timeout
 }
+
+// exhausted returns true if the job has been executed as many times as
+// allowed by the worker configuration.
+func (t *task) exhausted() bool {
+	return t.execCount >= t.conf.MaxExecCount
+}
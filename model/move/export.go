@@ -279,11 +279,13 @@ func copyVersions(zw *zip.Writer, inst *instance.Instance, exportDoc *ExportDoc,
 }
 
 // CreateExport is used to create a tarball with the data from an instance.
+// The progress is reported doctype by doctype to the given function, that can
+// be nil.
 //
 // Note: the tarball is a .tar.gz and not a .zip to allow streaming from Swift
 // to the stack, and from the stack to the client, as .tar.gz can be read
 // sequentially and reading a .zip need to seek.
-func CreateExport(i *instance.Instance, opts ExportOptions, archiver Archiver) (*ExportDoc, error) {
+func CreateExport(i *instance.Instance, opts ExportOptions, archiver Archiver, progress job.ProgressFunc) (*ExportDoc, error) {
 	exportDoc := prepareExportDoc(i, opts)
 	if err := exportDoc.CleanPreviousExports(archiver); err != nil {
 		return nil, err
@@ -294,7 +296,7 @@ func CreateExport(i *instance.Instance, opts ExportOptions, archiver Archiver) (
 	}
 	realtime.GetHub().Publish(i, realtime.EventCreate, exportDoc.Clone(), nil)
 
-	size, err := writeArchive(i, exportDoc, archiver)
+	size, err := writeArchive(i, exportDoc, archiver, progress)
 	old := exportDoc.Clone()
 	errf := exportDoc.MarksAsFinished(i, size, err)
 	realtime.GetHub().Publish(i, realtime.EventUpdate, exportDoc, old)
@@ -304,25 +306,25 @@ func CreateExport(i *instance.Instance, opts ExportOptions, archiver Archiver) (
 	return exportDoc, errf
 }
 
-func writeArchive(i *instance.Instance, exportDoc *ExportDoc, archiver Archiver) (int64, error) {
+func writeArchive(i *instance.Instance, exportDoc *ExportDoc, archiver Archiver, progress job.ProgressFunc) (int64, error) {
 	out, err := archiver.CreateArchive(exportDoc)
 	if err != nil {
 		return 0, err
 	}
-	size, err := writeArchiveContent(i, exportDoc, out)
+	size, err := writeArchiveContent(i, exportDoc, out, progress)
 	if err != nil {
 		return 0, err
 	}
 	return size, out.Close()
 }
 
-func writeArchiveContent(i *instance.Instance, exportDoc *ExportDoc, out io.Writer) (int64, error) {
+func writeArchiveContent(i *instance.Instance, exportDoc *ExportDoc, out io.Writer, progress job.ProgressFunc) (int64, error) {
 	gw, err := gzip.NewWriterLevel(out, gzip.BestCompression)
 	if err != nil {
 		return 0, err
 	}
 	tw := tar.NewWriter(gw)
-	size, err := writeDocuments(i, exportDoc, tw)
+	size, err := writeDocuments(i, exportDoc, tw, progress)
 	if err != nil {
 		return 0, err
 	}
@@ -335,7 +337,7 @@ func writeArchiveContent(i *instance.Instance, exportDoc *ExportDoc, out io.Writ
 	return size, nil
 }
 
-func writeDocuments(i *instance.Instance, exportDoc *ExportDoc, tw *tar.Writer) (int64, error) {
+func writeDocuments(i *instance.Instance, exportDoc *ExportDoc, tw *tar.Writer, progress job.ProgressFunc) (int64, error) {
 	var size int64
 	createdAt := exportDoc.CreatedAt
 
@@ -345,7 +347,7 @@ func writeDocuments(i *instance.Instance, exportDoc *ExportDoc, tw *tar.Writer)
 	}
 	size += n
 
-	n, err = exportDocuments(i, exportDoc, createdAt, tw)
+	n, err = exportDocuments(i, exportDoc, createdAt, tw, progress)
 	if err != nil {
 		return 0, err
 	}
@@ -407,14 +409,18 @@ func exportFiles(i *instance.Instance, exportDoc *ExportDoc, tw *tar.Writer) (in
 	return size, nil
 }
 
-func exportDocuments(in *instance.Instance, doc *ExportDoc, now time.Time, tw *tar.Writer) (int64, error) {
+// exportDocuments writes the documents of all the doctypes, except the files,
+// in the tarball. For the progress, the files are counted as the last step.
+func exportDocuments(in *instance.Instance, doc *ExportDoc, now time.Time, tw *tar.Writer, progress job.ProgressFunc) (int64, error) {
 	doctypes, err := couchdb.AllDoctypes(in)
 	if err != nil {
 		return 0, err
 	}
 
 	var size int64
-	for _, doctype := range doctypes {
+	total := int64(len(doctypes)) + 1
+	for i, doctype := range doctypes {
+		progress.Report(int64(i), total, doctype)
 		if !doc.AcceptDoctype(doctype) {
 			continue
 		}
@@ -435,6 +441,7 @@ func exportDocuments(in *instance.Instance, doc *ExportDoc, now time.Time, tw *t
 			return 0, err
 		}
 	}
+	progress.Report(total-1, total, consts.Files)
 	return size, nil
 }
 
@@ -128,8 +128,9 @@ func fetchManifest(manifestURL string) (*ExportDoc, error) {
 
 // Import downloads the documents and files from an export and add them to the
 // local instance. It returns the list of slugs for apps/konnectors that have
-// not been installed.
-func Import(inst *instance.Instance, options ImportOptions) ([]string, error) {
+// not been installed. The progress is reported part by part to the given
+// function, that can be nil.
+func Import(inst *instance.Instance, options ImportOptions, progress job.ProgressFunc) ([]string, error) {
 	defer func() {
 		settings, err := inst.SettingsDocument()
 		if err == nil {
@@ -160,10 +161,13 @@ func Import(inst *instance.Instance, options ImportOptions) ([]string, error) {
 		doc:             doc,
 		servicesInError: make(map[string]bool),
 	}
+	total := int64(len(doc.PartsCursors)) + 1
+	progress.Report(0, total, "")
 	if err = im.importPart(""); err != nil {
 		return nil, err
 	}
-	for _, cursor := range doc.PartsCursors {
+	for i, cursor := range doc.PartsCursors {
+		progress.Report(int64(i)+1, total, "")
 		if erri := im.importPart(cursor); erri != nil {
 			err = multierror.Append(err, erri)
 		}
@@ -3,10 +3,12 @@ package center
 import (
 	"errors"
 	"fmt"
+	"html"
 	"strings"
 	"time"
 
 	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/job"
@@ -25,6 +27,12 @@ const (
 	// NotificationDiskQuota category for sending alert when reaching 90% of disk
 	// usage quota.
 	NotificationDiskQuota = "disk-quota"
+	// NotificationTriggerPaused category for sending alert when a trigger has
+	// been paused after too many consecutive failures.
+	NotificationTriggerPaused = "trigger-paused"
+	// NotificationCredentialsReentry category for sending alert when the
+	// credentials vault needs the previous passphrase of the user.
+	NotificationCredentialsReentry = "credentials-reentry"
 )
 
 var (
@@ -36,6 +44,15 @@ var (
 			MailTemplate: "notifications_diskquota",
 			MinInterval:  7 * 24 * time.Hour,
 		},
+		NotificationTriggerPaused: {
+			Description: "Warn about a trigger paused after too many failures",
+			Collapsible: true,
+			Stateful:    true,
+		},
+		NotificationCredentialsReentry: {
+			Description: "Warn about the credentials vault locked after a reset of the passphrase",
+			Collapsible: true,
+		},
 	}
 )
 
@@ -59,6 +76,49 @@ func init() {
 		}
 		_ = PushStack(domain, NotificationDiskQuota, n)
 	})
+
+	job.RegisterTriggerPausedCallback(func(infos *job.TriggerInfos, failures int, paused bool) {
+		i, err := lifecycle.GetInstance(infos.Domain)
+		if err != nil {
+			return
+		}
+		name := infos.WorkerType
+		if infos.WorkerType == "konnector" {
+			var msg struct {
+				Konnector string `json:"konnector"`
+			}
+			if err := infos.Message.Unmarshal(&msg); err == nil && msg.Konnector != "" {
+				name = msg.Konnector
+			}
+		}
+		title := i.Translate("Notifications Trigger Paused Subject", name)
+		content := i.Translate("Notifications Trigger Paused Content", name, failures)
+		n := &notification.Notification{
+			CategoryID:  infos.TID,
+			State:       paused,
+			Title:       title,
+			Message:     content,
+			Content:     content,
+			ContentHTML: "<p>" + html.EscapeString(content) + "</p>",
+			Data: map[string]interface{}{
+				"trigger_id": infos.TID,
+				"worker":     infos.WorkerType,
+			},
+		}
+		_ = PushStack(infos.Domain, NotificationTriggerPaused, n)
+	})
+
+	settings.RegisterCredentialsReentryCallback(func(i *instance.Instance) {
+		title := i.Translate("Notifications Credentials Reentry Subject")
+		content := i.Translate("Notifications Credentials Reentry Content")
+		n := &notification.Notification{
+			Title:       title,
+			Message:     content,
+			Content:     content,
+			ContentHTML: "<p>" + html.EscapeString(content) + "</p>",
+		}
+		_ = PushStack(i.Domain, NotificationCredentialsReentry, n)
+	})
 }
 
 // PushStack creates and sends a new notification where the source is the stack.
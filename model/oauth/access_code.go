@@ -16,6 +16,10 @@ type AccessCode struct {
 	ClientID string `json:"client_id"`
 	IssuedAt int64  `json:"issued_at"`
 	Scope    string `json:"scope"`
+
+	// Challenge is the PKCE code_challenge (S256) sent with the authorize
+	// request, that must be verified when the code is exchanged for tokens.
+	Challenge string `json:"code_challenge,omitempty"`
 }
 
 // ID returns the access code qualified identifier
@@ -36,8 +40,9 @@ func (ac *AccessCode) SetID(id string) { ac.Code = id }
 // SetRev changes the access code revision
 func (ac *AccessCode) SetRev(rev string) { ac.CouchRev = rev }
 
-// CreateAccessCode an access code for the given clientID, persisted in CouchDB
-func CreateAccessCode(i *instance.Instance, client *Client, scope string) (*AccessCode, error) {
+// CreateAccessCode an access code for the given clientID, persisted in
+// CouchDB. The challenge is optional, and is the PKCE code_challenge.
+func CreateAccessCode(i *instance.Instance, client *Client, scope, challenge string) (*AccessCode, error) {
 	if client.Pending {
 		client.Pending = false
 		client.ClientID = ""
@@ -46,9 +51,10 @@ func CreateAccessCode(i *instance.Instance, client *Client, scope string) (*Acce
 	}
 
 	ac := &AccessCode{
-		ClientID: client.ClientID,
-		IssuedAt: crypto.Timestamp(),
-		Scope:    scope,
+		ClientID:  client.ClientID,
+		IssuedAt:  crypto.Timestamp(),
+		Scope:     scope,
+		Challenge: challenge,
 	}
 	if err := couchdb.CreateDoc(i, ac); err != nil {
 		return nil, err
@@ -42,6 +42,16 @@ const ClientSecretLen = 24
 // for login/authentication purposes.
 const ScopeLogin = "login"
 
+const (
+	// AuthMethodSecretPost is the default token_endpoint_auth_method: the
+	// client sends its client_secret with the other parameters.
+	AuthMethodSecretPost = "client_secret_post"
+	// AuthMethodNone is the token_endpoint_auth_method for the public clients,
+	// like mobile and desktop applications, that can't keep a secret. They
+	// must use PKCE for the authorization code flow.
+	AuthMethodNone = "none"
+)
+
 // CleanMessage is used for messages to the clean-clients worker.
 type CleanMessage struct {
 	ClientID string `json:"client_id"`
@@ -59,12 +69,15 @@ type Client struct {
 	CouchID  string `json:"_id,omitempty"`  // Generated by CouchDB
 	CouchRev string `json:"_rev,omitempty"` // Generated by CouchDB
 
-	ClientID          string `json:"client_id,omitempty"`                 // Same as CouchID
-	ClientSecret      string `json:"client_secret,omitempty"`             // Generated by the server
-	SecretExpiresAt   int    `json:"client_secret_expires_at"`            // Forced by the server to 0 (no expiration)
-	RegistrationToken string `json:"registration_access_token,omitempty"` // Generated by the server
-	AllowLoginScope   bool   `json:"allow_login_scope,omitempty"`         // Allow to generate token for a "login" scope (no permissions)
-	Pending           bool   `json:"pending,omitempty"`                   // True until a token is generated
+	ClientID           string `json:"client_id,omitempty"`                 // Same as CouchID
+	ClientSecret       string `json:"client_secret,omitempty"`             // Generated by the server
+	SecretExpiresAt    int    `json:"client_secret_expires_at"`            // Forced by the server to 0 (no expiration)
+	RegistrationToken  string `json:"registration_access_token,omitempty"` // Generated by the server
+	AllowLoginScope    bool   `json:"allow_login_scope,omitempty"`         // Allow to generate token for a "login" scope (no permissions)
+	AllowIntrospection bool   `json:"allow_introspection,omitempty"`       // Allow to introspect the tokens of the other clients
+	Pending            bool   `json:"pending,omitempty"`                   // True until a token is generated
+
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method,omitempty"` // Declared by the client (optional, "none" for public clients)
 
 	RedirectURIs    []string `json:"redirect_uris"`              // Declared by the client (mandatory)
 	GrantTypes      []string `json:"grant_types"`                // Forced by the server to ["authorization_code", "refresh_token"]
@@ -94,6 +107,12 @@ type Client struct {
 	Metadata *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
 }
 
+// IsPublic returns true if the client has been registered as a public client,
+// ie it can't keep a secret and must use PKCE.
+func (c *Client) IsPublic() bool {
+	return c.TokenEndpointAuthMethod == AuthMethodNone
+}
+
 // ID returns the client qualified identifier
 func (c *Client) ID() string { return c.CouchID }
 
@@ -288,6 +307,15 @@ func (c *Client) checkMandatoryFields(i *instance.Instance) *ClientRegistrationE
 			Description: "software_id is mandatory",
 		}
 	}
+	switch c.TokenEndpointAuthMethod {
+	case "", AuthMethodSecretPost, AuthMethodNone:
+	default:
+		return &ClientRegistrationError{
+			Code:        http.StatusBadRequest,
+			Error:       "invalid_client_metadata",
+			Description: fmt.Sprintf("%s is not a supported token_endpoint_auth_method", c.TokenEndpointAuthMethod),
+		}
+	}
 	c.NotificationPlatform = strings.ToLower(c.NotificationPlatform)
 	switch c.NotificationPlatform {
 	case "", PlatformFirebase, PlatformAPNS:
@@ -502,6 +530,7 @@ func (c *Client) Update(i *instance.Instance, old *Client) *ClientRegistrationEr
 	c.GrantTypes = []string{"authorization_code", "refresh_token"}
 	c.ResponseTypes = []string{"code"}
 	c.AllowLoginScope = old.AllowLoginScope
+	c.AllowIntrospection = old.AllowIntrospection
 	c.OnboardingSecret = ""
 	c.OnboardingApp = ""
 	c.OnboardingPermissions = ""
@@ -539,7 +568,8 @@ func (c *Client) Update(i *instance.Instance, old *Client) *ClientRegistrationEr
 	return nil
 }
 
-// Delete is a function that unregister a client
+// Delete is a function that unregister a client, and deletes the records of
+// its refresh tokens.
 func (c *Client) Delete(i *instance.Instance) *ClientRegistrationError {
 	if err := couchdb.DeleteDoc(i, c); err != nil {
 		return &ClientRegistrationError{
@@ -547,6 +577,11 @@ func (c *Client) Delete(i *instance.Instance) *ClientRegistrationError {
 			Error: "internal_server_error",
 		}
 	}
+	if err := DeleteRefreshTokens(i, c.CouchID); err != nil {
+		// The records left behind can be removed later by a purge
+		i.Logger().WithNamespace("oauth").
+			Warnf("Failed to delete the refresh tokens of %s: %s", c.CouchID, err)
+	}
 	return nil
 }
 
@@ -561,9 +596,10 @@ func (c *Client) AcceptRedirectURI(u string) bool {
 	return false
 }
 
-// CreateJWT returns a new JSON Web Token for the given instance and audience
+// CreateJWT returns a new JSON Web Token for the given instance and audience.
+// For a refresh token, a record is persisted to allow its revocation.
 func (c *Client) CreateJWT(i *instance.Instance, audience, scope string) (string, error) {
-	token, err := crypto.NewJWT(i.OAuthSecret, permission.Claims{
+	claims := permission.Claims{
 		StandardClaims: crypto.StandardClaims{
 			Audience: audience,
 			Issuer:   i.Domain,
@@ -571,7 +607,17 @@ func (c *Client) CreateJWT(i *instance.Instance, audience, scope string) (string
 			Subject:  c.CouchID,
 		},
 		Scope: scope,
-	})
+	}
+	if audience == consts.RefreshTokenAudience {
+		jti, err := RecordRefreshToken(i, c.CouchID, scope)
+		if err != nil {
+			i.Logger().WithNamespace("oauth").
+				Errorf("Failed to record the %s token: %s", audience, err)
+			return "", err
+		}
+		claims.ID = jti
+	}
+	token, err := crypto.NewJWT(i.OAuthSecret, claims)
 	if err != nil {
 		i.Logger().WithNamespace("oauth").
 			Errorf("Failed to create the %s token: %s", audience, err)
@@ -579,10 +625,10 @@ func (c *Client) CreateJWT(i *instance.Instance, audience, scope string) (string
 	return token, err
 }
 
-func validToken(i *instance.Instance, audience, token string) (permission.Claims, bool) {
+func validToken(i *instance.Instance, audience, token string) (permission.Claims, error) {
 	claims := permission.Claims{}
 	if token == "" {
-		return claims, false
+		return claims, ErrInvalidToken
 	}
 	keyFunc := func(token *jwt.Token) (interface{}, error) {
 		return i.OAuthSecret, nil
@@ -590,64 +636,80 @@ func validToken(i *instance.Instance, audience, token string) (permission.Claims
 	if err := crypto.ParseJWT(token, keyFunc, &claims); err != nil {
 		i.Logger().WithNamespace("oauth").
 			Errorf("Failed to verify the %s token: %s", audience, err)
-		return claims, false
+		return claims, ErrInvalidToken
 	}
 	if claims.Expired() {
 		i.Logger().WithNamespace("oauth").
 			Errorf("Failed to verify the %s token: expired", audience)
-		return claims, false
+		return claims, ErrInvalidToken
 	}
 	// Note: the refresh and registration tokens don't expire, no need to check its issue date
 	if claims.Audience != audience {
 		i.Logger().WithNamespace("oauth").
 			Errorf("Unexpected audience for %s token: %s", audience, claims.Audience)
-		return claims, false
+		return claims, ErrInvalidToken
 	}
 	if claims.Issuer != i.Domain {
 		i.Logger().WithNamespace("oauth").
 			Errorf("Expected %s issuer for %s token, but was: %s", audience, i.Domain, claims.Issuer)
-		return claims, false
+		return claims, ErrInvalidToken
 	}
-	return claims, true
+	// The refresh tokens issued before the records were introduced have no
+	// jti, and can only be revoked by deleting the client.
+	if audience == consts.RefreshTokenAudience && claims.ID != "" {
+		revoked, err := refreshTokenRevoked(i, claims.ID)
+		if err != nil {
+			return claims, err
+		}
+		if revoked {
+			i.Logger().WithNamespace("oauth").
+				Infof("The %s token %s has been revoked", audience, claims.ID)
+			return claims, ErrInvalidToken
+		}
+	}
+	return claims, nil
 }
 
 // ValidTokenWithSStamp checks that the JWT is valid and returns the associate
 // claims. You should use client.ValidToken if you know the client, as it also
-// checks that the claims are associated to this client.
-func ValidTokenWithSStamp(i *instance.Instance, audience, token string) (permission.Claims, bool) {
-	claims, valid := validToken(i, audience, token)
-	if !valid {
-		return claims, valid
+// checks that the claims are associated to this client. ErrInvalidToken is
+// returned for an invalid token, and another error if the check has failed.
+func ValidTokenWithSStamp(i *instance.Instance, audience, token string) (permission.Claims, error) {
+	claims, err := validToken(i, audience, token)
+	if err != nil {
+		return claims, err
 	}
 	settings, err := settings.Get(i)
 	if err != nil {
 		i.Logger().WithNamespace("oauth").
 			Errorf("Error while getting bitwarden settings: %s", err)
-		return claims, false
+		return claims, err
 	}
 	if claims.SStamp != settings.SecurityStamp {
 		i.Logger().WithNamespace("oauth").
 			Errorf("Expected %s security stamp for %s token, but was: %s",
 				settings.SecurityStamp, claims.Subject, claims.SStamp)
-		return claims, false
+		return claims, ErrInvalidToken
 	}
-	return claims, true
+	return claims, nil
 }
 
 // ValidToken checks that the JWT is valid and returns the associate claims.
 // It is expected to be used for registration token and refresh token, and
 // it doesn't check when they were issued as they don't expire.
-func (c *Client) ValidToken(i *instance.Instance, audience, token string) (permission.Claims, bool) {
-	claims, valid := validToken(i, audience, token)
-	if !valid {
-		return claims, valid
+// ErrInvalidToken is returned for an invalid token, and another error if the
+// check has failed, like when CouchDB is unavailable.
+func (c *Client) ValidToken(i *instance.Instance, audience, token string) (permission.Claims, error) {
+	claims, err := validToken(i, audience, token)
+	if err != nil {
+		return claims, err
 	}
 	if claims.Subject != c.CouchID {
 		i.Logger().WithNamespace("oauth").
 			Errorf("Expected %s subject for %s token, but was: %s", audience, c.CouchID, claims.Subject)
-		return claims, false
+		return claims, ErrInvalidToken
 	}
-	return claims, true
+	return claims, nil
 }
 
 // IsLinkedApp checks if an OAuth client has a linked app
@@ -3,11 +3,14 @@ package oauth_test
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/crypto"
 	"github.com/cozy/cozy-stack/tests/testutils"
 	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/assert"
@@ -43,8 +46,8 @@ func TestParseJWT(t *testing.T) {
 	tokenString, err := c.CreateJWT(testInstance, "refresh", "foo:read")
 	assert.NoError(t, err)
 
-	claims, ok := c.ValidToken(testInstance, consts.RefreshTokenAudience, tokenString)
-	assert.True(t, ok, "The token must be valid")
+	claims, err := c.ValidToken(testInstance, consts.RefreshTokenAudience, tokenString)
+	assert.NoError(t, err, "The token must be valid")
 	assert.Equal(t, "refresh", claims.Audience)
 	assert.Equal(t, testInstance.Domain, claims.Issuer)
 	assert.Equal(t, "my-client-id", claims.Subject)
@@ -54,8 +57,8 @@ func TestParseJWT(t *testing.T) {
 func TestParseJWTInvalidAudience(t *testing.T) {
 	tokenString, err := c.CreateJWT(testInstance, "access", "foo:read")
 	assert.NoError(t, err)
-	_, ok := c.ValidToken(testInstance, consts.RefreshTokenAudience, tokenString)
-	assert.False(t, ok, "The token should be invalid")
+	_, err = c.ValidToken(testInstance, consts.RefreshTokenAudience, tokenString)
+	assert.Equal(t, oauth.ErrInvalidToken, err, "The token should be invalid")
 }
 
 func TestCreateClient(t *testing.T) {
@@ -137,8 +140,8 @@ func TestParseJWTInvalidIssuer(t *testing.T) {
 	}
 	tokenString, err := c.CreateJWT(other, "refresh", "foo:read")
 	assert.NoError(t, err)
-	_, ok := c.ValidToken(testInstance, consts.RefreshTokenAudience, tokenString)
-	assert.False(t, ok, "The token should be invalid")
+	_, err = c.ValidToken(testInstance, consts.RefreshTokenAudience, tokenString)
+	assert.Equal(t, oauth.ErrInvalidToken, err, "The token should be invalid")
 }
 
 func TestParseJWTInvalidSubject(t *testing.T) {
@@ -147,8 +150,8 @@ func TestParseJWTInvalidSubject(t *testing.T) {
 	}
 	tokenString, err := other.CreateJWT(testInstance, "refresh", "foo:read")
 	assert.NoError(t, err)
-	_, ok := c.ValidToken(testInstance, consts.RefreshTokenAudience, tokenString)
-	assert.False(t, ok, "The token should be invalid")
+	_, err = c.ValidToken(testInstance, consts.RefreshTokenAudience, tokenString)
+	assert.Equal(t, oauth.ErrInvalidToken, err, "The token should be invalid")
 }
 
 func TestParseGoodSoftwareID(t *testing.T) {
@@ -171,6 +174,119 @@ func TestParseHttpSoftwareID(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestCodeChallenge(t *testing.T) {
+	// Example from https://tools.ietf.org/html/rfc7636#appendix-B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	assert.NoError(t, oauth.CheckCodeChallenge(challenge, "S256"))
+	assert.Equal(t, oauth.ErrInvalidChallengeMethod, oauth.CheckCodeChallenge(verifier, "plain"))
+	assert.Equal(t, oauth.ErrInvalidChallengeMethod, oauth.CheckCodeChallenge(challenge, ""))
+	assert.Equal(t, oauth.ErrInvalidChallenge, oauth.CheckCodeChallenge("foo", "S256"))
+
+	assert.True(t, oauth.VerifyCodeChallenge(challenge, verifier))
+	assert.False(t, oauth.VerifyCodeChallenge(challenge, verifier+"x"))
+	assert.False(t, oauth.VerifyCodeChallenge(challenge, "too-short"))
+	assert.False(t, oauth.VerifyCodeChallenge("", verifier))
+}
+
+func TestPublicClient(t *testing.T) {
+	client := &oauth.Client{
+		ClientName:              "client-pkce",
+		RedirectURIs:            []string{"com.example.app:/callback"},
+		SoftwareID:              "github.com/example/app",
+		TokenEndpointAuthMethod: "none",
+	}
+	assert.Nil(t, client.Create(testInstance))
+	assert.True(t, client.IsPublic())
+
+	client = &oauth.Client{
+		ClientName:              "client-basic",
+		RedirectURIs:            []string{"https://example.org/callback"},
+		SoftwareID:              "github.com/example/app",
+		TokenEndpointAuthMethod: "client_secret_basic",
+	}
+	err := client.Create(testInstance)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "invalid_client_metadata", err.Error)
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	client := &oauth.Client{
+		ClientName:   "client-revoke",
+		RedirectURIs: []string{"https://example.org/callback"},
+		SoftwareID:   "github.com/example/app",
+	}
+	assert.Nil(t, client.Create(testInstance))
+	other := &oauth.Client{
+		ClientName:   "client-other",
+		RedirectURIs: []string{"https://example.org/callback"},
+		SoftwareID:   "github.com/example/app",
+	}
+	assert.Nil(t, other.Create(testInstance))
+
+	refresh, err := client.CreateJWT(testInstance, consts.RefreshTokenAudience, "io.cozy.files")
+	assert.NoError(t, err)
+	_, err = client.ValidToken(testInstance, consts.RefreshTokenAudience, refresh)
+	assert.NoError(t, err)
+
+	found, claims, err := oauth.IntrospectToken(testInstance, client, refresh)
+	if assert.NoError(t, err) {
+		assert.Equal(t, client.CouchID, found.ClientID)
+		assert.Equal(t, "io.cozy.files", claims.Scope)
+		assert.NotEmpty(t, claims.ID)
+	}
+	// Only the owner of the token can introspect it
+	_, _, err = oauth.IntrospectToken(testInstance, other, refresh)
+	assert.Equal(t, oauth.ErrInvalidToken, err)
+	other.AllowIntrospection = true
+	_, _, err = oauth.IntrospectToken(testInstance, other, refresh)
+	assert.NoError(t, err)
+
+	access, err := client.CreateJWT(testInstance, consts.AccessTokenAudience, "io.cozy.files")
+	assert.NoError(t, err)
+	assert.Equal(t, oauth.ErrUnsupportedTokenType, client.RevokeToken(testInstance, access))
+	assert.Equal(t, oauth.ErrTokenNotOwned, other.RevokeToken(testInstance, refresh))
+	assert.NoError(t, client.RevokeToken(testInstance, "not-a-token"))
+
+	assert.NoError(t, client.RevokeToken(testInstance, refresh))
+	_, err = client.ValidToken(testInstance, consts.RefreshTokenAudience, refresh)
+	assert.Equal(t, oauth.ErrInvalidToken, err)
+	_, _, err = oauth.IntrospectToken(testInstance, client, refresh)
+	assert.Equal(t, oauth.ErrInvalidToken, err)
+
+	// The access tokens are not revoked
+	_, _, err = oauth.IntrospectToken(testInstance, client, access)
+	assert.NoError(t, err)
+
+	// But they are no longer active after their validity duration
+	old, err := crypto.NewJWT(testInstance.OAuthSecret, permission.Claims{
+		StandardClaims: crypto.StandardClaims{
+			Audience: consts.AccessTokenAudience,
+			Issuer:   testInstance.Domain,
+			IssuedAt: time.Now().Add(-consts.AccessTokenValidityDuration - time.Minute).Unix(),
+			Subject:  client.CouchID,
+		},
+		Scope: "io.cozy.files",
+	})
+	assert.NoError(t, err)
+	_, _, err = oauth.IntrospectToken(testInstance, client, old)
+	assert.Equal(t, oauth.ErrInvalidToken, err)
+
+	// The records are purged with the revoked tokens, and deleted with the client
+	kept, err := client.CreateJWT(testInstance, consts.RefreshTokenAudience, "io.cozy.files")
+	assert.NoError(t, err)
+	n, err := oauth.PurgeRefreshTokens(testInstance)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, n, 1)
+	_, err = client.ValidToken(testInstance, consts.RefreshTokenAudience, kept)
+	assert.NoError(t, err)
+	assert.Nil(t, client.Delete(testInstance))
+	_, err = client.ValidToken(testInstance, consts.RefreshTokenAudience, kept)
+	assert.Equal(t, oauth.ErrInvalidToken, err)
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	setup := testutils.NewSetup(m, "oauth_client")
@@ -0,0 +1,69 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+)
+
+// Proof Key for Code Exchange (PKCE) is used to protect the authorization
+// code flow for the public clients, that can't keep a client_secret.
+// See https://tools.ietf.org/html/rfc7636
+
+// ChallengeMethodS256 is the only code_challenge_method accepted by the stack.
+// The "plain" method does not protect the code if the authorization request
+// is intercepted, so it is rejected.
+const ChallengeMethodS256 = "S256"
+
+var (
+	// ErrInvalidChallengeMethod is used when the code_challenge_method is not S256
+	ErrInvalidChallengeMethod = errors.New("invalid code_challenge_method")
+	// ErrInvalidChallenge is used when the code_challenge is not a base64url
+	// encoded SHA-256 hash
+	ErrInvalidChallenge = errors.New("invalid code_challenge")
+)
+
+// CheckCodeChallenge returns an error if the code_challenge and
+// code_challenge_method parameters from an authorize request are not
+// acceptable. As the method defaults to plain when it is absent, it must be
+// given with the challenge.
+func CheckCodeChallenge(challenge, method string) error {
+	if method != ChallengeMethodS256 {
+		return ErrInvalidChallengeMethod
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(decoded) != sha256.Size {
+		return ErrInvalidChallenge
+	}
+	return nil
+}
+
+// VerifyCodeChallenge returns true if the code_verifier sent to the token
+// endpoint matches the code_challenge of the access code.
+func VerifyCodeChallenge(challenge, verifier string) bool {
+	if challenge == "" || !validCodeVerifier(verifier) {
+		return false
+	}
+	hash := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// validCodeVerifier checks that the code_verifier has between 43 and 128
+// characters from the unreserved set [A-Z] / [a-z] / [0-9] / "-" / "." / "_"
+// / "~".
+func validCodeVerifier(verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
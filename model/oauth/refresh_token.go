@@ -0,0 +1,269 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	// ErrUnsupportedTokenType is used when a client asks to revoke a token
+	// that can't be revoked individually, like an access token (they are short
+	// lived) or a refresh token issued before the records were introduced.
+	ErrUnsupportedTokenType = errors.New("unsupported_token_type")
+	// ErrTokenNotOwned is used when a client asks to revoke a token that was
+	// issued to another client.
+	ErrTokenNotOwned = errors.New("unauthorized_client")
+	// ErrInvalidToken is used when a token is malformed, expired, revoked, or
+	// was not issued for the expected audience, client or instance.
+	ErrInvalidToken = errors.New("invalid_token")
+)
+
+// RefreshToken is the server-side record of a refresh token issued to an
+// OAuth client. Its identifier is the jti claim of the token, and it is kept
+// after the revocation of the token, as a denylist entry.
+type RefreshToken struct {
+	DocID     string     `json:"_id,omitempty"`
+	DocRev    string     `json:"_rev,omitempty"`
+	ClientID  string     `json:"client_id"`
+	Scope     string     `json:"scope"`
+	IssuedAt  time.Time  `json:"issued_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// ID returns the refresh token qualified identifier
+func (rt *RefreshToken) ID() string { return rt.DocID }
+
+// Rev returns the refresh token revision
+func (rt *RefreshToken) Rev() string { return rt.DocRev }
+
+// DocType returns the refresh token document type
+func (rt *RefreshToken) DocType() string { return consts.OAuthRefreshTokens }
+
+// Clone implements couchdb.Doc
+func (rt *RefreshToken) Clone() couchdb.Doc {
+	cloned := *rt
+	if rt.RevokedAt != nil {
+		revoked := *rt.RevokedAt
+		cloned.RevokedAt = &revoked
+	}
+	return &cloned
+}
+
+// SetID changes the refresh token qualified identifier
+func (rt *RefreshToken) SetID(id string) { rt.DocID = id }
+
+// SetRev changes the refresh token revision
+func (rt *RefreshToken) SetRev(rev string) { rt.DocRev = rev }
+
+// Revoked returns true if the refresh token has been revoked
+func (rt *RefreshToken) Revoked() bool { return rt.RevokedAt != nil }
+
+// RecordRefreshToken persists a record for a new refresh token of the given
+// client, and returns the identifier to use as the jti claim of the token.
+func RecordRefreshToken(i *instance.Instance, clientID, scope string) (string, error) {
+	rt := &RefreshToken{
+		ClientID: clientID,
+		Scope:    scope,
+		IssuedAt: time.Now().UTC(),
+	}
+	if err := couchdb.CreateDoc(i, rt); err != nil {
+		return "", err
+	}
+	return rt.DocID, nil
+}
+
+// refreshTokenRevoked checks the denylist for the given jti. A refresh token
+// without record is considered as revoked, but an error while fetching the
+// record is returned to the caller, as it may be transient.
+func refreshTokenRevoked(i *instance.Instance, jti string) (bool, error) {
+	rt := &RefreshToken{}
+	if err := couchdb.GetDoc(i, consts.OAuthRefreshTokens, jti, rt); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return true, nil
+		}
+		i.Logger().WithNamespace("oauth").
+			Errorf("Failed to get the refresh token %s: %s", jti, err)
+		return false, err
+	}
+	return rt.Revoked(), nil
+}
+
+// DeleteRefreshTokens deletes the records of the refresh tokens issued to the
+// given client, as they are useless once the client has been deleted.
+func DeleteRefreshTokens(i *instance.Instance, clientID string) error {
+	req := &couchdb.FindRequest{
+		UseIndex: "by-client-id",
+		Selector: mango.Equal("client_id", clientID),
+		Limit:    1000,
+	}
+	for {
+		var tokens []*RefreshToken
+		err := couchdb.FindDocs(i, consts.OAuthRefreshTokens, req, &tokens)
+		if err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				return nil
+			}
+			return err
+		}
+		if len(tokens) == 0 {
+			return nil
+		}
+		docs := make([]couchdb.Doc, len(tokens))
+		for j, rt := range tokens {
+			docs[j] = rt
+		}
+		if err := couchdb.BulkDeleteDocs(i, consts.OAuthRefreshTokens, docs); err != nil {
+			return err
+		}
+		if len(tokens) < req.Limit {
+			return nil
+		}
+	}
+}
+
+// PurgeRefreshTokens deletes the records of the revoked refresh tokens, and of
+// the refresh tokens issued to a client that no longer exists. It returns the
+// number of deleted records.
+func PurgeRefreshTokens(i *instance.Instance) (int, error) {
+	var toDelete []couchdb.Doc
+	clients := make(map[string]bool)
+	err := couchdb.ForeachDocs(i, consts.OAuthRefreshTokens, func(_ string, data json.RawMessage) error {
+		rt := &RefreshToken{}
+		if err := json.Unmarshal(data, rt); err != nil {
+			return err
+		}
+		if rt.Revoked() {
+			toDelete = append(toDelete, rt)
+			return nil
+		}
+		exists, ok := clients[rt.ClientID]
+		if !ok {
+			_, err := FindClient(i, rt.ClientID)
+			if err != nil && !couchdb.IsNotFoundError(err) {
+				return err
+			}
+			exists = err == nil
+			clients[rt.ClientID] = exists
+		}
+		if !exists {
+			toDelete = append(toDelete, rt)
+		}
+		return nil
+	})
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if err := couchdb.BulkDeleteDocs(i, consts.OAuthRefreshTokens, toDelete); err != nil {
+		return 0, err
+	}
+	return len(toDelete), nil
+}
+
+// parseClaims checks the signature of the JWT and returns its claims, without
+// checking the audience.
+func parseClaims(i *instance.Instance, token string) (permission.Claims, bool) {
+	claims := permission.Claims{}
+	if token == "" {
+		return claims, false
+	}
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		return i.OAuthSecret, nil
+	}
+	if err := crypto.ParseJWT(token, keyFunc, &claims); err != nil {
+		return claims, false
+	}
+	return claims, true
+}
+
+// RevokeToken revokes a refresh token issued to the given client. An invalid
+// token is ignored, as explained by the RFC 7009.
+// See https://tools.ietf.org/html/rfc7009#section-2.2
+func (c *Client) RevokeToken(i *instance.Instance, token string) error {
+	claims, ok := parseClaims(i, token)
+	if !ok || claims.Issuer != i.Domain {
+		return nil
+	}
+	switch claims.Audience {
+	case consts.RefreshTokenAudience:
+	case consts.AccessTokenAudience:
+		return ErrUnsupportedTokenType
+	default:
+		return nil
+	}
+	if claims.Subject != c.CouchID {
+		return ErrTokenNotOwned
+	}
+	if claims.ID == "" {
+		return ErrUnsupportedTokenType
+	}
+
+	rt := &RefreshToken{}
+	if err := couchdb.GetDoc(i, consts.OAuthRefreshTokens, claims.ID, rt); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	if rt.Revoked() {
+		return nil
+	}
+	now := time.Now().UTC()
+	rt.RevokedAt = &now
+	return couchdb.UpdateDoc(i, rt)
+}
+
+// IntrospectToken returns the claims of an access or refresh token issued to
+// an OAuth client of the instance, and the client, if the token is active.
+// The caller can only introspect its own tokens, unless it has been allowed
+// to introspect the tokens of the other clients. ErrInvalidToken is returned
+// for a token that is not active for the caller.
+// See https://tools.ietf.org/html/rfc7662
+func IntrospectToken(i *instance.Instance, caller *Client, token string) (*Client, *permission.Claims, error) {
+	claims, ok := parseClaims(i, token)
+	if !ok {
+		return nil, nil, ErrInvalidToken
+	}
+	switch claims.Audience {
+	case consts.AccessTokenAudience, consts.RefreshTokenAudience:
+	default:
+		return nil, nil, ErrInvalidToken
+	}
+	if claims.Subject != caller.CouchID && !caller.AllowIntrospection {
+		return nil, nil, ErrInvalidToken
+	}
+
+	// The access tokens issued more than AccessTokenValidityDuration ago are
+	// rejected by validToken, via claims.Expired(), even without an exp claim.
+	// And the tokens for the bitwarden clients have a security stamp.
+	var err error
+	if claims.SStamp != "" {
+		claims, err = ValidTokenWithSStamp(i, claims.Audience, token)
+	} else {
+		claims, err = validToken(i, claims.Audience, token)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := FindClient(i, claims.Subject)
+	if err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, err
+	}
+	return client, &claims, nil
+}
+
+var _ couchdb.Doc = &RefreshToken{}
@@ -0,0 +1,222 @@
+package permission
+
+import "sort"
+
+// SetDiff is a readable description of the differences between the
+// permissions of two versions of an application. It is shown to the user
+// when a new version asks for more permissions.
+type SetDiff struct {
+	// AddedDoctypes are the doctypes that were not requested before
+	AddedDoctypes []string `json:"added_doctypes,omitempty"`
+	// RemovedDoctypes are the doctypes that are no longer requested
+	RemovedDoctypes []string `json:"removed_doctypes,omitempty"`
+	// WidenedVerbs are the new verbs for the doctypes already requested
+	WidenedVerbs []VerbsChange `json:"widened_verbs,omitempty"`
+	// NewSelectors are the new documents that can be accessed for the
+	// doctypes already requested
+	NewSelectors []SelectorChange `json:"new_selectors,omitempty"`
+}
+
+// VerbsChange is a list of verbs added on a doctype, for the documents that
+// were already accessible. Without selector nor values, the verbs are added
+// on all the documents of the doctype, else only on the documents matching
+// the selector and values.
+type VerbsChange struct {
+	Type     string   `json:"type"`
+	Selector string   `json:"selector,omitempty"`
+	Values   []string `json:"values,omitempty"`
+	Verbs    VerbSet  `json:"verbs"`
+}
+
+// SelectorChange is a list of values added for a selector on a doctype. An
+// empty selector without values means that all the documents of the doctype
+// can now be accessed.
+type SelectorChange struct {
+	Type     string   `json:"type"`
+	Selector string   `json:"selector,omitempty"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// IsEmpty returns true if the new permissions do not differ from the old
+// ones.
+func (d *SetDiff) IsEmpty() bool {
+	return len(d.AddedDoctypes) == 0 && len(d.RemovedDoctypes) == 0 &&
+		len(d.WidenedVerbs) == 0 && len(d.NewSelectors) == 0
+}
+
+// Widened returns true if the new permissions give access to more things
+// than the old ones.
+func (d *SetDiff) Widened() bool {
+	return len(d.AddedDoctypes) > 0 || len(d.WidenedVerbs) > 0 || len(d.NewSelectors) > 0
+}
+
+// Compare computes the differences between the old and the new sets of
+// permissions, doctype by doctype. The verbs are compared per scope (all the
+// documents of the doctype, or a value for a selector), so that the verbs
+// given on a restricted scope are not seen as given on a wider one.
+func Compare(oldSet, newSet Set) *SetDiff {
+	diff := &SetDiff{}
+	oldRules := rulesByType(oldSet)
+	newRules := rulesByType(newSet)
+
+	for _, typ := range sortedTypes(newRules) {
+		olds, ok := oldRules[typ]
+		if !ok {
+			diff.AddedDoctypes = append(diff.AddedDoctypes, typ)
+			continue
+		}
+		for _, r := range newRules[typ] {
+			diff.compareRule(olds, r)
+		}
+	}
+	for _, typ := range sortedTypes(oldRules) {
+		if _, ok := newRules[typ]; !ok {
+			diff.RemovedDoctypes = append(diff.RemovedDoctypes, typ)
+		}
+	}
+	return diff
+}
+
+// compareRule adds to the diff the verbs and the documents given by the new
+// rule that were not given by the old rules of the same doctype.
+func (d *SetDiff) compareRule(olds []Rule, r Rule) {
+	if isWholeType(r) {
+		oldVerbs, ok := scopeVerbs(olds, "", "")
+		if !ok {
+			d.addSelector(SelectorChange{Type: r.Type})
+		} else if verbs := addedVerbs(oldVerbs, r.Verbs); len(verbs) > 0 {
+			d.addVerbs(VerbsChange{Type: r.Type, Verbs: verbs})
+		}
+		return
+	}
+
+	var values []string
+	for _, val := range r.Values {
+		oldVerbs, ok := scopeVerbs(olds, r.Selector, val)
+		if !ok {
+			values = append(values, val)
+		} else if verbs := addedVerbs(oldVerbs, r.Verbs); len(verbs) > 0 {
+			d.addVerbs(VerbsChange{
+				Type:     r.Type,
+				Selector: r.Selector,
+				Values:   []string{val},
+				Verbs:    verbs,
+			})
+		}
+	}
+	if len(values) > 0 {
+		d.addSelector(SelectorChange{
+			Type:     r.Type,
+			Selector: r.Selector,
+			Values:   values,
+		})
+	}
+}
+
+// addVerbs adds a change of verbs to the diff, merged with a previous change
+// for the same scope and verbs.
+func (d *SetDiff) addVerbs(change VerbsChange) {
+	for i, c := range d.WidenedVerbs {
+		if c.Type != change.Type || c.Selector != change.Selector {
+			continue
+		}
+		if (len(c.Values) == 0) != (len(change.Values) == 0) {
+			continue
+		}
+		if len(c.Values) == 0 {
+			d.WidenedVerbs[i].Verbs.Merge(&change.Verbs)
+			return
+		}
+		if sameVerbs(c.Verbs, change.Verbs) {
+			for _, val := range change.Values {
+				if !contains(c.Values, val) {
+					d.WidenedVerbs[i].Values = append(d.WidenedVerbs[i].Values, val)
+				}
+			}
+			return
+		}
+	}
+	d.WidenedVerbs = append(d.WidenedVerbs, change)
+}
+
+// addSelector adds a change of selector to the diff, unless all the
+// documents of the doctype are already in the diff.
+func (d *SetDiff) addSelector(change SelectorChange) {
+	for i, c := range d.NewSelectors {
+		if c.Type != change.Type {
+			continue
+		}
+		if c.Selector == "" && len(c.Values) == 0 {
+			return
+		}
+		if len(change.Values) == 0 {
+			d.NewSelectors[i] = change
+			return
+		}
+	}
+	d.NewSelectors = append(d.NewSelectors, change)
+}
+
+func rulesByType(set Set) map[string][]Rule {
+	rules := make(map[string][]Rule)
+	for _, r := range set {
+		rules[r.Type] = append(rules[r.Type], r)
+	}
+	return rules
+}
+
+func sortedTypes(rules map[string][]Rule) []string {
+	types := make([]string, 0, len(rules))
+	for typ := range rules {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func isWholeType(r Rule) bool {
+	return r.Selector == "" && len(r.Values) == 0
+}
+
+func ruleVerbs(r Rule) VerbSet {
+	if len(r.Verbs) == 0 {
+		return ALL
+	}
+	return r.Verbs
+}
+
+// scopeVerbs returns the verbs given by the rules on the documents matching
+// the selector and value (or on all the documents of the doctype if value is
+// empty), and false if these documents were not accessible at all.
+func scopeVerbs(rules []Rule, selector, value string) (VerbSet, bool) {
+	verbs := VerbSet{}
+	found := false
+	for _, r := range rules {
+		matching := isWholeType(r) ||
+			(value != "" && r.Selector == selector && contains(r.Values, value))
+		if !matching {
+			continue
+		}
+		found = true
+		rv := ruleVerbs(r)
+		verbs.Merge(&rv)
+	}
+	return verbs, found
+}
+
+func addedVerbs(oldVerbs, newVerbs VerbSet) VerbSet {
+	if len(newVerbs) == 0 {
+		newVerbs = ALL
+	}
+	added := VerbSet{}
+	for v := range newVerbs {
+		if !oldVerbs.Contains(v) {
+			added[v] = struct{}{}
+		}
+	}
+	return added
+}
+
+func sameVerbs(a, b VerbSet) bool {
+	return a.ContainsAll(b) && b.ContainsAll(a)
+}
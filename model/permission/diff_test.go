@@ -0,0 +1,149 @@
+package permission
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareSameSets(t *testing.T) {
+	set1 := Set{
+		Rule{Title: "files", Type: "io.cozy.files", Verbs: Verbs(GET)},
+	}
+	set2 := Set{
+		Rule{Title: "other", Type: "io.cozy.files", Verbs: Verbs(GET)},
+	}
+	diff := Compare(set1, set2)
+	assert.True(t, diff.IsEmpty())
+	assert.False(t, diff.Widened())
+}
+
+func TestCompareDoctypes(t *testing.T) {
+	set1 := Set{
+		Rule{Title: "files", Type: "io.cozy.files", Verbs: Verbs(GET)},
+		Rule{Title: "contacts", Type: "io.cozy.contacts", Verbs: Verbs(GET)},
+	}
+	set2 := Set{
+		Rule{Title: "files", Type: "io.cozy.files", Verbs: Verbs(GET)},
+		Rule{Title: "bills", Type: "io.cozy.bills"},
+		Rule{Title: "accounts", Type: "io.cozy.accounts", Verbs: Verbs(GET)},
+	}
+	diff := Compare(set1, set2)
+	assert.Equal(t, []string{"io.cozy.accounts", "io.cozy.bills"}, diff.AddedDoctypes)
+	assert.Equal(t, []string{"io.cozy.contacts"}, diff.RemovedDoctypes)
+	assert.Empty(t, diff.WidenedVerbs)
+	assert.Empty(t, diff.NewSelectors)
+	assert.True(t, diff.Widened())
+}
+
+func TestCompareVerbs(t *testing.T) {
+	set1 := Set{
+		Rule{Title: "files", Type: "io.cozy.files", Verbs: Verbs(GET)},
+		Rule{Title: "settings", Type: "io.cozy.settings", Verbs: Verbs(GET, PUT)},
+		Rule{Title: "notes", Type: "io.cozy.notes"},
+	}
+	set2 := Set{
+		Rule{Title: "files", Type: "io.cozy.files", Verbs: Verbs(GET, POST)},
+		Rule{Title: "settings", Type: "io.cozy.settings"},
+		Rule{Title: "notes", Type: "io.cozy.notes", Verbs: Verbs(GET)},
+	}
+	diff := Compare(set1, set2)
+	assert.Empty(t, diff.AddedDoctypes)
+	assert.Empty(t, diff.RemovedDoctypes)
+	assert.Equal(t, []VerbsChange{
+		{Type: "io.cozy.files", Verbs: Verbs(POST)},
+		{Type: "io.cozy.settings", Verbs: Verbs(POST, PATCH, DELETE)},
+	}, diff.WidenedVerbs)
+	assert.True(t, diff.Widened())
+}
+
+func TestCompareSelectors(t *testing.T) {
+	set1 := Set{
+		Rule{
+			Title:  "files",
+			Type:   "io.cozy.files",
+			Verbs:  Verbs(GET),
+			Values: []string{"io.cozy.files.music-dir"},
+		},
+		Rule{
+			Title:    "contacts",
+			Type:     "io.cozy.contacts",
+			Verbs:    Verbs(GET),
+			Selector: "groups",
+			Values:   []string{"friends"},
+		},
+	}
+	set2 := Set{
+		Rule{
+			Title:  "files",
+			Type:   "io.cozy.files",
+			Verbs:  Verbs(GET),
+			Values: []string{"io.cozy.files.music-dir", "io.cozy.files.root-dir"},
+		},
+		Rule{
+			Title: "contacts",
+			Type:  "io.cozy.contacts",
+			Verbs: Verbs(GET),
+		},
+	}
+	diff := Compare(set1, set2)
+	assert.Empty(t, diff.WidenedVerbs)
+	assert.Equal(t, []SelectorChange{
+		{Type: "io.cozy.contacts"},
+		{Type: "io.cozy.files", Values: []string{"io.cozy.files.root-dir"}},
+	}, diff.NewSelectors)
+
+	// Restricting the access is not a widening
+	diff = Compare(set2, set1)
+	assert.Empty(t, diff.NewSelectors)
+	assert.False(t, diff.Widened())
+}
+
+func TestCompareVerbsPerScope(t *testing.T) {
+	// The verbs given on a directory are not given on all the files
+	set1 := Set{
+		Rule{Title: "files", Type: "io.cozy.files", Verbs: Verbs(GET)},
+		Rule{
+			Title:    "photos",
+			Type:     "io.cozy.files",
+			Selector: "dir_id",
+			Values:   []string{"photos-dir"},
+		},
+	}
+	set2 := Set{
+		Rule{Title: "files", Type: "io.cozy.files"},
+	}
+	diff := Compare(set1, set2)
+	assert.Equal(t, []VerbsChange{
+		{Type: "io.cozy.files", Verbs: Verbs(POST, PUT, PATCH, DELETE)},
+	}, diff.WidenedVerbs)
+	assert.Empty(t, diff.NewSelectors)
+	assert.True(t, diff.Widened())
+
+	// The verbs are widened only for the documents of the selector
+	set3 := Set{
+		Rule{Title: "files", Type: "io.cozy.files", Verbs: Verbs(GET)},
+		Rule{
+			Title:    "photos",
+			Type:     "io.cozy.files",
+			Verbs:    Verbs(GET, POST),
+			Selector: "dir_id",
+			Values:   []string{"photos-dir", "music-dir"},
+		},
+	}
+	diff = Compare(set1, set3)
+	assert.Equal(t, []VerbsChange{
+		{
+			Type:     "io.cozy.files",
+			Selector: "dir_id",
+			Values:   []string{"music-dir"},
+			Verbs:    Verbs(POST),
+		},
+	}, diff.WidenedVerbs)
+	assert.Empty(t, diff.NewSelectors)
+
+	// Restricting the verbs on a directory is not a widening
+	diff = Compare(set3, set3[:1])
+	assert.Empty(t, diff.WidenedVerbs)
+	assert.False(t, diff.Widened())
+}
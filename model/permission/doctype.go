@@ -19,16 +19,19 @@ var blockList = map[string]bool{
 	consts.AccountTypes:          none,
 	consts.KonnectorsMaintenance: none,
 	consts.RemoteSecrets:         none,
+	consts.RegistryVersions:      none,
 
 	// Only stack can manipulate them
-	consts.Sessions:         none,
-	consts.Permissions:      none,
-	consts.Intents:          none,
-	consts.OAuthClients:     none,
-	consts.OAuthAccessCodes: none,
-	consts.Archives:         none,
-	consts.Sharings:         none,
-	consts.Shared:           none,
+	consts.Sessions:            none,
+	consts.Permissions:         none,
+	consts.Intents:             none,
+	consts.OAuthClients:        none,
+	consts.OAuthAccessCodes:    none,
+	consts.OAuthRefreshTokens:  none,
+	consts.Archives:            none,
+	consts.Sharings:            none,
+	consts.Shared:              none,
+	consts.WebAuthnCredentials: none,
 
 	// Synthetic doctypes (API only)
 	consts.CertifiedCarbonCopy:     none,
@@ -62,6 +65,11 @@ var blockList = map[string]bool{
 	consts.NotesSteps:        readable,
 	consts.NotesImages:       readable,
 	consts.BitwardenContacts: readable,
+	consts.WebhookDeliveries: readable,
+	consts.JobLogs:           readable,
+	consts.AppsVersions:      readable,
+
+	consts.AppsServiceViolations: readable,
 }
 
 // CheckReadable will abort the context and returns false if the doctype
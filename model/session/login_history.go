@@ -4,7 +4,6 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"strings"
 	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
@@ -112,43 +111,65 @@ func lookupIP(ip, locale string) (city, subdivision, country, timezone string) {
 	return
 }
 
-// StoreNewLoginEntry creates a new login entry in the database associated with
-// the given instance.
-func StoreNewLoginEntry(i *instance.Instance, sessionID, clientID string,
-	req *http.Request, logMessage string, notifEnabled bool,
-) error {
-	var ip string
-	if forwardedFor := req.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		ip = strings.TrimSpace(strings.SplitN(forwardedFor, ",", 2)[0])
-	}
-	if ip == "" {
-		ip = strings.Split(req.RemoteAddr, ":")[0]
-	}
+// clientInfo is the information about the device and the coarse location of
+// the client that has made an HTTP request.
+type clientInfo struct {
+	IP          string
+	City        string
+	Subdivision string
+	Country     string
+	Timezone    string
+	UA          string
+	OS          string
+	Browser     string
+}
 
-	city, subdivision, country, timezone := lookupIP(ip, i.Locale)
+// getClientInfo parses the user-agent of the HTTP request, and looks for the
+// location of the IP address of the client in the GeoIP database (if one has
+// been configured). The IP address is given by the caller, as it depends on
+// the trusted reverse proxies.
+func getClientInfo(req *http.Request, ip, locale string) clientInfo {
+	city, subdivision, country, timezone := lookupIP(ip, locale)
 	ua := user_agent.New(req.UserAgent())
-
 	browser, _ := ua.Browser()
-	os := ua.OS()
+
+	return clientInfo{
+		IP:          ip,
+		City:        city,
+		Subdivision: subdivision,
+		Country:     country,
+		Timezone:    timezone,
+		UA:          req.UserAgent(),
+		OS:          ua.OS(),
+		Browser:     browser,
+	}
+}
+
+// StoreNewLoginEntry creates a new login entry in the database associated with
+// the given instance. The ip parameter is the IP address of the client.
+func StoreNewLoginEntry(i *instance.Instance, sessionID, clientID string,
+	req *http.Request, ip, logMessage string, notifEnabled bool,
+) error {
+	info := getClientInfo(req, ip, i.Locale)
 
 	createdAt := time.Now()
 	i.Logger().WithNamespace("loginaudit").
-		Infof("New connection from %s at %s (%s)", ip, createdAt, logMessage)
-	if timezone != "" {
-		if loc, err := time.LoadLocation(timezone); err == nil {
+		Infof("New connection from %s at %s (%s)", info.IP, createdAt, logMessage)
+	if info.Timezone != "" {
+		if loc, err := time.LoadLocation(info.Timezone); err == nil {
 			createdAt = createdAt.In(loc)
 		}
 	}
 
 	l := &LoginEntry{
-		IP:                 ip,
+		IP:                 info.IP,
 		SessionID:          sessionID,
-		City:               city,
-		Subdivision:        subdivision,
-		Country:            country,
-		UA:                 req.UserAgent(),
-		OS:                 os,
-		Browser:            browser,
+		City:               info.City,
+		Subdivision:        info.Subdivision,
+		Country:            info.Country,
+		UA:                 info.UA,
+		OS:                 info.OS,
+		Browser:            info.Browser,
 		ClientRegistration: clientID != "",
 		CreatedAt:          createdAt,
 	}
@@ -170,22 +191,32 @@ func StoreNewLoginEntry(i *instance.Instance, sessionID, clientID string,
 	return nil
 }
 
-func sendLoginNotification(i *instance.Instance, l *LoginEntry) error {
+// hasPreviousLogin returns true if another login entry matches the given
+// filters.
+func hasPreviousLogin(i *instance.Instance, l *LoginEntry, index string, filters ...mango.Filter) bool {
 	var results []*LoginEntry
+	filters = append(filters, mango.NotEqual("_id", l.ID()))
 	r := &couchdb.FindRequest{
-		UseIndex: "by-os-browser-ip",
-		Selector: mango.And(
-			mango.Equal("os", l.OS),
-			mango.Equal("browser", l.Browser),
-			mango.Equal("ip", l.IP),
-			mango.NotEqual("_id", l.ID()),
-		),
-		Limit: 1,
+		UseIndex: index,
+		Selector: mango.And(filters...),
+		Limit:    1,
 	}
 	err := couchdb.FindDocs(i, consts.SessionsLogins, r, &results)
-	sendNotification := err != nil || len(results) == 0
-	if !sendNotification {
-		return nil
+	return err == nil && len(results) > 0
+}
+
+// sendLoginNotification sends a mail to the user when the login comes from an
+// unknown device (OS and browser), or from a known device but with a new IP
+// address.
+func sendLoginNotification(i *instance.Instance, l *LoginEntry) error {
+	os := mango.Equal("os", l.OS)
+	browser := mango.Equal("browser", l.Browser)
+	knownDevice := hasPreviousLogin(i, l, "by-os-browser", os, browser)
+	if knownDevice {
+		ip := mango.Equal("ip", l.IP)
+		if hasPreviousLogin(i, l, "by-os-browser-ip", os, browser, ip) {
+			return nil
+		}
 	}
 
 	var changePassphraseLink string
@@ -193,7 +224,7 @@ func sendLoginNotification(i *instance.Instance, l *LoginEntry) error {
 		changePassphraseLink = i.ChangePasswordURL()
 	}
 	var activateTwoFALink string
-	if !i.HasAuthMode(instance.TwoFactorMail) {
+	if !i.HasTwoFactor() {
 		settingsURL := i.SubDomain(consts.SettingsSlug)
 		settingsURL.Fragment = "/profile"
 		activateTwoFALink = settingsURL.String()
@@ -212,8 +243,17 @@ func sendLoginNotification(i *instance.Instance, l *LoginEntry) error {
 		"ActivateTwoFALink":    activateTwoFALink,
 	}
 
+	templateName := "new_connection"
+	if !knownDevice {
+		templateName = "new_device"
+		sessionsLink := i.SubDomain(consts.SettingsSlug)
+		sessionsLink.Fragment = "/sessions"
+		templateValues["City"] = l.City
+		templateValues["SessionsLink"] = sessionsLink.String()
+	}
+
 	return lifecycle.SendMail(i, &lifecycle.Mail{
-		TemplateName:   "new_connection",
+		TemplateName:   templateName,
 		TemplateValues: templateValues,
 	})
 }
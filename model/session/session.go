@@ -40,6 +40,15 @@ type Session struct {
 	CreatedAt time.Time `json:"created_at"`
 	LastSeen  time.Time `json:"last_seen"`
 	LongRun   bool      `json:"long_run"`
+
+	// Device and coarse location of the client that has opened the session
+	IP          string `json:"ip,omitempty"`
+	City        string `json:"city,omitempty"`
+	Subdivision string `json:"subdivision,omitempty"`
+	Country     string `json:"country,omitempty"`
+	UA          string `json:"user_agent,omitempty"`
+	OS          string `json:"os,omitempty"`
+	Browser     string `json:"browser,omitempty"`
 }
 
 // DocType implements couchdb.Doc
@@ -75,8 +84,9 @@ func (s *Session) OlderThan(t time.Duration) bool {
 	return time.Now().After(s.LastSeen.Add(t))
 }
 
-// New creates a session in couchdb for the given instance
-func New(i *instance.Instance, longRun bool) (*Session, error) {
+// New creates a session in couchdb for the given instance. The request, if
+// not nil, is used to record the device and location of the client.
+func New(i *instance.Instance, longRun bool, req *http.Request, ip string) (*Session, error) {
 	now := time.Now()
 	s := &Session{
 		instance:  i,
@@ -84,6 +94,16 @@ func New(i *instance.Instance, longRun bool) (*Session, error) {
 		CreatedAt: now,
 		LongRun:   longRun,
 	}
+	if req != nil {
+		info := getClientInfo(req, ip, i.Locale)
+		s.IP = info.IP
+		s.City = info.City
+		s.Subdivision = info.Subdivision
+		s.Country = info.Country
+		s.UA = info.UA
+		s.OS = info.OS
+		s.Browser = info.Browser
+	}
 	if err := couchdb.CreateDoc(i, s); err != nil {
 		return nil, err
 	}
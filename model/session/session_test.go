@@ -2,11 +2,13 @@ package session
 
 import (
 	"encoding/base64"
+	"net/http/httptest"
 	"os"
 	"testing"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/stretchr/testify/assert"
 )
 
 var JWTSecret = []byte("foobar")
@@ -22,3 +24,16 @@ func TestMain(m *testing.M) {
 	delegatedInst = &instance.Instance{Domain: "external.notmycozy.net"}
 	os.Exit(m.Run())
 }
+
+func TestGetClientInfo(t *testing.T) {
+	req := httptest.NewRequest("GET", "/auth/login", nil)
+	req.RemoteAddr = "192.0.2.1:34567"
+	req.Header.Set("X-Forwarded-For", "203.0.113.42, 192.0.2.1")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/110.0")
+
+	info := getClientInfo(req, "192.0.2.1", "en")
+	assert.Equal(t, "192.0.2.1", info.IP)
+	assert.Equal(t, "Firefox", info.Browser)
+	assert.Equal(t, "Linux x86_64", info.OS)
+	assert.Empty(t, info.Country)
+}
@@ -82,6 +82,12 @@ func (s *Sharing) Replicate(inst *instance.Instance, errors int) error {
 
 // pushJob adds a new job to continue on the pending documents in the changes feed
 func (s *Sharing) pushJob(inst *instance.Instance, worker string) {
+	s.pushJobWithPriority(inst, worker, "")
+}
+
+// pushJobWithPriority is like pushJob, but the job is enqueued with the given
+// priority (the default priority of the worker if empty).
+func (s *Sharing) pushJobWithPriority(inst *instance.Instance, worker string, priority job.Priority) {
 	inst.Logger().WithNamespace("replicator").
 		Debugf("Push a new job for worker %s for sharing %s", worker, s.SID)
 	msg, err := job.NewMessage(&ReplicateMsg{
@@ -96,6 +102,7 @@ func (s *Sharing) pushJob(inst *instance.Instance, worker string) {
 	_, err = job.System().PushJob(inst, &job.JobRequest{
 		WorkerType: worker,
 		Message:    msg,
+		Priority:   priority,
 	})
 	if err != nil {
 		inst.Logger().WithNamespace("replicator").
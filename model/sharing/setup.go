@@ -114,8 +114,10 @@ func (s *Sharing) Setup(inst *instance.Instance, m *Member) {
 			Warnf("Error on initial replication (%s): %s", s.SID, err)
 		s.retryWorker(inst, "share-replicate", 0)
 	} else {
+		// The user has just accepted the sharing and is waiting for the
+		// documents, so the job is not enqueued behind the bulk jobs.
 		if pending {
-			s.pushJob(inst, "share-replicate")
+			s.pushJobWithPriority(inst, "share-replicate", job.PriorityHigh)
 		}
 		if s.FirstFilesRule() == nil {
 			return
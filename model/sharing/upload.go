@@ -12,6 +12,7 @@ import (
 
 	"github.com/cozy/cozy-stack/client/request"
 	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -92,7 +93,7 @@ func (s *Sharing) InitialUpload(inst *instance.Instance, m *Member) error {
 		}
 	}
 
-	s.pushJob(inst, "share-upload")
+	s.pushJobWithPriority(inst, "share-upload", job.PriorityHigh)
 	return nil
 }
 
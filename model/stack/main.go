@@ -6,6 +6,8 @@ import (
 	"os"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/catalog"
 	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/session"
 	"github.com/cozy/cozy-stack/pkg/assets/dynamic"
@@ -127,9 +129,18 @@ security features. Please do not use this binary as your production server.
 		}
 	}
 
+	// Initialize the storage for the tarballs of the registry built in the
+	// stack
+	if err = catalog.InitStorage(); err != nil {
+		return nil, err
+	}
+
 	sessionSweeper := session.SweepLoginRegistrations()
 	shutdowners = append(shutdowners, sessionSweeper)
 
+	maintenanceSyncer := app.SyncMaintenances()
+	shutdowners = append(shutdowners, maintenanceSyncer)
+
 	// Global shutdowner that composes all the running processes of the stack
 	processes = utils.NewGroupShutdown(shutdowners...)
 	return
@@ -0,0 +1,138 @@
+package webauthn
+
+import (
+	"context"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// PurposeRegistration is used for the challenges of the registration of a
+	// new credential.
+	PurposeRegistration = "registration"
+	// PurposeLogin is used for the challenges of an authentication with a
+	// credential.
+	PurposeLogin = "login"
+)
+
+// Store is essentially an object to store and retrieve the challenges sent to
+// the browsers for the WebAuthn ceremonies.
+type Store interface {
+	AddChallenge(db prefixer.Prefixer, purpose string) (string, error)
+	CheckChallenge(db prefixer.Prefixer, purpose, challenge string) (bool, error)
+}
+
+// storeTTL is the time an entry stay alive
+var storeTTL = 5 * time.Minute
+
+// storeCleanInterval is the time interval between each cleanup.
+var storeCleanInterval = 1 * time.Hour
+
+var mu sync.Mutex
+var globalStore Store
+
+// GetStore returns the store for the WebAuthn challenges.
+func GetStore() Store {
+	mu.Lock()
+	defer mu.Unlock()
+	if globalStore != nil {
+		return globalStore
+	}
+	cli := config.GetConfig().SessionStorage.Client()
+	if cli == nil {
+		globalStore = newMemStore()
+	} else {
+		ctx := context.Background()
+		globalStore = &redisStore{cli, ctx}
+	}
+	return globalStore
+}
+
+type memStore struct {
+	mu   sync.Mutex
+	vals map[string]time.Time
+}
+
+func newMemStore() Store {
+	store := &memStore{vals: make(map[string]time.Time)}
+	go store.cleaner()
+	return store
+}
+
+func (s *memStore) cleaner() {
+	for range time.Tick(storeCleanInterval) {
+		now := time.Now()
+		s.mu.Lock()
+		for k, v := range s.vals {
+			if now.After(v) {
+				delete(s.vals, k)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *memStore) AddChallenge(db prefixer.Prefixer, purpose string) (string, error) {
+	challenge := makeChallenge()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := challengeKey(db, purpose, challenge)
+	s.vals[key] = time.Now().Add(storeTTL)
+	return challenge, nil
+}
+
+// CheckChallenge returns true if the challenge has been issued for this
+// purpose and is still valid. A challenge can be checked only once.
+func (s *memStore) CheckChallenge(db prefixer.Prefixer, purpose, challenge string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := challengeKey(db, purpose, challenge)
+	exp, ok := s.vals[key]
+	if !ok {
+		return false, nil
+	}
+	delete(s.vals, key)
+	return time.Now().Before(exp), nil
+}
+
+type redisStore struct {
+	c   redis.UniversalClient
+	ctx context.Context
+}
+
+func (s *redisStore) AddChallenge(db prefixer.Prefixer, purpose string) (string, error) {
+	challenge := makeChallenge()
+	key := challengeKey(db, purpose, challenge)
+	if err := s.c.Set(s.ctx, key, "1", storeTTL).Err(); err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// CheckChallenge returns true if the challenge has been issued for this
+// purpose and is still valid. A challenge can be checked only once.
+func (s *redisStore) CheckChallenge(db prefixer.Prefixer, purpose, challenge string) (bool, error) {
+	key := challengeKey(db, purpose, challenge)
+	n, err := s.c.Del(s.ctx, key).Result()
+	if err == redis.Nil || n == 0 {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func challengeKey(db prefixer.Prefixer, purpose, challenge string) string {
+	return db.DBPrefix() + ":webauthn:" + purpose + ":" + challenge
+}
+
+func makeChallenge() string {
+	return base64.RawURLEncoding.EncodeToString(crypto.GenerateRandomBytes(32))
+}
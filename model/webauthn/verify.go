@@ -0,0 +1,227 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+)
+
+// The COSE algorithms supported for the credentials.
+// https://www.iana.org/assignments/cose/cose.xhtml#algorithms
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Flags of the authenticator data.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+var cborHandle = &codec.CborHandle{
+	BasicHandle: codec.BasicHandle{
+		DecodeOptions: codec.DecodeOptions{SignedInteger: true},
+	},
+}
+
+// clientData is the JSON built by the browser and signed by the
+// authenticator.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData is the binary structure built by the authenticator.
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte // COSE encoded
+}
+
+type attestationObject struct {
+	Fmt      string      `codec:"fmt"`
+	AttStmt  interface{} `codec:"attStmt"`
+	AuthData []byte      `codec:"authData"`
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func parseClientData(raw []byte, typ string) (*clientData, error) {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrInvalidCredential
+	}
+	if data.Type != typ {
+		return nil, ErrInvalidCredential
+	}
+	return &data, nil
+}
+
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, ErrInvalidCredential
+	}
+	data := &authenticatorData{
+		RPIDHash:  raw[0:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if data.Flags&flagAttestedData == 0 {
+		return data, nil
+	}
+
+	// The attested credential data is made of the AAGUID of the
+	// authenticator (16 bytes), the length of the credential ID (2 bytes),
+	// the credential ID and the public key. It can be followed by the
+	// extensions, that we ignore.
+	if len(raw) < 55 {
+		return nil, ErrInvalidCredential
+	}
+	idLen := int(binary.BigEndian.Uint16(raw[53:55]))
+	if len(raw) < 55+idLen+1 {
+		return nil, ErrInvalidCredential
+	}
+	data.CredentialID = raw[55 : 55+idLen]
+	data.PublicKey = raw[55+idLen:]
+	return data, nil
+}
+
+func (d *authenticatorData) checkRPID(rpID string) error {
+	hash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(d.RPIDHash, hash[:]) {
+		return ErrInvalidCredential
+	}
+	return nil
+}
+
+func (d *authenticatorData) checkFlags(userVerification bool) error {
+	if d.Flags&flagUserPresent == 0 {
+		return ErrUserNotVerified
+	}
+	if userVerification && d.Flags&flagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+	return nil
+}
+
+func parseAttestationObject(raw []byte) (*authenticatorData, error) {
+	var att attestationObject
+	if err := codec.NewDecoderBytes(raw, cborHandle).Decode(&att); err != nil {
+		return nil, ErrInvalidCredential
+	}
+	// The attestation statement is not verified: we ask for no attestation,
+	// as we don't restrict the authenticators that can be used.
+	data, err := parseAuthenticatorData(att.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if data.Flags&flagAttestedData == 0 {
+		return nil, ErrInvalidCredential
+	}
+	return data, nil
+}
+
+// parseCOSEKey converts a public key in the COSE format to a public key in
+// the PKIX format, and returns it with its algorithm.
+func parseCOSEKey(raw []byte) ([]byte, int, error) {
+	var key map[int]interface{}
+	if err := codec.NewDecoderBytes(raw, cborHandle).Decode(&key); err != nil {
+		return nil, 0, ErrInvalidCredential
+	}
+	alg, _ := key[3].(int64)
+
+	var pub crypto.PublicKey
+	switch alg {
+	case AlgES256:
+		x, _ := key[-2].([]byte)
+		y, _ := key[-3].([]byte)
+		if crv, _ := key[-1].(int64); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrInvalidCredential
+		}
+		ecKey := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !ecKey.Curve.IsOnCurve(ecKey.X, ecKey.Y) {
+			return nil, 0, ErrInvalidCredential
+		}
+		pub = ecKey
+	case AlgEdDSA:
+		x, _ := key[-2].([]byte)
+		if crv, _ := key[-1].(int64); crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrInvalidCredential
+		}
+		pub = ed25519.PublicKey(x)
+	case AlgRS256:
+		n, _ := key[-1].([]byte)
+		e, _ := key[-2].([]byte)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrInvalidCredential
+		}
+		pub = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	default:
+		return nil, 0, ErrUnsupportedAlgorithm
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, 0, ErrInvalidCredential
+	}
+	return der, int(alg), nil
+}
+
+// verifySignature checks the signature of an assertion, made on the
+// authenticator data followed by the hash of the client data.
+func verifySignature(publicKey []byte, alg int, authData, clientDataJSON, sig []byte) error {
+	pub, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return ErrInvalidCredential
+	}
+	hash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), hash[:]...)
+	digest := sha256.Sum256(signed)
+
+	ok := false
+	switch alg {
+	case AlgES256:
+		if key, isEC := pub.(*ecdsa.PublicKey); isEC {
+			ok = ecdsa.VerifyASN1(key, digest[:], sig)
+		}
+	case AlgEdDSA:
+		if key, isEd := pub.(ed25519.PublicKey); isEd {
+			ok = ed25519.Verify(key, signed, sig)
+		}
+	case AlgRS256:
+		if key, isRSA := pub.(*rsa.PublicKey); isRSA {
+			ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+		}
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,442 @@
+// Package webauthn implements the registration and the authentication with
+// WebAuthn credentials (security keys and passkeys), that can be used as a
+// second factor or for a passwordless login.
+package webauthn
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+var (
+	// ErrNoCredentials is used when the instance has no registered credential
+	ErrNoCredentials = errors.New("No WebAuthn credential has been registered")
+	// ErrInvalidChallenge is used when the challenge is unknown or expired
+	ErrInvalidChallenge = errors.New("Invalid or expired WebAuthn challenge")
+	// ErrInvalidOrigin is used when the ceremony was made on another website
+	ErrInvalidOrigin = errors.New("Invalid origin for the WebAuthn credential")
+	// ErrInvalidCredential is used when the response of the authenticator
+	// can't be parsed or doesn't match the instance
+	ErrInvalidCredential = errors.New("Invalid WebAuthn credential")
+	// ErrUnknownCredential is used when the credential has not been
+	// registered for this instance
+	ErrUnknownCredential = errors.New("Unknown WebAuthn credential")
+	// ErrCredentialExists is used when the credential is already registered
+	ErrCredentialExists = errors.New("The WebAuthn credential is already registered")
+	// ErrUnsupportedAlgorithm is used for public keys with an algorithm that
+	// is not supported
+	ErrUnsupportedAlgorithm = errors.New("Unsupported algorithm for the WebAuthn credential")
+	// ErrInvalidSignature is used when the signature of an assertion is not
+	// valid
+	ErrInvalidSignature = errors.New("Invalid signature for the WebAuthn credential")
+	// ErrUserNotVerified is used when the authenticator has not checked the
+	// presence or the identity of the user
+	ErrUserNotVerified = errors.New("The user has not been verified by the authenticator")
+)
+
+// timeout is the time given to the user for the ceremonies (in milliseconds)
+const timeout = 5 * 60 * 1000
+
+// Credential is a public key credential registered by the user. It is kept in
+// the io.cozy.webauthn.credentials doctype.
+type Credential struct {
+	DocID        string     `json:"_id,omitempty"`
+	DocRev       string     `json:"_rev,omitempty"`
+	Name         string     `json:"name"`
+	CredentialID string     `json:"credential_id"` // base64url encoded
+	PublicKey    []byte     `json:"public_key"`    // PKIX encoded
+	Algorithm    int        `json:"algorithm"`
+	SignCount    uint32     `json:"sign_count"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// ID implements couchdb.Doc
+func (c *Credential) ID() string { return c.DocID }
+
+// Rev implements couchdb.Doc
+func (c *Credential) Rev() string { return c.DocRev }
+
+// DocType implements couchdb.Doc
+func (c *Credential) DocType() string { return consts.WebAuthnCredentials }
+
+// Clone implements couchdb.Doc
+func (c *Credential) Clone() couchdb.Doc {
+	cloned := *c
+	cloned.PublicKey = make([]byte, len(c.PublicKey))
+	copy(cloned.PublicKey, c.PublicKey)
+	if c.LastUsedAt != nil {
+		tmp := *c.LastUsedAt
+		cloned.LastUsedAt = &tmp
+	}
+	return &cloned
+}
+
+// SetID implements couchdb.Doc
+func (c *Credential) SetID(id string) { c.DocID = id }
+
+// SetRev implements couchdb.Doc
+func (c *Credential) SetRev(rev string) { c.DocRev = rev }
+
+// RelyingParty is the website for which the credentials are created.
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// User is the account for which the credentials are created.
+type User struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is an algorithm accepted for a new credential.
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor identifies a registered credential.
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// AuthenticatorSelection are the requirements for the authenticator used to
+// create a credential.
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the options given to navigator.credentials.create in
+// the browser. The binary values are base64url encoded.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   User                   `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+// RequestOptions are the options given to navigator.credentials.get in the
+// browser. The binary values are base64url encoded.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int                    `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CredentialResponse is the PublicKeyCredential returned by the browser,
+// with its binary values base64url encoded.
+type CredentialResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject,omitempty"`
+		AuthenticatorData string `json:"authenticatorData,omitempty"`
+		Signature         string `json:"signature,omitempty"`
+	} `json:"response"`
+}
+
+// RPID returns the identifier of the relying party for the instance: the
+// domain of the instance. With flat subdomains, the parent domain is shared by
+// all the instances, and so it can't be used.
+func RPID(inst *instance.Instance) string {
+	domain := inst.ContextualDomain()
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+	return domain
+}
+
+// allowedOrigins returns the origins where the ceremonies can be made: only
+// the pages of the stack, as the apps are served on other domains.
+func allowedOrigins(inst *instance.Instance) []string {
+	u, err := url.Parse(inst.PageURL("/", nil))
+	if err != nil {
+		return nil
+	}
+	return []string{u.Scheme + "://" + u.Host}
+}
+
+func checkOrigin(inst *instance.Instance, origin string) error {
+	for _, o := range allowedOrigins(inst) {
+		if o == origin {
+			return nil
+		}
+	}
+	return ErrInvalidOrigin
+}
+
+func checkChallenge(inst *instance.Instance, purpose, challenge string) error {
+	ok, err := GetStore().CheckChallenge(inst, purpose, challenge)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidChallenge
+	}
+	return nil
+}
+
+// List returns the credentials registered for the instance.
+func List(inst *instance.Instance) ([]*Credential, error) {
+	var creds []*Credential
+	req := &couchdb.AllDocsRequest{Limit: 1000}
+	err := couchdb.GetAllDocs(inst, consts.WebAuthnCredentials, req, &creds)
+	if couchdb.IsNoDatabaseError(err) {
+		return []*Credential{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// HasCredentials returns true if at least one credential has been registered
+// for the instance.
+func HasCredentials(inst *instance.Instance) bool {
+	count, err := couchdb.CountNormalDocs(inst, consts.WebAuthnCredentials)
+	return err == nil && count > 0
+}
+
+// Get returns the credential with the given document ID.
+func Get(inst *instance.Instance, id string) (*Credential, error) {
+	var cred Credential
+	if err := couchdb.GetDoc(inst, consts.WebAuthnCredentials, id, &cred); err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// Delete removes a credential: it can no longer be used to log in.
+func (c *Credential) Delete(inst *instance.Instance) error {
+	return couchdb.DeleteDoc(inst, c)
+}
+
+func descriptors(creds []*Credential) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, len(creds))
+	for i, cred := range creds {
+		list[i] = CredentialDescriptor{Type: "public-key", ID: cred.CredentialID}
+	}
+	return list
+}
+
+// BeginRegistration returns the options for creating a new credential in the
+// browser.
+func BeginRegistration(inst *instance.Instance) (*CreationOptions, error) {
+	creds, err := List(inst)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := GetStore().AddChallenge(inst, PurposeRegistration)
+	if err != nil {
+		return nil, err
+	}
+	name := inst.Domain
+	if publicName, err := inst.PublicName(); err == nil && publicName != "" {
+		name = publicName
+	}
+	userID := sha256.Sum256([]byte(inst.Domain))
+	return &CreationOptions{
+		Challenge: challenge,
+		RP: RelyingParty{
+			ID:   RPID(inst),
+			Name: inst.TemplateTitle(),
+		},
+		User: User{
+			ID:          base64.RawURLEncoding.EncodeToString(userID[:]),
+			Name:        inst.Domain,
+			DisplayName: name,
+		},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:            timeout,
+		Attestation:        "none",
+		ExcludeCredentials: descriptors(creds),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+	}, nil
+}
+
+// Register checks the response of the browser for the creation of a
+// credential, and saves it.
+func Register(inst *instance.Instance, name string, resp *CredentialResponse) (*Credential, error) {
+	clientDataJSON, err := decodeBase64URL(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+	client, err := parseClientData(clientDataJSON, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOrigin(inst, client.Origin); err != nil {
+		return nil, err
+	}
+	if err := checkChallenge(inst, PurposeRegistration, client.Challenge); err != nil {
+		return nil, err
+	}
+
+	rawAttestation, err := decodeBase64URL(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+	data, err := parseAttestationObject(rawAttestation)
+	if err != nil {
+		return nil, err
+	}
+	if err := data.checkRPID(RPID(inst)); err != nil {
+		return nil, err
+	}
+	if err := data.checkFlags(false); err != nil {
+		return nil, err
+	}
+	publicKey, alg, err := parseCOSEKey(data.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(data.CredentialID)
+	creds, err := List(inst)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range creds {
+		if c.CredentialID == credentialID {
+			return nil, ErrCredentialExists
+		}
+	}
+
+	if name == "" {
+		name = "Security key"
+	}
+	cred := &Credential{
+		Name:         name,
+		CredentialID: credentialID,
+		PublicKey:    publicKey,
+		Algorithm:    alg,
+		SignCount:    data.SignCount,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := couchdb.CreateDoc(inst, cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+// BeginLogin returns the options for getting an assertion from one of the
+// registered credentials in the browser.
+func BeginLogin(inst *instance.Instance) (*RequestOptions, error) {
+	creds, err := List(inst)
+	if err != nil {
+		return nil, err
+	}
+	if len(creds) == 0 {
+		return nil, ErrNoCredentials
+	}
+	challenge, err := GetStore().AddChallenge(inst, PurposeLogin)
+	if err != nil {
+		return nil, err
+	}
+	return &RequestOptions{
+		Challenge:        challenge,
+		RPID:             RPID(inst),
+		Timeout:          timeout,
+		AllowCredentials: descriptors(creds),
+		UserVerification: "preferred",
+	}, nil
+}
+
+// Authenticate checks an assertion made by the browser with one of the
+// registered credentials. The userVerification parameter must be true when
+// the credential is used for a passwordless login, as the authenticator must
+// then have checked the identity of the user (PIN, biometrics).
+func Authenticate(inst *instance.Instance, resp *CredentialResponse, userVerification bool) (*Credential, error) {
+	creds, err := List(inst)
+	if err != nil {
+		return nil, err
+	}
+	var cred *Credential
+	for _, c := range creds {
+		if c.CredentialID == strings.TrimRight(resp.ID, "=") {
+			cred = c
+			break
+		}
+	}
+	if cred == nil {
+		return nil, ErrUnknownCredential
+	}
+
+	clientDataJSON, err := decodeBase64URL(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+	client, err := parseClientData(clientDataJSON, "webauthn.get")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOrigin(inst, client.Origin); err != nil {
+		return nil, err
+	}
+	if err := checkChallenge(inst, PurposeLogin, client.Challenge); err != nil {
+		return nil, err
+	}
+
+	rawAuthData, err := decodeBase64URL(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+	data, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := data.checkRPID(RPID(inst)); err != nil {
+		return nil, err
+	}
+	if err := data.checkFlags(userVerification); err != nil {
+		return nil, err
+	}
+	sig, err := decodeBase64URL(resp.Response.Signature)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+	if err := verifySignature(cred.PublicKey, cred.Algorithm, rawAuthData, clientDataJSON, sig); err != nil {
+		return nil, err
+	}
+
+	// A signature counter that doesn't increase is a sign that the
+	// authenticator may have been cloned. Authenticators that don't
+	// implement the counter always send 0.
+	if (data.SignCount != 0 || cred.SignCount != 0) && data.SignCount <= cred.SignCount {
+		return nil, ErrInvalidSignature
+	}
+	cred.SignCount = data.SignCount
+	now := time.Now().UTC()
+	cred.LastUsedAt = &now
+	if err := couchdb.UpdateDoc(inst, cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
@@ -0,0 +1,164 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+)
+
+const testRPID = "alice.cozy.example"
+
+func encodeCBOR(t *testing.T, v interface{}) []byte {
+	var out []byte
+	require.NoError(t, codec.NewEncoderBytes(&out, cborHandle).Encode(v))
+	return out
+}
+
+func makeAuthData(flags byte, count uint32, credID, coseKey []byte) []byte {
+	hash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte{}, hash[:]...)
+	data = append(data, flags)
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, count)
+	data = append(data, counter...)
+	if coseKey == nil {
+		return data
+	}
+	data = append(data, make([]byte, 16)...) // AAGUID
+	idLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(idLen, uint16(len(credID)))
+	data = append(data, idLen...)
+	data = append(data, credID...)
+	return append(data, coseKey...)
+}
+
+func TestRegistrationES256(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	coseKey := encodeCBOR(t, map[int]interface{}{
+		1:  2, // EC2
+		3:  AlgES256,
+		-1: 1, // P-256
+		-2: priv.X.FillBytes(make([]byte, 32)),
+		-3: priv.Y.FillBytes(make([]byte, 32)),
+	})
+	credID := []byte("my-credential-id")
+	authData := makeAuthData(flagUserPresent|flagAttestedData, 0, credID, coseKey)
+	attestation := encodeCBOR(t, map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+
+	data, err := parseAttestationObject(attestation)
+	require.NoError(t, err)
+	assert.Equal(t, credID, data.CredentialID)
+	assert.NoError(t, data.checkRPID(testRPID))
+	assert.Error(t, data.checkRPID("bob.cozy.example"))
+	assert.NoError(t, data.checkFlags(false))
+	assert.Equal(t, ErrUserNotVerified, data.checkFlags(true))
+
+	publicKey, alg, err := parseCOSEKey(data.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, AlgES256, alg)
+
+	// Assertion
+	clientDataJSON := []byte(`{"type":"webauthn.get","challenge":"abc","origin":"https://alice.cozy.example"}`)
+	client, err := parseClientData(clientDataJSON, "webauthn.get")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", client.Challenge)
+	_, err = parseClientData(clientDataJSON, "webauthn.create")
+	assert.Equal(t, ErrInvalidCredential, err)
+
+	assertionData := makeAuthData(flagUserPresent|flagUserVerified, 1, nil, nil)
+	hash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, assertionData...), hash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	require.NoError(t, err)
+
+	parsed, err := parseAuthenticatorData(assertionData)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), parsed.SignCount)
+	assert.NoError(t, parsed.checkFlags(true))
+	assert.NoError(t, verifySignature(publicKey, alg, assertionData, clientDataJSON, sig))
+
+	tampered := append([]byte{}, assertionData...)
+	tampered[36] = 2
+	assert.Equal(t, ErrInvalidSignature, verifySignature(publicKey, alg, tampered, clientDataJSON, sig))
+	assert.Equal(t, ErrInvalidSignature, verifySignature(publicKey, AlgEdDSA, assertionData, clientDataJSON, sig))
+}
+
+func TestEdDSA(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	coseKey := encodeCBOR(t, map[int]interface{}{
+		1:  1, // OKP
+		3:  AlgEdDSA,
+		-1: 6, // Ed25519
+		-2: []byte(pub),
+	})
+	publicKey, alg, err := parseCOSEKey(coseKey)
+	require.NoError(t, err)
+	assert.Equal(t, AlgEdDSA, alg)
+
+	clientDataJSON := []byte(`{"type":"webauthn.get","challenge":"def","origin":"https://alice.cozy.example"}`)
+	authData := makeAuthData(flagUserPresent, 0, nil, nil)
+	hash := sha256.Sum256(clientDataJSON)
+	sig := ed25519.Sign(priv, append(append([]byte{}, authData...), hash[:]...))
+	assert.NoError(t, verifySignature(publicKey, alg, authData, clientDataJSON, sig))
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	coseKey := encodeCBOR(t, map[int]interface{}{1: 2, 3: -36})
+	_, _, err := parseCOSEKey(coseKey)
+	assert.Equal(t, ErrUnsupportedAlgorithm, err)
+
+	_, err = parseAuthenticatorData([]byte("too short"))
+	assert.Equal(t, ErrInvalidCredential, err)
+}
+
+func TestMemStore(t *testing.T) {
+	store := newMemStore()
+	db := prefixer.NewPrefixer("alice.cozy.example", "alice.cozy.example")
+
+	challenge, err := store.AddChallenge(db, PurposeLogin)
+	require.NoError(t, err)
+
+	ok, err := store.CheckChallenge(db, PurposeRegistration, challenge)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = store.CheckChallenge(db, PurposeLogin, challenge)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// A challenge can be used only once
+	ok, err = store.CheckChallenge(db, PurposeLogin, challenge)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestRPIDWithFlatSubdomains(t *testing.T) {
+	config.UseTestFile()
+	cfg := config.GetConfig()
+	previous := cfg.Subdomains
+	defer func() { cfg.Subdomains = previous }()
+	cfg.Subdomains = config.FlatSubdomains
+
+	inst := &instance.Instance{Domain: "alice.cozy.example"}
+	assert.Equal(t, "alice.cozy.example", RPID(inst))
+	assert.NoError(t, checkOrigin(inst, "https://alice.cozy.example"))
+	assert.Equal(t, ErrInvalidOrigin, checkOrigin(inst, "https://alice-settings.cozy.example"))
+	assert.Equal(t, ErrInvalidOrigin, checkOrigin(inst, "https://bob.cozy.example"))
+}
@@ -20,11 +20,29 @@ import (
 
 // Copier is an interface defining a common set of functions for the installer
 // to copy the application into an unknown storage.
+//
+// The versions of an application are stored side by side, and the installer
+// can use Prune to keep only the last ones.
 type Copier interface {
 	Start(slug, version, shasum string) (exists bool, err error)
 	Copy(stat os.FileInfo, src io.Reader) error
 	Abort() error
 	Commit() error
+	// Exists returns true if the given version of the application is stored.
+	Exists(slug, version, shasum string) (bool, error)
+	// Prune removes the stored versions of the application, except the ones
+	// in keep (with the same format as the names used by Start: the version,
+	// followed by a dash and the shasum if there is one).
+	Prune(slug string, keep []string) error
+}
+
+// VersionName returns the name used for storing the given version of an
+// application.
+func VersionName(version, shasum string) string {
+	if shasum != "" {
+		return version + "-" + shasum
+	}
+	return version
 }
 
 type swiftCopier struct {
@@ -54,10 +72,7 @@ func NewSwiftCopier(conn *swift.Connection, appsType consts.AppType) Copier {
 }
 
 func (f *swiftCopier) Start(slug, version, shasum string) (bool, error) {
-	f.appObj = path.Join(slug, version)
-	if shasum != "" {
-		f.appObj += "-" + shasum
-	}
+	f.appObj = path.Join(slug, VersionName(version, shasum))
 	_, _, err := f.c.Object(f.ctx, f.container, f.appObj)
 	if err == nil {
 		return true, nil
@@ -141,6 +156,21 @@ func (f *swiftCopier) Commit() (err error) {
 	return f.c.ObjectPutString(f.ctx, f.container, f.appObj, "", "text/plain")
 }
 
+func (f *swiftCopier) Exists(slug, version, shasum string) (bool, error) {
+	objName := path.Join(slug, VersionName(version, shasum))
+	_, _, err := f.c.Object(f.ctx, f.container, objName)
+	if err == swift.ObjectNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Prune does nothing for swift, as the container is shared by all the
+// instances: a version can still be used by another instance.
+func (f *swiftCopier) Prune(slug string, keep []string) error {
+	return nil
+}
+
 // NewAferoCopier defines a copier using an afero.Fs filesystem to store the
 // application data.
 func NewAferoCopier(fs afero.Fs) Copier {
@@ -148,10 +178,7 @@ func NewAferoCopier(fs afero.Fs) Copier {
 }
 
 func (f *aferoCopier) Start(slug, version, shasum string) (bool, error) {
-	f.appDir = path.Join("/", slug, version)
-	if shasum != "" {
-		f.appDir += "-" + shasum
-	}
+	f.appDir = path.Join("/", slug, VersionName(version, shasum))
 	exists, err := afero.DirExists(f.fs, f.appDir)
 	if err != nil || exists {
 		return exists, err
@@ -205,6 +232,32 @@ func (f *aferoCopier) Abort() error {
 	return f.fs.RemoveAll(f.tmpDir)
 }
 
+func (f *aferoCopier) Exists(slug, version, shasum string) (bool, error) {
+	return afero.DirExists(f.fs, path.Join("/", slug, VersionName(version, shasum)))
+}
+
+func (f *aferoCopier) Prune(slug string, keep []string) error {
+	dir := path.Join("/", slug)
+	infos, err := afero.ReadDir(f.fs, dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		// The temporary directories are used by the installations in progress
+		if !info.IsDir() || strings.HasPrefix(name, "tmp") || utils.IsInArray(name, keep) {
+			continue
+		}
+		if err := f.fs.RemoveAll(path.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // NewFileInfo returns an os.FileInfo
 func NewFileInfo(name string, size int64, mode os.FileMode) os.FileInfo {
 	return &fileInfo{
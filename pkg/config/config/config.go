@@ -23,11 +23,13 @@ import (
 
 	"github.com/cozy/cozy-stack/pkg/cache"
 	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/cozy/cozy-stack/pkg/crypto"
 	"github.com/cozy/cozy-stack/pkg/keymgmt"
 	"github.com/cozy/cozy-stack/pkg/logger"
 	"github.com/cozy/cozy-stack/pkg/tlsclient"
 	"github.com/cozy/cozy-stack/pkg/utils"
 	"github.com/cozy/gomail"
+	humanize "github.com/dustin/go-humanize"
 	"github.com/go-redis/redis/v8"
 	"github.com/spf13/viper"
 )
@@ -76,6 +78,10 @@ const (
 	SchemeSwiftSecure = "swift+https"
 )
 
+// maxCredentialsUnlockTTL is the maximal duration for which the credentials
+// vault of an instance stays unlocked after a login.
+const maxCredentialsUnlockTTL = 1 * time.Hour
+
 // defaultAdminSecretFileName is the default name of the file containing the
 // administration hashed passphrase.
 const defaultAdminSecretFileName = "cozy-admin-passphrase"
@@ -104,9 +110,11 @@ type Config struct {
 	Hooks                 string
 	GeoDB                 string
 	PasswordResetInterval time.Duration
+	BruteForce            BruteForce
 
 	CredentialsEncryptorKey string
 	CredentialsDecryptorKey string
+	CredentialsUnlockTTL    time.Duration
 
 	RemoteAssets map[string]string
 
@@ -114,6 +122,7 @@ type Config struct {
 	CouchDB        CouchDB
 	Jobs           Jobs
 	Konnectors     Konnectors
+	Apps           Apps
 	Mail           *gomail.DialerOptions
 	MailPerContext map[string]interface{}
 	Matomo         Matomo
@@ -196,15 +205,76 @@ type Jobs struct {
 	NoWorkers             bool
 	AllowList             bool
 	Workers               []Worker
+	ContextWeights        map[string]int
+	MaxTriggerFailures    int
 	ImageMagickConvertCmd string
 	// XXX for retro-compatibility
 	NbWorkers             int
 	DefaultDurationToKeep string
 }
 
+// Apps contains the configuration values for the updates of the webapps and
+// konnectors
+type Apps struct {
+	// KeptVersions is the number of previous versions of an application that
+	// are kept for a rollback
+	KeptVersions int
+	// SmokeURL is an optional URL called with the new version of an
+	// application before switching to it
+	SmokeURL string
+	// Signatures is the configuration for the verification of the signatures
+	// of the application packages
+	Signatures AppsSignatures
+}
+
+// Policies for the signatures of the application packages
+const (
+	// SignaturesRequired means that the packages without a valid signature
+	// are refused
+	SignaturesRequired = "require"
+	// SignaturesWarn means that the signatures are checked, but a missing or
+	// invalid signature is only logged
+	SignaturesWarn = "warn"
+	// SignaturesOff means that the signatures are not checked
+	SignaturesOff = "off"
+)
+
+// AppsSignatures contains the publisher keys used to verify the minisign
+// signatures of the application packages.
+type AppsSignatures struct {
+	Policy string
+	// Keys are the trusted keys, by context name
+	Keys map[string][]string
+	// Registries are the trusted keys, by registry URL
+	Registries map[string][]string
+}
+
 // Konnectors contains the configuration values for the konnectors
 type Konnectors struct {
 	Cmd string
+
+	// Runtimes are the runtimes that can be used to execute the konnectors
+	// and services, by name.
+	Runtimes map[string]Runtime
+	// Languages and Slugs associates a language or a slug to the name of a
+	// runtime. The slug has the precedence over the language.
+	Languages map[string]string
+	Slugs     map[string]string
+
+	// MaintenanceSyncInterval is the delay between two synchronizations of
+	// the lists of konnectors in maintenance on the registries.
+	MaintenanceSyncInterval time.Duration
+}
+
+// Runtime contains the configuration for a runtime of the konnectors and
+// services.
+type Runtime struct {
+	Type    string  // "process", "namespaces" or "wasm"
+	Cmd     string  // the command to execute (konnectors.cmd by default)
+	Memory  int64   // the maximal memory in bytes (0 for no limit)
+	CPU     float64 // the maximal number of CPUs (0 for no limit)
+	Pids    int     // the maximal number of processes (0 for no limit)
+	Network string  // "slirp4netns" (default), "host" or "none"
 }
 
 // Matomo contains the configuration for the JS tracking
@@ -214,6 +284,54 @@ type Matomo struct {
 	OnboardingAppID int
 }
 
+// BruteForce contains the configuration for the protection against the
+// brute-force attacks on the authentication endpoints
+type BruteForce struct {
+	// IPLimit is the number of failed attempts from an IP address, on all the
+	// instances, before the IP address is banned
+	IPLimit int64
+	// BanDuration is how long an IP address stays banned
+	BanDuration time.Duration
+	// Allowlist is the list of networks that are never delayed nor banned
+	Allowlist []*net.IPNet
+	// TrustedProxies is the list of networks of the reverse proxies that can
+	// be trusted for the X-Forwarded-For header
+	TrustedProxies []*net.IPNet
+}
+
+// IsAllowed returns true if the IP address is in the allowlist.
+func (b *BruteForce) IsAllowed(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range b.Allowlist {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func makeBruteForceNetworks(key string, list []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid brute_force.%s entry %q: %s", key, item, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
 // Move contains the configuration for the move wizard
 type Move struct {
 	URL string
@@ -252,10 +370,18 @@ type SMS struct {
 
 // Worker contains the configuration fields for a specific worker type.
 type Worker struct {
-	WorkerType   string
-	Concurrency  *int
-	MaxExecCount *int
-	Timeout      *time.Duration
+	WorkerType    string
+	Concurrency   *int
+	MaxExecCount  *int
+	Timeout       *time.Duration
+	RetryDelay    *time.Duration
+	MaxRetryDelay *time.Duration
+	Backoff       *string
+	Jitter        *float64
+	DeadLetter    *bool
+	// MaxConcurrencyPerInstance limits the number of jobs executed in
+	// parallel for a single instance.
+	MaxConcurrencyPerInstance *int
 }
 
 // RedisConfig contains the configuration values for a redis system
@@ -443,8 +569,16 @@ func Setup(cfgFile string) (err error) {
 
 func applyDefaults(v *viper.Viper) {
 	v.SetDefault("password_reset_interval", defaultPasswordResetInterval)
+	v.SetDefault("brute_force.ip_limit", 50)
+	v.SetDefault("brute_force.ban_duration", 1*time.Hour)
+	v.SetDefault("brute_force.trusted_proxies", []string{"127.0.0.0/8", "::1"})
 	v.SetDefault("jobs.imagemagick_convert_cmd", "convert")
 	v.SetDefault("jobs.defaultDurationToKeep", "2W")
+	v.SetDefault("jobs.max_trigger_failures", 10)
+	v.SetDefault("apps.kept_versions", 3)
+	v.SetDefault("konnectors.maintenance_sync_interval", 10*time.Minute)
+	v.SetDefault("vault.credentials_unlock_ttl", 10*time.Minute)
+	v.SetDefault("apps.signatures.policy", SignaturesOff)
 	v.SetDefault("assets_polling_disabled", false)
 	v.SetDefault("assets_polling_interval", 2*time.Minute)
 	v.SetDefault("fs.versioning.max_number_of_versions_to_keep", 20)
@@ -531,6 +665,16 @@ func UseViper(v *viper.Viper) error {
 		return err
 	}
 
+	konnectors, err := makeKonnectors(v)
+	if err != nil {
+		return err
+	}
+
+	signatures, err := makeAppsSignatures(v)
+	if err != nil {
+		return err
+	}
+
 	var subdomains SubdomainType
 	if subs := v.GetString("subdomains"); subs != "" {
 		switch subs {
@@ -603,6 +747,14 @@ func UseViper(v *viper.Viper) error {
 	if err != nil {
 		return err
 	}
+	bruteForceAllowlist, err := makeBruteForceNetworks("allowlist", v.GetStringSlice("brute_force.allowlist"))
+	if err != nil {
+		return err
+	}
+	trustedProxies, err := makeBruteForceNetworks("trusted_proxies", v.GetStringSlice("brute_force.trusted_proxies"))
+	if err != nil {
+		return err
+	}
 	oauthStateRedis, err := GetRedisConfig(v, redisOptions, "konnectors", "oauthstate")
 	if err != nil {
 		return err
@@ -628,6 +780,7 @@ func UseViper(v *viper.Viper) error {
 		RedisConfig:           jobsRedis,
 		ImageMagickConvertCmd: v.GetString("jobs.imagemagick_convert_cmd"),
 		DefaultDurationToKeep: v.GetString("jobs.defaultDurationToKeep"),
+		MaxTriggerFailures:    v.GetInt("jobs.max_trigger_failures"),
 	}
 	{
 		if allow := v.GetBool("jobs.allowlist"); allow {
@@ -671,6 +824,69 @@ func UseViper(v *viper.Viper) error {
 								}
 								w.Timeout = &d
 							}
+						case "retry_delay", "max_retry_delay":
+							if delay, ok := v.(string); ok {
+								var d time.Duration
+								d, err = time.ParseDuration(delay)
+								if err != nil {
+									return fmt.Errorf("config: could not parse %s duration for worker %q: %s",
+										k, workerType, err)
+								}
+								if k == "retry_delay" {
+									w.RetryDelay = &d
+								} else {
+									w.MaxRetryDelay = &d
+								}
+							} else {
+								return fmt.Errorf("config: %s for worker %q must be a duration",
+									k, workerType)
+							}
+						case "backoff":
+							if backoff, ok := v.(string); ok {
+								switch backoff {
+								case "constant", "linear", "exponential":
+									w.Backoff = &backoff
+								default:
+									return fmt.Errorf("config: unknown backoff strategy %q for worker %q",
+										backoff, workerType)
+								}
+							} else {
+								return fmt.Errorf("config: backoff for worker %q must be a string",
+									workerType)
+							}
+						case "jitter":
+							var jitter float64
+							switch j := v.(type) {
+							case float64:
+								jitter = j
+							case int:
+								jitter = float64(j)
+							case string:
+								jitter, err = strconv.ParseFloat(j, 64)
+								if err != nil {
+									return fmt.Errorf("config: could not parse jitter for worker %q: %s",
+										workerType, err)
+								}
+							default:
+								return fmt.Errorf("config: jitter for worker %q must be a number",
+									workerType)
+							}
+							if jitter < 0 || jitter > 1 {
+								return fmt.Errorf("config: jitter for worker %q must be between 0 and 1",
+									workerType)
+							}
+							w.Jitter = &jitter
+						case "dead_letter":
+							if deadLetter, ok := v.(bool); ok {
+								w.DeadLetter = &deadLetter
+							} else {
+								return fmt.Errorf("config: dead_letter for worker %q must be a boolean",
+									workerType)
+							}
+						case "max_concurrency_per_instance":
+							if limit, ok := v.(int); ok {
+								w.MaxConcurrencyPerInstance = &limit
+							}
 						default:
 							return fmt.Errorf("config: unknown key %q",
 								"jobs.workers."+workerType+"."+k)
@@ -685,6 +901,17 @@ func UseViper(v *viper.Viper) error {
 			}
 			jobs.Workers = workers
 		}
+		if weights := v.GetStringMap("jobs.context_weights"); len(weights) > 0 {
+			jobs.ContextWeights = make(map[string]int, len(weights))
+			for context, weight := range weights {
+				w, ok := weight.(int)
+				if !ok || w < 1 {
+					return fmt.Errorf("config: the weight for the context %q must be a positive integer",
+						context)
+				}
+				jobs.ContextWeights[context] = w
+			}
+		}
 	}
 
 	// Use the layout v3 (value 2) for missing/invalid value
@@ -717,6 +944,11 @@ func UseViper(v *viper.Viper) error {
 		}
 	}
 
+	credentialsUnlockTTL := v.GetDuration("vault.credentials_unlock_ttl")
+	if credentialsUnlockTTL <= 0 || credentialsUnlockTTL > maxCredentialsUnlockTTL {
+		credentialsUnlockTTL = maxCredentialsUnlockTTL
+	}
+
 	config = &Config{
 		Host: v.GetString("host"),
 		Port: v.GetInt("port"),
@@ -735,11 +967,18 @@ func UseViper(v *viper.Viper) error {
 		Hooks:                 v.GetString("hooks"),
 		GeoDB:                 v.GetString("geodb"),
 		PasswordResetInterval: v.GetDuration("password_reset_interval"),
+		BruteForce: BruteForce{
+			IPLimit:        v.GetInt64("brute_force.ip_limit"),
+			BanDuration:    v.GetDuration("brute_force.ban_duration"),
+			Allowlist:      bruteForceAllowlist,
+			TrustedProxies: trustedProxies,
+		},
 
 		RemoteAssets: v.GetStringMapString("remote_assets"),
 
 		CredentialsEncryptorKey: v.GetString("vault.credentials_encryptor_key"),
 		CredentialsDecryptorKey: v.GetString("vault.credentials_decryptor_key"),
+		CredentialsUnlockTTL:    credentialsUnlockTTL,
 
 		Fs: Fs{
 			URL:                   fsURL,
@@ -757,9 +996,12 @@ func UseViper(v *viper.Viper) error {
 			URL:    couchURL,
 			Client: couchClient,
 		},
-		Jobs: jobs,
-		Konnectors: Konnectors{
-			Cmd: v.GetString("konnectors.cmd"),
+		Jobs:       jobs,
+		Konnectors: konnectors,
+		Apps: Apps{
+			KeptVersions: v.GetInt("apps.kept_versions"),
+			SmokeURL:     v.GetString("apps.smoke_url"),
+			Signatures:   signatures,
 		},
 		Matomo: Matomo{
 			URL:             v.GetString("matomo.url"),
@@ -982,6 +1224,154 @@ func makeOffice(v *viper.Viper) (map[string]Office, error) {
 	return office, nil
 }
 
+func makeKonnectors(v *viper.Viper) (Konnectors, error) {
+	konnectors := Konnectors{
+		Cmd:       v.GetString("konnectors.cmd"),
+		Runtimes:  make(map[string]Runtime),
+		Languages: v.GetStringMapString("konnectors.languages"),
+		Slugs:     v.GetStringMapString("konnectors.slugs"),
+
+		MaintenanceSyncInterval: v.GetDuration("konnectors.maintenance_sync_interval"),
+	}
+	for name, raw := range v.GetStringMap("konnectors.runtimes") {
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return konnectors, fmt.Errorf("config: expecting a map in the key %q",
+				"konnectors.runtimes."+name)
+		}
+		rt := Runtime{Type: "process", Network: "slirp4netns"}
+		for k, val := range m {
+			switch k {
+			case "type":
+				rt.Type, _ = val.(string)
+				switch rt.Type {
+				case "process", "namespaces", "wasm":
+				default:
+					return konnectors, fmt.Errorf("config: unknown type %q for the runtime %q",
+						rt.Type, name)
+				}
+			case "cmd":
+				rt.Cmd, _ = val.(string)
+			case "memory":
+				switch mem := val.(type) {
+				case int:
+					rt.Memory = int64(mem)
+				case string:
+					bytes, err := humanize.ParseBytes(mem)
+					if err != nil {
+						return konnectors, fmt.Errorf("config: could not parse the memory for the runtime %q: %s",
+							name, err)
+					}
+					rt.Memory = int64(bytes)
+				}
+			case "cpu":
+				switch cpu := val.(type) {
+				case float64:
+					rt.CPU = cpu
+				case int:
+					rt.CPU = float64(cpu)
+				}
+			case "pids":
+				rt.Pids, _ = val.(int)
+			case "network":
+				rt.Network, _ = val.(string)
+				switch rt.Network {
+				case "slirp4netns", "host", "none":
+				default:
+					return konnectors, fmt.Errorf("config: the network for the runtime %q must be slirp4netns, host or none",
+						name)
+				}
+			default:
+				return konnectors, fmt.Errorf("config: unknown key %q",
+					"konnectors.runtimes."+name+"."+k)
+			}
+		}
+		konnectors.Runtimes[name] = rt
+	}
+	for _, names := range []map[string]string{konnectors.Languages, konnectors.Slugs} {
+		for key, name := range names {
+			if _, ok := konnectors.Runtimes[name]; !ok {
+				return konnectors, fmt.Errorf("config: unknown runtime %q for %q", name, key)
+			}
+		}
+	}
+	return konnectors, nil
+}
+
+func makeAppsSignatures(v *viper.Viper) (AppsSignatures, error) {
+	signatures := AppsSignatures{
+		Policy:     v.GetString("apps.signatures.policy"),
+		Keys:       make(map[string][]string),
+		Registries: make(map[string][]string),
+	}
+	switch signatures.Policy {
+	case "", "false":
+		// YAML parses an unquoted off as a boolean
+		signatures.Policy = SignaturesOff
+	case SignaturesRequired, SignaturesWarn, SignaturesOff:
+	default:
+		return signatures, fmt.Errorf("config: the policy for the signatures must be %s, %s or %s",
+			SignaturesRequired, SignaturesWarn, SignaturesOff)
+	}
+
+	parseKeys := func(key string, raw interface{}) ([]string, error) {
+		list, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("config: expecting a list of keys in %q", key)
+		}
+		keys := make([]string, 0, len(list))
+		for _, item := range list {
+			k, _ := item.(string)
+			if _, err := crypto.ParseMinisignKey(k); err != nil {
+				return nil, fmt.Errorf("config: invalid key in %q: %s", key, err)
+			}
+			keys = append(keys, k)
+		}
+		return keys, nil
+	}
+
+	for ctx, raw := range v.GetStringMap("apps.signatures.keys") {
+		keys, err := parseKeys("apps.signatures.keys."+ctx, raw)
+		if err != nil {
+			return signatures, err
+		}
+		signatures.Keys[ctx] = keys
+	}
+
+	// The registries are given as a list, as viper would split the URLs on
+	// the dots if they were used as keys of a map.
+	if raw := v.Get("apps.signatures.registries"); raw != nil {
+		list, ok := raw.([]interface{})
+		if !ok {
+			return signatures, errors.New("config: expecting a list in \"apps.signatures.registries\"")
+		}
+		for _, item := range list {
+			entry := make(map[string]interface{})
+			switch m := item.(type) {
+			case map[string]interface{}:
+				entry = m
+			case map[interface{}]interface{}:
+				for k, val := range m {
+					entry[fmt.Sprintf("%v", k)] = val
+				}
+			default:
+				return signatures, errors.New("config: expecting a url and keys in \"apps.signatures.registries\"")
+			}
+			u, _ := entry["url"].(string)
+			if u == "" {
+				return signatures, errors.New("config: missing url in \"apps.signatures.registries\"")
+			}
+			keys, err := parseKeys("apps.signatures.registries", entry["keys"])
+			if err != nil {
+				return signatures, err
+			}
+			u = strings.TrimSuffix(u, "/")
+			signatures.Registries[u] = append(signatures.Registries[u], keys...)
+		}
+	}
+	return signatures, nil
+}
+
 func makeSMS(raw map[string]interface{}) map[string]SMS {
 	sms := make(map[string]SMS)
 	for name, val := range raw {
@@ -4,6 +4,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/sirupsen/logrus"
@@ -99,3 +100,35 @@ func regsToStrings(regs []*url.URL) []string {
 	}
 	return ss
 }
+
+func TestAppsSignatures(t *testing.T) {
+	key := "RWQAAQIDBAUGBwABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4f"
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(strings.NewReader(`
+apps:
+  signatures:
+    policy: require
+    keys:
+      default:
+        - ` + key + `
+    registries:
+      - url: https://apps-registry.cozycloud.cc/
+        keys:
+          - ` + key + `
+`))
+	assert.NoError(t, err)
+	signatures, err := makeAppsSignatures(v)
+	assert.NoError(t, err)
+	assert.Equal(t, SignaturesRequired, signatures.Policy)
+	assert.Equal(t, []string{key}, signatures.Keys["default"])
+	assert.Equal(t, []string{key}, signatures.Registries["https://apps-registry.cozycloud.cc"])
+
+	v.Set("apps.signatures.policy", "maybe")
+	_, err = makeAppsSignatures(v)
+	assert.Error(t, err)
+	v.Set("apps.signatures.policy", "warn")
+	v.Set("apps.signatures.keys", map[string]interface{}{"default": []interface{}{"foo"}})
+	_, err = makeAppsSignatures(v)
+	assert.Error(t, err)
+}
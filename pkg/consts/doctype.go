@@ -11,10 +11,19 @@ const (
 	Apps = "io.cozy.apps"
 	// AppsSuggestion doc type for suggesting apps to the user
 	AppsSuggestion = "io.cozy.apps.suggestions"
+	// AppsVersions doc type for the previous versions of the applications,
+	// kept for a rollback
+	AppsVersions = "io.cozy.apps.versions"
+	// AppsServiceViolations doc type for the last violations of the limits
+	// of the services of a webapp
+	AppsServiceViolations = "io.cozy.apps.service_violations"
 	// Konnectors doc type for konnector application manifests
 	Konnectors = "io.cozy.konnectors"
 	// KonnectorsMaintenance doc type for maintenance of konnectors.
 	KonnectorsMaintenance = "io.cozy.konnectors.maintenance"
+	// RegistryVersions doc type for the versions of the applications
+	// published on the registry built in the stack
+	RegistryVersions = "io.cozy.registry.versions"
 	// Archives doc type for zip archives with files and directories
 	Archives = "io.cozy.files.archives"
 	// Exports doc type for global exports archives
@@ -50,6 +59,8 @@ const (
 	Jobs = "io.cozy.jobs"
 	// JobEvents doc type for real time events sent by jobs
 	JobEvents = "io.cozy.jobs.events"
+	// JobLogs doc type for the execution journals of the konnectors
+	JobLogs = "io.cozy.jobs.logs"
 	// Support doc type for sending mail to the support
 	Support = "io.cozy.support"
 	// Notifications doc type for notifications
@@ -58,6 +69,8 @@ const (
 	OAuthAccessCodes = "io.cozy.oauth.access_codes"
 	// OAuthClients doc type for OAuth2 clients
 	OAuthClients = "io.cozy.oauth.clients"
+	// OAuthRefreshTokens doc type for the records of the OAuth2 refresh tokens
+	OAuthRefreshTokens = "io.cozy.oauth.refresh_tokens"
 	// Permissions doc type for permissions identifying a connection
 	Permissions = "io.cozy.permissions"
 	// Contacts doc type for sharing
@@ -89,6 +102,8 @@ const (
 	Triggers = "io.cozy.triggers"
 	// TriggersState doc type for triggers current state, jobs launchers
 	TriggersState = "io.cozy.triggers.state"
+	// WebhookDeliveries doc type for the delivery log of the outgoing webhooks
+	WebhookDeliveries = "io.cozy.webhooks.deliveries"
 	// Accounts doc type for accounts
 	Accounts = "io.cozy.accounts"
 	// AccountTypes doc type for account types
@@ -124,4 +139,7 @@ const (
 	// AuthConfirmations doc type used for realtime events when confirming
 	// authentication.
 	AuthConfirmations = "io.cozy.auth.confirmations"
+	// WebAuthnCredentials doc type for the security keys and passkeys
+	// registered by the user.
+	WebAuthnCredentials = "io.cozy.webauthn.credentials"
 )
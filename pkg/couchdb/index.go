@@ -10,7 +10,7 @@ import (
 
 // IndexViewsVersion is the version of current definition of views & indexes.
 // This number should be incremented when this file changes.
-const IndexViewsVersion int = 32
+const IndexViewsVersion int = 38
 
 // Indexes is the index list required by an instance to run properly.
 var Indexes = []*mango.Index{
@@ -35,15 +35,28 @@ var Indexes = []*mango.Index{
 	mango.IndexOnFields(consts.Jobs, "by-trigger-id", []string{"trigger_id", "queued_at"}),
 	mango.IndexOnFields(consts.Jobs, "by-queued-at", []string{"queued_at"}),
 
+	// Used to lookup the previous versions of an application
+	mango.IndexOnFields(consts.AppsVersions, "by-slug", []string{"app_type", "slug", "archived_at"}),
+
+	// Used to delete the refresh tokens of an OAuth client
+	mango.IndexOnFields(consts.OAuthRefreshTokens, "by-client-id", []string{"client_id"}),
+
+	// Used to lookup the execution journals of a konnector
+	mango.IndexOnFields(consts.JobLogs, "by-konnector", []string{"konnector", "started_at"}),
+
 	// Used to lookup a trigger to see if it exists or must be created
 	mango.IndexOnFields(consts.Triggers, "by-worker-and-type", []string{"worker", "type"}),
 
+	// Used to lookup the delivery log of an outgoing webhook
+	mango.IndexOnFields(consts.WebhookDeliveries, "by-trigger-id", []string{"trigger_id", "created_at"}),
+
 	// Used to lookup oauth clients by name
 	mango.IndexOnFields(consts.OAuthClients, "by-client-name", []string{"client_name"}),
 	mango.IndexOnFields(consts.OAuthClients, "by-notification-platform", []string{"notification_platform"}),
 
 	// Used to lookup login history by OS, browser, and IP
 	mango.IndexOnFields(consts.SessionsLogins, "by-os-browser-ip", []string{"os", "browser", "ip"}),
+	mango.IndexOnFields(consts.SessionsLogins, "by-os-browser", []string{"os", "browser"}),
 
 	// Used to lookup notifications by their source, ordered by their creation
 	// date
@@ -300,6 +313,7 @@ func IndexesByDoctype(doctype string) []*mango.Index {
 // properly.
 var globalIndexes = []*mango.Index{
 	mango.IndexOnFields(consts.Exports, "by-domain", []string{"domain", "created_at"}),
+	mango.IndexOnFields(consts.RegistryVersions, "by-slug", []string{"slug", "created_at"}),
 }
 
 // secretIndexes is the index list required on the secret databases to run
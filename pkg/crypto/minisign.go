@@ -0,0 +1,160 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// The minisign format is described on https://jedisct1.github.io/minisign/.
+// Only the prehashed signatures (the default since minisign 0.10) are
+// supported, as they can be checked without keeping the whole signed file in
+// memory.
+
+var (
+	// ErrMinisignBadFormat is used when a minisign key or signature cannot be
+	// parsed.
+	ErrMinisignBadFormat = errors.New("minisign: bad format")
+	// ErrMinisignLegacy is used for the signatures that are not prehashed.
+	ErrMinisignLegacy = errors.New("minisign: legacy signatures are not supported")
+	// ErrMinisignUnknownKey is used when the signature has not been made by
+	// one of the trusted keys.
+	ErrMinisignUnknownKey = errors.New("minisign: the signature has been made by an unknown key")
+	// ErrMinisignInvalid is used when the signature does not match.
+	ErrMinisignInvalid = errors.New("minisign: invalid signature")
+)
+
+const (
+	minisignKeyLen = 2 + 8 + ed25519.PublicKeySize
+	minisignSigLen = 2 + 8 + ed25519.SignatureSize
+
+	untrustedPrefix = "untrusted comment:"
+	trustedPrefix   = "trusted comment: "
+)
+
+var (
+	// "Ed" is used for the keys, and for the legacy signatures
+	minisignAlgEd      = []byte("Ed")
+	minisignAlgPrehash = []byte("ED")
+)
+
+// MinisignKey is a minisign public key.
+type MinisignKey struct {
+	ID  [8]byte
+	Key ed25519.PublicKey
+}
+
+// KeyID returns the identifier of the key, in hexadecimal.
+func (k *MinisignKey) KeyID() string {
+	return hex.EncodeToString(k.ID[:])
+}
+
+// MinisignSignature is a detached minisign signature.
+type MinisignSignature struct {
+	KeyID           [8]byte
+	Signature       []byte
+	TrustedComment  string
+	GlobalSignature []byte
+}
+
+// ParseMinisignKey parses a minisign public key. It accepts the content of a
+// .pub file, or only its base64 line.
+func ParseMinisignKey(s string) (*MinisignKey, error) {
+	var line string
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		l = strings.TrimSpace(l)
+		if l != "" && !strings.HasPrefix(l, untrustedPrefix) {
+			line = l
+			break
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(raw) != minisignKeyLen || !bytes.Equal(raw[:2], minisignAlgEd) {
+		return nil, ErrMinisignBadFormat
+	}
+	k := &MinisignKey{Key: ed25519.PublicKey(raw[10:])}
+	copy(k.ID[:], raw[2:10])
+	return k, nil
+}
+
+// ParseMinisignSignature parses the content of a .minisig file.
+func ParseMinisignSignature(s string) (*MinisignSignature, error) {
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		lines = append(lines, strings.TrimRight(l, "\r"))
+	}
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], untrustedPrefix) ||
+		!strings.HasPrefix(lines[2], trustedPrefix) {
+		return nil, ErrMinisignBadFormat
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != minisignSigLen {
+		return nil, ErrMinisignBadFormat
+	}
+	if bytes.Equal(raw[:2], minisignAlgEd) {
+		return nil, ErrMinisignLegacy
+	}
+	if !bytes.Equal(raw[:2], minisignAlgPrehash) {
+		return nil, ErrMinisignBadFormat
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return nil, ErrMinisignBadFormat
+	}
+	sig := &MinisignSignature{
+		Signature:       raw[10:],
+		TrustedComment:  strings.TrimPrefix(lines[2], trustedPrefix),
+		GlobalSignature: global,
+	}
+	copy(sig.KeyID[:], raw[2:10])
+	return sig, nil
+}
+
+// NewMinisignHash returns the hash used to compute the digest of a file for
+// a prehashed minisign signature.
+func NewMinisignHash() hash.Hash {
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+// Verify checks the signature of a file, whose digest has been computed with
+// NewMinisignHash, against a list of trusted keys. It returns the key that
+// has been used for the signature.
+func (s *MinisignSignature) Verify(keys []*MinisignKey, digest []byte) (*MinisignKey, error) {
+	var key *MinisignKey
+	for _, k := range keys {
+		if k.ID == s.KeyID {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return nil, ErrMinisignUnknownKey
+	}
+	if !ed25519.Verify(key.Key, digest, s.Signature) {
+		return nil, ErrMinisignInvalid
+	}
+	global := append(append([]byte{}, s.Signature...), s.TrustedComment...)
+	if !ed25519.Verify(key.Key, global, s.GlobalSignature) {
+		return nil, ErrMinisignInvalid
+	}
+	return key, nil
+}
+
+// SignMinisign creates a prehashed minisign signature of a file, from its
+// digest computed with NewMinisignHash.
+func SignMinisign(priv ed25519.PrivateKey, keyID [8]byte, digest []byte, trustedComment string) string {
+	sig := ed25519.Sign(priv, digest)
+	raw := append(append(append([]byte{}, minisignAlgPrehash...), keyID[:]...), sig...)
+	global := ed25519.Sign(priv, append(append([]byte{}, sig...), trustedComment...))
+	return untrustedPrefix + " signature from cozy-stack\n" +
+		base64.StdEncoding.EncodeToString(raw) + "\n" +
+		trustedPrefix + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+}
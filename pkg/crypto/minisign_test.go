@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMinisignKey(t *testing.T, id byte) (*MinisignKey, ed25519.PrivateKey, string) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	keyID := [8]byte{id, 1, 2, 3, 4, 5, 6, 7}
+	raw := append(append([]byte("Ed"), keyID[:]...), pub...)
+	encoded := "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(raw) + "\n"
+	return &MinisignKey{ID: keyID, Key: pub}, priv, encoded
+}
+
+func minisignDigest(content string) []byte {
+	h := NewMinisignHash()
+	_, _ = h.Write([]byte(content))
+	return h.Sum(nil)
+}
+
+func TestMinisign(t *testing.T) {
+	key, priv, encoded := newMinisignKey(t, 0)
+	other, _, _ := newMinisignKey(t, 1)
+
+	parsed, err := ParseMinisignKey(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, parsed.ID)
+	assert.Equal(t, key.Key, parsed.Key)
+	assert.Equal(t, "0001020304050607", parsed.KeyID())
+
+	signature := SignMinisign(priv, key.ID, minisignDigest("foo"), "timestamp:1600000000")
+	sig, err := ParseMinisignSignature(signature)
+	require.NoError(t, err)
+	assert.Equal(t, "timestamp:1600000000", sig.TrustedComment)
+
+	used, err := sig.Verify([]*MinisignKey{other, parsed}, minisignDigest("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, parsed, used)
+
+	_, err = sig.Verify([]*MinisignKey{parsed}, minisignDigest("bar"))
+	assert.Equal(t, ErrMinisignInvalid, err)
+	_, err = sig.Verify([]*MinisignKey{other}, minisignDigest("foo"))
+	assert.Equal(t, ErrMinisignUnknownKey, err)
+
+	// The trusted comment is signed too
+	tampered := strings.Replace(signature, "1600000000", "1700000000", 1)
+	sig, err = ParseMinisignSignature(tampered)
+	require.NoError(t, err)
+	_, err = sig.Verify([]*MinisignKey{parsed}, minisignDigest("foo"))
+	assert.Equal(t, ErrMinisignInvalid, err)
+
+	// Legacy signatures are refused
+	legacy := strings.Split(signature, "\n")
+	raw, _ := base64.StdEncoding.DecodeString(legacy[1])
+	raw[1] = 'd'
+	legacy[1] = base64.StdEncoding.EncodeToString(raw)
+	_, err = ParseMinisignSignature(strings.Join(legacy, "\n"))
+	assert.Equal(t, ErrMinisignLegacy, err)
+
+	_, err = ParseMinisignSignature("foo")
+	assert.Equal(t, ErrMinisignBadFormat, err)
+	_, err = ParseMinisignKey("foo")
+	assert.Equal(t, ErrMinisignBadFormat, err)
+}
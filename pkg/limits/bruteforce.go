@@ -0,0 +1,484 @@
+package limits
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/go-redis/redis/v8"
+)
+
+// The failed authentication attempts are counted per IP address (on all the
+// instances) and per instance. After a few free attempts, the next attempt is
+// delayed progressively: it is rejected if it comes too early. The delay for
+// an instance has a lower maximum than the delay for an IP address, so that
+// an attacker can't lock out the legitimate user, but can only slow them down.
+// Finally, an IP address is banned for some time, on all the instances, when
+// it has made too many failed attempts.
+//
+// To avoid a burst of concurrent attempts between the check and the record of
+// a failure, an attempt is counted as failed, and delays the next attempts,
+// from the moment it is allowed. It is given back if it has not failed.
+const (
+	bruteForcePeriod     = 1 * time.Hour
+	bruteForceFree       = 3
+	bruteForceBaseDelay  = 1 * time.Second
+	bruteForceIPMaxDelay = 1 * time.Minute
+	bruteForceInstMax    = 10 * time.Second
+	defaultBanDuration   = 1 * time.Hour
+)
+
+var (
+	// ErrIPBanned is returned when the IP address has been temporarily banned
+	// after too many failed authentication attempts.
+	ErrIPBanned = errors.New("IP address banned after too many failed attempts")
+	// ErrAttemptTooEarly is returned when an authentication attempt comes
+	// before the end of the delay after the last failed attempt.
+	ErrAttemptTooEarly = errors.New("Too many failed attempts, please wait")
+)
+
+// Ban is a temporary ban of an IP address.
+type Ban struct {
+	IP        string    `json:"ip"`
+	Attempts  int64     `json:"attempts"`
+	BannedAt  time.Time `json:"banned_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// bruteForceStore is used to persist the bans, the counters of failed
+// attempts, and the dates before which the next attempts are rejected. The
+// values expire after the given TTL.
+type bruteForceStore interface {
+	Set(key string, value []byte, ttl time.Duration) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+	Keys(prefix string) ([]string, error)
+	// Attempt checks that no next-attempt date in the future is set for the
+	// given keys. If so, it returns the duration to wait. Else, it increments
+	// the counters and sets the next-attempt dates for the delays, in a
+	// single atomic step.
+	Attempt(keys []attemptKeys) (time.Duration, error)
+	// Decrement decrements a counter, if it still exists.
+	Decrement(key string) error
+}
+
+// attemptKeys are the keys of a counter of failed attempts, and of the date
+// before which the next attempts are rejected, with the maximal delay.
+type attemptKeys struct {
+	counter  string
+	next     string
+	maxDelay time.Duration
+}
+
+var globalBruteForceStore bruteForceStore
+var globalBruteForceStoreMu sync.Mutex
+
+func getBruteForceStore() bruteForceStore {
+	globalBruteForceStoreMu.Lock()
+	defer globalBruteForceStoreMu.Unlock()
+	if globalBruteForceStore != nil {
+		return globalBruteForceStore
+	}
+	client := config.GetConfig().RateLimitingStorage.Client()
+	if client == nil {
+		globalBruteForceStore = newMemBruteForceStore()
+	} else {
+		globalBruteForceStore = &redisBruteForceStore{client, context.Background()}
+	}
+	return globalBruteForceStore
+}
+
+type memBruteForceValue struct {
+	val []byte
+	exp time.Time
+}
+
+type memBruteForceStore struct {
+	mu   sync.Mutex
+	vals map[string]memBruteForceValue
+}
+
+func newMemBruteForceStore() bruteForceStore {
+	return &memBruteForceStore{vals: make(map[string]memBruteForceValue)}
+}
+
+func (s *memBruteForceStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vals[key] = memBruteForceValue{val: value, exp: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memBruteForceStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key), nil
+}
+
+func (s *memBruteForceStore) get(key string) []byte {
+	v, ok := s.vals[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(v.exp) {
+		delete(s.vals, key)
+		return nil
+	}
+	return v.val
+}
+
+func (s *memBruteForceStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.vals, key)
+	return nil
+}
+
+func (s *memBruteForceStore) Keys(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var keys []string
+	for k, v := range s.vals {
+		if now.After(v.exp) {
+			delete(s.vals, k)
+		} else if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memBruteForceStore) Attempt(keys []attemptKeys) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	for _, k := range keys {
+		next, err := strconv.ParseInt(string(s.get(k.next)), 10, 64)
+		if err != nil {
+			continue
+		}
+		if d := time.Unix(0, next*int64(time.Millisecond)).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return wait, nil
+	}
+	for _, k := range keys {
+		failures, _ := strconv.ParseInt(string(s.get(k.counter)), 10, 64)
+		failures++
+		exp := now.Add(bruteForcePeriod)
+		if v, ok := s.vals[k.counter]; ok && v.exp.After(now) {
+			exp = v.exp
+		}
+		s.vals[k.counter] = memBruteForceValue{
+			val: []byte(strconv.FormatInt(failures, 10)),
+			exp: exp,
+		}
+		if delay := bruteForceDelay(failures, k.maxDelay); delay > 0 {
+			next := now.Add(delay).UnixNano() / int64(time.Millisecond)
+			s.vals[k.next] = memBruteForceValue{
+				val: []byte(strconv.FormatInt(next, 10)),
+				exp: now.Add(delay),
+			}
+		}
+	}
+	return 0, nil
+}
+
+func (s *memBruteForceStore) Decrement(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failures, err := strconv.ParseInt(string(s.get(key)), 10, 64)
+	if err != nil {
+		return nil
+	}
+	v := s.vals[key]
+	v.val = []byte(strconv.FormatInt(failures-1, 10))
+	s.vals[key] = v
+	return nil
+}
+
+type redisBruteForceStore struct {
+	c   redis.UniversalClient
+	ctx context.Context
+}
+
+func (s *redisBruteForceStore) Set(key string, value []byte, ttl time.Duration) error {
+	return s.c.Set(s.ctx, key, value, ttl).Err()
+}
+
+func (s *redisBruteForceStore) Get(key string) ([]byte, error) {
+	val, err := s.c.Get(s.ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return val, err
+}
+
+func (s *redisBruteForceStore) Delete(key string) error {
+	return s.c.Del(s.ctx, key).Err()
+}
+
+func (s *redisBruteForceStore) Keys(prefix string) ([]string, error) {
+	var keys []string
+	iter := s.c.Scan(s.ctx, 0, prefix+"*", 0).Iterator()
+	for iter.Next(s.ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// bruteForceAttempt is a lua script for redis to check and count an attempt
+// in a single step. KEYS are the pairs of counter and next-attempt keys, and
+// ARGV are the current time in milliseconds, the period of the counters in
+// seconds, the number of free attempts, the base delay, and then the maximal
+// delay for each pair, in milliseconds. The delays are computed like in
+// bruteForceDelay.
+const bruteForceAttempt = `
+local now = tonumber(ARGV[1])
+local wait = 0
+for i = 2, #KEYS, 2 do
+  local next = tonumber(redis.call("GET", KEYS[i]))
+  if next and next - now > wait then
+    wait = next - now
+  end
+end
+if wait > 0 then
+  return wait
+end
+local free = tonumber(ARGV[3])
+local base = tonumber(ARGV[4])
+for i = 1, #KEYS, 2 do
+  local failures = redis.call("INCR", KEYS[i])
+  if redis.call("TTL", KEYS[i]) == -1 then
+    redis.call("EXPIRE", KEYS[i], ARGV[2])
+  end
+  if failures > free then
+    local max = tonumber(ARGV[4 + (i + 1) / 2])
+    local delay = max
+    if failures - free - 1 <= 16 then
+      delay = math.min(base * 2 ^ (failures - free - 1), max)
+    end
+    redis.call("SET", KEYS[i + 1], now + delay, "PX", delay)
+  end
+end
+return 0
+`
+
+// decrementIfExists is a lua script for redis to decrement a counter, without
+// creating it if it has expired.
+const decrementIfExists = `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+  return redis.call("DECR", KEYS[1])
+end
+return 0
+`
+
+func (s *redisBruteForceStore) Attempt(keys []attemptKeys) (time.Duration, error) {
+	redisKeys := make([]string, 0, 2*len(keys))
+	args := []interface{}{
+		time.Now().UnixNano() / int64(time.Millisecond),
+		int64(bruteForcePeriod / time.Second),
+		bruteForceFree,
+		int64(bruteForceBaseDelay / time.Millisecond),
+	}
+	for _, k := range keys {
+		redisKeys = append(redisKeys, k.counter, k.next)
+		args = append(args, int64(k.maxDelay/time.Millisecond))
+	}
+	wait, err := s.c.Eval(s.ctx, bruteForceAttempt, redisKeys, args...).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+func (s *redisBruteForceStore) Decrement(key string) error {
+	return s.c.Eval(s.ctx, decrementIfExists, []string{key}).Err()
+}
+
+// The keys share the {bruteforce} hash tag, as the bruteForceAttempt script
+// uses several of them, and they must be in the same slot of a redis cluster.
+const (
+	bruteForceIPCounter   = "{bruteforce}-ip:"
+	bruteForceInstCounter = "{bruteforce}-instance:"
+	bruteForceIPNext      = "{bruteforce}-next-ip:"
+	bruteForceInstNext    = "{bruteforce}-next-instance:"
+	bruteForceBan         = "{bruteforce}-ban:"
+)
+
+// bruteForceDelay returns the delay to wait after the given number of failed
+// attempts, with an exponential backoff after the free attempts.
+func bruteForceDelay(failures int64, max time.Duration) time.Duration {
+	if failures <= bruteForceFree {
+		return 0
+	}
+	exp := failures - bruteForceFree - 1
+	if exp > 16 {
+		return max
+	}
+	delay := bruteForceBaseDelay << uint(exp)
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// CheckAuthAttempt must be called before checking the credentials sent by a
+// client. It returns ErrIPBanned if the IP address is banned, or
+// ErrAttemptTooEarly with the duration to wait if the attempt comes too soon
+// after the last failed attempt for this IP address or this instance.
+// Otherwise, the attempt is counted as failed until CancelAuthAttempt is
+// called, so that the concurrent attempts are delayed too.
+func CheckAuthAttempt(p prefixer.Prefixer, ip string) (time.Duration, error) {
+	cfg := config.GetConfig().BruteForce
+	if cfg.IsAllowed(ip) {
+		return 0, nil
+	}
+	store := getBruteForceStore()
+
+	ban, err := getBan(store, ip)
+	if err != nil {
+		return 0, err
+	}
+	if ban != nil {
+		return time.Until(ban.ExpiresAt), ErrIPBanned
+	}
+
+	wait, err := store.Attempt(authAttemptKeys(p, ip))
+	if err != nil {
+		return 0, err
+	}
+	if wait > 0 {
+		return wait, ErrAttemptTooEarly
+	}
+	return 0, nil
+}
+
+// CancelAuthAttempt gives back an attempt allowed by CheckAuthAttempt, when
+// the client has not sent invalid credentials.
+func CancelAuthAttempt(p prefixer.Prefixer, ip string) error {
+	cfg := config.GetConfig().BruteForce
+	if cfg.IsAllowed(ip) {
+		return nil
+	}
+	store := getBruteForceStore()
+	for _, k := range authAttemptKeys(p, ip) {
+		if err := store.Decrement(k.counter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordFailedAuth must be called when an attempt allowed by CheckAuthAttempt
+// has failed. The attempt has already been counted, and this function bans
+// the IP address if it has made too many failed attempts.
+func RecordFailedAuth(p prefixer.Prefixer, ip string) error {
+	cfg := config.GetConfig().BruteForce
+	if cfg.IsAllowed(ip) || cfg.IPLimit <= 0 {
+		return nil
+	}
+	store := getBruteForceStore()
+
+	val, err := store.Get(bruteForceIPCounter + ip)
+	if err != nil || val == nil {
+		return err
+	}
+	ipFailures, err := strconv.ParseInt(string(val), 10, 64)
+	if err != nil || ipFailures < cfg.IPLimit {
+		return nil
+	}
+
+	duration := cfg.BanDuration
+	if duration <= 0 {
+		duration = defaultBanDuration
+	}
+	now := time.Now().UTC()
+	ban := &Ban{
+		IP:        ip,
+		Attempts:  ipFailures,
+		BannedAt:  now,
+		ExpiresAt: now.Add(duration),
+	}
+	val, err = json.Marshal(ban)
+	if err != nil {
+		return err
+	}
+	return store.Set(bruteForceBan+ip, val, duration)
+}
+
+// ResetFailedAuth resets the counter of failed attempts for the instance,
+// after a successful authentication. The counter for the IP address is kept,
+// as an attacker can have their own instance.
+func ResetFailedAuth(p prefixer.Prefixer) {
+	store := getBruteForceStore()
+	_ = store.Delete(bruteForceInstCounter + p.DomainName())
+	_ = store.Delete(bruteForceInstNext + p.DomainName())
+}
+
+func authAttemptKeys(p prefixer.Prefixer, ip string) []attemptKeys {
+	return []attemptKeys{
+		{bruteForceIPCounter + ip, bruteForceIPNext + ip, bruteForceIPMaxDelay},
+		{bruteForceInstCounter + p.DomainName(), bruteForceInstNext + p.DomainName(), bruteForceInstMax},
+	}
+}
+
+func getBan(store bruteForceStore, ip string) (*Ban, error) {
+	val, err := store.Get(bruteForceBan + ip)
+	if err != nil || val == nil {
+		return nil, err
+	}
+	var ban Ban
+	if err := json.Unmarshal(val, &ban); err != nil {
+		return nil, err
+	}
+	return &ban, nil
+}
+
+// ListBans returns the IP addresses that are currently banned, the most
+// recent first.
+func ListBans() ([]*Ban, error) {
+	store := getBruteForceStore()
+	keys, err := store.Keys(bruteForceBan)
+	if err != nil {
+		return nil, err
+	}
+	bans := make([]*Ban, 0, len(keys))
+	for _, key := range keys {
+		ban, err := getBan(store, strings.TrimPrefix(key, bruteForceBan))
+		if err != nil {
+			return nil, err
+		}
+		if ban != nil {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].BannedAt.After(bans[j].BannedAt)
+	})
+	return bans, nil
+}
+
+// ClearBan removes the ban for the given IP address, and resets its counter
+// of failed attempts.
+func ClearBan(ip string) error {
+	store := getBruteForceStore()
+	if err := store.Delete(bruteForceBan + ip); err != nil {
+		return err
+	}
+	if err := store.Delete(bruteForceIPNext + ip); err != nil {
+		return err
+	}
+	return store.Delete(bruteForceIPCounter + ip)
+}
@@ -0,0 +1,144 @@
+package limits
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBruteForceDelay(t *testing.T) {
+	assert.Equal(t, time.Duration(0), bruteForceDelay(3, time.Minute))
+	assert.Equal(t, 1*time.Second, bruteForceDelay(4, time.Minute))
+	assert.Equal(t, 2*time.Second, bruteForceDelay(5, time.Minute))
+	assert.Equal(t, 8*time.Second, bruteForceDelay(7, time.Minute))
+	assert.Equal(t, time.Minute, bruteForceDelay(12, time.Minute))
+	assert.Equal(t, time.Minute, bruteForceDelay(1000, time.Minute))
+}
+
+func TestBruteForceMem(t *testing.T) {
+	config.UseTestFile()
+	conf := config.GetConfig()
+	conf.BruteForce.IPLimit = 6
+	conf.BruteForce.BanDuration = time.Hour
+	_, network, err := net.ParseCIDR("192.0.2.0/24")
+	require.NoError(t, err)
+	conf.BruteForce.Allowlist = []*net.IPNet{network}
+
+	globalBruteForceStore = newMemBruteForceStore()
+	ip := "203.0.113.42"
+
+	for i := 0; i < 3; i++ {
+		_, err := CheckAuthAttempt(testInstance, ip)
+		assert.NoError(t, err)
+		assert.NoError(t, RecordFailedAuth(testInstance, ip))
+	}
+	_, err = CheckAuthAttempt(testInstance, ip)
+	assert.NoError(t, err)
+	assert.NoError(t, RecordFailedAuth(testInstance, ip))
+
+	// The 5th attempt comes too early, for this IP and for this instance
+	wait, err := CheckAuthAttempt(testInstance, ip)
+	assert.Equal(t, ErrAttemptTooEarly, err)
+	assert.True(t, wait > 0 && wait <= time.Second)
+	_, err = CheckAuthAttempt(testInstance, "198.51.100.7")
+	assert.Equal(t, ErrAttemptTooEarly, err)
+
+	// The allowlisted IP addresses are never delayed
+	_, err = CheckAuthAttempt(testInstance, "192.0.2.12")
+	assert.NoError(t, err)
+
+	// A successful login resets the delay for the instance, but not for the IP
+	ResetFailedAuth(testInstance)
+	_, err = CheckAuthAttempt(testInstance, "198.51.100.7")
+	assert.NoError(t, err)
+	_, err = CheckAuthAttempt(testInstance, ip)
+	assert.Equal(t, ErrAttemptTooEarly, err)
+
+	// An attempt without invalid credentials is given back
+	assert.NoError(t, globalBruteForceStore.Delete(bruteForceIPNext+ip))
+	_, err = CheckAuthAttempt(testInstance, ip)
+	assert.NoError(t, err)
+	assert.NoError(t, CancelAuthAttempt(testInstance, ip))
+
+	// After too many failed attempts, the IP is banned
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, globalBruteForceStore.Delete(bruteForceIPNext+ip))
+		_, err = CheckAuthAttempt(testInstance, ip)
+		assert.NoError(t, err)
+		assert.NoError(t, RecordFailedAuth(testInstance, ip))
+	}
+	_, err = CheckAuthAttempt(testInstance, ip)
+	assert.Equal(t, ErrIPBanned, err)
+
+	bans, err := ListBans()
+	assert.NoError(t, err)
+	if assert.Len(t, bans, 1) {
+		assert.Equal(t, ip, bans[0].IP)
+		assert.EqualValues(t, 6, bans[0].Attempts)
+	}
+
+	assert.NoError(t, ClearBan(ip))
+	_, err = CheckAuthAttempt(testInstance, ip)
+	assert.NoError(t, err)
+	bans, err = ListBans()
+	assert.NoError(t, err)
+	assert.Len(t, bans, 0)
+}
+
+func TestBruteForceConcurrentAttemptsMem(t *testing.T) {
+	globalBruteForceStore = newMemBruteForceStore()
+	testConcurrentAttempts(t)
+}
+
+func TestBruteForceConcurrentAttemptsRedis(t *testing.T) {
+	opts, _ := redis.ParseURL(redisURL)
+	client := redis.NewClient(opts)
+	store := &redisBruteForceStore{client, context.Background()}
+	for _, k := range authAttemptKeys(testInstance, "203.0.113.43") {
+		client.Del(context.Background(), k.counter, k.next)
+	}
+	globalBruteForceStore = store
+	testConcurrentAttempts(t)
+
+	// The attempt is given back, but the delay is kept
+	assert.NoError(t, CancelAuthAttempt(testInstance, "203.0.113.43"))
+	val, err := store.Get(bruteForceIPCounter + "203.0.113.43")
+	assert.NoError(t, err)
+	assert.Equal(t, "3", string(val))
+	wait, err := CheckAuthAttempt(testInstance, "203.0.113.43")
+	assert.Equal(t, ErrAttemptTooEarly, err)
+	assert.True(t, wait > 0 && wait <= time.Second)
+}
+
+// testConcurrentAttempts checks that the attempts are counted when they are
+// allowed, so that only the free attempts and the next one can be made
+// concurrently.
+func testConcurrentAttempts(t *testing.T) {
+	config.UseTestFile()
+	conf := config.GetConfig()
+	conf.BruteForce.IPLimit = 50
+	conf.BruteForce.Allowlist = nil
+	ip := "203.0.113.43"
+
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := CheckAuthAttempt(testInstance, ip); err == nil {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, bruteForceFree+1, allowed)
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// LocalScheme is the URL scheme for the registry built in the stack. The host
+// of the URL is ignored, for example local://registry/.
+const LocalScheme = "local"
+
+var registerLocalOnce sync.Once
+
+// RegisterLocal makes the given handler respond to the requests made with the
+// local:// scheme by the HTTP clients that use the default transport, like the
+// ones used for the registries and for downloading the tarballs.
+func RegisterLocal(h http.Handler) {
+	registerLocalOnce.Do(func() {
+		if t, ok := http.DefaultTransport.(*http.Transport); ok {
+			t.RegisterProtocol(LocalScheme, &localTransport{handler: h})
+		}
+	})
+}
+
+type localTransport struct {
+	handler http.Handler
+}
+
+// RoundTrip executes the handler in a goroutine, and returns the response as
+// soon as its headers have been written. The body is streamed via a pipe, and
+// closing it stops the handler.
+func (t *localTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.RequestURI = req.URL.RequestURI()
+	r.Host = req.URL.Host
+	pr, pw := io.Pipe()
+	w := &localResponse{
+		header: make(http.Header),
+		body:   pw,
+		ready:  make(chan struct{}),
+	}
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				_ = pw.CloseWithError(fmt.Errorf("registry: panic in the local handler: %v", rec))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			_ = pw.Close()
+		}()
+		t.handler.ServeHTTP(w, r)
+	}()
+	<-w.ready
+
+	length := int64(-1)
+	if cl := w.sent.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil {
+			length = n
+		}
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(w.status) + " " + http.StatusText(w.status),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.sent,
+		Body:          pr,
+		ContentLength: length,
+		Request:       req,
+	}, nil
+}
+
+// localResponse is a http.ResponseWriter that writes the body of the response
+// in a pipe.
+type localResponse struct {
+	header http.Header
+	sent   http.Header
+	status int
+	body   *io.PipeWriter
+	ready  chan struct{}
+}
+
+func (w *localResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *localResponse) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	w.sent = w.header.Clone()
+	close(w.ready)
+}
+
+func (w *localResponse) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
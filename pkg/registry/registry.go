@@ -32,6 +32,10 @@ type Version struct {
 	Size      string          `json:"size"`
 	Manifest  json.RawMessage `json:"manifest"`
 	TarPrefix string          `json:"tar_prefix"`
+	// Signature is an optional minisign signature of the tarball
+	Signature string `json:"signature,omitempty"`
+	// Registry is the URL of the registry that has given this version
+	Registry *url.URL `json:"-"`
 }
 
 // A MaintenanceOptions defines options about a maintenance
@@ -102,6 +106,7 @@ func GetVersion(slug, version string, registries []*url.URL) (*Version, error) {
 	if err = json.NewDecoder(resp.Body).Decode(&v); err != nil {
 		return nil, err
 	}
+	v.Registry = findRegistry(resp, registries)
 	return v, nil
 }
 
@@ -124,6 +129,7 @@ func GetLatestVersion(slug, channel string, registries []*url.URL) (*Version, er
 	if err = json.NewDecoder(resp.Body).Decode(&v); err != nil {
 		return nil, err
 	}
+	v.Registry = findRegistry(resp, registries)
 	return v, nil
 }
 
@@ -461,6 +467,20 @@ func (a *appsList) Paginated(sortBy string, reverse bool, limit int) *AppsPagina
 	}
 }
 
+// findRegistry returns the registry that has given the response.
+func findRegistry(resp *http.Response, registries []*url.URL) *url.URL {
+	if resp.Request == nil {
+		return nil
+	}
+	u := resp.Request.URL.String()
+	for _, registry := range registries {
+		if strings.HasPrefix(u, strings.TrimSuffix(registry.String(), "/")+"/") {
+			return registry
+		}
+	}
+	return nil
+}
+
 func fetchUntilFound(client *http.Client, registries []*url.URL, requestURI string, cache CacheControl) (resp *http.Response, ok bool, err error) {
 	ref, err := url.Parse(requestURI)
 	if err != nil {
@@ -5,6 +5,7 @@ package apps
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -213,6 +214,43 @@ func updateHandler(installerType consts.AppType) echo.HandlerFunc {
 	}
 }
 
+// approveHandler handles all POST /:slug/approve requests, used to install
+// the pending update of an application after the user has approved its new
+// permissions.
+func approveHandler(installerType consts.AppType) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		instance := middlewares.GetInstance(c)
+		slug := c.Param("slug")
+		source := "registry://" + slug
+		if err := middlewares.AllowInstallApp(c, installerType, source, permission.POST); err != nil {
+			return err
+		}
+
+		var w http.ResponseWriter
+		isEventStream := c.Request().Header.Get("Accept") == typeTextEventStream
+		inst, err := app.NewInstaller(instance, app.Copier(installerType, instance),
+			&app.InstallerOptions{
+				Operation:  app.Update,
+				Type:       installerType,
+				Slug:       slug,
+				Registries: instance.Registries(),
+				Approve:    true,
+			},
+		)
+		if err != nil {
+			return wrapAppsError(err)
+		}
+		if isEventStream {
+			w = c.Response().Writer
+			w.Header().Set("Content-Type", typeTextEventStream)
+			w.WriteHeader(200)
+		}
+
+		go inst.Run()
+		return pollInstaller(c, instance, isEventStream, w, slug, inst)
+	}
+}
+
 // deleteHandler handles all DELETE /:slug used to delete an application with
 // the specified slug.
 func deleteHandler(installerType consts.AppType) echo.HandlerFunc {
@@ -267,6 +305,24 @@ func deleteHandler(installerType consts.AppType) echo.HandlerFunc {
 	}
 }
 
+// rollbackHandler handles all POST /:slug/rollback requests, used to go back
+// to the previous version of an application.
+func rollbackHandler(installerType consts.AppType) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		instance := middlewares.GetInstance(c)
+		slug := c.Param("slug")
+		source := "registry://" + slug
+		if err := middlewares.AllowInstallApp(c, installerType, source, permission.POST); err != nil {
+			return err
+		}
+		man, err := app.Rollback(instance, app.Copier(installerType, instance), installerType, slug)
+		if err != nil {
+			return wrapAppsError(err)
+		}
+		return jsonapi.Data(c, http.StatusOK, &apiApp{man}, nil)
+	}
+}
+
 func findAccountsToDelete(instance *instance.Instance, slug string) ([]account.CleanEntry, error) {
 	jobsSystem := job.System()
 	triggers, err := jobsSystem.GetAllTriggers(instance)
@@ -590,6 +646,8 @@ func WebappsRoutes(router *echo.Group) {
 	router.POST("/:slug", installHandler(consts.WebappType))
 	router.PUT("/:slug", updateHandler(consts.WebappType))
 	router.DELETE("/:slug", deleteHandler(consts.WebappType))
+	router.POST("/:slug/approve", approveHandler(consts.WebappType))
+	router.POST("/:slug/rollback", rollbackHandler(consts.WebappType))
 	router.GET("/:slug/icon", iconHandler(consts.WebappType))
 	router.GET("/:slug/icon/:version", iconHandler(consts.WebappType))
 }
@@ -601,6 +659,8 @@ func KonnectorRoutes(router *echo.Group) {
 	router.POST("/:slug", installHandler(consts.KonnectorType))
 	router.PUT("/:slug", updateHandler(consts.KonnectorType))
 	router.DELETE("/:slug", deleteHandler(consts.KonnectorType))
+	router.POST("/:slug/approve", approveHandler(consts.KonnectorType))
+	router.POST("/:slug/rollback", rollbackHandler(consts.KonnectorType))
 	router.GET("/:slug/icon", iconHandler(consts.KonnectorType))
 	router.GET("/:slug/icon/:version", iconHandler(consts.KonnectorType))
 	router.POST("/:slug/trigger", createTrigger)
@@ -620,16 +680,28 @@ func wrapAppsError(err error) error {
 		return jsonapi.NotFound(err)
 	case app.ErrSourceNotReachable:
 		return jsonapi.BadRequest(err)
-	case app.ErrBadManifest:
+	case app.ErrBadManifest, app.ErrInvalidRoute:
 		return jsonapi.BadRequest(err)
 	case app.ErrMissingSource:
 		return jsonapi.BadRequest(err)
 	case app.ErrLinkedAppExists:
 		return jsonapi.BadRequest(err)
+	case app.ErrNoPreviousVersion, app.ErrNoPendingUpdate:
+		return jsonapi.NotFound(err)
+	case app.ErrBadState:
+		return jsonapi.Conflict(err)
+	case app.ErrMissingSignature, app.ErrManifestMismatch:
+		return jsonapi.Forbidden(err)
 	case limits.ErrRateLimitReached,
 		limits.ErrRateLimitExceeded:
 		return jsonapi.BadRequest(err)
 	}
+	if errors.Is(err, app.ErrHealthCheck) {
+		return jsonapi.NewError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if errors.Is(err, app.ErrBadSignature) {
+		return jsonapi.Forbidden(err)
+	}
 	if _, ok := err.(*url.Error); ok {
 		return jsonapi.InvalidParameter("Source", err)
 	}
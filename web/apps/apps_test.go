@@ -512,7 +512,7 @@ func TestMain(m *testing.M) {
 	ts = setup.GetTestServer("/apps", webApps.WebappsRoutes, func(r *echo.Echo) *echo.Echo {
 		r.POST("/login", func(c echo.Context) error {
 			longRunSession := true
-			sess, _ := session.New(testInstance, longRunSession)
+			sess, _ := session.New(testInstance, longRunSession, nil, "")
 			cookie, _ := sess.ToCookie()
 			c.SetCookie(cookie)
 			return c.HTML(http.StatusOK, "OK")
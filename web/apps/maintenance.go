@@ -9,7 +9,6 @@ import (
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
-	"github.com/cozy/cozy-stack/pkg/registry"
 	"github.com/labstack/echo/v4"
 )
 
@@ -52,7 +51,7 @@ func listMaintenance(c echo.Context) error {
 		if !ok {
 			registries = contexts[config.DefaultInstanceContext]
 		}
-		apps, err := registry.ProxyMaintenance(registries)
+		apps, err := app.ListRegistryMaintenance(registries)
 		if err != nil {
 			return err
 		}
@@ -76,6 +75,9 @@ func activateMaintenance(c echo.Context) error {
 		return err
 	}
 	if err := app.ActivateMaintenance(slug, doc.M); err != nil {
+		if err == app.ErrInvalidMaintenanceWindow {
+			return jsonapi.BadRequest(err)
+		}
 		return err
 	}
 	return c.NoContent(http.StatusNoContent)
@@ -204,6 +204,11 @@ func ServeAppFile(c echo.Context, i *instance.Instance, fs appfs.FileServer, web
 		return c.Redirect(http.StatusFound, i.PageURL("/auth/login", params))
 	}
 
+	if route.Redirect != "" {
+		return c.Redirect(route.RedirectCode(), route.Redirect)
+	}
+	applyRouteHeaders(c, i, route)
+
 	version := webapp.Version()
 	shasum := webapp.Checksum()
 
@@ -302,6 +307,19 @@ func ServeAppFile(c echo.Context, i *instance.Instance, fs appfs.FileServer, web
 	})
 }
 
+// applyRouteHeaders adds the headers and the CSP extensions declared in the
+// manifest for the route. They are added before the headers set by the stack
+// for the file, which have the precedence.
+func applyRouteHeaders(c echo.Context, i *instance.Instance, route app.Route) {
+	h := c.Response().Header()
+	for name, value := range route.Headers {
+		h.Set(name, value)
+	}
+	for directive, sources := range route.AllowedCSP(i) {
+		middlewares.ExtendCSPRule(c, directive, sources...)
+	}
+}
+
 func renderMovedLink(c echo.Context, i *instance.Instance, to, subdomainType string) error {
 	name, _ := i.PublicName()
 	link := *c.Request().URL
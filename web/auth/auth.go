@@ -13,6 +13,7 @@ import (
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/oauth"
 	"github.com/cozy/cozy-stack/model/session"
+	"github.com/cozy/cozy-stack/model/webauthn"
 	build "github.com/cozy/cozy-stack/pkg/config"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/crypto"
@@ -105,7 +106,7 @@ func Home(c echo.Context) error {
 // SetCookieForNewSession creates a new session and sets the cookie on echo context
 func SetCookieForNewSession(c echo.Context, longRunSession bool) (string, error) {
 	instance := middlewares.GetInstance(c)
-	session, err := session.New(instance, longRunSession)
+	session, err := session.New(instance, longRunSession, c.Request(), middlewares.ClientIP(c))
 	if err != nil {
 		return "", err
 	}
@@ -217,6 +218,7 @@ func renderLoginForm(c echo.Context, i *instance.Instance, code int, credsErrors
 		"Redirect":         redirectStr,
 		"CSRF":             c.Get("csrf"),
 		"OAuth":            hasOAuth,
+		"WebAuthn":         webauthn.HasCredentials(i),
 	})
 }
 
@@ -244,7 +246,7 @@ func loginForm(c echo.Context) error {
 			if err != nil {
 				return err
 			}
-			if err = session.StoreNewLoginEntry(instance, sessionID, "", c.Request(), "JWT", true); err != nil {
+			if err = session.StoreNewLoginEntry(instance, sessionID, "", c.Request(), middlewares.ClientIP(c), "JWT", true); err != nil {
 				instance.Logger().Errorf("Could not store session history %q: %s", sessionID, err)
 			}
 			if redirect == nil {
@@ -275,9 +277,10 @@ func newSession(c echo.Context, inst *instance.Instance, redirect *url.URL, long
 		}
 	}
 
-	if err = session.StoreNewLoginEntry(inst, sessionID, clientID, c.Request(), logMessage, true); err != nil {
+	if err = session.StoreNewLoginEntry(inst, sessionID, clientID, c.Request(), middlewares.ClientIP(c), logMessage, true); err != nil {
 		inst.Logger().Errorf("Could not store session history %q: %s", sessionID, err)
 	}
+	limits.ResetFailedAuth(inst)
 
 	return nil
 }
@@ -315,6 +318,22 @@ func migrateToHashedPassphrase(inst *instance.Instance, settings *settings.Setti
 	}
 }
 
+// unlockCredentialsVault unlocks the credentials vault of the instance for
+// the konnectors, as the passphrase of the user is known at login. When a
+// second factor is needed, the vault is unlocked only after its check.
+func unlockCredentialsVault(inst *instance.Instance, passphrase, twoFactorToken []byte) {
+	var err error
+	if twoFactorToken != nil {
+		err = settings.PrepareCredentialsVaultUnlock(inst, passphrase, twoFactorToken)
+	} else {
+		err = settings.UnlockCredentialsVaultAtLogin(inst, passphrase)
+	}
+	if err != nil {
+		inst.Logger().WithNamespace("auth").
+			Warnf("Cannot unlock the credentials vault: %s", err)
+	}
+}
+
 func login(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
 
@@ -339,19 +358,24 @@ func login(c echo.Context) error {
 		}
 		settings, err := settings.Get(inst)
 		// If the passphrase was not yet hashed on the client side, migrate it
+		hashed := true
 		if err == nil && settings.PassphraseKdfIterations == 0 {
 			migrateToHashedPassphrase(inst, settings, passphrase, iterations)
+			hashed = false
 		}
 
 		// In case the second factor authentication mode is "mail", we also
 		// check that the mail has been confirmed. If not, 2FA is not
 		// activated.
 		// If device is trusted, skip the 2FA.
-		if inst.HasAuthMode(instance.TwoFactorMail) && !isTrustedDevice(c, inst) {
+		if inst.HasTwoFactor() && !isTrustedDevice(c, inst) {
 			twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
 			if err != nil {
 				return err
 			}
+			if hashed {
+				unlockCredentialsVault(inst, passphrase, twoFactorToken)
+			}
 			v := url.Values{}
 			v.Add("two_factor_token", string(twoFactorToken))
 			v.Add("long_run_session", strconv.FormatBool(longRunSession))
@@ -366,14 +390,12 @@ func login(c echo.Context) error {
 			}
 			return c.Redirect(http.StatusSeeOther, inst.PageURL("/auth/twofactor", v))
 		}
+		if hashed {
+			unlockCredentialsVault(inst, passphrase, nil)
+		}
 	} else { // Bad login passphrase
 		errorMessage := inst.Translate(CredentialsErrorKey)
-		err := limits.CheckRateLimit(inst, limits.AuthType)
-		if limits.IsLimitReachedOrExceeded(err) {
-			if err = LoginRateExceeded(inst); err != nil {
-				inst.Logger().WithNamespace("auth").Warn(err.Error())
-			}
-		}
+		middlewares.RecordFailedAuth(c, inst)
 		if wantsJSON(c) {
 			return c.JSON(http.StatusUnauthorized, echo.Map{
 				"error": errorMessage,
@@ -575,7 +597,7 @@ func Routes(router *echo.Group) {
 
 	// Login/logout
 	router.GET("/login", loginForm, noCSRF, middlewares.CheckOnboardingNotFinished)
-	router.POST("/login", login, noCSRF, middlewares.CheckOnboardingNotFinished)
+	router.POST("/login", login, noCSRF, middlewares.CheckOnboardingNotFinished, middlewares.CheckBruteForce)
 	router.DELETE("/login/others", logoutOthers)
 	router.OPTIONS("/login/others", logoutPreflight)
 	router.DELETE("/login", logout)
@@ -583,15 +605,15 @@ func Routes(router *echo.Group) {
 
 	// Passphrase
 	router.GET("/passphrase_reset", passphraseResetForm, noCSRF)
-	router.POST("/passphrase_reset", passphraseReset, noCSRF)
+	router.POST("/passphrase_reset", passphraseReset, noCSRF, middlewares.CheckBruteForce)
 	router.GET("/passphrase_renew", passphraseRenewForm, noCSRF)
-	router.POST("/passphrase_renew", passphraseRenew, noCSRF)
+	router.POST("/passphrase_renew", passphraseRenew, noCSRF, middlewares.CheckBruteForce)
 	router.GET("/passphrase", passphraseForm, noCSRF)
 	router.POST("/hint", sendHint)
 
 	// Confirmation by typing
 	router.GET("/confirm", confirmForm, noCSRF)
-	router.POST("/confirm", confirmAuth, noCSRF)
+	router.POST("/confirm", confirmAuth, noCSRF, middlewares.CheckBruteForce)
 	router.GET("/confirm/:code", confirmCode)
 
 	// Register OAuth clients
@@ -608,12 +630,21 @@ func Routes(router *echo.Group) {
 	authorizeGroup.POST("/sharing", authorizeSharing)
 	authorizeGroup.GET("/sharing/:sharing-id/cancel", cancelAuthorizeSharing)
 	authorizeGroup.GET("/move", authorizeMoveForm)
-	authorizeGroup.POST("/move", authorizeMove)
+	authorizeGroup.POST("/move", authorizeMove, middlewares.CheckBruteForce)
 
 	router.POST("/access_token", accessToken)
+	router.POST("/revoke", revokeToken)
+	router.POST("/introspect", introspectToken)
 	router.POST("/secret_exchange", secretExchange)
 
 	// 2FA
 	router.GET("/twofactor", twoFactorForm)
-	router.POST("/twofactor", twoFactor)
+	router.POST("/twofactor", twoFactor, middlewares.CheckBruteForce)
+
+	// WebAuthn
+	router.GET("/webauthn", webauthnOptions)
+	router.POST("/webauthn/login", webauthnLogin, noCSRF, middlewares.CheckOnboardingNotFinished, middlewares.CheckBruteForce)
+	router.GET("/webauthn/register", webauthnRegisterForm, noCSRF)
+	router.POST("/webauthn/registration", webauthnRegistration, noCSRF, middlewares.CheckBruteForce)
+	router.POST("/webauthn/register", webauthnRegister, noCSRF)
 }
@@ -62,6 +62,12 @@ var linkedClientID string
 var linkedClientSecret string
 var linkedCode string
 var confirmCode string
+var publicClientID string
+var publicCode string
+var publicRefreshToken string
+
+const codeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+const codeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
 
 func getSessionID(cookies []*http.Cookie) string {
 	for _, c := range cookies {
@@ -1256,6 +1262,130 @@ func TestRefreshTokenSuccess(t *testing.T) {
 	assertValidToken(t, response["access_token"], "access", clientID, "files:read")
 }
 
+func TestRegisterPublicClient(t *testing.T) {
+	res, err := postJSON("/auth/register", echo.Map{
+		"redirect_uris":              []string{"https://example.org/oauth/callback"},
+		"client_name":                "cozy-test-public",
+		"software_id":                "github.com/cozy/cozy-test-public",
+		"token_endpoint_auth_method": "none",
+	})
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "201 Created", res.Status)
+	var client oauth.Client
+	err = json.NewDecoder(res.Body).Decode(&client)
+	assert.NoError(t, err)
+	assert.True(t, client.IsPublic())
+	publicClientID = client.ClientID
+}
+
+func TestAuthorizeFormPublicClientWithoutCodeChallenge(t *testing.T) {
+	u := url.QueryEscape("https://example.org/oauth/callback")
+	req, _ := http.NewRequest("GET", ts.URL+"/auth/authorize?response_type=code&state=123456&scope=files:read&redirect_uri="+u+"&client_id="+publicClientID, nil)
+	req.Host = domain
+	res, err := client.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "400 Bad Request", res.Status)
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Contains(t, string(body), "code_challenge")
+}
+
+func TestAuthorizePublicClientWithCodeChallenge(t *testing.T) {
+	res, err := postForm("/auth/authorize", &url.Values{
+		"state":                 {"123456"},
+		"client_id":             {publicClientID},
+		"redirect_uri":          {"https://example.org/oauth/callback"},
+		"scope":                 {"files:read"},
+		"csrf_token":            {csrfToken},
+		"response_type":         {"code"},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	})
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	if assert.Equal(t, "302 Found", res.Status) {
+		location, err := url.Parse(res.Header.Get("Location"))
+		assert.NoError(t, err)
+		publicCode = location.Query().Get("code")
+		assert.NotEmpty(t, publicCode)
+	}
+}
+
+func TestAccessTokenPublicClientWrongCodeVerifier(t *testing.T) {
+	res, err := postForm("/auth/access_token", &url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {publicClientID},
+		"code":          {publicCode},
+		"code_verifier": {"wrong-code-verifier-0123456789-0123456789-0123456789"},
+	})
+	assert.NoError(t, err)
+	assertJSONError(t, res, "invalid code_verifier")
+}
+
+func TestAccessTokenPublicClientWithoutClientSecret(t *testing.T) {
+	res, err := postForm("/auth/access_token", &url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {publicClientID},
+		"code":          {publicCode},
+		"code_verifier": {codeVerifier},
+	})
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "200 OK", res.Status)
+	var response map[string]string
+	err = json.NewDecoder(res.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Equal(t, "files:read", response["scope"])
+	assertValidToken(t, response["access_token"], "access", publicClientID, "files:read")
+	assertValidToken(t, response["refresh_token"], "refresh", publicClientID, "files:read")
+	publicRefreshToken = response["refresh_token"]
+}
+
+func TestRevokeTokenOfAnotherClient(t *testing.T) {
+	res, err := postForm("/auth/revoke", &url.Values{
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"token":         {publicRefreshToken},
+	})
+	assert.NoError(t, err)
+	assertJSONError(t, res, "unauthorized_client")
+}
+
+func TestIntrospectTokenOfAnotherClient(t *testing.T) {
+	res, err := postForm("/auth/introspect", &url.Values{
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"token":         {publicRefreshToken},
+	})
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "200 OK", res.Status)
+	var response map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Equal(t, false, response["active"])
+	assert.NotContains(t, response, "client_id")
+}
+
+func TestRevokeOwnRefreshToken(t *testing.T) {
+	res, err := postForm("/auth/revoke", &url.Values{
+		"client_id": {publicClientID},
+		"token":     {publicRefreshToken},
+	})
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "200 OK", res.Status)
+
+	res, err = postForm("/auth/access_token", &url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {publicClientID},
+		"refresh_token": {publicRefreshToken},
+	})
+	assert.NoError(t, err)
+	assertJSONError(t, res, "invalid refresh token")
+}
+
 func TestAppRedirectionOnLogin(t *testing.T) {
 	req, _ := http.NewRequest("GET", ts.URL+"/auth/login?redirect=drive/%23/foobar", nil)
 	req.Host = domain
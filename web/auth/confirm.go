@@ -9,7 +9,6 @@ import (
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
-	"github.com/cozy/cozy-stack/pkg/limits"
 	"github.com/cozy/cozy-stack/pkg/realtime"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/labstack/echo/v4"
@@ -57,19 +56,13 @@ func confirmAuth(c echo.Context) error {
 	// Check passphrase
 	passphrase := []byte(c.FormValue("passphrase"))
 	if lifecycle.CheckPassphrase(inst, passphrase) != nil {
-		errorMessage := inst.Translate(CredentialsErrorKey)
-		err := limits.CheckRateLimit(inst, limits.AuthType)
-		if limits.IsLimitReachedOrExceeded(err) {
-			if err = LoginRateExceeded(inst); err != nil {
-				inst.Logger().WithNamespace("auth").Warn(err.Error())
-			}
-		}
+		middlewares.RecordFailedAuth(c, inst)
 		return c.JSON(http.StatusUnauthorized, echo.Map{
-			"error": errorMessage,
+			"error": inst.Translate(CredentialsErrorKey),
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) && !isTrustedDevice(c, inst) {
+	if inst.HasTwoFactor() && !isTrustedDevice(c, inst) {
 		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
 		if err != nil {
 			return err
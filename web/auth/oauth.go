@@ -24,7 +24,6 @@ import (
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
-	"github.com/cozy/cozy-stack/pkg/limits"
 	"github.com/cozy/cozy-stack/pkg/registry"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/labstack/echo/v4"
@@ -42,6 +41,8 @@ type authorizeParams struct {
 	redirectURI string
 	scope       string
 	resType     string
+	challenge   string
+	method      string
 	client      *oauth.Client
 	webapp      *webappParams
 }
@@ -69,6 +70,18 @@ func checkAuthorizeParams(c echo.Context, params *authorizeParams) (bool, error)
 		return true, renderError(c, http.StatusBadRequest, "Error Incorrect redirect_uri")
 	}
 
+	// PKCE is mandatory for the public clients, and optional for the others
+	if params.challenge == "" {
+		if params.client.IsPublic() {
+			return true, renderError(c, http.StatusBadRequest, "Error No code_challenge parameter")
+		}
+	} else if err := oauth.CheckCodeChallenge(params.challenge, params.method); err != nil {
+		if err == oauth.ErrInvalidChallengeMethod {
+			return true, renderError(c, http.StatusBadRequest, "Error Invalid code_challenge_method")
+		}
+		return true, renderError(c, http.StatusBadRequest, "Error Invalid code_challenge")
+	}
+
 	if appSlug := oauth.GetLinkedAppSlug(params.client.SoftwareID); appSlug != "" {
 		webapp, err := registry.GetLatestVersion(appSlug, "stable", params.instance.Registries())
 
@@ -116,6 +129,8 @@ func authorizeForm(c echo.Context) error {
 		redirectURI: c.QueryParam("redirect_uri"),
 		scope:       c.QueryParam("scope"),
 		resType:     c.QueryParam("response_type"),
+		challenge:   c.QueryParam("code_challenge"),
+		method:      c.QueryParam("code_challenge_method"),
 	}
 
 	if hasError, err := checkAuthorizeParams(c, &params); hasError {
@@ -133,7 +148,7 @@ func authorizeForm(c echo.Context) error {
 	// for the manager. It does not require any authorization from the user, and
 	// generate a code without asking any permission.
 	if params.scope == oauth.ScopeLogin {
-		access, err := oauth.CreateAccessCode(params.instance, params.client, "" /* = scope */)
+		access, err := oauth.CreateAccessCode(params.instance, params.client, "" /* = scope */, params.challenge)
 		if err != nil {
 			return err
 		}
@@ -204,6 +219,8 @@ func authorizeForm(c echo.Context) error {
 		"State":            params.state,
 		"RedirectURI":      params.redirectURI,
 		"Scope":            params.scope,
+		"CodeChallenge":    params.challenge,
+		"ChallengeMethod":  params.method,
 		"Permissions":      permissions,
 		"ReadOnly":         readOnly,
 		"CSRF":             c.Get("csrf"),
@@ -221,6 +238,8 @@ func authorize(c echo.Context) error {
 		redirectURI: c.FormValue("redirect_uri"),
 		scope:       c.FormValue("scope"),
 		resType:     c.FormValue("response_type"),
+		challenge:   c.FormValue("code_challenge"),
+		method:      c.FormValue("code_challenge_method"),
 	}
 
 	if hasError, err := checkAuthorizeParams(c, &params); hasError {
@@ -269,19 +288,12 @@ func authorize(c echo.Context) error {
 		}
 	}
 
-	access, err := oauth.CreateAccessCode(params.instance, params.client, params.scope)
+	access, err := oauth.CreateAccessCode(params.instance, params.client, params.scope, params.challenge)
 	if err != nil {
 		return err
 	}
-	var ip string
-	if forwardedFor := c.Request().Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		ip = strings.TrimSpace(strings.SplitN(forwardedFor, ",", 2)[0])
-	}
-	if ip == "" {
-		ip = strings.Split(c.Request().RemoteAddr, ":")[0]
-	}
 	instance.Logger().WithNamespace("loginaudit").
-		Infof("Access code created from %s at %s with scope %s", ip, time.Now(), access.Scope)
+		Infof("Access code created from %s at %s with scope %s", middlewares.ClientIP(c), time.Now(), access.Scope)
 
 	// We should be sending "code" only, but for compatibility reason, we keep
 	// the access_code parameter that we used to send in our first impl.
@@ -555,19 +567,13 @@ func authorizeMove(c echo.Context) error {
 	// Check passphrase
 	passphrase := []byte(c.FormValue("passphrase"))
 	if lifecycle.CheckPassphrase(inst, passphrase) != nil {
-		errorMessage := inst.Translate(CredentialsErrorKey)
-		err := limits.CheckRateLimit(inst, limits.AuthType)
-		if limits.IsLimitReachedOrExceeded(err) {
-			if err = LoginRateExceeded(inst); err != nil {
-				inst.Logger().WithNamespace("auth").Warn(err.Error())
-			}
-		}
+		middlewares.RecordFailedAuth(c, inst)
 		return c.JSON(http.StatusUnauthorized, echo.Map{
-			"error": errorMessage,
+			"error": inst.Translate(CredentialsErrorKey),
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) && !isTrustedDevice(c, inst) {
+	if inst.HasTwoFactor() && !isTrustedDevice(c, inst) {
 		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
 		if err != nil {
 			return err
@@ -610,7 +616,7 @@ func moveSuccessURI(c echo.Context) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	access, err := oauth.CreateAccessCode(inst, client, move.MoveScope)
+	access, err := oauth.CreateAccessCode(inst, client, move.MoveScope, "")
 	if err != nil {
 		return "", err
 	}
@@ -671,11 +677,6 @@ func accessToken(c echo.Context) error {
 			"error": "the client_id parameter is mandatory",
 		})
 	}
-	if clientSecret == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error": "the client_secret parameter is mandatory",
-		})
-	}
 
 	client, err := oauth.FindClient(instance, clientID)
 	if err != nil {
@@ -686,7 +687,14 @@ func accessToken(c echo.Context) error {
 			"error": "the client must be registered",
 		})
 	}
-	if subtle.ConstantTimeCompare([]byte(clientSecret), []byte(client.ClientSecret)) == 0 {
+
+	// The public clients can't keep a secret, they use PKCE instead
+	if clientSecret == "" && !client.IsPublic() {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "the client_secret parameter is mandatory",
+		})
+	}
+	if clientSecret != "" && subtle.ConstantTimeCompare([]byte(clientSecret), []byte(client.ClientSecret)) == 0 {
 		return c.JSON(http.StatusBadRequest, echo.Map{
 			"error": "invalid client_secret",
 		})
@@ -716,6 +724,24 @@ func accessToken(c echo.Context) error {
 				"error": "invalid code",
 			})
 		}
+		if accessCode.ClientID != client.ClientID {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "invalid code",
+			})
+		}
+		if accessCode.Challenge != "" || client.IsPublic() {
+			verifier := c.FormValue("code_verifier")
+			if verifier == "" {
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"error": "the code_verifier parameter is mandatory",
+				})
+			}
+			if !oauth.VerifyCodeChallenge(accessCode.Challenge, verifier) {
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"error": "invalid code_verifier",
+				})
+			}
+		}
 		out.Scope = accessCode.Scope
 		out.Refresh, err = client.CreateJWT(instance, consts.RefreshTokenAudience, out.Scope)
 		if err != nil {
@@ -732,7 +758,11 @@ func accessToken(c echo.Context) error {
 
 	case "refresh_token":
 		token := c.FormValue("refresh_token")
-		claims, ok := client.ValidToken(instance, consts.RefreshTokenAudience, token)
+		claims, err := client.ValidToken(instance, consts.RefreshTokenAudience, token)
+		if err != nil && err != oauth.ErrInvalidToken {
+			return err
+		}
+		ok := err == nil
 		if !ok && client.ClientKind == "sharing" {
 			out.Refresh, claims, ok = sharing.TryTokenForMovedSharing(instance, client, token)
 		}
@@ -766,6 +796,138 @@ func accessToken(c echo.Context) error {
 	return c.JSON(http.StatusOK, out)
 }
 
+// errInvalidClient is used when the client can't be authenticated on the
+// revocation and introspection endpoints.
+var errInvalidClient = errors.New("invalid_client")
+
+// authenticateClient loads the OAuth client from the client_id parameter and
+// checks its client_secret. The public clients can't keep a secret, and are
+// accepted without it only if allowPublic is true.
+func authenticateClient(c echo.Context, allowPublic bool) (*oauth.Client, error) {
+	inst := middlewares.GetInstance(c)
+	clientID := c.FormValue("client_id")
+	clientSecret := c.FormValue("client_secret")
+	if clientID == "" {
+		return nil, errInvalidClient
+	}
+
+	client, err := oauth.FindClient(inst, clientID)
+	if err != nil {
+		if couchErr, isCouchErr := couchdb.IsCouchError(err); isCouchErr && couchErr.StatusCode >= 500 {
+			return nil, err
+		}
+		return nil, errInvalidClient
+	}
+	if clientSecret == "" && allowPublic && client.IsPublic() {
+		return client, nil
+	}
+	if clientSecret == "" || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(client.ClientSecret)) == 0 {
+		return nil, errInvalidClient
+	}
+	return client, nil
+}
+
+// revokeToken is the endpoint for revoking a refresh token.
+// See https://tools.ietf.org/html/rfc7009
+func revokeToken(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	client, err := authenticateClient(c, true)
+	if err == errInvalidClient {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error":             "invalid_request",
+			"error_description": "the token parameter is mandatory",
+		})
+	}
+
+	switch err := client.RevokeToken(inst, token); err {
+	case nil:
+		return c.NoContent(http.StatusOK)
+	case oauth.ErrUnsupportedTokenType, oauth.ErrTokenNotOwned:
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	default:
+		return err
+	}
+}
+
+// IntrospectionResponse is the struct used for serializing to JSON the
+// response of the introspection endpoint.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	ID        string `json:"jti,omitempty"`
+}
+
+// introspectToken is the endpoint for getting the state of an access token
+// or a refresh token. The caller must be an OAuth client authenticated with
+// its client_secret.
+// See https://tools.ietf.org/html/rfc7662
+func introspectToken(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	caller, err := authenticateClient(c, false)
+	if err != nil {
+		if err == errInvalidClient {
+			return c.JSON(http.StatusUnauthorized, echo.Map{
+				"error": err.Error(),
+			})
+		}
+		return err
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error":             "invalid_request",
+			"error_description": "the token parameter is mandatory",
+		})
+	}
+
+	client, claims, err := oauth.IntrospectToken(inst, caller, token)
+	if err == oauth.ErrInvalidToken {
+		return c.JSON(http.StatusOK, IntrospectionResponse{Active: false})
+	}
+	if err != nil {
+		return err
+	}
+	out := IntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  client.ClientID,
+		TokenType: "refresh_token",
+		ExpiresAt: claims.ExpiresAt,
+		IssuedAt:  claims.IssuedAt,
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		ID:        claims.ID,
+	}
+	if claims.Audience == consts.AccessTokenAudience {
+		out.TokenType = "access_token"
+		if out.ExpiresAt == 0 {
+			out.ExpiresAt = claims.IssuedAtUTC().Add(consts.AccessTokenValidityDuration).Unix()
+		}
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
 // Used to trade a secret for OAuth client informations
 func secretExchange(c echo.Context) error {
 	type exchange struct {
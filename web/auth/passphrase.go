@@ -120,8 +120,13 @@ func sendHint(c echo.Context) error {
 
 func passphraseReset(c echo.Context) error {
 	i := middlewares.GetInstance(c)
-	if err := lifecycle.RequestPassphraseReset(i); err != nil && err != instance.ErrResetAlreadyRequested {
-		return err
+	if err := lifecycle.RequestPassphraseReset(i); err != nil {
+		if err != instance.ErrResetAlreadyRequested {
+			return err
+		}
+		// Asking again and again for a reset is counted as a failed attempt,
+		// to avoid flooding the mailbox of the user.
+		middlewares.RecordFailedAuth(c, i)
 	}
 	// Disconnect the user if it is logged in. The idea is that if the user
 	// (maybe by accident) asks for a passphrase reset while logged in, we log
@@ -208,6 +213,7 @@ func passphraseRenew(c echo.Context) error {
 	iterations, _ := strconv.Atoi(c.FormValue("iterations"))
 	token, err := hex.DecodeString(c.FormValue("passphrase_reset_token"))
 	if err != nil {
+		middlewares.RecordFailedAuth(c, inst)
 		if wantsJSON(c) {
 			return c.JSON(http.StatusUnauthorized, echo.Map{
 				"error": "Invalid reset token",
@@ -225,6 +231,7 @@ func passphraseRenew(c echo.Context) error {
 	})
 	if err != nil {
 		if err == instance.ErrMissingToken {
+			middlewares.RecordFailedAuth(c, inst)
 			if wantsJSON(c) {
 				return c.JSON(http.StatusUnauthorized, echo.Map{
 					"error": "Invalid reset token",
@@ -8,14 +8,6 @@ import (
 	"github.com/cozy/cozy-stack/pkg/limits"
 )
 
-// LoginRateExceeded blocks the instance after too many failed attempts to
-// login
-func LoginRateExceeded(i *instance.Instance) error {
-	err := fmt.Errorf("Instance was blocked because of too many login failed attempts")
-	i.Logger().WithNamespace("rate_limiting").Warn(err.Error())
-	return lifecycle.Block(i, instance.BlockedLoginFailed.Code)
-}
-
 // TwoFactorRateExceeded regenerates a new 2FA passcode after too many failed
 // attempts to login
 func TwoFactorRateExceeded(i *instance.Instance) error {
@@ -26,8 +26,9 @@ func registerClient(c echo.Context) error {
 		return err
 	}
 	// We do not allow the creation of clients allowed to have an empty scope
-	// ("login" scope), except via the CLI.
-	if client.AllowLoginScope {
+	// ("login" scope), or to introspect the tokens of the other clients,
+	// except via the CLI.
+	if client.AllowLoginScope || client.AllowIntrospection {
 		perm, err := middlewares.GetPermission(c)
 		if err != nil || perm.Type != permission.TypeCLI {
 			return echo.NewHTTPError(http.StatusUnauthorized,
@@ -111,8 +112,7 @@ func checkClientToken(c echo.Context, client *oauth.Client) error {
 	}
 	token := header[len("Bearer "):]
 	instance := middlewares.GetInstance(c)
-	_, ok := client.ValidToken(instance, consts.RegistrationTokenAudience, token)
-	if !ok {
+	if _, err := client.ValidToken(instance, consts.RegistrationTokenAudience, token); err != nil {
 		return errors.New("invalid_token")
 	}
 	return nil
@@ -5,8 +5,10 @@ import (
 	"net/url"
 	"strconv"
 
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/webauthn"
 	"github.com/cozy/cozy-stack/pkg/limits"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/labstack/echo/v4"
@@ -54,6 +56,8 @@ func renderTwoFactorForm(c echo.Context, i *instance.Instance, code int, credsEr
 		"LongRunSession":        longRunSession,
 		"TwoFactorToken":        string(twoFactorToken),
 		"TrustedDeviceCheckBox": trustedCheckbox,
+		"TwoFactorApp":          i.HasAuthMode(instance.TwoFactorApp),
+		"WebAuthn":              webauthn.HasCredentials(i),
 	})
 }
 
@@ -97,8 +101,14 @@ func twoFactor(c echo.Context) error {
 	passcode := c.FormValue("two-factor-passcode")
 	generateTrustedDeviceToken, _ := strconv.ParseBool(c.FormValue("two-factor-generate-trusted-device-token"))
 
-	// Handle 2FA failed
-	correctPasscode := inst.ValidateTwoFactorPasscode(token, passcode)
+	// Handle 2FA failed. A security key can be used instead of the passcode.
+	var correctPasscode bool
+	if c.FormValue("two-factor-webauthn") != "" {
+		correctPasscode = inst.ValidateTwoFactorToken(token) &&
+			checkWebAuthnAssertion(c, inst, "two-factor-webauthn", false)
+	} else {
+		correctPasscode = inst.ValidateTwoFactorPasscode(token, passcode)
+	}
 	if !correctPasscode {
 		return twoFactorFailed(c, inst, token)
 	}
@@ -133,6 +143,7 @@ func twoFactor(c echo.Context) error {
 	if err := newSession(c, inst, redirect, longRunSession, "2FA"); err != nil {
 		return err
 	}
+	settings.CompleteCredentialsVaultUnlock(inst, token)
 
 	// Check if the user trusts its device
 	var generatedTrustedDeviceToken []byte
@@ -153,6 +164,7 @@ func twoFactor(c echo.Context) error {
 // twoFactorFailed returns the 2FA form with an error message
 func twoFactorFailed(c echo.Context, inst *instance.Instance, token []byte) error {
 	errorMessage := inst.Translate(TwoFactorErrorKey)
+	middlewares.RecordFailedAuth(c, inst)
 	errCheckRateLimit := limits.CheckRateLimit(inst, limits.TwoFactorType)
 	if errCheckRateLimit == limits.ErrRateLimitExceeded {
 		if err := TwoFactorRateExceeded(inst); err != nil {
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/webauthn"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// WebAuthnErrorKey is the key for translating the message showed to the user
+// when the assertion of a security key or passkey is not valid
+const WebAuthnErrorKey = "Login WebAuthn error"
+
+// webauthnOptions returns the options for getting an assertion from one of
+// the credentials of the instance, for the passwordless login or as a second
+// factor.
+func webauthnOptions(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	opts, err := webauthn.BeginLogin(inst)
+	if err == webauthn.ErrNoCredentials {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, opts)
+}
+
+// checkWebAuthnAssertion checks the assertion sent in the given form field.
+// The userVerification parameter must be true when the assertion is not used
+// as a second factor.
+func checkWebAuthnAssertion(c echo.Context, inst *instance.Instance, field string, userVerification bool) bool {
+	var resp webauthn.CredentialResponse
+	if err := json.Unmarshal([]byte(c.FormValue(field)), &resp); err != nil {
+		return false
+	}
+	if _, err := webauthn.Authenticate(inst, &resp, userVerification); err != nil {
+		inst.Logger().WithNamespace("auth").Infof("Invalid WebAuthn assertion: %s", err)
+		return false
+	}
+	return true
+}
+
+// webauthnLogin is the passwordless login with a passkey. The authenticator
+// must have verified the user (PIN, biometrics), and so the two-factor
+// authentication is not asked.
+func webauthnLogin(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+
+	// When the authentication is delegated, it is the identity provider that
+	// decides who can log in.
+	if !inst.IsPasswordAuthenticationEnabled() {
+		return c.JSON(http.StatusForbidden, echo.Map{
+			"error": "The passwordless login is disabled for this instance",
+		})
+	}
+
+	redirect, err := checkRedirectParam(c, inst.DefaultRedirection())
+	if err != nil {
+		return err
+	}
+	longRunSession, _ := strconv.ParseBool(c.FormValue("long-run-session"))
+
+	if !checkWebAuthnAssertion(c, inst, "webauthn", true) {
+		middlewares.RecordFailedAuth(c, inst)
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": inst.Translate(WebAuthnErrorKey),
+		})
+	}
+
+	if _, ok := middlewares.GetSession(c); !ok {
+		if err := newSession(c, inst, redirect, longRunSession, "webauthn"); err != nil {
+			return err
+		}
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"redirect": redirect.String(),
+	})
+}
+
+// webauthnRegisterForm shows the page where the user can register a new
+// security key or passkey. The ceremony is made on the stack origin, as the
+// relying party ID is the domain of the instance.
+func webauthnRegisterForm(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if !middlewares.IsLoggedIn(c) {
+		u := inst.PageURL("/auth/login", url.Values{
+			"redirect": {inst.FromURL(c.Request().URL)},
+		})
+		return c.Redirect(http.StatusSeeOther, u)
+	}
+
+	redirect, err := checkRedirectParam(c, inst.SubDomain(consts.SettingsSlug))
+	if err != nil {
+		return err
+	}
+	iterations := 0
+	if settings, err := settings.Get(inst); err == nil {
+		iterations = settings.PassphraseKdfIterations
+	}
+	return c.Render(http.StatusOK, "webauthn_register.html", echo.Map{
+		"TemplateTitle":  inst.TemplateTitle(),
+		"Domain":         inst.ContextualDomain(),
+		"ContextName":    inst.ContextName,
+		"Locale":         inst.Locale,
+		"Iterations":     iterations,
+		"Salt":           string(inst.PassphraseSalt()),
+		"CSRF":           c.Get("csrf"),
+		"Favicon":        middlewares.Favicon(inst),
+		"CryptoPolyfill": middlewares.CryptoPolyfill(c),
+		"Redirect":       redirect.String(),
+	})
+}
+
+// webauthnRegistration returns the options for creating a new credential. As
+// a credential can be used for a passwordless login, the current passphrase
+// is required.
+func webauthnRegistration(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if !middlewares.IsLoggedIn(c) {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": inst.Translate("Error Must be authenticated"),
+		})
+	}
+
+	passphrase := []byte(c.FormValue("passphrase"))
+	if lifecycle.CheckPassphrase(inst, passphrase) != nil {
+		middlewares.RecordFailedAuth(c, inst)
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": inst.Translate(CredentialsErrorKey),
+		})
+	}
+
+	opts, err := webauthn.BeginRegistration(inst)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, opts)
+}
+
+// webauthnRegister registers the credential created by the browser for the
+// options given by webauthnRegistration.
+func webauthnRegister(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if !middlewares.IsLoggedIn(c) {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": inst.Translate("Error Must be authenticated"),
+		})
+	}
+
+	redirect, err := checkRedirectParam(c, inst.SubDomain(consts.SettingsSlug))
+	if err != nil {
+		return err
+	}
+	var resp webauthn.CredentialResponse
+	if err := json.Unmarshal([]byte(c.FormValue("credential")), &resp); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": inst.Translate(WebAuthnErrorKey),
+		})
+	}
+	if _, err := webauthn.Register(inst, c.FormValue("name"), &resp); err != nil {
+		inst.Logger().WithNamespace("auth").Infof("Cannot register a WebAuthn credential: %s", err)
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": inst.Translate(WebAuthnErrorKey),
+		})
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"redirect": redirect.String(),
+	})
+}
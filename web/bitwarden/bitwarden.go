@@ -19,6 +19,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/limits"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/labstack/echo/v4"
 )
@@ -236,17 +237,24 @@ func getInitialCredentials(c echo.Context) error {
 	pass := []byte(c.FormValue("password"))
 
 	// Authentication
+	if err := middlewares.CheckAuthAttempt(c, inst); err != nil {
+		return err
+	}
+	defer middlewares.EndAuthAttempt(c, inst)
 	if err := lifecycle.CheckPassphrase(inst, pass); err != nil {
+		middlewares.RecordFailedAuth(c, inst)
 		return c.JSON(http.StatusUnauthorized, echo.Map{
 			"error": "invalid password",
 		})
 	}
-
-	if inst.HasAuthMode(instance.TwoFactorMail) {
+	if inst.HasTwoFactor() {
 		if !checkTwoFactor(c, inst) {
 			return nil
 		}
 	}
+	if err := settings.UnlockCredentialsVaultAtLogin(inst, pass); err != nil {
+		log.Warnf("Cannot unlock the credentials vault: %s", err)
+	}
 
 	// Register the client
 	kind, softwareID := bitwarden.ParseBitwardenDeviceType(c.FormValue("deviceType"))
@@ -313,15 +321,9 @@ func getInitialCredentials(c echo.Context) error {
 		}
 	}
 
-	var ip string
-	if forwardedFor := c.Request().Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		ip = strings.TrimSpace(strings.SplitN(forwardedFor, ",", 2)[0])
-	}
-	if ip == "" {
-		ip = strings.Split(c.Request().RemoteAddr, ":")[0]
-	}
+	limits.ResetFailedAuth(inst)
 	inst.Logger().WithNamespace("loginaudit").
-		Infof("New bitwarden client from %s at %s", ip, time.Now())
+		Infof("New bitwarden client from %s at %s", middlewares.ClientIP(c), time.Now())
 
 	// Send the response
 	out := AccessTokenReponse{
@@ -352,6 +354,7 @@ func checkTwoFactor(c echo.Context, inst *instance.Instance) bool {
 				return true
 			}
 		}
+		middlewares.RecordFailedAuth(c, inst)
 	}
 
 	// Allow the settings webapp get a bitwarden token without the 2FA. It's OK
@@ -383,15 +386,21 @@ func checkTwoFactor(c echo.Context, inst *instance.Instance) bool {
 	}
 	cache.Set(key, token, 5*time.Minute)
 
+	// 0 means authenticator app, and 1 means email
+	// https://github.com/bitwarden/jslib/blob/master/common/src/enums/twoFactorProviderType.ts
+	providers := []int{1}
+	providers2 := map[string]map[string]string{
+		"1": {"Email": obscured},
+	}
+	if inst.HasAuthMode(instance.TwoFactorApp) {
+		providers = []int{0}
+		providers2 = map[string]map[string]string{"0": {}}
+	}
 	_ = c.JSON(http.StatusBadRequest, echo.Map{
-		"error":             "invalid_grant",
-		"error_description": "Two factor required.",
-		// 1 means email
-		// https://github.com/bitwarden/jslib/blob/master/common/src/enums/twoFactorProviderType.ts
-		"TwoFactorProviders": []int{1},
-		"TwoFactorProviders2": map[string]map[string]string{
-			"1": {"Email": obscured},
-		},
+		"error":               "invalid_grant",
+		"error_description":   "Two factor required.",
+		"TwoFactorProviders":  providers,
+		"TwoFactorProviders2": providers2,
 	})
 	return false
 }
@@ -401,8 +410,11 @@ func refreshToken(c echo.Context) error {
 	refresh := c.FormValue("refresh_token")
 
 	// Check the refresh token
-	claims, ok := oauth.ValidTokenWithSStamp(inst, consts.RefreshTokenAudience, refresh)
-	if !ok || !bitwarden.IsBitwardenScope(claims.Scope) {
+	claims, err := oauth.ValidTokenWithSStamp(inst, consts.RefreshTokenAudience, refresh)
+	if err != nil && err != oauth.ErrInvalidToken {
+		return err
+	}
+	if err != nil || !bitwarden.IsBitwardenScope(claims.Scope) {
 		return c.JSON(http.StatusBadRequest, echo.Map{
 			"error": "invalid refresh token",
 		})
@@ -6,8 +6,9 @@ import (
 	"strings"
 
 	"github.com/cozy/cozy-stack/model/account"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/permission"
-	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
@@ -44,7 +45,7 @@ func getAccount(c echo.Context) error {
 		return err
 	}
 
-	if EncryptAccount(out) {
+	if EncryptAccount(instance, out) {
 		if err = couchdb.UpdateDoc(instance, &out); err != nil {
 			return err
 		}
@@ -57,7 +58,7 @@ func getAccount(c echo.Context) error {
 	if perm.Type == permission.TypeKonnector ||
 		(c.QueryParam("include") == "credentials" && perm.Type == permission.TypeWebapp) {
 		// The account decryption is allowed for konnectors or for apps services
-		DecryptAccount(out)
+		DecryptAccount(instance, out)
 	}
 
 	return c.JSON(http.StatusOK, out.ToMapWithType())
@@ -110,7 +111,7 @@ func updateAccount(c echo.Context) error {
 		}
 	}
 
-	EncryptAccount(doc)
+	EncryptAccount(instance, doc)
 
 	errUpdate := couchdb.UpdateDoc(instance, &doc)
 	if errUpdate != nil {
@@ -122,7 +123,7 @@ func updateAccount(c echo.Context) error {
 		return err
 	}
 	if perm.Type == permission.TypeKonnector {
-		DecryptAccount(doc)
+		DecryptAccount(instance, doc)
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{
@@ -134,25 +135,37 @@ func updateAccount(c echo.Context) error {
 	})
 }
 
-// Encrypts sensitive fields inside the account. The document
+// EncryptAccount encrypts sensitive fields inside the account. The document
 // is modified in place.
-func EncryptAccount(doc couchdb.JSONDoc) bool {
-	if config.GetVault().CredentialsEncryptorKey() != nil {
-		return encryptMap(doc.M)
+func EncryptAccount(inst *instance.Instance, doc couchdb.JSONDoc) bool {
+	cipher, err := settings.CredentialsCipher(inst)
+	if err != nil {
+		inst.Logger().WithNamespace("accounts").
+			Warnf("Cannot get the credentials cipher: %s", err)
+		return false
+	}
+	if cipher.CanEncrypt() {
+		return encryptMap(cipher, doc.M)
 	}
 	return false
 }
 
-// Decrypts sensitive fields inside the account. The document
+// DecryptAccount decrypts sensitive fields inside the account. The document
 // is modified in place.
-func DecryptAccount(doc couchdb.JSONDoc) bool {
-	if config.GetVault().CredentialsDecryptorKey() != nil {
-		return decryptMap(doc.M)
+func DecryptAccount(inst *instance.Instance, doc couchdb.JSONDoc) bool {
+	cipher, err := settings.CredentialsCipher(inst)
+	if err != nil {
+		inst.Logger().WithNamespace("accounts").
+			Warnf("Cannot get the credentials cipher: %s", err)
+		return false
+	}
+	if cipher.CanDecrypt() {
+		return decryptMap(cipher, doc.M)
 	}
 	return false
 }
 
-func encryptMap(m map[string]interface{}) (encrypted bool) {
+func encryptMap(cipher *account.Cipher, m map[string]interface{}) (encrypted bool) {
 	auth, ok := m["auth"].(map[string]interface{})
 	if !ok {
 		return
@@ -165,12 +178,12 @@ func encryptMap(m map[string]interface{}) (encrypted bool) {
 		switch k {
 		case "password":
 			password, _ := v.(string)
-			cloned["credentials_encrypted"], err = account.EncryptCredentials(login, password)
+			cloned["credentials_encrypted"], err = cipher.EncryptCredentials(login, password)
 			if err == nil {
 				encrypted = true
 			}
 		case "secret", "dob", "code", "answer", "access_token", "refresh_token", "appSecret", "session":
-			cloned[k+"_encrypted"], err = account.EncryptCredentialsData(v)
+			cloned[k+"_encrypted"], err = cipher.EncryptData(v)
 			if err == nil {
 				encrypted = true
 			}
@@ -183,20 +196,29 @@ func encryptMap(m map[string]interface{}) (encrypted bool) {
 		}
 	}
 	for _, key := range encKeys {
-		if _, ok := cloned[key]; !ok {
-			cloned[key] = auth[key]
+		if _, ok := cloned[key]; ok {
+			continue
+		}
+		cloned[key] = auth[key]
+		// The values encrypted with the vault key of the stack are migrated to
+		// the credentials vault of the instance.
+		if str, ok := auth[key].(string); ok {
+			if upgraded, ok := cipher.Upgrade(key, str); ok {
+				cloned[key] = upgraded
+				encrypted = true
+			}
 		}
 	}
 	m["auth"] = cloned
 	if data, ok := m["data"].(map[string]interface{}); ok {
-		if encryptMap(data) && !encrypted {
+		if encryptMap(cipher, data) && !encrypted {
 			encrypted = true
 		}
 	}
 	return
 }
 
-func decryptMap(m map[string]interface{}) (decrypted bool) {
+func decryptMap(cipher *account.Cipher, m map[string]interface{}) (decrypted bool) {
 	auth, ok := m["auth"].(map[string]interface{})
 	if !ok {
 		return
@@ -207,18 +229,24 @@ func decryptMap(m map[string]interface{}) (decrypted bool) {
 			cloned[k] = v
 			continue
 		}
-		k = strings.TrimSuffix(k, "_encrypted")
 		var str string
 		str, ok = v.(string)
 		if !ok {
+			cloned[strings.TrimSuffix(k, "_encrypted")] = v
+			continue
+		}
+		// When the credentials vault is locked, the encrypted values are kept
+		// as is.
+		if cipher.Locked() && account.IsEncryptedForInstance(str) {
 			cloned[k] = v
 			continue
 		}
+		k = strings.TrimSuffix(k, "_encrypted")
 		var err error
 		if k == "credentials" {
-			cloned["login"], cloned["password"], err = account.DecryptCredentials(str)
+			cloned["login"], cloned["password"], err = cipher.DecryptCredentials(str)
 		} else {
-			cloned[k], err = account.DecryptCredentialsData(str)
+			cloned[k], err = cipher.DecryptData(str)
 		}
 		if !decrypted {
 			decrypted = err == nil
@@ -226,7 +254,7 @@ func decryptMap(m map[string]interface{}) (decrypted bool) {
 	}
 	m["auth"] = cloned
 	if data, ok := m["data"].(map[string]interface{}); ok {
-		if decryptMap(data) && !decrypted {
+		if decryptMap(cipher, data) && !decrypted {
 			decrypted = true
 		}
 	}
@@ -246,7 +274,7 @@ func createAccount(c echo.Context) error {
 		return err
 	}
 
-	EncryptAccount(doc)
+	EncryptAccount(instance, doc)
 
 	if err := couchdb.CreateDoc(instance, &doc); err != nil {
 		return err
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/cozy/cozy-stack/model/account"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -815,7 +816,7 @@ func TestAccountsEncryptDecrypt(t *testing.T) {
 	assert.NoError(t, json.Unmarshal(v, &m2))
 	assert.NoError(t, json.Unmarshal(v, &m3))
 
-	encrypted = encryptMap(m2)
+	encrypted = encryptMap(account.NewCipher(nil), m2)
 	assert.True(t, encrypted)
 
 	{
@@ -835,8 +836,8 @@ func TestAccountsEncryptDecrypt(t *testing.T) {
 		}
 	}
 
-	encrypted = encryptMap(m3)
-	decrypted = decryptMap(m3)
+	encrypted = encryptMap(account.NewCipher(nil), m3)
+	decrypted = decryptMap(account.NewCipher(nil), m3)
 	assert.True(t, encrypted)
 	assert.True(t, decrypted)
 	assert.EqualValues(t, m1, m3)
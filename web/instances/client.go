@@ -93,12 +93,20 @@ func registerClient(c echo.Context) error {
 	if err != nil {
 		return wrapError(err)
 	}
+	allowIntrospection := false
+	if param := c.QueryParam("AllowIntrospection"); param != "" {
+		allowIntrospection, err = strconv.ParseBool(param)
+		if err != nil {
+			return wrapError(err)
+		}
+	}
 
 	client := oauth.Client{
 		RedirectURIs:          []string{c.QueryParam("RedirectURI")},
 		ClientName:            c.QueryParam("ClientName"),
 		SoftwareID:            c.QueryParam("SoftwareID"),
 		AllowLoginScope:       allowLoginScope,
+		AllowIntrospection:    allowIntrospection,
 		OnboardingSecret:      c.QueryParam("OnboardingSecret"),
 		OnboardingApp:         c.QueryParam("OnboardingApp"),
 		OnboardingPermissions: c.QueryParam("OnboardingPermissions"),
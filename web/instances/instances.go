@@ -279,6 +279,7 @@ func updatesHandler(c echo.Context) error {
 		WorkerType:  "updates",
 		Message:     msg,
 		ForwardLogs: true,
+		Priority:    job.PriorityLow,
 	})
 	if err != nil {
 		return wrapError(err)
@@ -308,6 +309,16 @@ func setAuthMode(c echo.Context) error {
 	}
 
 	if !inst.HasAuthMode(authMode) {
+		// The two_factor_app mode can only be activated by the user, as it
+		// needs an authenticator app to be enrolled.
+		if authMode == instance.TwoFactorApp && len(inst.TOTPSecret) == 0 {
+			return jsonapi.BadRequest(instance.ErrInvalidTwoFactor)
+		}
+		if authMode != instance.TwoFactorApp {
+			inst.TOTPSecret = nil
+			inst.TOTPLastCounter = 0
+			inst.TwoFactorRecoveryCodes = nil
+		}
 		inst.AuthMode = authMode
 		if err = inst.Update(); err != nil {
 			return err
@@ -498,6 +509,13 @@ func Routes(router *echo.Group) {
 	router.GET("/contexts/:name", showContext)
 	router.GET("/with-app-version/:slug/:version", appVersion)
 
+	// Jobs
+	router.GET("/jobs/stats", jobsStats)
+	router.GET("/jobs/dead-letters/:worker-type", listDeadLetters)
+	router.GET("/jobs/dead-letters/:worker-type/:job-id", getDeadLetter)
+	router.POST("/jobs/dead-letters/:worker-type/:job-id/replay", replayDeadLetter)
+	router.DELETE("/jobs/dead-letters/:worker-type/:job-id", discardDeadLetter)
+
 	// Checks
 	router.GET("/:domain/fsck", fsckHandler)
 	router.POST("/:domain/checks/triggers", checkTriggers)
@@ -508,4 +526,5 @@ func Routes(router *echo.Group) {
 	router.POST("/:domain/fixers/content-mismatch", contentMismatchFixer)
 	router.POST("/:domain/fixers/orphan-account", orphanAccountFixer)
 	router.POST("/:domain/fixers/indexes", indexesFixer)
+	router.POST("/:domain/fixers/refresh-tokens", refreshTokensFixer)
 }
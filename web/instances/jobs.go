@@ -0,0 +1,76 @@
+package instances
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/labstack/echo/v4"
+)
+
+func listDeadLetters(c echo.Context) error {
+	jobs, err := job.System().DeadLetters(c.Param("worker-type"))
+	if err != nil {
+		return wrapJobError(err)
+	}
+	return c.JSON(http.StatusOK, jobs)
+}
+
+func getDeadLetter(c echo.Context) error {
+	j, err := job.System().DeadLetter(c.Param("worker-type"), c.Param("job-id"))
+	if err != nil {
+		return wrapJobError(err)
+	}
+	return c.JSON(http.StatusOK, j)
+}
+
+func replayDeadLetter(c echo.Context) error {
+	j, err := job.System().ReplayDeadLetter(c.Param("worker-type"), c.Param("job-id"))
+	if err != nil {
+		return wrapJobError(err)
+	}
+	return c.JSON(http.StatusCreated, j)
+}
+
+func discardDeadLetter(c echo.Context) error {
+	err := job.System().DiscardDeadLetter(c.Param("worker-type"), c.Param("job-id"))
+	if err != nil {
+		return wrapJobError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func jobsStats(c echo.Context) error {
+	opts := job.StatsOptions{
+		WorkerType: c.QueryParam("worker"),
+		Slug:       c.QueryParam("slug"),
+		Domain:     c.QueryParam("domain"),
+	}
+	if window := c.QueryParam("window"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			return jsonapi.InvalidParameter("window", err)
+		}
+		opts.Window = d
+	}
+	if groupBy := c.QueryParam("group_by"); groupBy != "" {
+		opts.GroupBy = strings.Split(groupBy, ",")
+	}
+	stats, err := job.System().Stats(opts)
+	if err != nil {
+		return wrapJobError(err)
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+func wrapJobError(err error) error {
+	switch err {
+	case job.ErrNotFoundJob, job.ErrUnknownWorker:
+		return jsonapi.NotFound(err)
+	case job.ErrInvalidStatsGroup:
+		return jsonapi.InvalidParameter("group_by", err)
+	}
+	return err
+}
@@ -23,6 +23,8 @@ import (
 	"github.com/cozy/cozy-stack/pkg/mail"
 	"github.com/cozy/cozy-stack/pkg/metadata"
 	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/cozy/cozy-stack/worker/exec"
+	"github.com/cozy/cozy-stack/worker/webhook"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/labstack/echo/v4"
 
@@ -67,13 +69,16 @@ type (
 		s *job.TriggerState
 	}
 	apiTriggerRequest struct {
-		Type            string          `json:"type"`
-		Arguments       string          `json:"arguments"`
-		WorkerType      string          `json:"worker"`
-		Message         json.RawMessage `json:"message"`
-		WorkerArguments json.RawMessage `json:"worker_arguments"`
-		Debounce        string          `json:"debounce"`
-		Options         *job.JobOptions `json:"options"`
+		Type            string           `json:"type"`
+		Arguments       string           `json:"arguments"`
+		WorkerType      string           `json:"worker"`
+		Message         json.RawMessage  `json:"message"`
+		WorkerArguments json.RawMessage  `json:"worker_arguments"`
+		Debounce        string           `json:"debounce"`
+		DebouncePerDoc  bool             `json:"debounce_per_document"`
+		Timezone        string           `json:"timezone"`
+		Exclusions      []job.TimeWindow `json:"exclusion_windows"`
+		Options         *job.JobOptions  `json:"options"`
 	}
 )
 
@@ -125,7 +130,13 @@ func (t apiTrigger) Links() *jsonapi.LinksList {
 }
 
 func (t apiTrigger) MarshalJSON() ([]byte, error) {
-	return json.Marshal(t.t)
+	infos := t.t
+	if infos.WorkerType == webhook.WorkerType {
+		redacted := *infos
+		redacted.Message = webhook.RedactMessage(infos.Message)
+		infos = &redacted
+	}
+	return json.Marshal(infos)
 }
 
 func (t apiTriggerState) ID() string                             { return t.t.TID }
@@ -257,6 +268,9 @@ func newTrigger(c echo.Context) error {
 			return jsonapi.InvalidAttribute("debounce", err)
 		}
 	}
+	if req.Options != nil && !req.Options.Priority.IsValid() {
+		return wrapJobsError(job.ErrUnknownPriority)
+	}
 
 	// Handle metadata
 	md := metadata.New()
@@ -273,13 +287,16 @@ func newTrigger(c echo.Context) error {
 		msg = req.WorkerArguments
 	}
 	t, err := job.NewTrigger(instance, job.TriggerInfos{
-		Type:       req.Type,
-		WorkerType: req.WorkerType,
-		Domain:     instance.Domain,
-		Arguments:  req.Arguments,
-		Debounce:   req.Debounce,
-		Options:    req.Options,
-		Metadata:   md,
+		Type:                req.Type,
+		WorkerType:          req.WorkerType,
+		Domain:              instance.Domain,
+		Arguments:           req.Arguments,
+		Debounce:            req.Debounce,
+		Timezone:            req.Timezone,
+		Exclusions:          req.Exclusions,
+		DebouncePerDocument: req.DebouncePerDoc,
+		Options:             req.Options,
+		Metadata:            md,
 	}, msg)
 	if err != nil {
 		return wrapJobsError(err)
@@ -296,6 +313,11 @@ func newTrigger(c echo.Context) error {
 			return err
 		}
 	}
+	if req.WorkerType == webhook.WorkerType {
+		if err := checkWebhookTrigger(t, permd); err != nil {
+			return err
+		}
+	}
 
 	if err = sched.AddTrigger(t); err != nil {
 		return wrapJobsError(err)
@@ -341,6 +363,7 @@ func getTriggerState(c echo.Context) error {
 	if err != nil {
 		return wrapJobsError(err)
 	}
+	state.Paused = infos.Paused
 	return jsonapi.Data(c, http.StatusOK, apiTriggerState{t: infos, s: state}, nil)
 }
 
@@ -379,6 +402,44 @@ func getTriggerJobs(c echo.Context) error {
 	return jsonapi.DataList(c, http.StatusOK, objs, nil)
 }
 
+func getTriggerDeliveries(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+
+	var err error
+
+	var limit int
+	if queryLimit := c.QueryParam("Limit"); queryLimit != "" {
+		limit, err = strconv.Atoi(queryLimit)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+	}
+
+	sched := job.System()
+	t, err := sched.GetTrigger(instance, c.Param("trigger-id"))
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	if err = middlewares.Allow(c, permission.GET, t); err != nil {
+		return err
+	}
+	if t.Infos().WorkerType != webhook.WorkerType {
+		return jsonapi.NotFound(errors.New("This trigger is not an outgoing webhook"))
+	}
+
+	deliveries, err := webhook.ListDeliveries(instance, t.ID(), limit)
+	if err != nil {
+		return wrapJobsError(err)
+	}
+
+	objs := make([]jsonapi.Object, len(deliveries))
+	for i, d := range deliveries {
+		objs[i] = d
+	}
+
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
 func patchTrigger(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
 	sched := job.System()
@@ -407,6 +468,25 @@ func patchTrigger(c echo.Context) error {
 	return jsonapi.Data(c, http.StatusOK, apiTrigger{infos, inst}, nil)
 }
 
+func resumeTrigger(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	t, err := job.System().GetTrigger(inst, c.Param("trigger-id"))
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	infos := t.Infos()
+	if err := middlewares.Allow(c, permission.PATCH, t); err != nil {
+		if !allowKonnectorForItsOwnTrigger(c, infos) {
+			return err
+		}
+	}
+	infos, err = job.ResumeTrigger(inst, t)
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, apiTrigger{infos, inst}, nil)
+}
+
 func launchTrigger(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 	t, err := job.System().GetTrigger(instance, c.Param("trigger-id"))
@@ -446,6 +526,9 @@ func deleteTrigger(c echo.Context) error {
 	if err := sched.DeleteTrigger(instance, c.Param("trigger-id")); err != nil {
 		return wrapJobsError(err)
 	}
+	if infos.WorkerType == webhook.WorkerType {
+		webhook.DeleteDeliveries(instance, infos.TID)
+	}
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -575,6 +658,25 @@ func getJob(c echo.Context) error {
 	return jsonapi.Data(c, http.StatusOK, apiJob{j}, nil)
 }
 
+func getJobLogs(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+	j, err := job.Get(instance, c.Param("job-id"))
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	if err := middlewares.Allow(c, permission.GET, j); err != nil {
+		return err
+	}
+	journal, err := exec.GetJournal(instance, j.ID())
+	if couchdb.IsNotFoundError(err) {
+		return jsonapi.NotFound(errors.New("This job has no execution journal"))
+	}
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, journal, nil)
+}
+
 func patchJob(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
 	j, err := job.Get(inst, c.Param("job-id"))
@@ -756,8 +858,10 @@ func Routes(router *echo.Group) {
 	router.GET("/triggers/:trigger-id", getTrigger)
 	router.GET("/triggers/:trigger-id/state", getTriggerState)
 	router.GET("/triggers/:trigger-id/jobs", getTriggerJobs)
+	router.GET("/triggers/:trigger-id/deliveries", getTriggerDeliveries)
 	router.PATCH("/triggers/:trigger-id", patchTrigger)
 	router.POST("/triggers/:trigger-id/launch", launchTrigger)
+	router.POST("/triggers/:trigger-id/resume", resumeTrigger)
 	router.DELETE("/triggers/:trigger-id", deleteTrigger)
 
 	router.POST("/webhooks/bi", fireBIWebhook)
@@ -766,6 +870,7 @@ func Routes(router *echo.Group) {
 	router.POST("/clean", cleanJobs)
 	router.DELETE("/purge", purgeJobs)
 	router.GET("/:job-id", getJob)
+	router.GET("/:job-id/logs", getJobLogs)
 	router.PATCH("/:job-id", patchJob)
 }
 
@@ -778,6 +883,13 @@ func wrapJobsError(err error) error {
 	case job.ErrUnknownTrigger,
 		job.ErrNotCronTrigger:
 		return jsonapi.InvalidAttribute("Type", err)
+	case job.ErrUnknownPriority:
+		return jsonapi.InvalidAttribute("priority", err)
+	case job.ErrUnknownTimezone:
+		return jsonapi.InvalidAttribute("timezone", err)
+	case job.ErrInvalidTimeWindow,
+		job.ErrExcludedExecution:
+		return jsonapi.InvalidAttribute("exclusion_windows", err)
 	case limits.ErrRateLimitReached,
 		limits.ErrRateLimitExceeded:
 		return jsonapi.BadRequest(err)
@@ -785,6 +897,37 @@ func wrapJobsError(err error) error {
 	return err
 }
 
+// checkWebhookTrigger returns an error if the message of an outgoing webhook
+// is invalid, or if the events of the trigger must not be sent outside of the
+// stack with the permissions of the request. The secret of the message is
+// encrypted before the trigger is saved.
+func checkWebhookTrigger(t job.Trigger, permd *permission.Permission) error {
+	var msg webhook.Message
+	if err := t.Infos().Message.Unmarshal(&msg); err != nil {
+		return jsonapi.InvalidAttribute("message", err)
+	}
+	if err := webhook.CheckMessage(&msg); err != nil {
+		return jsonapi.InvalidAttribute("message", err)
+	}
+	if permd.Type != permission.TypeCLI {
+		if err := webhook.CheckDoctypes(t, permd.Permissions); err != nil {
+			if err == webhook.ErrForbiddenDoctype {
+				return jsonapi.Forbidden(err)
+			}
+			return wrapJobsError(err)
+		}
+	}
+	if err := msg.EncryptSecret(); err != nil {
+		return wrapJobsError(err)
+	}
+	sealed, err := job.NewMessage(msg)
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	t.Infos().Message = sealed
+	return nil
+}
+
 // checkReservedWorker returns an error if the worker should only by used by
 // the stack, and the clients must not push jobs for it.
 func checkReservedWorker(worker string) error {
@@ -0,0 +1,29 @@
+package limits
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/labstack/echo/v4"
+)
+
+func listBans(c echo.Context) error {
+	bans, err := limits.ListBans()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, bans)
+}
+
+func clearBan(c echo.Context) error {
+	if err := limits.ClearBan(c.Param("ip")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Routes sets the routing for the brute-force protection (admin)
+func Routes(router *echo.Group) {
+	router.GET("/bans", listBans)
+	router.DELETE("/bans/:ip", clearBan)
+}
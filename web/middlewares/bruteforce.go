@@ -0,0 +1,98 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	authAttemptKey = "auth_attempt"
+	authFailedKey  = "auth_failed"
+)
+
+// ClientIP returns the IP address of the client that has made the request.
+// The X-Forwarded-For header is used when the stack is behind a reverse proxy:
+// the client IP address is the rightmost address that is not one of the
+// trusted proxies, as the addresses on its left can be forged by the client.
+func ClientIP(c echo.Context) string {
+	proxies := config.GetConfig().BruteForce.TrustedProxies
+	options := make([]echo.TrustOption, 0, len(proxies)+3)
+	options = append(options,
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false))
+	for _, network := range proxies {
+		options = append(options, echo.TrustIPRange(network))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)(c.Request())
+}
+
+// CheckAuthAttempt returns an error with the 429 Too Many Requests status
+// code if the IP address of the client is banned, or if the client must wait
+// before making a new authentication attempt. Otherwise, EndAuthAttempt must
+// be called once the credentials have been checked.
+func CheckAuthAttempt(c echo.Context, inst *instance.Instance) error {
+	wait, err := limits.CheckAuthAttempt(inst, ClientIP(c))
+	switch err {
+	case nil:
+		c.Set(authAttemptKey, true)
+		return nil
+	case limits.ErrIPBanned, limits.ErrAttemptTooEarly:
+		seconds := int64((wait + time.Second - 1) / time.Second)
+		if seconds < 1 {
+			seconds = 1
+		}
+		c.Response().Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+		return echo.NewHTTPError(http.StatusTooManyRequests, inst.Translate("Error Too many attempts"))
+	default:
+		inst.Logger().WithNamespace("limits").
+			Warnf("Cannot check the authentication attempt: %s", err)
+		return nil
+	}
+}
+
+// CheckBruteForce is an echo middleware that rejects the authentication
+// attempts from banned IP addresses, or that come too soon after the last
+// failed attempts.
+func CheckBruteForce(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		inst := GetInstance(c)
+		if err := CheckAuthAttempt(c, inst); err != nil {
+			return err
+		}
+		defer EndAuthAttempt(c, inst)
+		return next(c)
+	}
+}
+
+// EndAuthAttempt gives back the attempt allowed by CheckAuthAttempt if the
+// client has not sent invalid credentials.
+func EndAuthAttempt(c echo.Context, inst *instance.Instance) {
+	if allowed, _ := c.Get(authAttemptKey).(bool); !allowed {
+		return
+	}
+	c.Set(authAttemptKey, false)
+	if failed, _ := c.Get(authFailedKey).(bool); failed {
+		return
+	}
+	if err := limits.CancelAuthAttempt(inst, ClientIP(c)); err != nil {
+		inst.Logger().WithNamespace("limits").
+			Warnf("Cannot cancel the authentication attempt: %s", err)
+	}
+}
+
+// RecordFailedAuth must be called when a client has sent invalid credentials,
+// to keep the delay before its next attempts.
+func RecordFailedAuth(c echo.Context, inst *instance.Instance) {
+	c.Set(authFailedKey, true)
+	if err := limits.RecordFailedAuth(inst, ClientIP(c)); err != nil {
+		inst.Logger().WithNamespace("limits").
+			Warnf("Cannot record the failed authentication: %s", err)
+	}
+}
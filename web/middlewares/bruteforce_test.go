@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	config.UseTestFile()
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	config.GetConfig().BruteForce.TrustedProxies = []*net.IPNet{proxies}
+	defer func() { config.GetConfig().BruteForce.TrustedProxies = nil }()
+
+	e := echo.New()
+	clientIP := func(remoteAddr, forwardedFor string) string {
+		req, _ := http.NewRequest(echo.POST, "http://cozy.local/auth/login", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+		}
+		return ClientIP(e.NewContext(req, httptest.NewRecorder()))
+	}
+
+	// Without a proxy, the header is ignored
+	assert.Equal(t, "203.0.113.42", clientIP("203.0.113.42:1234", ""))
+	assert.Equal(t, "203.0.113.42", clientIP("203.0.113.42:1234", "198.51.100.7"))
+
+	// Behind a trusted proxy, the rightmost untrusted address is used, as the
+	// client can forge the addresses on its left
+	assert.Equal(t, "198.51.100.7", clientIP("10.0.0.1:1234", "198.51.100.7"))
+	assert.Equal(t, "198.51.100.7", clientIP("10.0.0.1:1234", "192.0.2.12, 198.51.100.7"))
+	assert.Equal(t, "198.51.100.7", clientIP("10.0.0.1:1234", "192.0.2.12, 198.51.100.7, 10.0.0.2"))
+
+	// The private networks are not trusted by default
+	assert.Equal(t, "192.168.1.1", clientIP("192.168.1.1:1234", "198.51.100.7"))
+
+	// IPv6
+	assert.Equal(t, "2001:db8::1", clientIP("[2001:db8::1]:1234", ""))
+}
@@ -297,6 +297,64 @@ func AppendCSPRule(c echo.Context, ruleType string, appendedValues ...string) {
 	c.Response().Header().Set(echo.HeaderContentSecurityPolicy, newRules)
 }
 
+// ExtendCSPRule is like AppendCSPRule, but when the rule is not yet in the
+// CSP headers, it starts with the sources of default-src, as the browsers use
+// them for a missing rule.
+func ExtendCSPRule(c echo.Context, ruleType string, appendedValues ...string) {
+	currentRules := c.Response().Header().Get(echo.HeaderContentSecurityPolicy)
+	newRules := extendCSPRule(currentRules, ruleType, appendedValues...)
+	c.Response().Header().Set(echo.HeaderContentSecurityPolicy, newRules)
+}
+
+func extendCSPRule(currentRules, ruleType string, appendedValues ...string) string {
+	var sources []string
+	for _, src := range appendedValues {
+		if isCSPSourceToken(src) {
+			sources = append(sources, src)
+		}
+	}
+	if len(sources) == 0 {
+		return currentRules
+	}
+	appendedValues = sources
+	if cspRuleSources(currentRules, ruleType) == nil {
+		var values []string
+		for _, src := range cspRuleSources(currentRules, "default-src") {
+			if src != "'none'" {
+				values = append(values, src)
+			}
+		}
+		appendedValues = append(values, appendedValues...)
+	}
+	return appendCSPRule(currentRules, ruleType, appendedValues...)
+}
+
+// isCSPSourceToken returns true if the source is a single token that can't
+// add other sources or directives to the CSP header: no whitespace, no ; or
+// , separator, and quotes only around a keyword like 'self'.
+func isCSPSourceToken(src string) bool {
+	if src == "" || strings.ContainsAny(src, " \t\r\n\f\v;,\"") {
+		return false
+	}
+	if strings.Contains(src, "'") {
+		return len(src) > 2 && src[0] == '\'' && src[len(src)-1] == '\'' &&
+			!strings.Contains(src[1:len(src)-1], "'")
+	}
+	return true
+}
+
+// cspRuleSources returns the sources of a rule in the CSP headers, or nil if
+// the rule is not present.
+func cspRuleSources(rules, ruleType string) []string {
+	for _, rule := range strings.Split(rules, ";") {
+		fields := strings.Fields(rule)
+		if len(fields) > 0 && fields[0] == ruleType {
+			return append([]string{}, fields[1:]...)
+		}
+	}
+	return nil
+}
+
 func appendCSPRule(currentRules, ruleType string, appendedValues ...string) (newRules string) {
 	ruleIndex := strings.Index(currentRules, ruleType)
 	if ruleIndex >= 0 {
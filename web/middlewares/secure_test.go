@@ -103,3 +103,21 @@ func TestAppendCSPRule(t *testing.T) {
 	r = appendCSPRule("script '*'; toto;", "frame-ancestors", "new-rule")
 	assert.Equal(t, "script '*'; toto;frame-ancestors new-rule;", r)
 }
+
+func TestExtendCSPRule(t *testing.T) {
+	r := extendCSPRule("default-src 'self'; img-src 'self' data:;", "img-src", "https://tiles.example.com")
+	assert.Equal(t, "default-src 'self'; img-src 'self' data: https://tiles.example.com;", r)
+
+	r = extendCSPRule("default-src 'self' blob:;", "media-src", "https://media.example.com")
+	assert.Equal(t, "default-src 'self' blob:;media-src 'self' blob: https://media.example.com;", r)
+
+	r = extendCSPRule("default-src 'none';", "font-src", "https://fonts.example.com")
+	assert.Equal(t, "default-src 'none';font-src https://fonts.example.com;", r)
+
+	r = extendCSPRule("default-src 'self'; img-src 'self';", "img-src",
+		"https://x.com/ 'unsafe-inline'", "https://x.com/;script-src *", "https://x.com,*", "data:")
+	assert.Equal(t, "default-src 'self'; img-src 'self' data:;", r)
+
+	r = extendCSPRule("default-src 'self';", "img-src", "https://x.com/ *")
+	assert.Equal(t, "default-src 'self';", r)
+}
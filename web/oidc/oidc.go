@@ -126,7 +126,7 @@ func Login(c echo.Context) error {
 	}
 
 	// Check 2FA if enabled
-	if inst.HasAuthMode(instance.TwoFactorMail) {
+	if inst.HasTwoFactor() {
 		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
 		if err != nil {
 			return err
@@ -156,7 +156,7 @@ func Login(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	if err = session.StoreNewLoginEntry(inst, sessionID, "", c.Request(), "OIDC", true); err != nil {
+	if err = session.StoreNewLoginEntry(inst, sessionID, "", c.Request(), middlewares.ClientIP(c), "OIDC", true); err != nil {
 		inst.Logger().Errorf("Could not store session history %q: %s", sessionID, err)
 	}
 	if redirect == "" {
@@ -203,6 +203,11 @@ func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *web
 		if permType == consts.Thumbnails || permType == consts.NotesEvents {
 			permType = consts.Files
 		}
+		// XXX: the execution journals are visible to those who can see the
+		// jobs.
+		if permType == consts.JobLogs {
+			permType = consts.Jobs
+		}
 		// XXX: no permissions are required for io.cozy.sharings.initial_sync
 		// and io.cozy.auth.confirmations
 		if withAuthentication &&
@@ -0,0 +1,256 @@
+package registry
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/catalog"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/registry"
+	"github.com/labstack/echo/v4"
+)
+
+// localVersion is the representation of a version of the built-in registry,
+// with the same format as the external registries.
+type localVersion struct {
+	registry.Version
+	Type       string `json:"type"`
+	Channel    string `json:"channel"`
+	Deprecated bool   `json:"deprecated,omitempty"`
+}
+
+func newLocalVersion(c echo.Context, v *catalog.Version) *localVersion {
+	tarball := url.URL{
+		Scheme: registry.LocalScheme,
+		Host:   c.Request().Host,
+		Path:   path.Join("/registry", v.Slug, v.Version, "tarball"),
+	}
+	return &localVersion{
+		Version: registry.Version{
+			Slug:      v.Slug,
+			Version:   v.Version,
+			URL:       tarball.String(),
+			Sha256:    v.Sha256,
+			CreatedAt: v.CreatedAt,
+			Size:      strconv.FormatInt(v.Size, 10),
+			Manifest:  v.Manifest,
+			TarPrefix: v.TarPrefix,
+			Signature: v.Signature,
+		},
+		Type:       v.Type,
+		Channel:    v.Channel,
+		Deprecated: v.Deprecated,
+	}
+}
+
+func newLocalApp(c echo.Context, a *catalog.App) map[string]interface{} {
+	versions := map[string][]string{
+		catalog.Stable: {},
+		catalog.Beta:   {},
+		catalog.Dev:    {},
+	}
+	for _, v := range a.Versions {
+		if v.Deprecated {
+			continue
+		}
+		for _, channel := range []string{catalog.Dev, catalog.Beta, catalog.Stable} {
+			versions[channel] = append(versions[channel], v.Version)
+			if channel == v.Channel {
+				break
+			}
+		}
+	}
+	doc := map[string]interface{}{
+		"slug":                  a.Slug,
+		"type":                  a.Type,
+		"versions":              versions,
+		"maintenance_activated": false,
+	}
+	if latest := a.Latest(catalog.Stable); latest != nil {
+		doc["latest_version"] = newLocalVersion(c, latest)
+	}
+	return doc
+}
+
+func localList(c echo.Context) error {
+	apps, err := catalog.ListApps()
+	if err != nil {
+		return err
+	}
+	cursor, _ := strconv.Atoi(c.QueryParam("cursor"))
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if cursor < 0 || cursor > len(apps) {
+		cursor = len(apps)
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+	end := cursor + limit
+	if end > len(apps) {
+		end = len(apps)
+	}
+	list := make([]map[string]interface{}, 0, end-cursor)
+	for _, a := range apps[cursor:end] {
+		list = append(list, newLocalApp(c, a))
+	}
+	page := registry.AppsPaginated{
+		Apps:     list,
+		PageInfo: registry.PageInfo{Count: len(list)},
+	}
+	if end < len(apps) {
+		page.PageInfo.NextCursor = strconv.Itoa(end)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+func localMaintenance(c echo.Context) error {
+	return c.JSON(http.StatusOK, []interface{}{})
+}
+
+func localApp(c echo.Context) error {
+	versions, err := catalog.ListVersions(c.Param("app"))
+	if err != nil {
+		return wrapCatalogError(err)
+	}
+	a := &catalog.App{Slug: versions[0].Slug, Type: versions[0].Type, Versions: versions}
+	return c.JSON(http.StatusOK, newLocalApp(c, a))
+}
+
+func localVersionReq(c echo.Context) error {
+	v, err := catalog.GetVersion(c.Param("app"), c.Param("version"))
+	if err != nil {
+		return wrapCatalogError(err)
+	}
+	return c.JSON(http.StatusOK, newLocalVersion(c, v))
+}
+
+func localLatest(c echo.Context) error {
+	channel := c.Param("channel")
+	if !catalog.IsValidChannel(channel) {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	v, err := catalog.LatestVersion(c.Param("app"), channel)
+	if err != nil {
+		return wrapCatalogError(err)
+	}
+	return c.JSON(http.StatusOK, newLocalVersion(c, v))
+}
+
+func localIcon(c echo.Context) error {
+	var v *catalog.Version
+	var err error
+	if version := c.Param("version"); version != "" {
+		v, err = catalog.GetVersion(c.Param("app"), version)
+	} else {
+		v, err = catalog.LatestVersion(c.Param("app"), catalog.Stable)
+	}
+	if err != nil {
+		return wrapCatalogError(err)
+	}
+	icon, err := catalog.OpenIcon(v)
+	if err != nil {
+		return wrapCatalogError(err)
+	}
+	defer icon.Close()
+	return c.Stream(http.StatusOK, v.IconMIME, icon)
+}
+
+func localTarball(c echo.Context) error {
+	v, err := catalog.GetVersion(c.Param("app"), c.Param("version"))
+	if err != nil {
+		return wrapCatalogError(err)
+	}
+	tarball, err := catalog.OpenTarball(v)
+	if err != nil {
+		return wrapCatalogError(err)
+	}
+	defer tarball.Close()
+	c.Response().Header().Set(echo.HeaderContentLength, strconv.FormatInt(v.Size, 10))
+	return c.Stream(http.StatusOK, "application/gzip", tarball)
+}
+
+// LocalHandler returns the handler for the registry built in the stack. It
+// has the same API as the external registries, and is used for the local://
+// URLs in the registries of the contexts.
+func LocalHandler() http.Handler {
+	router := echo.New()
+	router.HideBanner = true
+	router.HidePort = true
+	router.HTTPErrorHandler = func(err error, c echo.Context) {
+		// The clients of the registries expect a JSON with a message
+		if jerr, ok := err.(*jsonapi.Error); ok {
+			err = echo.NewHTTPError(jerr.Status, jerr.Detail)
+		}
+		router.DefaultHTTPErrorHandler(err, c)
+	}
+	g := router.Group("/registry")
+	g.GET("", localList)
+	g.GET("/", localList)
+	g.GET("/maintenance", localMaintenance)
+	g.GET("/:app", localApp)
+	g.GET("/:app/", localApp)
+	g.GET("/:app/icon", localIcon)
+	g.GET("/:app/:version", localVersionReq)
+	g.GET("/:app/:version/icon", localIcon)
+	g.GET("/:app/:version/tarball", localTarball)
+	g.GET("/:app/:channel/latest", localLatest)
+	return router
+}
+
+// SignatureHeader is the HTTP header used to give the minisign signature of
+// a tarball, encoded in base64, when it is published.
+const SignatureHeader = "X-Cozy-Minisign-Signature"
+
+func publishVersion(c echo.Context) error {
+	var signature string
+	if header := c.Request().Header.Get(SignatureHeader); header != "" {
+		sig, err := base64.StdEncoding.DecodeString(header)
+		if err != nil {
+			return jsonapi.BadRequest(catalog.ErrInvalidSignature)
+		}
+		signature = string(sig)
+	}
+	v, err := catalog.Publish(c.Request().Body, signature)
+	if err != nil {
+		return wrapCatalogError(err)
+	}
+	return c.JSON(http.StatusCreated, v)
+}
+
+func deprecateVersion(c echo.Context) error {
+	v, err := catalog.Deprecate(c.Param("app"), c.Param("version"))
+	if err != nil {
+		return wrapCatalogError(err)
+	}
+	return c.JSON(http.StatusOK, v)
+}
+
+// AdminRoutes sets the routing for the admin interface to publish and
+// deprecate the versions on the registry built in the stack.
+func AdminRoutes(router *echo.Group) {
+	router.POST("", publishVersion)
+	router.POST("/", publishVersion)
+	router.POST("/:app/:version/deprecate", deprecateVersion)
+}
+
+func wrapCatalogError(err error) error {
+	switch err {
+	case catalog.ErrVersionNotFound, catalog.ErrAppNotFound, os.ErrNotExist:
+		return jsonapi.NotFound(err)
+	case catalog.ErrVersionExists:
+		return jsonapi.Conflict(err)
+	case catalog.ErrTarballTooLarge:
+		return jsonapi.NewError(http.StatusRequestEntityTooLarge, err.Error())
+	case catalog.ErrInvalidTarball, catalog.ErrNoManifest,
+		catalog.ErrInvalidManifest, catalog.ErrInvalidSignature:
+		return jsonapi.BadRequest(err)
+	}
+	if os.IsNotExist(err) {
+		return jsonapi.NotFound(err)
+	}
+	return err
+}
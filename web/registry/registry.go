@@ -87,7 +87,7 @@ func proxyListReq(c echo.Context) error {
 	for _, app := range list.Apps {
 		slug, _ := app["slug"].(string)
 		for _, item := range maintenance {
-			if item["slug"] == slug {
+			if item["slug"] == slug && item["maintenance_activated"] == true {
 				app["maintenance_activated"] = true
 				app["maintenance_options"] = item["maintenance_options"]
 			}
@@ -135,7 +135,7 @@ func proxyMaintenanceReq(c echo.Context) error {
 	if pdoc.Type != permission.TypeWebapp && pdoc.Type != permission.TypeOauth {
 		return echo.NewHTTPError(http.StatusForbidden)
 	}
-	apps, err := registry.ProxyMaintenance(i.Registries())
+	apps, err := app.ListRegistryMaintenance(i.Registries())
 	if err != nil {
 		return err
 	}
@@ -145,7 +145,10 @@ func proxyMaintenanceReq(c echo.Context) error {
 	}
 	list := make([]interface{}, 0, len(apps)+len(maintenance))
 	for _, item := range maintenance {
-		list = append(list, item)
+		// The scheduled maintenances that have not started yet are not listed
+		if item["maintenance_activated"] == true {
+			list = append(list, item)
+		}
 	}
 	for _, item := range apps {
 		list = append(list, item)
@@ -24,6 +24,7 @@ import (
 	"github.com/cozy/cozy-stack/web/instances"
 	"github.com/cozy/cozy-stack/web/intents"
 	"github.com/cozy/cozy-stack/web/jobs"
+	"github.com/cozy/cozy-stack/web/limits"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/cozy/cozy-stack/web/move"
 	"github.com/cozy/cozy-stack/web/notes"
@@ -281,9 +282,11 @@ func SetupAdminRoutes(router *echo.Echo) error {
 
 	instances.Routes(router.Group("/instances", mws...))
 	apps.AdminRoutes(router.Group("/konnectors", mws...))
+	registry.AdminRoutes(router.Group("/registry", mws...))
 	version.Routes(router.Group("/version", mws...))
 	metrics.Routes(router.Group("/metrics", mws...))
 	oauth.Routes(router.Group("/oauth", mws...))
+	limits.Routes(router.Group("/limits", mws...))
 	realtime.Routes(router.Group("/realtime", mws...))
 	swift.Routes(router.Group("/swift", mws...))
 
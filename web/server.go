@@ -18,8 +18,10 @@ import (
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/i18n"
 	"github.com/cozy/cozy-stack/pkg/logger"
+	pkgregistry "github.com/cozy/cozy-stack/pkg/registry"
 	"github.com/cozy/cozy-stack/pkg/utils"
 	"github.com/cozy/cozy-stack/web/apps"
+	"github.com/cozy/cozy-stack/web/registry"
 	"github.com/sirupsen/logrus"
 
 	"github.com/labstack/echo/v4"
@@ -138,6 +140,9 @@ func ListenAndServe() (*Servers, error) {
 		return nil, err
 	}
 
+	// The registry built in the stack is reachable with local:// URLs
+	pkgregistry.RegisterLocal(registry.LocalHandler())
+
 	return &Servers{
 		major: major,
 		admin: admin,
@@ -0,0 +1,105 @@
+package settings
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+func getCredentialsVault(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Settings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return err
+	}
+	key, err := settings.CredentialsKey(inst, setting)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"enabled":  key != nil,
+		"unlocked": key != nil && !key.Locked(),
+		"reentry":  key != nil && setting.CredentialsReentry,
+	})
+}
+
+func enableCredentialsVault(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+
+	args := struct {
+		Passphrase string `json:"passphrase"`
+	}{}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	passphrase := []byte(args.Passphrase)
+	if err := lifecycle.CheckPassphrase(inst, passphrase); err != nil {
+		return jsonapi.Forbidden(instance.ErrInvalidPassphrase)
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return err
+	}
+	if setting.PassphraseKdfIterations == 0 {
+		// The passphrase will be migrated to a hashed one on the next login
+		return jsonapi.BadRequest(errors.New("The passphrase is not hashed on the client side"))
+	}
+	err = settings.EnableCredentialsVaultAndMigrate(inst, setting, passphrase)
+	if err == settings.ErrCredentialsVaultEnabled {
+		return jsonapi.Conflict(err)
+	}
+	if err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func reenterCredentialsPassphrase(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+
+	args := struct {
+		Previous   string `json:"previous_passphrase"`
+		Passphrase string `json:"passphrase"`
+	}{}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	passphrase := []byte(args.Passphrase)
+	if err := lifecycle.CheckPassphrase(inst, passphrase); err != nil {
+		return jsonapi.Forbidden(instance.ErrInvalidPassphrase)
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return err
+	}
+	err = setting.ReenterCredentialsPassphrase(inst, []byte(args.Previous), passphrase)
+	switch err {
+	case nil:
+		return c.NoContent(http.StatusNoContent)
+	case settings.ErrNoCredentialsReentry:
+		return jsonapi.Conflict(err)
+	case settings.ErrInvalidCredentialsKey:
+		return jsonapi.Forbidden(err)
+	default:
+		return err
+	}
+}
@@ -3,12 +3,17 @@
 package settings
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"image/png"
 	"net/http"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
@@ -16,6 +21,11 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	twoFactorAppEnrollPrefix = "2fa-app-enroll:"
+	twoFactorAppEnrollTTL    = 15 * time.Minute
+)
+
 type apiInstance struct {
 	doc *couchdb.JSONDoc
 }
@@ -134,6 +144,7 @@ func updateInstanceAuthMode(c echo.Context) error {
 	args := struct {
 		AuthMode                string `json:"auth_mode"`
 		TwoFactorActivationCode string `json:"two_factor_activation_code"`
+		Passphrase              string `json:"passphrase"`
 	}{}
 	if err := c.Bind(&args); err != nil {
 		return err
@@ -143,10 +154,20 @@ func updateInstanceAuthMode(c echo.Context) error {
 	if err != nil {
 		return jsonapi.BadRequest(err)
 	}
-	if inst.HasAuthMode(authMode) {
+	// An authenticator app can be enrolled again, for example on a new device
+	if inst.HasAuthMode(authMode) && authMode != instance.TwoFactorApp {
 		return c.NoContent(http.StatusNoContent)
 	}
 
+	// Enrolling or disabling an authenticator app requires the current
+	// passphrase, as it is the only factor that can be used to recover the
+	// access to the Cozy if the app is lost.
+	if authMode == instance.TwoFactorApp || inst.HasAuthMode(instance.TwoFactorApp) {
+		if err := lifecycle.CheckPassphrase(inst, []byte(args.Passphrase)); err != nil {
+			return jsonapi.Forbidden(instance.ErrInvalidPassphrase)
+		}
+	}
+
 	switch authMode {
 	case instance.Basic:
 	case instance.TwoFactorMail:
@@ -159,6 +180,8 @@ func updateInstanceAuthMode(c echo.Context) error {
 		if ok := inst.ValidateMailConfirmationCode(args.TwoFactorActivationCode); !ok {
 			return c.NoContent(http.StatusUnprocessableEntity)
 		}
+	case instance.TwoFactorApp:
+		return enrollTwoFactorApp(c, inst, args.TwoFactorActivationCode)
 	}
 
 	err = lifecycle.Patch(inst, &lifecycle.Options{AuthMode: args.AuthMode})
@@ -169,6 +192,84 @@ func updateInstanceAuthMode(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
+// enrollTwoFactorApp has two steps: without a code, a new TOTP secret is
+// generated and returned to be added in the authenticator app, and with a
+// code generated by the app for this secret, the two_factor_app mode is
+// activated.
+func enrollTwoFactorApp(c echo.Context, inst *instance.Instance, code string) error {
+	cache := config.GetConfig().CacheStorage
+	key := twoFactorAppEnrollPrefix + inst.Domain
+
+	if code == "" {
+		otpKey, err := inst.NewTwoFactorAppKey()
+		if err != nil {
+			return err
+		}
+		img, err := otpKey.Image(256, 256)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+		cache.Set(key, []byte(otpKey.Secret()), twoFactorAppEnrollTTL)
+		return c.JSON(http.StatusOK, echo.Map{
+			"secret":      otpKey.Secret(),
+			"otpauth_uri": otpKey.URL(),
+			"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+		})
+	}
+
+	secret, ok := cache.Get(key)
+	if !ok {
+		return c.NoContent(http.StatusUnprocessableEntity)
+	}
+	counter, ok := instance.ValidateTwoFactorAppKey(string(secret), code)
+	if !ok {
+		return c.NoContent(http.StatusUnprocessableEntity)
+	}
+	cache.Clear(key)
+	codes, err := lifecycle.EnableTwoFactorApp(inst, string(secret), counter)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"recovery_codes": codes,
+	})
+}
+
+// regenerateTwoFactorRecoveryCodes replaces the recovery codes of the
+// authenticator app by new ones, for example when they have been lost.
+func regenerateTwoFactorRecoveryCodes(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+
+	args := struct {
+		Passphrase string `json:"passphrase"`
+	}{}
+	if err := c.Bind(&args); err != nil {
+		return err
+	}
+	if err := lifecycle.CheckPassphrase(inst, []byte(args.Passphrase)); err != nil {
+		return jsonapi.Forbidden(instance.ErrInvalidPassphrase)
+	}
+
+	codes, err := lifecycle.RegenerateTwoFactorRecoveryCodes(inst)
+	if err == instance.ErrInvalidTwoFactor {
+		return jsonapi.BadRequest(err)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"recovery_codes": codes,
+	})
+}
+
 func clearMovedFrom(c echo.Context) error {
 	if !middlewares.IsLoggedIn(c) {
 		return echo.NewHTTPError(http.StatusForbidden)
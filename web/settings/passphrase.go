@@ -121,7 +121,7 @@ func registerPassphrase(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	if err := session.StoreNewLoginEntry(inst, sessionID, "", c.Request(), "registration", false); err != nil {
+	if err := session.StoreNewLoginEntry(inst, sessionID, "", c.Request(), middlewares.ClientIP(c), "registration", false); err != nil {
 		inst.Logger().Errorf("Could not store session history %q: %s", sessionID, err)
 	}
 
@@ -202,7 +202,7 @@ func updatePassphrase(c echo.Context) error {
 	}
 
 	// Else, we keep going on the standard checks (2FA, current passphrase, ...)
-	if inst.HasAuthMode(instance.TwoFactorMail) && len(args.TwoFactorToken) == 0 {
+	if inst.HasTwoFactor() && len(args.TwoFactorToken) == 0 {
 		if lifecycle.CheckPassphrase(inst, currentPassphrase) == nil {
 			var twoFactorToken []byte
 			twoFactorToken, err = lifecycle.SendTwoFactorPasscode(inst)
@@ -50,6 +50,30 @@ func getSessions(c echo.Context) error {
 	return jsonapi.DataList(c, http.StatusOK, objs, nil)
 }
 
+// deleteSession revokes a session. If it is the current session, the cookie
+// is also cleared.
+func deleteSession(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.Sessions); err != nil {
+		return err
+	}
+
+	sess, err := session.Get(inst, c.Param("id"))
+	if err == session.ErrInvalidID || err == session.ErrExpired {
+		return jsonapi.NotFound(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	cookie := sess.Delete(inst)
+	if current, ok := middlewares.GetSession(c); ok && current.ID() == sess.ID() {
+		c.SetCookie(cookie)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
 func warnings(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
 
@@ -89,17 +113,25 @@ func Routes(router *echo.Group) {
 	router.PUT("/passphrase", updatePassphrase)
 	router.GET("/hint", getHint)
 	router.PUT("/hint", updateHint)
+	router.GET("/credentials-vault", getCredentialsVault)
+	router.POST("/credentials-vault", enableCredentialsVault)
+	router.POST("/credentials-vault/reentry", reenterCredentialsPassphrase)
 
 	router.GET("/capabilities", getCapabilities)
 	router.GET("/instance", getInstance)
 	router.PUT("/instance", updateInstance)
 	router.PUT("/instance/auth_mode", updateInstanceAuthMode)
+	router.POST("/instance/two_factor_recovery_codes", regenerateTwoFactorRecoveryCodes)
 	router.PUT("/instance/sign_tos", updateInstanceTOS)
 	router.DELETE("/instance/moved_from", clearMovedFrom)
 
 	router.GET("/flags", getFlags)
 
 	router.GET("/sessions", getSessions)
+	router.DELETE("/sessions/:id", deleteSession)
+
+	router.GET("/webauthn/credentials", listWebAuthnCredentials)
+	router.DELETE("/webauthn/credentials/:id", deleteWebAuthnCredential)
 
 	router.GET("/clients", listClients)
 	router.DELETE("/clients/:id", revokeClient)
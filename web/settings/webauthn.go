@@ -0,0 +1,65 @@
+package settings
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/webauthn"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type apiWebAuthnCredential struct {
+	c *webauthn.Credential
+}
+
+func (c *apiWebAuthnCredential) ID() string                             { return c.c.ID() }
+func (c *apiWebAuthnCredential) Rev() string                            { return c.c.Rev() }
+func (c *apiWebAuthnCredential) DocType() string                        { return consts.WebAuthnCredentials }
+func (c *apiWebAuthnCredential) Clone() couchdb.Doc                     { return c }
+func (c *apiWebAuthnCredential) SetID(_ string)                         {}
+func (c *apiWebAuthnCredential) SetRev(_ string)                        {}
+func (c *apiWebAuthnCredential) Relationships() jsonapi.RelationshipMap { return nil }
+func (c *apiWebAuthnCredential) Included() []jsonapi.Object             { return nil }
+func (c *apiWebAuthnCredential) Links() *jsonapi.LinksList              { return nil }
+func (c *apiWebAuthnCredential) MarshalJSON() ([]byte, error)           { return json.Marshal(c.c) }
+
+func listWebAuthnCredentials(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Settings); err != nil {
+		return err
+	}
+
+	creds, err := webauthn.List(inst)
+	if err != nil {
+		return err
+	}
+
+	objs := make([]jsonapi.Object, len(creds))
+	for i, cred := range creds {
+		objs[i] = &apiWebAuthnCredential{cred}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func deleteWebAuthnCredential(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.Settings); err != nil {
+		return err
+	}
+
+	cred, err := webauthn.Get(inst, c.Param("id"))
+	if err != nil {
+		return jsonapi.NotFound(err)
+	}
+	if err := cred.Delete(inst); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -50,6 +50,7 @@ var (
 		"passphrase_reset.html",
 		"sharing_discovery.html",
 		"twofactor.html",
+		"webauthn_register.html",
 	}
 )
 
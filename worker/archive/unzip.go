@@ -25,10 +25,10 @@ func WorkerUnzip(ctx *job.WorkerContext) error {
 		return err
 	}
 	fs := ctx.Instance.VFS()
-	return unzip(fs, msg.Zip, msg.Destination)
+	return unzip(fs, msg.Zip, msg.Destination, ctx.SetProgress)
 }
 
-func unzip(fs vfs.VFS, zipID, destination string) error {
+func unzip(fs vfs.VFS, zipID, destination string, progress job.ProgressFunc) error {
 	zipDoc, err := fs.FileByID(zipID)
 	if err != nil {
 		return err
@@ -49,8 +49,10 @@ func unzip(fs vfs.VFS, zipID, destination string) error {
 	}
 
 	dirs := make(map[string]*vfs.DirDoc)
-	for _, f := range r.File {
+	total := int64(len(r.File))
+	for i, f := range r.File {
 		f.Name = utils.CleanUTF8(f.Name)
+		progress.Report(int64(i), total, f.Name)
 		name := path.Base(f.Name)
 		dirname := path.Dir(f.Name)
 		dir := dstDoc
@@ -110,5 +112,6 @@ func unzip(fs vfs.VFS, zipID, destination string) error {
 			return cerr
 		}
 	}
+	progress.Report(total, total, "")
 	return nil
 }
@@ -37,7 +37,7 @@ func TestUnzip(t *testing.T) {
 	_, err = fs.OpenFile(zip)
 	assert.NoError(t, err)
 
-	err = unzip(fs, zip.ID(), dst.ID())
+	err = unzip(fs, zip.ID(), dst.ID(), nil)
 	assert.NoError(t, err)
 
 	blue, err := fs.FileByPath("/destination/blue.svg")
@@ -85,13 +85,13 @@ func TestZip(t *testing.T) {
 		"hello.txt":    two.ID(),
 	}
 
-	err = createZip(fs, files, src.ID(), "archive.zip")
+	err = createZip(fs, files, src.ID(), "archive.zip", nil)
 	assert.NoError(t, err)
 
 	zipDoc, err := fs.FileByPath("/src/archive.zip")
 	assert.NoError(t, err)
 
-	err = unzip(fs, zipDoc.ID(), dst.ID())
+	err = unzip(fs, zipDoc.ID(), dst.ID(), nil)
 	assert.NoError(t, err)
 
 	f, err := fs.FileByPath("/dst/wet-cozy.jpg")
@@ -22,10 +22,10 @@ func WorkerZip(ctx *job.WorkerContext) error {
 		return err
 	}
 	fs := ctx.Instance.VFS()
-	return createZip(fs, msg.Files, msg.DirID, msg.Filename)
+	return createZip(fs, msg.Files, msg.DirID, msg.Filename, ctx.SetProgress)
 }
 
-func createZip(fs vfs.VFS, files map[string]string, dirID, filename string) error {
+func createZip(fs vfs.VFS, files map[string]string, dirID, filename string, progress job.ProgressFunc) error {
 	now := time.Now()
 	zipDoc, err := vfs.NewFileDoc(filename, dirID, -1, nil, "application/zip", "zip", now, false, false, nil)
 	if err != nil {
@@ -38,11 +38,15 @@ func createZip(fs vfs.VFS, files map[string]string, dirID, filename string) erro
 		return err
 	}
 	w := zip.NewWriter(z)
+	total := int64(len(files))
+	var done int64
 	for filePath, fileID := range files {
 		err = addFileToZip(fs, w, fileID, filePath)
 		if err != nil {
 			break
 		}
+		done++
+		progress.Report(done, total, filePath)
 	}
 	werr := w.Close()
 	zerr := z.Close()
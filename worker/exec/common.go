@@ -4,11 +4,15 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"io"
+	"io/ioutil"
 	"math"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/pkg/logger"
@@ -39,16 +43,18 @@ func init() {
 		WorkerStart: func(ctx *job.WorkerContext) (*job.WorkerContext, error) {
 			return ctx.WithCookie(&serviceWorker{}), nil
 		},
-		WorkerFunc:   worker,
-		WorkerCommit: commit,
-		Concurrency:  runtime.NumCPU() * 2,
-		MaxExecCount: 2,
-		Timeout:      defaultTimeout,
+		WorkerFunc:       worker,
+		WorkerCommit:     commit,
+		Concurrency:      runtime.NumCPU() * 2,
+		MaxExecCount:     2,
+		Timeout:          defaultTimeout,
+		ConcurrencyGroup: serviceConcurrencyGroup,
 	})
 }
 
 type execWorker interface {
 	Slug() string
+	Language() string
 	PrepareWorkDir(ctx *job.WorkerContext, i *instance.Instance) (workDir string, cleanDir func(), err error)
 	PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Instance) (cmd string, env []string, err error)
 	ScanOutput(ctx *job.WorkerContext, i *instance.Instance, line []byte) error
@@ -57,6 +63,29 @@ type execWorker interface {
 	Commit(ctx *job.WorkerContext, errjob error) error
 }
 
+// limitedWorker can be implemented by the exec workers that have limits
+// specific to the executed code, on top of the configuration of the worker.
+type limitedWorker interface {
+	// Limits returns the maximal duration and memory (in bytes) of the
+	// execution, or 0 for no limit.
+	Limits() (timeout time.Duration, memory int64)
+	// LimitExceeded is called when the execution has been stopped because it
+	// has exceeded one of its limits.
+	LimitExceeded(ctx *job.WorkerContext, i *instance.Instance, limit string)
+}
+
+// memoryLimiter can be implemented by the runtimes that can enforce a memory
+// limit.
+type memoryLimiter interface {
+	limitMemory(bytes int64)
+}
+
+// stderrScanner can be implemented by the exec workers that want to read the
+// lines written by the process on stderr.
+type stderrScanner interface {
+	ScanStderr(ctx *job.WorkerContext, line string)
+}
+
 func worker(ctx *job.WorkerContext) (err error) {
 	worker := ctx.Cookie().(execWorker)
 
@@ -71,18 +100,42 @@ func worker(ctx *job.WorkerContext) (err error) {
 		return err
 	}
 
+	limited, isLimited := worker.(limitedWorker)
+	var memory int64
+	if isLimited {
+		var timeout time.Duration
+		timeout, memory = limited.Limits()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = ctx.WithTimeout(timeout)
+			defer cancel()
+		}
+	}
+
 	cmdStr, env, err := worker.PrepareCmdEnv(ctx, ctx.Instance)
 	if err != nil {
 		worker.Logger(ctx).Errorf("PrepareCmdEnv: %s", err)
 		return err
 	}
 
+	rt, err := selectRuntime(worker.Slug(), worker.Language())
+	if err != nil {
+		worker.Logger(ctx).Errorf("selectRuntime: %s", err)
+		return err
+	}
+	if limiter, ok := rt.(memoryLimiter); ok && memory > 0 {
+		limiter.limitMemory(memory)
+	}
+
 	var stderrBuf bytes.Buffer
-	cmd := CreateCmd(cmdStr, workDir)
-	cmd.Env = env
 
 	// set stderr writable with a bytes.Buffer limited total size of 256Ko
-	cmd.Stderr = utils.LimitWriterDiscard(&stderrBuf, 256*1024)
+	var stderr io.Writer = utils.LimitWriterDiscard(&stderrBuf, 256*1024)
+	if scanner, ok := worker.(stderrScanner); ok {
+		lines := &lineWriter{line: func(line string) { scanner.ScanStderr(ctx, line) }}
+		stderr = io.MultiWriter(stderr, lines)
+		defer lines.Flush()
+	}
 
 	// Log out all things printed in stderr, whatever the result of the
 	// konnector is.
@@ -93,10 +146,7 @@ func worker(ctx *job.WorkerContext) (err error) {
 		}
 	}()
 
-	cmdOut, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
+	cmdOut, stdout := io.Pipe()
 	scanBuf := make([]byte, 16*1024)
 	scanOut := bufio.NewScanner(cmdOut)
 	scanOut.Buffer(scanBuf, 64*1024)
@@ -114,12 +164,14 @@ func worker(ctx *job.WorkerContext) (err error) {
 	}))
 	defer timer.ObserveDuration()
 
-	if err = cmd.Start(); err != nil {
+	exe, err := rt.Start(cmdStr, workDir, env, stdout, stderr)
+	if err != nil {
 		return wrapErr(ctx, err)
 	}
 
-	waitDone := make(chan error)
+	scanDone := make(chan struct{})
 	go func() {
+		defer close(scanDone)
 		for scanOut.Scan() {
 			if errOut := worker.ScanOutput(ctx, ctx.Instance, scanOut.Bytes()); errOut != nil {
 				log.Debug(errOut.Error())
@@ -127,8 +179,16 @@ func worker(ctx *job.WorkerContext) (err error) {
 		}
 		if errs := scanOut.Err(); errs != nil {
 			log.Errorf("could not scan stdout: %s", errs)
+			_, _ = io.Copy(ioutil.Discard, cmdOut)
 		}
-		waitDone <- cmd.Wait()
+	}()
+
+	waitDone := make(chan error)
+	go func() {
+		errWait := exe.Wait()
+		_ = stdout.Close()
+		<-scanDone
+		waitDone <- errWait
 		close(waitDone)
 	}()
 
@@ -136,13 +196,40 @@ func worker(ctx *job.WorkerContext) (err error) {
 	case err = <-waitDone:
 	case <-ctx.Done():
 		err = ctx.Err()
-		_ = KillCmd(cmd)
+		_ = exe.Kill()
 		<-waitDone
 	}
 
+	if isLimited && err != nil {
+		if limit := exceededLimit(ctx, exe, memory, stderrBuf.String()); limit != "" {
+			limited.LimitExceeded(ctx, ctx.Instance, limit)
+		}
+	}
+
 	return worker.Error(ctx.Instance, err)
 }
 
+// exceededLimit returns the limit that has been exceeded by an execution that
+// has failed, or an empty string if the failure is not caused by a limit.
+func exceededLimit(ctx context.Context, exe execution, memory int64, stderr string) string {
+	if ctx.Err() == context.DeadlineExceeded {
+		return app.ServiceLimitTimeout
+	}
+	if memory <= 0 {
+		return ""
+	}
+	// node exits with this message when its heap is full, and the OOM killer
+	// is counted in the memory events of the cgroup when it has reached its
+	// memory limit.
+	if strings.Contains(stderr, "JavaScript heap out of memory") {
+		return app.ServiceLimitMemory
+	}
+	if exe.OOMKilled() {
+		return app.ServiceLimitMemory
+	}
+	return ""
+}
+
 func commit(ctx *job.WorkerContext, errjob error) error {
 	return ctx.Cookie().(execWorker).Commit(ctx, errjob)
 }
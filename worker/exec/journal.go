@@ -0,0 +1,360 @@
+package exec
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/account"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+)
+
+const (
+	// journalMaxLines is the maximal number of lines kept in a journal. The
+	// next lines are dropped.
+	journalMaxLines = 1000
+	// journalMaxLineLength is the maximal length of a line of a journal.
+	journalMaxLineLength = 4000
+	// journalMaxSize is the maximal total length of the lines of a journal,
+	// to keep the CouchDB document small. The next lines are dropped.
+	journalMaxSize = 256 * 1024
+	// journalFlushInterval is the minimal delay between two saves of the
+	// journal while the konnector is running.
+	journalFlushInterval = 10 * time.Second
+	// journalsPerKonnector is the number of journals kept for a konnector.
+	journalsPerKonnector = 10
+)
+
+// JournalRunning is the state of a journal while the konnector is running.
+const JournalRunning = "running"
+
+// redacted replaces the secrets in the journals.
+const redacted = "***"
+
+var redactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)((?:password|passwd|pwd|secret|token|apikey|api_key)["']?\s*[:=]\s*["']?)[^\s"',;&}]+`),
+	regexp.MustCompile(`(?i)(bearer\s+)[a-z0-9._~+/=-]+`),
+	regexp.MustCompile(`(?i)(basic\s+)[a-z0-9+/=]{8,}`),
+}
+
+// JournalLine is a line written by a konnector on its stdout or stderr.
+type JournalLine struct {
+	Time    time.Time `json:"time"`
+	Stream  string    `json:"stream"` // "stdout" or "stderr"
+	Level   string    `json:"level,omitempty"`
+	Message string    `json:"message"`
+}
+
+// Journal is the execution journal of a konnector: the lines written by the
+// konnector, with the timings and the final status of the job. Its
+// identifier is the identifier of the job, and the secrets of the account
+// are redacted.
+type Journal struct {
+	DocID      string        `json:"_id,omitempty"`
+	DocRev     string        `json:"_rev,omitempty"`
+	Konnector  string        `json:"konnector"`
+	Account    string        `json:"account,omitempty"`
+	TriggerID  string        `json:"trigger_id,omitempty"`
+	State      string        `json:"state"`
+	Error      string        `json:"error,omitempty"`
+	Attempts   int           `json:"attempts"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at,omitempty"`
+	Duration   float64       `json:"duration,omitempty"` // in seconds
+	Lines      []JournalLine `json:"lines"`
+	Truncated  bool          `json:"truncated,omitempty"`
+
+	mu      sync.Mutex
+	db      prefixer.Prefixer
+	secrets []string
+	size    int
+	savedAt time.Time
+	saving  bool
+}
+
+// ID implements the couchdb.Doc interface
+func (j *Journal) ID() string { return j.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (j *Journal) Rev() string { return j.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (j *Journal) DocType() string { return consts.JobLogs }
+
+// Clone implements the couchdb.Doc interface
+func (j *Journal) Clone() couchdb.Doc {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	cloned := &Journal{
+		DocID:      j.DocID,
+		DocRev:     j.DocRev,
+		Konnector:  j.Konnector,
+		Account:    j.Account,
+		TriggerID:  j.TriggerID,
+		State:      j.State,
+		Error:      j.Error,
+		Attempts:   j.Attempts,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+		Duration:   j.Duration,
+		Truncated:  j.Truncated,
+	}
+	cloned.Lines = make([]JournalLine, len(j.Lines))
+	copy(cloned.Lines, j.Lines)
+	return cloned
+}
+
+// SetID implements the couchdb.Doc interface
+func (j *Journal) SetID(id string) { j.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (j *Journal) SetRev(rev string) { j.DocRev = rev }
+
+// Relationships implements the jsonapi.Object interface
+func (j *Journal) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Included implements the jsonapi.Object interface
+func (j *Journal) Included() []jsonapi.Object { return nil }
+
+// Links implements the jsonapi.Object interface
+func (j *Journal) Links() *jsonapi.LinksList { return nil }
+
+// newJournal creates the journal for the konnector executed by the job, and
+// saves it.
+func newJournal(ctx *job.WorkerContext, msg *KonnectorMessage) *Journal {
+	j := &Journal{
+		DocID:     ctx.JobID(),
+		Konnector: msg.Konnector,
+		Account:   msg.Account,
+		State:     JournalRunning,
+		StartedAt: time.Now().UTC(),
+		Lines:     []JournalLine{},
+		db:        ctx.Instance,
+	}
+	if triggerID, ok := ctx.TriggerID(); ok {
+		j.TriggerID = triggerID
+	}
+	j.saving = true
+	if err := j.save(); err != nil {
+		ctx.Logger().Warnf("Cannot save the journal of the konnector: %s", err)
+	}
+	return j
+}
+
+// GetJournal returns the execution journal of the konnector run by the given
+// job.
+func GetJournal(db prefixer.Prefixer, jobID string) (*Journal, error) {
+	j := &Journal{}
+	if err := couchdb.GetDoc(db, consts.JobLogs, jobID, j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// addSecrets adds some values that must not appear in the journal.
+func (j *Journal) addSecrets(secrets ...string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, secret := range secrets {
+		// Very short values would redact too many things
+		if len(secret) >= 4 {
+			j.secrets = append(j.secrets, secret)
+		}
+	}
+}
+
+// addAccountSecrets adds the credentials of the account to the secrets.
+func (j *Journal) addAccountSecrets(acc *account.Account, cipher *account.Cipher) {
+	if acc == nil {
+		return
+	}
+	j.addSecrets(acc.Token)
+	if acc.Basic != nil {
+		j.addSecrets(acc.Basic.Password)
+		if acc.Basic.EncryptedCredentials != "" {
+			if _, password, err := cipher.DecryptCredentials(acc.Basic.EncryptedCredentials); err == nil {
+				j.addSecrets(password)
+			}
+		}
+	}
+	if acc.Oauth != nil {
+		j.addSecrets(acc.Oauth.AccessToken, acc.Oauth.RefreshToken, acc.Oauth.ClientSecret)
+	}
+}
+
+// redact removes the secrets from the given text.
+func (j *Journal) redact(text string) string {
+	for _, secret := range j.secrets {
+		text = strings.Replace(text, secret, redacted, -1)
+	}
+	for _, re := range redactPatterns {
+		text = re.ReplaceAllString(text, "${1}"+redacted)
+	}
+	return text
+}
+
+// newAttempt is called at the beginning of each execution of the konnector
+// for the job.
+func (j *Journal) newAttempt() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Attempts++
+}
+
+// addLine adds a line to the journal, and sends it to the clients via the
+// realtime. The journal is saved if it has not been saved for some time.
+func (j *Journal) addLine(stream, level, message string) {
+	j.mu.Lock()
+	if len(message) > journalMaxLineLength {
+		message = message[:journalMaxLineLength]
+	}
+	if len(j.Lines) >= journalMaxLines || j.size+len(message) > journalMaxSize {
+		j.Truncated = true
+		j.mu.Unlock()
+		return
+	}
+	line := JournalLine{
+		Time:    time.Now().UTC(),
+		Stream:  stream,
+		Level:   level,
+		Message: j.redact(message),
+	}
+	j.Lines = append(j.Lines, line)
+	j.size += len(line.Message)
+	flush := !j.saving && time.Since(j.savedAt) >= journalFlushInterval
+	if flush {
+		j.saving = true
+	}
+	j.mu.Unlock()
+
+	realtime.GetHub().Publish(j.db, realtime.EventCreate,
+		&couchdb.JSONDoc{Type: consts.JobLogs, M: map[string]interface{}{
+			"_id":     j.DocID,
+			"stream":  line.Stream,
+			"level":   line.Level,
+			"message": line.Message,
+			"time":    line.Time,
+		}},
+		nil)
+
+	if flush {
+		// If the save fails, it will be retried with the next lines.
+		_ = j.save()
+	}
+}
+
+// save saves a copy of the journal in CouchDB. The saving flag must have been
+// set by the caller, to avoid concurrent saves of the journal.
+func (j *Journal) save() error {
+	doc := j.Clone().(*Journal)
+	var err error
+	if doc.DocRev == "" {
+		err = couchdb.CreateNamedDocWithDB(j.db, doc)
+	} else {
+		err = couchdb.UpdateDoc(j.db, doc)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err == nil {
+		j.DocRev = doc.DocRev
+	}
+	j.savedAt = time.Now()
+	j.saving = false
+	return err
+}
+
+// finish saves the journal with the final status of the job, and removes the
+// oldest journals of the konnector.
+func (j *Journal) finish(errjob error) error {
+	j.mu.Lock()
+	j.FinishedAt = time.Now().UTC()
+	j.Duration = j.FinishedAt.Sub(j.StartedAt).Seconds()
+	if errjob == nil {
+		j.State = string(job.Done)
+		j.Error = ""
+	} else {
+		j.State = string(job.Errored)
+		j.Error = j.redact(errjob.Error())
+	}
+	j.saving = true
+	j.mu.Unlock()
+
+	if err := j.save(); err != nil {
+		return err
+	}
+	realtime.GetHub().Publish(j.db, realtime.EventUpdate,
+		&couchdb.JSONDoc{Type: consts.JobLogs, M: map[string]interface{}{
+			"_id":   j.DocID,
+			"state": j.State,
+			"error": j.Error,
+		}},
+		nil)
+	return cleanJournals(j.db, j.Konnector)
+}
+
+// cleanJournals removes the oldest journals of a konnector, to keep only the
+// last ones.
+func cleanJournals(db prefixer.Prefixer, slug string) error {
+	var journals []*Journal
+	req := &couchdb.FindRequest{
+		UseIndex: "by-konnector",
+		Selector: mango.Equal("konnector", slug),
+		Sort: mango.SortBy{
+			{Field: "konnector", Direction: mango.Desc},
+			{Field: "started_at", Direction: mango.Desc},
+		},
+		Skip:  journalsPerKonnector,
+		Limit: 100,
+	}
+	if err := couchdb.FindDocs(db, consts.JobLogs, req, &journals); err != nil {
+		return err
+	}
+	if len(journals) == 0 {
+		return nil
+	}
+	docs := make([]couchdb.Doc, len(journals))
+	for i, j := range journals {
+		docs[i] = j
+	}
+	return couchdb.BulkDeleteDocs(db, consts.JobLogs, docs)
+}
+
+// lineWriter is an io.Writer that calls a function for each line written.
+type lineWriter struct {
+	buf  bytes.Buffer
+	line func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		idx := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+		line := string(w.buf.Next(idx + 1))
+		w.line(strings.TrimRight(line, "\r\n"))
+	}
+	if w.buf.Len() > journalMaxLineLength {
+		w.line(string(w.buf.Next(w.buf.Len())))
+	}
+	return len(p), nil
+}
+
+// Flush calls the function for the last line, if it does not end with a new
+// line character.
+func (w *lineWriter) Flush() {
+	if w.buf.Len() > 0 {
+		w.line(string(w.buf.Next(w.buf.Len())))
+	}
+}
+
+var _ jsonapi.Object = &Journal{}
@@ -0,0 +1,47 @@
+package exec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/account"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalRedact(t *testing.T) {
+	j := &Journal{}
+	j.addAccountSecrets(&account.Account{
+		Basic: &account.BasicInfo{Login: "alice", Password: "s3cr3t-p4ss"},
+		Oauth: &account.OauthInfo{AccessToken: "abcdefgh12345678"},
+	}, account.NewCipher(nil))
+	j.addSecrets("abc")
+	assert.Equal(t, "login with alice / *** failed", j.redact("login with alice / s3cr3t-p4ss failed"))
+	assert.Equal(t, "GET /api?access=***", j.redact("GET /api?access=abcdefgh12345678"))
+	assert.Equal(t, `{"password":"***","user":"bob"}`, j.redact(`{"password":"hunter22","user":"bob"}`))
+	assert.Equal(t, "Authorization: Bearer ***", j.redact("Authorization: Bearer eyJhbGciOi.xyz"))
+	assert.Equal(t, "token=*** & abc", j.redact("token=foobar & abc"))
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{line: func(line string) { lines = append(lines, line) }}
+	_, _ = w.Write([]byte("first line\nsecond "))
+	_, _ = w.Write([]byte("line\r\nthird"))
+	assert.Equal(t, []string{"first line", "second line"}, lines)
+	w.Flush()
+	assert.Equal(t, []string{"first line", "second line", "third"}, lines)
+}
+
+func TestJournalTruncated(t *testing.T) {
+	db := prefixer.NewPrefixer("cozy.example.net", "cozy-example-net")
+	j := &Journal{db: db, saving: true}
+	line := strings.Repeat("a", journalMaxLineLength+10)
+	for i := 0; i < journalMaxLines; i++ {
+		j.addLine("stdout", "", line)
+	}
+	assert.True(t, j.Truncated)
+	assert.Len(t, j.Lines, journalMaxSize/journalMaxLineLength)
+	assert.Len(t, j.Lines[0].Message, journalMaxLineLength)
+	assert.LessOrEqual(t, j.size, journalMaxSize)
+}
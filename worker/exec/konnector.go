@@ -6,13 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/account"
 	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/job"
@@ -25,7 +28,6 @@ import (
 	"github.com/cozy/cozy-stack/pkg/logger"
 	"github.com/cozy/cozy-stack/pkg/metadata"
 	"github.com/cozy/cozy-stack/pkg/realtime"
-	"github.com/cozy/cozy-stack/pkg/registry"
 	"github.com/spf13/afero"
 )
 
@@ -33,8 +35,13 @@ const (
 	konnErrorLoginFailed         = "LOGIN_FAILED"
 	konnErrorUserActionNeeded    = "USER_ACTION_NEEDED"
 	konnErrorUserActionNeededCgu = "USER_ACTION_NEEDED.CGU_FORM"
+	konnErrorVaultLocked         = "VAULT_LOCKED"
 )
 
+// maintenanceRescheduleSpread is the duration after the end of a maintenance
+// window during which the skipped konnector runs are rescheduled.
+const maintenanceRescheduleSpread = 30 * time.Minute
+
 type konnectorWorker struct {
 	slug string
 	msg  *KonnectorMessage
@@ -42,6 +49,8 @@ type konnectorWorker struct {
 
 	err     error
 	lastErr error
+
+	journal *Journal
 }
 
 const (
@@ -122,31 +131,28 @@ func beforeHookKonnector(j *job.Job) (bool, error) {
 
 	if err := json.Unmarshal(j.Message, &msg); err == nil {
 		slug = msg.Konnector
-		doc, err := app.GetMaintenanceOptions(slug)
+		opts, err := app.GetMaintenanceOptions(slug)
 		if err != nil {
 			j.Logger().Warnf("konnector %q could not get local maintenance status", slug)
-		} else if doc != nil {
-			if j.Manual {
-				opts, ok := doc["maintenance_options"].(map[string]interface{})
-				if ok && opts["flag_disallow_manual_exec"] != true {
-					return true, nil
-				}
-			}
-			j.Logger().Infof("konnector %q has not been triggered because of its maintenance status", slug)
-			return false, nil
 		}
 		inst, err := lifecycle.GetInstance(j.DomainName())
 		if err != nil {
 			return false, err
 		}
-		app, err := registry.GetApplication(slug, inst.Registries())
-		if err != nil {
-			j.Logger().Warnf("konnector %q could not get application to fetch maintenance status", slug)
-		} else if app.MaintenanceActivated {
-			if j.Manual && !app.MaintenanceOptions.FlagDisallowManualExec {
+		if opts == nil {
+			opts, err = app.GetRegistryMaintenanceOptions(slug, inst.Registries())
+			if err != nil {
+				j.Logger().Warnf("konnector %q could not get registry maintenance status", slug)
+			}
+		}
+		if opts != nil {
+			if j.Manual && opts["flag_disallow_manual_exec"] != true {
 				return true, nil
 			}
 			j.Logger().Infof("konnector %q has not been triggered because of its maintenance status", slug)
+			if end := app.MaintenanceEnd(opts); !end.IsZero() && !j.Manual && j.TriggerID != "" {
+				return postponeAfterMaintenance(j, end)
+			}
 			return false, nil
 		}
 
@@ -176,6 +182,23 @@ func beforeHookKonnector(j *job.Job) (bool, error) {
 	return true, nil
 }
 
+// postponeAfterMaintenance pushes again a konnector job that has been
+// skipped because of a maintenance, with the same trigger, shortly after the
+// end of the maintenance window. The runs are spread to avoid a spike of load
+// when the window ends. The job is skipped if the trigger already has a
+// postponed job.
+func postponeAfterMaintenance(j *job.Job, end time.Time) (bool, error) {
+	jobs, err := job.GetJobs(j, j.TriggerID, 1)
+	if err != nil {
+		return false, err
+	}
+	if len(jobs) > 0 && jobs[0].State == job.Queued {
+		return false, nil
+	}
+	at := end.Add(time.Duration(rand.Int63n(int64(maintenanceRescheduleSpread))))
+	return false, job.ErrPostponed{Until: at}
+}
+
 func (w *konnectorWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Instance) (string, func(), error) {
 	cleanDir := func() {}
 
@@ -197,6 +220,10 @@ func (w *konnectorWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Ins
 	slug := msg.Konnector
 	w.slug = slug
 	w.msg = &msg
+	if w.journal == nil {
+		w.journal = newJournal(ctx, &msg)
+	}
+	w.journal.newAttempt()
 
 	w.man, err = app.GetKonnectorBySlugAndUpdate(i, slug,
 		app.Copier(consts.KonnectorType, i), i.Registries())
@@ -214,6 +241,17 @@ func (w *konnectorWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Ins
 		if couchdb.IsNotFoundError(err) {
 			return "", cleanDir, job.ErrBadTrigger{Err: err}
 		}
+		cipher, err := settings.CredentialsCipher(i)
+		if err != nil {
+			return "", cleanDir, err
+		}
+		// The credentials encrypted with the key of the instance can be
+		// decrypted only if the user has logged in recently.
+		if cipher.Locked() && acc.Basic != nil &&
+			account.IsEncryptedForInstance(acc.Basic.EncryptedCredentials) {
+			return "", cleanDir, errors.New(konnErrorVaultLocked)
+		}
+		w.journal.addAccountSecrets(acc, cipher)
 	}
 
 	man := w.man
@@ -488,6 +526,13 @@ func (w *konnectorWorker) Slug() string {
 	return w.slug
 }
 
+func (w *konnectorWorker) Language() string {
+	if language := w.man.Language(); language != "" {
+		return language
+	}
+	return "node"
+}
+
 func (w *konnectorWorker) PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Instance) (cmd string, env []string, err error) {
 	parameters := w.man.Parameters()
 
@@ -511,14 +556,12 @@ func (w *konnectorWorker) PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Inst
 		return
 	}
 
-	language := w.man.Language()
-	if language == "" {
-		language = "node"
-	}
-
 	// Directly pass the job message as fields parameters
 	fieldsJSON := w.msg.ToJSON()
 	token := i.BuildKonnectorToken(w.man.Slug())
+	if w.journal != nil {
+		w.journal.addSecrets(token)
+	}
 
 	payload := []byte{}
 	if p, err := ctx.UnmarshalPayload(); err == nil {
@@ -535,7 +578,7 @@ func (w *konnectorWorker) PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Inst
 		"COZY_FIELDS=" + fieldsJSON,
 		"COZY_PARAMETERS=" + string(paramsJSON),
 		"COZY_PAYLOAD=" + string(payload),
-		"COZY_LANGUAGE=" + language,
+		"COZY_LANGUAGE=" + w.Language(),
 		"COZY_LOCALE=" + i.Locale,
 		"COZY_TIME_LIMIT=" + ctxToTimeLimit(ctx),
 		"COZY_JOB_ID=" + ctx.ID(),
@@ -558,6 +601,9 @@ func (w *konnectorWorker) ScanOutput(ctx *job.WorkerContext, i *instance.Instanc
 		NoRetry bool   `json:"no_retry"`
 	}
 	if err := json.Unmarshal(line, &msg); err != nil {
+		if w.journal != nil {
+			w.journal.addLine("stdout", "", string(line))
+		}
 		return fmt.Errorf("Could not parse stdout as JSON: %q", string(line))
 	}
 
@@ -566,6 +612,10 @@ func (w *konnectorWorker) ScanOutput(ctx *job.WorkerContext, i *instance.Instanc
 		msg.Message = msg.Message[:4000]
 	}
 
+	if w.journal != nil {
+		w.journal.addLine("stdout", msg.Type, msg.Message)
+	}
+
 	log := w.Logger(ctx)
 	switch msg.Type {
 	case konnectorMsgTypeDebug, konnectorMsgTypeInfo:
@@ -596,6 +646,12 @@ func (w *konnectorWorker) ScanOutput(ctx *job.WorkerContext, i *instance.Instanc
 	return nil
 }
 
+func (w *konnectorWorker) ScanStderr(ctx *job.WorkerContext, line string) {
+	if w.journal != nil {
+		w.journal.addLine("stderr", "", line)
+	}
+}
+
 func (w *konnectorWorker) Error(i *instance.Instance, err error) error {
 	if w.err != nil {
 		return w.err
@@ -622,5 +678,10 @@ func (w *konnectorWorker) Commit(ctx *job.WorkerContext, errjob error) error {
 	} else {
 		log.Infof("Konnector failure: %s", errjob)
 	}
+	if w.journal != nil {
+		if err := w.journal.finish(errjob); err != nil {
+			log.Warnf("Cannot save the journal of the konnector: %s", err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,142 @@
+package exec
+
+import (
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+)
+
+// execRuntime is a backend used to execute the code of the konnectors and
+// services: a plain process, a process in a sandbox, etc.
+type execRuntime interface {
+	// Start starts the execution of the konnector or service in the working
+	// directory, with the given environment variables. cmdStr is the command
+	// from the konnectors.cmd parameter of the configuration file. The
+	// outputs of the execution are written in stdout and stderr.
+	Start(cmdStr, workDir string, env []string, stdout, stderr io.Writer) (execution, error)
+}
+
+// execution is a konnector or service executed by a runtime.
+type execution interface {
+	// Wait waits for the end of the execution, and frees the resources used
+	// by the runtime.
+	Wait() error
+	// Kill stops the execution (and the children processes).
+	Kill() error
+	// ExitCode returns the exit code of the execution, or -1 if it has been
+	// killed.
+	ExitCode() int
+	// OOMKilled returns true if the execution has been killed because it has
+	// reached its memory limit.
+	OOMKilled() bool
+}
+
+// selectRuntime returns the runtime to use for the given konnector or
+// service, from the konnectors section of the configuration file. The
+// runtime for the slug has the precedence over the one for the language, and
+// the default is a plain process.
+func selectRuntime(slug, language string) (execRuntime, error) {
+	cfg := config.GetConfig().Konnectors
+	name, ok := cfg.Slugs[slug]
+	if !ok {
+		name, ok = cfg.Languages[language]
+	}
+	if !ok {
+		return &processRuntime{}, nil
+	}
+	return newRuntime(cfg.Runtimes[name])
+}
+
+func newRuntime(rt config.Runtime) (execRuntime, error) {
+	switch rt.Type {
+	case "namespaces":
+		return newNamespacesRuntime(rt)
+	case "wasm":
+		return newWasmRuntime(rt)
+	default:
+		return &processRuntime{cmd: rt.Cmd}, nil
+	}
+}
+
+// processRuntime executes the konnectors and services with a plain process,
+// the sandboxing being left to the command.
+type processRuntime struct {
+	cmd string
+}
+
+func (r *processRuntime) command(cmdStr, workDir string, env []string) *exec.Cmd {
+	if r.cmd != "" {
+		cmdStr = r.cmd
+	}
+	cmd := CreateCmd(cmdStr, workDir)
+	cmd.Env = env
+	return cmd
+}
+
+func (r *processRuntime) Start(cmdStr, workDir string, env []string, stdout, stderr io.Writer) (execution, error) {
+	cmd := r.command(cmdStr, workDir, env)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdExecution{cmd: cmd, kill: KillCmd}, nil
+}
+
+// cmdExecution is an execution made by a process.
+type cmdExecution struct {
+	cmd     *exec.Cmd
+	kill    func(cmd *exec.Cmd) error
+	release func()
+
+	// memoryEvents is the memory.events file of the cgroup of the process,
+	// if it has one, and oomKills is the number of processes killed by the
+	// OOM killer in this cgroup, read when the process has finished.
+	memoryEvents string
+	oomKills     int
+}
+
+func (e *cmdExecution) Wait() error {
+	err := e.cmd.Wait()
+	if e.memoryEvents != "" {
+		if events, errRead := ioutil.ReadFile(e.memoryEvents); errRead == nil {
+			e.oomKills = parseOOMKills(events)
+		}
+	}
+	if e.release != nil {
+		e.release()
+	}
+	return err
+}
+
+func (e *cmdExecution) Kill() error {
+	return e.kill(e.cmd)
+}
+
+func (e *cmdExecution) ExitCode() int {
+	if e.cmd.ProcessState == nil {
+		return 0
+	}
+	return e.cmd.ProcessState.ExitCode()
+}
+
+func (e *cmdExecution) OOMKilled() bool {
+	return e.oomKills > 0
+}
+
+// parseOOMKills returns the oom_kill counter of a memory.events file of a
+// cgroup.
+func parseOOMKills(events []byte) int {
+	for _, line := range strings.Split(string(events), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, _ := strconv.Atoi(fields[1])
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,227 @@
+//go:build linux
+// +build linux
+
+package exec
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+)
+
+// cgroupRoot is the cgroup (v2) under which a cgroup is created for each
+// execution of the namespaces runtime.
+const cgroupRoot = "/sys/fs/cgroup/cozy-stack"
+
+// cpuPeriod is the period, in microseconds, used for the CPU limit.
+const cpuPeriod = 100000
+
+// slirpCmd is the command used to give an egress to the network namespace of
+// the sandbox.
+const slirpCmd = "slirp4netns"
+
+// namespacesRuntime executes the konnectors and services in new Linux
+// namespaces (user, mount, PID, IPC, UTS, and network unless the network of
+// the host is explicitly used), with the memory, CPU and number of processes
+// limited by a cgroup. With the slirp4netns network (the default), the
+// egress goes through a user-mode network stack that can't reach the
+// loopback of the host. The process is moved in its cgroup before executing the command,
+// and it only sees a minimal root filesystem (see sandboxInit). The user namespace allows to
+// run it without being root.
+type namespacesRuntime struct {
+	cmd     string
+	memory  int64
+	cpu     float64
+	pids    int
+	network string
+}
+
+func newNamespacesRuntime(rt config.Runtime) (execRuntime, error) {
+	return &namespacesRuntime{
+		cmd:     rt.Cmd,
+		memory:  rt.Memory,
+		cpu:     rt.CPU,
+		pids:    rt.Pids,
+		network: rt.Network,
+	}, nil
+}
+
+// limitMemory lowers the memory limit of the cgroup for this execution.
+func (r *namespacesRuntime) limitMemory(bytes int64) {
+	if r.memory == 0 || bytes < r.memory {
+		r.memory = bytes
+	}
+}
+
+func (r *namespacesRuntime) Start(cmdStr, workDir string, env []string, stdout, stderr io.Writer) (execution, error) {
+	if r.cmd != "" {
+		cmdStr = r.cmd
+	}
+	cmdPath, err := exec.LookPath(cmdStr)
+	if err != nil {
+		return nil, err
+	}
+	if cmdPath, err = filepath.Abs(cmdPath); err != nil {
+		return nil, err
+	}
+	workDir, err = filepath.Abs(workDir)
+	if err != nil {
+		return nil, err
+	}
+	rootDir, err := ioutil.TempDir("", "cozy-sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	cgroupDir, err := r.createCgroup()
+	if err != nil {
+		_ = os.Remove(rootDir)
+		return nil, err
+	}
+	release := func() {
+		_ = os.Remove(cgroupDir)
+		_ = os.Remove(rootDir)
+	}
+	// The sandbox init waits on this pipe for the process to be moved in its
+	// cgroup before doing anything else.
+	ready, start, err := os.Pipe()
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	cmd := exec.Command("/proc/self/exe", rootDir, workDir, cmdPath, r.network)
+	cmd.Args[0] = sandboxInitName
+	cmd.Env = env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.ExtraFiles = []*os.File{ready}
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+		syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	if r.network != "host" {
+		flags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Cloneflags: uintptr(flags),
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+		GidMappingsEnableSetgroups: false,
+	}
+	err = cmd.Start()
+	ready.Close()
+	if err != nil {
+		start.Close()
+		release()
+		return nil, fmt.Errorf("exec: cannot start the sandbox: %s", err)
+	}
+	// The process is moved in the cgroup, and its network is set up, before
+	// it can execute the konnector, so the limits apply to the konnector and
+	// all its children from the start.
+	pid := cmd.Process.Pid
+	err = ioutil.WriteFile(filepath.Join(cgroupDir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+	if err != nil {
+		err = fmt.Errorf("exec: cannot move the sandbox in its cgroup: %s", err)
+	} else if r.network == "slirp4netns" {
+		var slirp *exec.Cmd
+		if slirp, err = startSlirp(pid); err == nil {
+			releaseDirs := release
+			release = func() {
+				_ = slirp.Process.Kill()
+				_ = slirp.Wait()
+				releaseDirs()
+			}
+		}
+	}
+	if err == nil {
+		_, err = start.Write([]byte{1})
+	}
+	start.Close()
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		release()
+		return nil, err
+	}
+	return &cmdExecution{
+		cmd:          cmd,
+		kill:         KillCmd,
+		release:      release,
+		memoryEvents: filepath.Join(cgroupDir, "memory.events"),
+	}, nil
+}
+
+// createCgroup creates a new cgroup with the limits of the runtime, and
+// returns its path.
+func (r *namespacesRuntime) createCgroup() (string, error) {
+	if err := os.MkdirAll(cgroupRoot, 0755); err != nil {
+		return "", fmt.Errorf("exec: cannot create the cgroup: %s", err)
+	}
+	controllers := filepath.Join(cgroupRoot, "cgroup.subtree_control")
+	if err := ioutil.WriteFile(controllers, []byte("+memory +cpu +pids"), 0644); err != nil {
+		return "", fmt.Errorf("exec: cannot enable the cgroup controllers: %s", err)
+	}
+	dir := filepath.Join(cgroupRoot, crypto.GenerateRandomString(16))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", fmt.Errorf("exec: cannot create the cgroup: %s", err)
+	}
+	limits := make(map[string]string)
+	if r.memory > 0 {
+		limits["memory.max"] = strconv.FormatInt(r.memory, 10)
+	}
+	if r.cpu > 0 {
+		quota := int64(r.cpu * cpuPeriod)
+		limits["cpu.max"] = fmt.Sprintf("%d %d", quota, cpuPeriod)
+	}
+	if r.pids > 0 {
+		limits["pids.max"] = strconv.Itoa(r.pids)
+	}
+	for file, value := range limits {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+			_ = os.Remove(dir)
+			return "", fmt.Errorf("exec: cannot set %s: %s", file, err)
+		}
+	}
+	return dir, nil
+}
+
+// startSlirp gives an egress to the network namespace of the process with the
+// given pid, and returns when the network is configured. The loopback of the
+// host is not reachable from the sandbox.
+func startSlirp(pid int) (*exec.Cmd, error) {
+	slirpPath, err := exec.LookPath(slirpCmd)
+	if err != nil {
+		return nil, fmt.Errorf("exec: %s is needed for the network of the sandbox: %s", slirpCmd, err)
+	}
+	ready, done, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer ready.Close()
+	cmd := exec.Command(slirpPath, "--configure", "--mtu=65520",
+		"--disable-host-loopback", "--ready-fd=3", strconv.Itoa(pid), "tap0")
+	cmd.ExtraFiles = []*os.File{done}
+	err = cmd.Start()
+	done.Close()
+	if err != nil {
+		return nil, fmt.Errorf("exec: cannot start %s: %s", slirpCmd, err)
+	}
+	buf := make([]byte, 1)
+	if n, _ := ready.Read(buf); n != 1 {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("exec: %s has failed to configure the network", slirpCmd)
+	}
+	return cmd, nil
+}
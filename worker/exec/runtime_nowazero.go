@@ -0,0 +1,14 @@
+//go:build !wazero
+// +build !wazero
+
+package exec
+
+import (
+	"errors"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+)
+
+func newWasmRuntime(rt config.Runtime) (execRuntime, error) {
+	return nil, errors.New("exec: the wasm runtime needs a stack built with the wazero tag")
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package exec
+
+import (
+	"errors"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+)
+
+func newNamespacesRuntime(rt config.Runtime) (execRuntime, error) {
+	return nil, errors.New("exec: the namespaces runtime is only available on Linux")
+}
@@ -0,0 +1,68 @@
+package exec
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectRuntime(t *testing.T) {
+	cfg := config.GetConfig()
+	previous := cfg.Konnectors
+	defer func() { cfg.Konnectors = previous }()
+	cfg.Konnectors = config.Konnectors{
+		Cmd: "./scripts/konnector-node-run.sh",
+		Runtimes: map[string]config.Runtime{
+			"custom": {Type: "process", Cmd: "./custom-run.sh"},
+		},
+		Languages: map[string]string{"node": "custom"},
+	}
+
+	rt, err := selectRuntime("other", "python")
+	assert.NoError(t, err)
+	assert.IsType(t, &processRuntime{}, rt)
+	cmd := rt.(*processRuntime).command("./run.sh", "/tmp/work", []string{"FOO=bar"})
+	assert.Equal(t, "./run.sh", cmd.Path)
+	assert.Equal(t, []string{"./run.sh", "/tmp/work"}, cmd.Args)
+	assert.Equal(t, []string{"FOO=bar"}, cmd.Env)
+
+	rt, err = selectRuntime("other", "node")
+	assert.NoError(t, err)
+	cmd = rt.(*processRuntime).command("./run.sh", "/tmp/work", nil)
+	assert.Equal(t, "./custom-run.sh", cmd.Path)
+	assert.Equal(t, []string{"./custom-run.sh", "/tmp/work"}, cmd.Args)
+}
+
+func TestProcessRuntime(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the script needs a unix shell")
+	}
+	workDir, err := ioutil.TempDir("", "cozy-exec-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+	script := filepath.Join(workDir, "run.sh")
+	content := "#!/bin/sh\necho \"$FOO $1\"\necho oops >&2\nexit 2\n"
+	require.NoError(t, ioutil.WriteFile(script, []byte(content), 0755))
+
+	var stdout, stderr bytes.Buffer
+	rt := &processRuntime{}
+	exe, err := rt.Start(script, workDir, []string{"FOO=bar"}, &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Error(t, exe.Wait())
+	assert.Equal(t, 2, exe.ExitCode())
+	assert.Equal(t, "bar "+workDir+"\n", stdout.String())
+	assert.Equal(t, "oops\n", stderr.String())
+}
+
+func TestParseOOMKills(t *testing.T) {
+	events := "low 0\nhigh 0\nmax 12\noom 2\noom_kill 1\noom_group_kill 0\n"
+	assert.Equal(t, 1, parseOOMKills([]byte(events)))
+	assert.Equal(t, 0, parseOOMKills([]byte("low 0\nmax 3\n")))
+}
@@ -0,0 +1,137 @@
+//go:build wazero
+// +build wazero
+
+package exec
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// wasmModule is the name of the WebAssembly module executed by the wasm
+// runtime, in the working directory of the konnector or service.
+const wasmModule = "index.wasm"
+
+// wasmPageSize is the size of a page of the memory of a WebAssembly module.
+const wasmPageSize = 64 * 1024
+
+func newWasmRuntime(rt config.Runtime) (execRuntime, error) {
+	return &wasmRuntime{memory: rt.Memory}, nil
+}
+
+// wasmRuntime executes a WebAssembly module in the stack process, with the
+// WASI API. The module has only access to its working directory (mounted as
+// /), and to the environment variables given by the stack.
+type wasmRuntime struct {
+	memory int64
+}
+
+// limitMemory lowers the memory limit of the module for this execution.
+func (r *wasmRuntime) limitMemory(bytes int64) {
+	if r.memory == 0 || bytes < r.memory {
+		r.memory = bytes
+	}
+}
+
+func (r *wasmRuntime) Start(cmdStr, workDir string, env []string, stdout, stderr io.Writer) (execution, error) {
+	bin, err := ioutil.ReadFile(filepath.Join(workDir, wasmModule))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rtConfig := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if r.memory > 0 {
+		pages := uint32(r.memory / wasmPageSize)
+		if pages == 0 {
+			pages = 1
+		}
+		rtConfig = rtConfig.WithMemoryLimitPages(pages)
+	}
+	rt := wazero.NewRuntimeWithConfig(ctx, rtConfig)
+	if _, err = wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		_ = rt.Close(ctx)
+		cancel()
+		return nil, err
+	}
+	compiled, err := rt.CompileModule(ctx, bin)
+	if err != nil {
+		_ = rt.Close(ctx)
+		cancel()
+		return nil, err
+	}
+
+	modConfig := wazero.NewModuleConfig().
+		WithArgs(wasmModule).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithFSConfig(wazero.NewFSConfig().WithDirMount(workDir, "/")).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			modConfig = modConfig.WithEnv(parts[0], parts[1])
+		}
+	}
+
+	e := &wasmExecution{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(e.done)
+		defer cancel()
+		_, e.err = rt.InstantiateModule(ctx, compiled, modConfig)
+		_ = rt.Close(context.Background())
+	}()
+	return e, nil
+}
+
+// wasmExecution is an execution of a WebAssembly module, in a goroutine.
+type wasmExecution struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+func (e *wasmExecution) Wait() error {
+	<-e.done
+	return e.err
+}
+
+func (e *wasmExecution) Kill() error {
+	e.cancel()
+	return nil
+}
+
+// OOMKilled returns false, as a module that reaches its memory limit is not
+// killed: it fails to grow its memory.
+func (e *wasmExecution) OOMKilled() bool {
+	return false
+}
+
+func (e *wasmExecution) ExitCode() int {
+	<-e.done
+	if e.err == nil {
+		return 0
+	}
+	var exitErr *sys.ExitError
+	if errors.As(e.err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case sys.ExitCodeContextCanceled, sys.ExitCodeDeadlineExceeded:
+			return -1
+		}
+		return int(exitErr.ExitCode())
+	}
+	return 1
+}
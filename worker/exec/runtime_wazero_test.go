@@ -0,0 +1,68 @@
+//go:build wazero
+// +build wazero
+
+package exec
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exitWasm is a WebAssembly module that calls proc_exit(3) from WASI.
+var exitWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// types: (i32) -> () and () -> ()
+	0x01, 0x08, 0x02, 0x60, 0x01, 0x7f, 0x00, 0x60, 0x00, 0x00,
+	// import wasi_snapshot_preview1.proc_exit
+	0x02, 0x24, 0x01, 0x16,
+	'w', 'a', 's', 'i', '_', 's', 'n', 'a', 'p', 's', 'h', 'o', 't', '_',
+	'p', 'r', 'e', 'v', 'i', 'e', 'w', '1',
+	0x09, 'p', 'r', 'o', 'c', '_', 'e', 'x', 'i', 't', 0x00, 0x00,
+	// one function of type () -> ()
+	0x03, 0x02, 0x01, 0x01,
+	// export _start
+	0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x01,
+	// _start: proc_exit(3)
+	0x0a, 0x08, 0x01, 0x06, 0x00, 0x41, 0x03, 0x10, 0x00, 0x0b,
+}
+
+func TestSelectWasmRuntime(t *testing.T) {
+	cfg := config.GetConfig()
+	previous := cfg.Konnectors
+	defer func() { cfg.Konnectors = previous }()
+	cfg.Konnectors = config.Konnectors{
+		Runtimes: map[string]config.Runtime{
+			"wasm": {Type: "wasm", Memory: 1 << 20},
+		},
+		Slugs: map[string]string{"wasm-konnector": "wasm"},
+	}
+
+	rt, err := selectRuntime("wasm-konnector", "node")
+	assert.NoError(t, err)
+	assert.Equal(t, &wasmRuntime{memory: 1 << 20}, rt)
+}
+
+func TestWasmRuntime(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "cozy-exec-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	var stdout, stderr bytes.Buffer
+	rt := &wasmRuntime{}
+	_, err = rt.Start("", workDir, nil, &stdout, &stderr)
+	assert.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workDir, wasmModule), exitWasm, 0644))
+	rt.limitMemory(1 << 20)
+	exe, err := rt.Start("", workDir, []string{"FOO=bar"}, &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Error(t, exe.Wait())
+	assert.Equal(t, 3, exe.ExitCode())
+}
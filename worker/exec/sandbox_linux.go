@@ -0,0 +1,222 @@
+//go:build linux
+// +build linux
+
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// sandboxInitName is the name used for executing the stack binary as the
+// init process of the namespaces runtime.
+const sandboxInitName = "cozy-sandbox-init"
+
+// sandboxReadOnlyDirs are the directories of the host that are visible, in
+// read-only, inside the sandbox.
+var sandboxReadOnlyDirs = []string{"/bin", "/sbin", "/lib", "/lib32", "/lib64", "/usr"}
+
+// sandboxEtcFiles are the files of the /etc directory of the host that are
+// visible, in read-only, inside the sandbox. The rest of /etc is not, as it
+// can contain secrets (like the configuration of the stack).
+var sandboxEtcFiles = []string{"/etc/hosts", "/etc/ssl/certs"}
+
+// sandboxPasswd and sandboxGroup are the content of /etc/passwd and
+// /etc/group inside the sandbox, where the process runs as root (mapped to
+// the user of the stack).
+const (
+	sandboxPasswd = "root:x:0:0:root:/tmp:/bin/sh\nnobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin\n"
+	sandboxGroup  = "root:x:0:\nnogroup:x:65534:\n"
+)
+
+// slirpResolvConf is the content of /etc/resolv.conf inside the sandbox when
+// the egress goes through slirp4netns, which has a DNS forwarder on this
+// address.
+const slirpResolvConf = "nameserver 10.0.2.3\n"
+
+// sandboxDevices are the devices of the host that are visible inside the
+// sandbox.
+var sandboxDevices = []string{"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom"}
+
+func init() {
+	if len(os.Args) != 5 || os.Args[0] != sandboxInitName {
+		return
+	}
+	if err := sandboxInit(os.Args[1], os.Args[2], os.Args[3], os.Args[4]); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// waitReady waits for the stack to move the process in its cgroup and to
+// set up its network: the stack writes a byte on the pipe given as the first
+// extra file once it is done.
+func waitReady() error {
+	ready := os.NewFile(3, "ready")
+	defer ready.Close()
+	buf := make([]byte, 1)
+	if n, err := ready.Read(buf); n != 1 || err != nil {
+		return errors.New("the sandbox has not been set up by the stack")
+	}
+	return nil
+}
+
+// sandboxInit is executed in the new namespaces, before the konnector. It
+// waits to be in its cgroup with its network, then builds a minimal root filesystem in
+// rootDir, with the working directory, the command, a few directories, files
+// and devices of the host, and a new /proc for the PID namespace. It then
+// pivots to this root, and executes the command.
+func sandboxInit(rootDir, workDir, cmdPath, network string) error {
+	if err := waitReady(); err != nil {
+		return err
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("cannot make the mounts private: %s", err)
+	}
+	if err := syscall.Mount("tmpfs", rootDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+		return fmt.Errorf("cannot mount the root: %s", err)
+	}
+	for _, dir := range sandboxReadOnlyDirs {
+		if _, err := os.Lstat(dir); err != nil {
+			continue
+		}
+		if err := bindMount(dir, rootDir, true); err != nil {
+			return err
+		}
+	}
+	if err := sandboxEtc(rootDir, network); err != nil {
+		return err
+	}
+	for _, dev := range sandboxDevices {
+		if err := bindMount(dev, rootDir, false); err != nil {
+			return err
+		}
+	}
+
+	// The private /tmp is mounted before the working directory, as the
+	// working directory is usually created in the /tmp of the host.
+	tmp := filepath.Join(rootDir, "tmp")
+	if err := os.MkdirAll(tmp, 01777); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", tmp, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("cannot mount /tmp: %s", err)
+	}
+	if err := bindMount(cmdPath, rootDir, true); err != nil {
+		return err
+	}
+	if err := bindMount(workDir, rootDir, false); err != nil {
+		return err
+	}
+
+	proc := filepath.Join(rootDir, "proc")
+	if err := os.MkdirAll(proc, 0555); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err := syscall.Mount("proc", proc, "proc", flags, ""); err != nil {
+		return fmt.Errorf("cannot mount /proc: %s", err)
+	}
+
+	oldRoot := filepath.Join(rootDir, ".old-root")
+	if err := os.Mkdir(oldRoot, 0700); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(rootDir, oldRoot); err != nil {
+		return fmt.Errorf("cannot pivot the root: %s", err)
+	}
+	if err := syscall.Chdir("/"); err != nil {
+		return err
+	}
+	if err := syscall.Unmount("/.old-root", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("cannot unmount the old root: %s", err)
+	}
+	if err := os.Remove("/.old-root"); err != nil {
+		return err
+	}
+	if err := syscall.Chdir(workDir); err != nil {
+		return err
+	}
+	return syscall.Exec(cmdPath, []string{cmdPath, workDir}, os.Environ())
+}
+
+// sandboxEtc fills the /etc directory of the sandbox with a few files of the
+// host, stubs for the users and groups, and the DNS configuration for the
+// network of the runtime.
+func sandboxEtc(rootDir, network string) error {
+	etc := filepath.Join(rootDir, "etc")
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		return err
+	}
+	for _, file := range sandboxEtcFiles {
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		if err := bindMount(file, rootDir, true); err != nil {
+			return err
+		}
+	}
+	stubs := map[string]string{
+		"passwd": sandboxPasswd,
+		"group":  sandboxGroup,
+	}
+	switch network {
+	case "slirp4netns":
+		stubs["resolv.conf"] = slirpResolvConf
+	case "host":
+		if _, err := os.Stat("/etc/resolv.conf"); err == nil {
+			if err := bindMount("/etc/resolv.conf", rootDir, true); err != nil {
+				return err
+			}
+		}
+	}
+	for name, content := range stubs {
+		if err := ioutil.WriteFile(filepath.Join(etc, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindMount mounts the given file or directory of the host at the same path
+// in the new root.
+func bindMount(src, rootDir string, readOnly bool) error {
+	dst := filepath.Join(rootDir, src)
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		err = os.MkdirAll(dst, 0755)
+	} else {
+		if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+			var f *os.File
+			if f, err = os.OpenFile(dst, os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+				err = f.Close()
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err = syscall.Mount(src, dst, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("cannot bind %s: %s", src, err)
+	}
+	if !readOnly {
+		return nil
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY | syscall.MS_REC)
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(src, &st); err == nil {
+		// The flags locked by the mount of the host must be kept.
+		flags |= uintptr(st.Flags) & (syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	}
+	if err = syscall.Mount("", dst, "", flags, ""); err != nil {
+		return fmt.Errorf("cannot bind %s in read-only: %s", src, err)
+	}
+	return nil
+}
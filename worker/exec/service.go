@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/instance"
@@ -29,9 +30,61 @@ type ServiceOptions struct {
 }
 
 type serviceWorker struct {
-	man  *app.WebappManifest
-	slug string
-	name string
+	man    *app.WebappManifest
+	slug   string
+	name   string
+	limits app.ServiceLimits
+}
+
+// serviceConcurrencyCacheTTL is the duration for which the concurrency group
+// of a service is kept in the cache, as it is needed each time a job of the
+// service is taken from the queue.
+const serviceConcurrencyCacheTTL = 5 * time.Minute
+
+// serviceConcurrency is the concurrency group of a service, as cached.
+type serviceConcurrency struct {
+	Group string `json:"group"`
+	Max   int    `json:"max"`
+}
+
+// serviceConcurrencyGroup returns the service as the concurrency group of the
+// job, with the maximal number of executions in parallel for an instance
+// from the limits of the service.
+func serviceConcurrencyGroup(j *job.Job) (string, int) {
+	opts := &ServiceOptions{}
+	if err := j.Message.Unmarshal(&opts); err != nil {
+		return "", 0
+	}
+	if opts.Message != nil {
+		opts = opts.Message
+	}
+
+	cache := config.GetConfig().CacheStorage
+	key := "service-concurrency:" + j.Domain + "/" + opts.Slug + "/" + opts.Name + "/" + opts.File
+	var concurrency serviceConcurrency
+	if data, ok := cache.Get(key); ok {
+		if err := json.Unmarshal(data, &concurrency); err == nil {
+			return concurrency.Group, concurrency.Max
+		}
+	}
+
+	inst, err := instance.Get(j.Domain)
+	if err != nil {
+		return "", 0
+	}
+	man, err := app.GetWebappBySlug(inst, opts.Slug)
+	if err != nil {
+		return "", 0
+	}
+	if name, service, ok := man.FindService(opts.Name, opts.File); ok {
+		limits := app.ServiceLimitsFor(inst, opts.Slug, name, service)
+		concurrency.Group = opts.Slug + "/" + name
+		concurrency.Max = limits.Concurrency
+	}
+	if data, err := json.Marshal(concurrency); err == nil {
+		cache.Set(key, data, serviceConcurrencyCacheTTL)
+	}
+	return concurrency.Group, concurrency.Max
 }
 
 func (w *serviceWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Instance) (workDir string, cleanDir func(), err error) {
@@ -69,23 +122,13 @@ func (w *serviceWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Insta
 		return
 	}
 
-	var service *app.Service
-	var ok bool
-	services := man.Services()
-	if name != "" {
-		service, ok = services[name]
-	} else {
-		for _, s := range services {
-			if s.File == opts.File {
-				service, ok = s, true
-				break
-			}
-		}
-	}
+	serviceName, service, ok := man.FindService(name, opts.File)
 	if !ok {
 		err = job.ErrBadTrigger{Err: fmt.Errorf("Service %q was not found", name)}
 		return
 	}
+	w.name = serviceName
+	w.limits = app.ServiceLimitsFor(i, slug, serviceName, service)
 	// Check if the trigger is orphan
 	if triggerID, ok := ctx.TriggerID(); ok && service.TriggerID != "" {
 		if triggerID != service.TriggerID {
@@ -151,6 +194,10 @@ func (w *serviceWorker) Slug() string {
 	return w.slug
 }
 
+func (w *serviceWorker) Language() string {
+	return "node" // the services are always executed with node
+}
+
 func (w *serviceWorker) PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Instance) (cmd string, env []string, err error) {
 	type serviceEvent struct {
 		Doc interface{} `json:"doc"`
@@ -188,9 +235,25 @@ func (w *serviceWorker) PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Instan
 	if triggerID, ok := ctx.TriggerID(); ok {
 		env = append(env, "COZY_TRIGGER_ID="+triggerID)
 	}
+	// The services are executed with node, and the size of its heap can be
+	// capped to stay under the memory limit.
+	if memory := w.limits.MemoryBytes(); memory > 0 {
+		env = append(env, fmt.Sprintf("NODE_OPTIONS=--max-old-space-size=%d", memory/(1024*1024)))
+	}
 	return
 }
 
+func (w *serviceWorker) Limits() (time.Duration, int64) {
+	return w.limits.TimeoutDuration(), w.limits.MemoryBytes()
+}
+
+func (w *serviceWorker) LimitExceeded(ctx *job.WorkerContext, i *instance.Instance, limit string) {
+	w.Logger(ctx).Warnf("Service has exceeded its %s limit", limit)
+	if err := app.RecordServiceViolation(i, w.slug, w.name, limit, ctx.JobID()); err != nil {
+		w.Logger(ctx).Errorf("Cannot record the violation of the %s limit: %s", limit, err)
+	}
+}
+
 func (w *serviceWorker) Logger(ctx *job.WorkerContext) *logger.Entry {
 	log := ctx.Logger().WithField("slug", w.Slug())
 	if w.name != "" {
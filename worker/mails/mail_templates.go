@@ -29,6 +29,7 @@ func initMailTemplates() {
 		"two_factor":                   subjectEntry{"Mail Two Factor Subject", nil},
 		"two_factor_mail_confirmation": subjectEntry{"Mail Two Factor Mail Confirmation Subject", []string{templateTitleVar}},
 		"new_connection":               subjectEntry{"Mail New Connection Subject", []string{templateTitleVar}},
+		"new_device":                   subjectEntry{"Mail New Device Subject", []string{templateTitleVar}},
 		"new_registration":             subjectEntry{"Mail New Registration Subject", []string{templateTitleVar}},
 		"alert_account":                subjectEntry{"Mail Alert Account Subject", nil},
 		"support_request":              subjectEntry{"Mail Support Confirmation Subject", nil},
@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/web/data"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// migrateAccountsToCredentialsVault encrypts with the key of the credentials
+// vault of the instance the accounts that were encrypted with the vault key
+// of the stack.
+func migrateAccountsToCredentialsVault(domain string) error {
+	inst, err := instance.GetFromCouch(domain)
+	if err != nil {
+		return err
+	}
+	log := inst.Logger().WithNamespace("migration")
+
+	var docs []couchdb.JSONDoc
+	err = couchdb.ForeachDocs(inst, consts.Accounts, func(_ string, raw json.RawMessage) error {
+		var doc couchdb.JSONDoc
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+		doc.Type = consts.Accounts
+		docs = append(docs, doc)
+		return nil
+	})
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil
+		}
+		return err
+	}
+
+	var errm error
+	migrated := 0
+	for _, doc := range docs {
+		if !data.EncryptAccount(inst, doc) {
+			continue
+		}
+		if err := couchdb.UpdateDoc(inst, &doc); err != nil {
+			errm = multierror.Append(errm, err)
+			continue
+		}
+		migrated++
+	}
+	log.Infof("%d accounts have been moved to the credentials vault", migrated)
+	return errm
+}
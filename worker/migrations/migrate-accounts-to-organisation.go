@@ -254,7 +254,7 @@ func migrateAccountsToOrganization(domain string) error {
 
 		accJSON.Type = consts.Accounts
 
-		data.DecryptAccount(accJSON)
+		data.DecryptAccount(inst, accJSON)
 
 		cipher, err := buildCipher(orgKey, manifest, accJSON, link, log)
 		if err != nil {
@@ -268,7 +268,7 @@ func migrateAccountsToOrganization(domain string) error {
 
 		addCipherRelationshipToAccount(accJSON, cipher)
 
-		data.EncryptAccount(accJSON)
+		data.EncryptAccount(inst, accJSON)
 
 		log.Infof("Updating doc %s", accJSON)
 		if err := couchdb.UpdateDoc(inst, &accJSON); err != nil {
@@ -40,6 +40,7 @@ const (
 	accountsToOrganization = "accounts-to-organization"
 	notesMimeType          = "notes-mime-type"
 	unwantedFolders        = "remove-unwanted-folders"
+	accountsToVault        = "accounts-to-credentials-vault"
 )
 
 // maxSimultaneousCalls is the maximal number of simultaneous calls to Swift
@@ -82,6 +83,8 @@ func worker(ctx *job.WorkerContext) error {
 		return migrateNotesMimeType(ctx.Instance.Domain)
 	case unwantedFolders:
 		return removeUnwantedFolders(ctx.Instance.Domain)
+	case accountsToVault:
+		return migrateAccountsToCredentialsVault(ctx.Instance.Domain)
 	default:
 		return fmt.Errorf("unknown migration type %q", msg.Type)
 	}
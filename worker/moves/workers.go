@@ -41,7 +41,7 @@ func ExportWorker(c *job.WorkerContext) error {
 	}
 
 	archiver := move.SystemArchiver()
-	exportDoc, err := move.CreateExport(c.Instance, opts, archiver)
+	exportDoc, err := move.CreateExport(c.Instance, opts, archiver, c.SetProgress)
 	if err != nil {
 		if opts.MoveTo != nil {
 			move.Abort(c.Instance, opts.MoveTo.URL, opts.MoveTo.Token)
@@ -71,7 +71,7 @@ func ImportWorker(c *job.WorkerContext) error {
 		return err
 	}
 
-	inError, err := move.Import(c.Instance, opts)
+	inError, err := move.Import(c.Instance, opts, c.SetProgress)
 
 	if erru := lifecycle.Unblock(c.Instance); erru != nil {
 		// Try again
@@ -16,6 +16,7 @@ func init() {
 		MaxExecCount: 2,
 		Reserved:     true,
 		Timeout:      30 * time.Second,
+		Priority:     job.PriorityLow,
 		WorkerFunc:   WorkerClean,
 	})
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"os/exec"
 	"runtime"
@@ -17,6 +18,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
 	"github.com/cozy/cozy-stack/pkg/realtime"
 	multierror "github.com/hashicorp/go-multierror"
 )
@@ -45,6 +47,7 @@ func init() {
 		MaxExecCount: 2,
 		Reserved:     true,
 		Timeout:      30 * time.Second,
+		JobPriority:  jobPriority,
 		WorkerFunc:   Worker,
 	})
 
@@ -58,6 +61,41 @@ func init() {
 	})
 }
 
+// jobPriority gives a high priority to the thumbnails of the images uploaded
+// by the user, as they are waiting for them, and a low priority to the
+// thumbnails of the images imported by a konnector, received via a sharing,
+// or synchronized in bulk by the desktop client.
+func jobPriority(db prefixer.Prefixer, req *job.JobRequest) job.Priority {
+	var msg imageMessage
+	if err := req.Message.Unmarshal(&msg); err == nil && msg.NoteImage != nil {
+		return job.PriorityHigh
+	}
+
+	var img imageEvent
+	if err := req.Event.Unmarshal(&img); err != nil {
+		return ""
+	}
+	if img.Verb == "DELETED" || img.Doc.Trashed {
+		return job.PriorityLow
+	}
+	fcm := img.Doc.CozyMetadata
+	if fcm == nil {
+		return job.PriorityHigh
+	}
+	if fcm.SourceAccount != "" {
+		return job.PriorityLow
+	}
+	if fcm.UploadedOn != "" {
+		if u, err := url.Parse(fcm.UploadedOn); err == nil && u.Host != db.DomainName() {
+			return job.PriorityLow
+		}
+	}
+	if fcm.UploadedBy != nil && fcm.UploadedBy.Client["kind"] == "desktop" {
+		return job.PriorityLow
+	}
+	return job.PriorityHigh
+}
+
 // Worker is a worker that creates thumbnails for photos and images.
 func Worker(ctx *job.WorkerContext) error {
 	var msg imageMessage
@@ -120,6 +158,7 @@ func WorkerCheck(ctx *job.WorkerContext) error {
 	fs := ctx.Instance.VFS()
 	fsThumb := ctx.Instance.ThumbsFS()
 	var errm error
+	var checked int64
 	_ = vfs.Walk(fs, "/", func(name string, dir *vfs.DirDoc, img *vfs.FileDoc, err error) error {
 		if err != nil {
 			return err
@@ -127,6 +166,9 @@ func WorkerCheck(ctx *job.WorkerContext) error {
 		if dir != nil || img.Class != "image" {
 			return nil
 		}
+		// The total number of images is not known in advance
+		checked++
+		ctx.SetProgress(checked, 0, name)
 		allExists := true
 		for _, format := range vfs.ThumbnailFormatNames {
 			var exists bool
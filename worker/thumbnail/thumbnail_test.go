@@ -0,0 +1,45 @@
+package thumbnail
+
+import (
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobPriority(t *testing.T) {
+	db := prefixer.NewPrefixer("alice.cozy.example", "alice-cozy-example")
+	priority := func(verb string, fcm *vfs.FilesCozyMetadata) job.Priority {
+		doc := &vfs.FileDoc{DocID: "image", CozyMetadata: fcm}
+		evt, err := job.NewEvent(&realtime.Event{Verb: verb, Doc: doc})
+		assert.NoError(t, err)
+		return jobPriority(db, &job.JobRequest{WorkerType: "thumbnail", Event: evt})
+	}
+
+	uploaded := vfs.NewCozyMetadata("https://alice.cozy.example/")
+	uploaded.UploadedOn = "https://alice.cozy.example/"
+	uploaded.UploadedBy = &vfs.UploadedByEntry{Slug: "drive"}
+	assert.Equal(t, job.PriorityHigh, priority("CREATED", uploaded))
+	assert.Equal(t, job.PriorityLow, priority("DELETED", uploaded))
+
+	imported := vfs.NewCozyMetadata("https://alice.cozy.example/")
+	imported.SourceAccount = "account-id"
+	assert.Equal(t, job.PriorityLow, priority("CREATED", imported))
+
+	shared := vfs.NewCozyMetadata("https://bob.cozy.example/")
+	shared.UploadedOn = "https://bob.cozy.example/"
+	assert.Equal(t, job.PriorityLow, priority("CREATED", shared))
+
+	synced := vfs.NewCozyMetadata("https://alice.cozy.example/")
+	synced.UploadedOn = "https://alice.cozy.example/"
+	synced.UploadedBy = &vfs.UploadedByEntry{Client: map[string]string{"kind": "desktop"}}
+	assert.Equal(t, job.PriorityLow, priority("UPDATED", synced))
+
+	msg, err := job.NewMessage(imageMessage{NoteImage: &note.Image{}})
+	assert.NoError(t, err)
+	assert.Equal(t, job.PriorityHigh, jobPriority(db, &job.JobRequest{WorkerType: "thumbnail", Message: msg}))
+}
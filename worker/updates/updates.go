@@ -27,6 +27,7 @@ func init() {
 		Concurrency:  1,
 		MaxExecCount: 1,
 		Timeout:      1 * time.Hour,
+		Priority:     job.PriorityLow,
 		WorkerFunc:   Worker,
 	})
 }
@@ -342,6 +343,7 @@ func createInstaller(inst *instance.Instance, registries []*url.URL, man app.Man
 			Registries:       registries,
 			SourceURL:        sourceURL,
 			PermissionsAcked: true,
+			Automatic:        true,
 		},
 	)
 }
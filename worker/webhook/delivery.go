@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// defaultDeliveriesLimit is the number of deliveries returned by
+// ListDeliveries when no limit is given.
+const defaultDeliveriesLimit = 50
+
+// maxDeliveries is the number of deliveries kept for a trigger: the older
+// ones are purged when a new delivery is saved.
+const maxDeliveries = 100
+
+// Delivery is an attempt to send an event to the external endpoint of a
+// webhook-out trigger. They are kept in CouchDB as a delivery log.
+type Delivery struct {
+	DocID      string    `json:"_id,omitempty"`
+	DocRev     string    `json:"_rev,omitempty"`
+	TriggerID  string    `json:"trigger_id"`
+	JobID      string    `json:"job_id"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Duration   float64   `json:"duration"` // in seconds
+}
+
+// ID implements the couchdb.Doc interface
+func (d *Delivery) ID() string { return d.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (d *Delivery) Rev() string { return d.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (d *Delivery) DocType() string { return consts.WebhookDeliveries }
+
+// Clone implements the couchdb.Doc interface
+func (d *Delivery) Clone() couchdb.Doc {
+	cloned := *d
+	return &cloned
+}
+
+// SetID implements the couchdb.Doc interface
+func (d *Delivery) SetID(id string) { d.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (d *Delivery) SetRev(rev string) { d.DocRev = rev }
+
+// Relationships implements the jsonapi.Object interface
+func (d *Delivery) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Included implements the jsonapi.Object interface
+func (d *Delivery) Included() []jsonapi.Object { return nil }
+
+// Links implements the jsonapi.Object interface
+func (d *Delivery) Links() *jsonapi.LinksList { return nil }
+
+func (d *Delivery) save(db prefixer.Prefixer) error {
+	if err := couchdb.CreateDoc(db, d); err != nil {
+		return err
+	}
+	purgeOldDeliveries(db, d.TriggerID)
+	return nil
+}
+
+// purgeOldDeliveries deletes the deliveries of a trigger, except the last
+// ones.
+func purgeOldDeliveries(db prefixer.Prefixer, triggerID string) {
+	var deliveries []*Delivery
+	req := &couchdb.FindRequest{
+		UseIndex: "by-trigger-id",
+		Selector: mango.Equal("trigger_id", triggerID),
+		Sort: mango.SortBy{
+			{Field: "trigger_id", Direction: mango.Desc},
+			{Field: "created_at", Direction: mango.Desc},
+		},
+		Skip:  maxDeliveries,
+		Limit: defaultDeliveriesLimit,
+	}
+	if err := couchdb.FindDocs(db, consts.WebhookDeliveries, req, &deliveries); err != nil {
+		return
+	}
+	bulkDeleteDeliveries(db, deliveries)
+}
+
+// DeleteDeliveries deletes the delivery log of a webhook-out trigger, when
+// the trigger is deleted.
+func DeleteDeliveries(db prefixer.Prefixer, triggerID string) {
+	for {
+		var deliveries []*Delivery
+		req := &couchdb.FindRequest{
+			UseIndex: "by-trigger-id",
+			Selector: mango.Equal("trigger_id", triggerID),
+			Limit:    1000,
+		}
+		err := couchdb.FindDocs(db, consts.WebhookDeliveries, req, &deliveries)
+		if err != nil || len(deliveries) == 0 {
+			return
+		}
+		if !bulkDeleteDeliveries(db, deliveries) {
+			return
+		}
+	}
+}
+
+func bulkDeleteDeliveries(db prefixer.Prefixer, deliveries []*Delivery) bool {
+	if len(deliveries) == 0 {
+		return false
+	}
+	docs := make([]couchdb.Doc, len(deliveries))
+	for i, d := range deliveries {
+		docs[i] = d
+	}
+	if err := couchdb.BulkDeleteDocs(db, consts.WebhookDeliveries, docs); err != nil {
+		logger.WithDomain(db.DomainName()).WithNamespace("webhook").
+			Warnf("Cannot purge the deliveries: %s", err)
+		return false
+	}
+	return true
+}
+
+// ListDeliveries returns the last deliveries for the given trigger, the most
+// recent first.
+func ListDeliveries(db prefixer.Prefixer, triggerID string, limit int) ([]*Delivery, error) {
+	if limit <= 0 {
+		limit = defaultDeliveriesLimit
+	}
+	var deliveries []*Delivery
+	req := &couchdb.FindRequest{
+		UseIndex: "by-trigger-id",
+		Selector: mango.Equal("trigger_id", triggerID),
+		Sort: mango.SortBy{
+			{Field: "trigger_id", Direction: mango.Desc},
+			{Field: "created_at", Direction: mango.Desc},
+		},
+		Limit: limit,
+	}
+	err := couchdb.FindDocs(db, consts.WebhookDeliveries, req, &deliveries)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return []*Delivery{}, nil
+		}
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+var _ jsonapi.Object = &Delivery{}
@@ -0,0 +1,355 @@
+// Package webhook is for the webhook-out worker, that sends the realtime
+// events of an @event trigger to an external HTTP endpoint.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/account"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
+	build "github.com/cozy/cozy-stack/pkg/config"
+)
+
+// WorkerType is the type of the worker for the outgoing webhooks.
+const WorkerType = "webhook-out"
+
+// minSecretLength is the minimal length of the secret used to sign the
+// payloads.
+const minSecretLength = 16
+
+// SignatureHeader is the HTTP header used to send the signature of the
+// payload. Its value is sha256= followed by the hex-encoded HMAC-SHA256 of
+// the timestamp, a dot, and the body, with the secret of the trigger as key.
+const SignatureHeader = "X-Cozy-Signature"
+
+// TimestampHeader is the HTTP header used to send the timestamp (in seconds
+// since epoch) of the delivery.
+const TimestampHeader = "X-Cozy-Timestamp"
+
+// DeliveryHeader is the HTTP header used to send the identifier of the job,
+// which is the same for all the attempts of a delivery.
+const DeliveryHeader = "X-Cozy-Delivery"
+
+var (
+	// ErrInvalidURL is used when the URL of the webhook is not valid
+	ErrInvalidURL = errors.New("Invalid URL for webhook")
+	// ErrInvalidSecret is used when the secret of the webhook is too short
+	ErrInvalidSecret = errors.New("The secret of the webhook is too short")
+	// ErrForbiddenDoctype is used when the permissions do not allow to send
+	// the documents of a doctype outside of the stack
+	ErrForbiddenDoctype = errors.New("The documents of this doctype cannot be sent by a webhook")
+	// ErrForbiddenAddress is used when the host of the webhook resolves to a
+	// private, loopback or link-local address
+	ErrForbiddenAddress = errors.New("The webhook cannot be sent to this address")
+)
+
+// privateNetworks are the IP ranges that are not reachable from the
+// internet, and where the webhooks must not be sent. It covers the same
+// private ranges as net.IP.IsPrivate, plus the other reserved ranges.
+var privateNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // "this" network
+		"10.0.0.0/8",     // private
+		"100.64.0.0/10",  // carrier-grade NAT
+		"172.16.0.0/12",  // private
+		"192.0.0.0/24",   // IETF protocol assignments
+		"192.168.0.0/16", // private
+		"198.18.0.0/15",  // benchmarking
+		"240.0.0.0/4",    // reserved, and broadcast
+		"64:ff9b::/96",   // NAT64, can map to a private IPv4 address
+		"fc00::/7",       // unique local addresses
+		"fec0::/10",      // site-local (deprecated)
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// checkAddress is called by the dialer after the DNS resolution, and before
+// connecting to the address. It refuses the private, loopback and link-local
+// addresses, for the first request and for the redirections.
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	if build.IsDevRelease() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return ErrForbiddenAddress
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+var webhookClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   checkAddress,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func init() {
+	// The retries are not made in the worker slot: the jobs are put back in
+	// the queue with a delay.
+	jitter := 0.2
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:    WorkerType,
+		Concurrency:   runtime.NumCPU() * 4,
+		MaxExecCount:  5,
+		Timeout:       1 * time.Minute,
+		RetryDelay:    1 * time.Minute,
+		MaxRetryDelay: 30 * time.Minute,
+		RetryJitter:   &jitter,
+		Backoff:       job.BackoffExponential,
+		DeadLetter:    true,
+		WorkerFunc:    Worker,
+	})
+}
+
+// Message is the message of a webhook-out trigger. The secret is given in
+// clear by the client, but it is stored encrypted with the vault key of the
+// stack when possible.
+type Message struct {
+	URL             string `json:"url"`
+	Secret          string `json:"secret,omitempty"`
+	SecretEncrypted string `json:"secret_encrypted,omitempty"`
+}
+
+// EncryptSecret replaces the secret of the message by its encrypted version,
+// if the vault key of the stack is available.
+func (m *Message) EncryptSecret() error {
+	if m.Secret == "" || !account.NewCipher(nil).CanEncrypt() {
+		return nil
+	}
+	encrypted, err := account.EncryptCredentialsData(m.Secret)
+	if err != nil {
+		return err
+	}
+	m.SecretEncrypted = encrypted
+	m.Secret = ""
+	return nil
+}
+
+func (m *Message) secret() (string, error) {
+	if m.SecretEncrypted == "" {
+		return m.Secret, nil
+	}
+	data, err := account.DecryptCredentialsData(m.SecretEncrypted)
+	if err != nil {
+		return "", err
+	}
+	secret, ok := data.(string)
+	if !ok {
+		return "", account.ErrBadCredentials
+	}
+	return secret, nil
+}
+
+// RedactMessage returns the message of a webhook-out trigger without its
+// secret, so that it can be sent to the clients.
+func RedactMessage(msg job.Message) job.Message {
+	var m map[string]interface{}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil
+	}
+	delete(m, "secret")
+	delete(m, "secret_encrypted")
+	redacted, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// Payload is the JSON body sent to the external endpoint.
+type Payload struct {
+	TriggerID string          `json:"trigger_id"`
+	Domain    string          `json:"domain"`
+	Event     json.RawMessage `json:"event,omitempty"`
+	Debounced bool            `json:"debounced,omitempty"`
+}
+
+// CheckMessage returns an error if the message of a webhook-out trigger is
+// not valid.
+func CheckMessage(msg *Message) error {
+	u, err := url.Parse(msg.URL)
+	if err != nil || u.Host == "" {
+		return ErrInvalidURL
+	}
+	if u.Scheme != "https" && (u.Scheme != "http" || !build.IsDevRelease()) {
+		return ErrInvalidURL
+	}
+	if msg.SecretEncrypted == "" && len(msg.Secret) < minSecretLength {
+		return ErrInvalidSecret
+	}
+	return nil
+}
+
+// CheckDoctypes returns an error if the realtime events of the trigger cannot
+// be sent outside of the stack with the given permissions: the doctypes must
+// be readable, and the permissions must allow to read the whole doctype.
+func CheckDoctypes(trigger job.Trigger, perms permission.Set) error {
+	et, ok := trigger.(*job.EventTrigger)
+	if !ok {
+		return job.ErrUnknownTrigger
+	}
+	for _, rule := range et.Rules() {
+		if err := permission.CheckReadable(rule.Type); err != nil {
+			return ErrForbiddenDoctype
+		}
+		if !perms.AllowWholeType(permission.GET, rule.Type) {
+			return ErrForbiddenDoctype
+		}
+	}
+	return nil
+}
+
+// Sign returns the value of the signature header for the given secret,
+// timestamp and body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Worker is the worker that sends the realtime events to the external
+// endpoint of a webhook-out trigger.
+func Worker(ctx *job.WorkerContext) error {
+	var msg Message
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	if err := CheckMessage(&msg); err != nil {
+		return job.ErrBadTrigger{Err: err}
+	}
+	secret, err := msg.secret()
+	if err != nil {
+		return err
+	}
+	triggerID, ok := ctx.TriggerID()
+	if !ok {
+		// The doctypes are checked when the trigger is created, so a job
+		// pushed directly in the queue is not allowed.
+		ctx.SetNoRetry()
+		return errors.New("The webhook-out jobs must be created by a trigger")
+	}
+
+	payload := Payload{
+		TriggerID: triggerID,
+		Domain:    ctx.Instance.Domain,
+	}
+	var event json.RawMessage
+	if err := ctx.UnmarshalEvent(&event); err == nil {
+		payload.Event = event
+	} else {
+		payload.Debounced = true
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delivery := &Delivery{
+		TriggerID: triggerID,
+		JobID:     ctx.JobID(),
+		URL:       msg.URL,
+		CreatedAt: time.Now().UTC(),
+	}
+	res, err := send(ctx, msg.URL, secret, body)
+	delivery.Duration = time.Since(delivery.CreatedAt).Seconds()
+	if res != nil {
+		delivery.StatusCode = res.StatusCode
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	if errd := delivery.save(ctx.Instance); errd != nil {
+		ctx.Logger().Warnf("Cannot save the delivery of webhook %s: %s", triggerID, errd)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusGone:
+		// The endpoint tells us that it no longer wants to receive the
+		// events: the trigger can be deleted, with its deliveries.
+		DeleteDeliveries(ctx.Instance, triggerID)
+		return job.ErrBadTrigger{Err: fmt.Errorf("webhook %s is gone", msg.URL)}
+	case res.StatusCode >= 400 && res.StatusCode < 500 &&
+		res.StatusCode != http.StatusRequestTimeout &&
+		res.StatusCode != http.StatusTooManyRequests:
+		ctx.SetNoRetry()
+	}
+	return fmt.Errorf("webhook %s has responded with status %d", msg.URL, res.StatusCode)
+}
+
+func send(ctx *job.WorkerContext, u, secret string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cozy-stack "+build.Version+" ("+runtime.Version()+")")
+	req.Header.Set(DeliveryHeader, ctx.JobID())
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// Drain the body to allow the reuse of the connection
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+	res.Body.Close()
+	return res, nil
+}
@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var inst *instance.Instance
+
+func TestCheckMessage(t *testing.T) {
+	secret := "0123456789abcdef"
+	assert.NoError(t, CheckMessage(&Message{URL: "https://example.org/hook", Secret: secret}))
+	assert.Equal(t, ErrInvalidURL, CheckMessage(&Message{URL: "ftp://example.org/hook", Secret: secret}))
+	assert.Equal(t, ErrInvalidURL, CheckMessage(&Message{URL: "/hook", Secret: secret}))
+	assert.Equal(t, ErrInvalidSecret, CheckMessage(&Message{URL: "https://example.org/hook", Secret: "short"}))
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"trigger_id":"123"}`)
+	signature := Sign("0123456789abcdef", 1600000000, body)
+	assert.Equal(t, "sha256=fe67e74cfea11ba9a386219adb58f5219cf4259057d922e48484b2d89fb20fa1", signature)
+	assert.NotEqual(t, signature, Sign("0123456789abcdef", 1600000001, body))
+}
+
+func TestIsPublicIP(t *testing.T) {
+	assert.True(t, isPublicIP(net.ParseIP("93.184.216.34")))
+	assert.True(t, isPublicIP(net.ParseIP("2606:2800:220:1:248:1893:25c8:1946")))
+	assert.False(t, isPublicIP(net.ParseIP("127.0.0.1")))
+	assert.False(t, isPublicIP(net.ParseIP("10.1.2.3")))
+	assert.False(t, isPublicIP(net.ParseIP("172.20.0.1")))
+	assert.False(t, isPublicIP(net.ParseIP("192.168.1.1")))
+	assert.False(t, isPublicIP(net.ParseIP("169.254.169.254")))
+	assert.False(t, isPublicIP(net.ParseIP("0.0.0.0")))
+	assert.False(t, isPublicIP(net.ParseIP("0.1.2.3")))
+	assert.False(t, isPublicIP(net.ParseIP("100.64.0.1")))
+	assert.False(t, isPublicIP(net.ParseIP("198.18.0.1")))
+	assert.False(t, isPublicIP(net.ParseIP("255.255.255.255")))
+	assert.False(t, isPublicIP(net.ParseIP("::")))
+	assert.False(t, isPublicIP(net.ParseIP("::ffff:10.1.2.3")))
+	assert.False(t, isPublicIP(net.ParseIP("64:ff9b::a01:203")))
+	assert.False(t, isPublicIP(net.ParseIP("::1")))
+	assert.False(t, isPublicIP(net.ParseIP("fe80::1")))
+	assert.False(t, isPublicIP(net.ParseIP("fd00::1")))
+}
+
+func TestRedactMessage(t *testing.T) {
+	msg := []byte(`{"url":"https://example.org/hook","secret":"0123456789abcdef","secret_encrypted":"bmFjbA=="}`)
+	assert.JSONEq(t, `{"url":"https://example.org/hook"}`, string(RedactMessage(msg)))
+}
+
+func TestFailedDeliveryIsDeadLettered(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	// Use the configuration of the webhook-out worker, with shorter delays
+	workers, err := job.GetWorkersList()
+	require.NoError(t, err)
+	var conf *job.WorkerConfig
+	for _, w := range workers {
+		if w.WorkerType == WorkerType {
+			conf = w.Clone()
+		}
+	}
+	require.NotNil(t, conf)
+	jitter := 0.0
+	conf.RetryDelay = 10 * time.Millisecond
+	conf.MaxRetryDelay = 100 * time.Millisecond
+	conf.RetryJitter = &jitter
+
+	broker := job.NewMemBroker()
+	require.NoError(t, broker.StartWorkers(job.WorkersList{conf}))
+	defer func() { _ = broker.ShutdownWorkers(context.Background()) }()
+
+	msg, err := job.NewMessage(&Message{URL: ts.URL, Secret: "0123456789abcdef"})
+	require.NoError(t, err)
+	j, err := broker.PushJob(inst, &job.JobRequest{
+		WorkerType: WorkerType,
+		TriggerID:  "webhook-trigger-id",
+		Message:    msg,
+	})
+	require.NoError(t, err)
+
+	var list []*job.Job
+	for i := 0; i < 300 && len(list) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		list, err = broker.DeadLetters(WorkerType)
+		require.NoError(t, err)
+	}
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, j.ID(), list[0].ID())
+		assert.Equal(t, conf.MaxExecCount, list[0].ExecCount)
+	}
+	assert.EqualValues(t, conf.MaxExecCount, atomic.LoadInt32(&calls))
+
+	deliveries, err := ListDeliveries(inst, "webhook-trigger-id", 0)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, conf.MaxExecCount)
+}
+
+func TestMain(m *testing.M) {
+	config.UseTestFile()
+	setup := testutils.NewSetup(m, "webhook_test")
+	inst = setup.GetTestInstance()
+	os.Exit(setup.Run())
+}